@@ -0,0 +1,28 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRealAfter(t *testing.T) {
+	c := Real()
+
+	start := c.Now()
+	<-c.After(time.Millisecond)
+
+	if elapsed := c.Now().Sub(start); elapsed < time.Millisecond {
+		t.Errorf("After() returned after %v, want at least 1ms", elapsed)
+	}
+}
+
+func TestRealTicker(t *testing.T) {
+	ticker := Real().NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	select {
+	case <-ticker.C():
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for tick")
+	}
+}
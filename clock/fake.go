@@ -0,0 +1,147 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only moves when Advance is called.
+// It is safe for concurrent use.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is a pending After call or an active ticker.
+type fakeWaiter struct {
+	at     time.Time
+	period time.Duration // Zero for After
+	ch     chan time.Time
+}
+
+// NewFake creates a fake clock starting at now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now returns the fake current time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// After returns a channel that receives once the clock has been advanced by d.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{at: f.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.ch <- f.now
+		return w.ch
+	}
+
+	f.addWaiter(w)
+	return w.ch
+}
+
+// NewTicker returns a ticker that fires each time the clock passes a multiple of d.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{at: f.now.Add(d), period: d, ch: make(chan time.Time, 1)}
+	f.addWaiter(w)
+	return &fakeTicker{f: f, w: w}
+}
+
+// Advance moves the clock forward by d, firing any timers and tickers that
+// become due. Like time.Ticker, a ticker whose channel is full drops ticks.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+
+		select {
+		case w.ch <- f.now:
+		default:
+		}
+
+		if w.period > 0 {
+			for !w.at.After(f.now) {
+				w.at = w.at.Add(w.period)
+			}
+			pending = append(pending, w)
+		}
+	}
+	f.waiters = pending
+}
+
+// Waiters returns the number of pending timers and active tickers.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.waiters)
+}
+
+// BlockUntil blocks until at least n timers or tickers are pending. Tests use
+// it to wait for the code under test to start waiting before calling Advance.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// addWaiter registers w and wakes BlockUntil callers. f.mu must be held.
+func (f *Fake) addWaiter(w *fakeWaiter) {
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+}
+
+// removeWaiter unregisters w. f.mu must be held.
+func (f *Fake) removeWaiter(w *fakeWaiter) {
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	f *Fake
+	w *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.w.ch
+}
+
+func (t *fakeTicker) Stop() {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+
+	t.f.removeWaiter(t.w)
+}
@@ -0,0 +1,105 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var testEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeNow(t *testing.T) {
+	f := NewFake(testEpoch)
+
+	if got := f.Now(); !got.Equal(testEpoch) {
+		t.Errorf("Now() = %v, want %v", got, testEpoch)
+	}
+
+	f.Advance(time.Minute)
+
+	if got := f.Now(); !got.Equal(testEpoch.Add(time.Minute)) {
+		t.Errorf("Now() after Advance = %v, want %v", got, testEpoch.Add(time.Minute))
+	}
+}
+
+func TestFakeAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		wait     time.Duration
+		advance  time.Duration
+		wantFire bool
+	}{
+		{name: "before deadline", wait: time.Second, advance: 999 * time.Millisecond, wantFire: false},
+		{name: "at deadline", wait: time.Second, advance: time.Second, wantFire: true},
+		{name: "past deadline", wait: time.Second, advance: time.Hour, wantFire: true},
+		{name: "zero duration", wait: 0, advance: 0, wantFire: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFake(testEpoch)
+			ch := f.After(tt.wait)
+			f.Advance(tt.advance)
+
+			select {
+			case <-ch:
+				if !tt.wantFire {
+					t.Error("After() fired before deadline")
+				}
+			default:
+				if tt.wantFire {
+					t.Error("After() did not fire")
+				}
+			}
+		})
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(testEpoch)
+	ticker := f.NewTicker(time.Second)
+
+	for i := 0; i < 3; i++ {
+		f.Advance(time.Second)
+
+		select {
+		case got := <-ticker.C():
+			if want := testEpoch.Add(time.Duration(i+1) * time.Second); !got.Equal(want) {
+				t.Errorf("tick %d = %v, want %v", i, got, want)
+			}
+		default:
+			t.Fatalf("tick %d not delivered", i)
+		}
+	}
+
+	ticker.Stop()
+	f.Advance(time.Second)
+
+	select {
+	case <-ticker.C():
+		t.Error("stopped ticker fired")
+	default:
+	}
+
+	if got := f.Waiters(); got != 0 {
+		t.Errorf("Waiters() after Stop = %d, want 0", got)
+	}
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	f := NewFake(testEpoch)
+
+	done := make(chan struct{})
+	go func() {
+		<-f.After(time.Second)
+		close(done)
+	}()
+
+	f.BlockUntil(1)
+	f.Advance(time.Second)
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for After to fire")
+	}
+}
@@ -3,9 +3,12 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,45 +16,121 @@ import (
 	"github.com/kradalby/nefit-homekit/events"
 	"github.com/kradalby/nefit-homekit/homekit"
 	"github.com/kradalby/nefit-homekit/logging"
+	"github.com/kradalby/nefit-homekit/metrics"
 	"github.com/kradalby/nefit-homekit/nefit"
+	"github.com/kradalby/nefit-homekit/recovery"
+	"github.com/kradalby/nefit-homekit/timeseries"
 	"github.com/kradalby/nefit-homekit/web"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
+// exitCodeFailure is the exit code for both errors and recovered panics.
+const exitCodeFailure = 1
+
+// resetHomeKit forgets the stored HomeKit pairing and identity before starting,
+// for when the bridge is moved to a different boiler.
+var resetHomeKit = flag.Bool("reset-homekit", false, "forget HomeKit pairings and identity, then start as a new accessory")
+
+// exportPairing and importPairing back up and restore the HomeKit identity and
+// pairings, so a reinstall keeps the accessory and its automations.
+var (
+	exportPairing = flag.String("export-pairing", "", "write the HomeKit identity and pairings to this file as a tar.gz backup, then exit")
+	importPairing = flag.String("import-pairing", "", "restore the HomeKit identity and pairings from a backup made with -export-pairing before starting")
+)
+
 func main() {
-	if err := run(); err != nil {
+	flag.Parse()
+
+	// Catches panics before the logger exists; later ones are logged by run
+	if err := recovery.Run(run); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		var panicErr *recovery.PanicError
+		if errors.As(err, &panicErr) {
+			_, _ = os.Stderr.Write(panicErr.Stack)
+		}
+		os.Exit(exitCodeFailure)
 	}
 }
 
-func run() error {
+func run() (err error) {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		return fmt.Errorf("failed to load configuration: %w", err)
 	}
 
-	// Setup logger
-	logger, err := logging.New(cfg.LogLevel, cfg.LogFormat)
+	// Setup logger, with a named logger per subsystem
+	samplingInitial, samplingThereafter, err := logging.ParseSampling(cfg.LogSampling)
 	if err != nil {
 		return fmt.Errorf("failed to setup logger: %w", err)
 	}
+	loggers, err := logging.NewLoggers(cfg.LogLevel, cfg.LogFormat, cfg.LogLevelOverrides(),
+		logging.WithDebugSampling(samplingInitial, samplingThereafter),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to setup logger: %w", err)
+	}
+	logger := loggers.Root
 	defer func() {
 		_ = logger.Sync()
 	}()
 
+	// Log panics in run through zap. Registered before the services so their
+	// deferred Close calls still run while the panic unwinds.
+	defer recovery.Recover(logger, "main", &err)
+
 	logger.Info("starting nefit-homekit",
 		zap.String("log_level", cfg.LogLevel),
 		zap.String("log_format", cfg.LogFormat),
+		zap.Any("log_level_overrides", cfg.LogLevelOverrides()),
+		zap.String("log_sampling", cfg.LogSampling),
 		zap.String("nefit_serial", cfg.NefitSerial),
 		zap.Int("hap_port", cfg.HAPPort),
 		zap.Int("web_port", cfg.WebPort),
 	)
 
+	if *resetHomeKit && *importPairing != "" {
+		return errors.New("-reset-homekit and -import-pairing cannot be combined")
+	}
+
+	if *exportPairing != "" {
+		if err := exportHomeKitPairing(cfg.HAPStoragePath, *exportPairing); err != nil {
+			return err
+		}
+		logger.Info("exported homekit identity and pairings",
+			zap.String("storage_path", cfg.HAPStoragePath),
+			zap.String("file", *exportPairing),
+		)
+		return nil
+	}
+
+	if *importPairing != "" {
+		if err := importHomeKitPairing(cfg.HAPStoragePath, *importPairing); err != nil {
+			return err
+		}
+		logger.Warn("restored homekit identity and pairings from backup",
+			zap.String("storage_path", cfg.HAPStoragePath),
+			zap.String("file", *importPairing),
+		)
+	}
+
+	if *resetHomeKit {
+		if err := homekit.Reset(cfg.HAPStoragePath); err != nil {
+			return fmt.Errorf("failed to reset homekit identity: %w", err)
+		}
+		logger.Warn("reset homekit identity, remove the old accessory from the Home app and pair again",
+			zap.String("storage_path", cfg.HAPStoragePath),
+		)
+	}
+
 	// Initialize EventBus
 	logger.Info("initializing eventbus")
-	bus, err := events.New(logger)
+	bus, err := events.New(loggers.Named(logging.SubsystemEvents),
+		events.WithDedupScope(events.DedupScope(cfg.EventBusDedupScope)),
+		events.WithMinPublishInterval(cfg.EventBusMinPublishInterval),
+		events.WithStatsFile(cfg.StatsFilePath),
+	)
 	if err != nil {
 		return fmt.Errorf("failed to create eventbus: %w", err)
 	}
@@ -62,7 +141,7 @@ func run() error {
 
 	// Initialize Nefit client
 	logger.Info("initializing nefit client")
-	nefitClient, err := nefit.New(cfg, logger, bus)
+	nefitClient, err := nefit.New(cfg, loggers.Named(logging.SubsystemNefit), bus)
 	if err != nil {
 		return fmt.Errorf("failed to create nefit client: %w", err)
 	}
@@ -73,7 +152,7 @@ func run() error {
 
 	// Initialize HomeKit server
 	logger.Info("initializing homekit server")
-	homekitServer, err := homekit.New(cfg, logger, bus)
+	homekitServer, err := homekit.New(cfg, loggers.Named(logging.SubsystemHomeKit), bus)
 	if err != nil {
 		return fmt.Errorf("failed to create homekit server: %w", err)
 	}
@@ -84,7 +163,7 @@ func run() error {
 
 	// Initialize Web server
 	logger.Info("initializing web server")
-	webServer, err := web.New(cfg, logger, bus)
+	webServer, err := web.New(cfg, loggers.Named(logging.SubsystemWeb), bus)
 	if err != nil {
 		return fmt.Errorf("failed to create web server: %w", err)
 	}
@@ -93,9 +172,48 @@ func run() error {
 		_ = webServer.Close()
 	}()
 
+	// Initialize metrics collector, registered on the default registry
+	// served on /metrics. Fahrenheit deployments get Fahrenheit gauges too.
+	logger.Info("initializing metrics collector")
+	var metricsOpts []metrics.Option
+	if cfg.WebDisplayUnit == "fahrenheit" {
+		metricsOpts = append(metricsOpts, metrics.WithFahrenheit())
+	}
+	metricsCollector, err := metrics.New(loggers.Named(logging.SubsystemMetrics), bus, prometheus.DefaultRegisterer, metricsOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to create metrics collector: %w", err)
+	}
+	defer func() {
+		logger.Info("closing metrics collector")
+		_ = metricsCollector.Close()
+	}()
+
+	// Initialize time-series exporter
+	logger.Info("initializing time-series exporter", zap.String("sink", cfg.TimeSeriesSink))
+	sink, err := timeseries.NewSink(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create time-series sink: %w", err)
+	}
+	exporter, err := timeseries.New(loggers.Named(logging.SubsystemMetrics), bus, sink)
+	if err != nil {
+		return fmt.Errorf("failed to create time-series exporter: %w", err)
+	}
+	defer func() {
+		logger.Info("closing time-series exporter")
+		_ = exporter.Close()
+	}()
+
 	// Start all services
 	logger.Info("starting services")
 
+	if err := metricsCollector.Start(); err != nil {
+		return fmt.Errorf("failed to start metrics collector: %w", err)
+	}
+
+	if err := exporter.Start(); err != nil {
+		return fmt.Errorf("failed to start time-series exporter: %w", err)
+	}
+
 	if err := nefitClient.Start(); err != nil {
 		return fmt.Errorf("failed to start nefit client: %w", err)
 	}
@@ -112,22 +230,33 @@ func run() error {
 		zap.Int("hap_port", cfg.HAPPort),
 		zap.Int("web_port", cfg.WebPort),
 	)
+	setupURI, err := homekitServer.SetupURI()
+	if err != nil {
+		logger.Warn("failed to build homekit setup URI", zap.Error(err))
+	}
 	logger.Info("homekit pairing",
 		zap.String("pin", cfg.HAPPin),
-		zap.String("instructions", "Use the Home app to add accessory with PIN"),
+		zap.String("setup_uri", setupURI),
+		zap.String("instructions", "Use the Home app to add accessory with PIN or a QR code of the setup URI"),
 	)
 	logger.Info("web interface",
-		zap.String("url", fmt.Sprintf("http://localhost:%d", cfg.WebPort)),
+		zap.String("url", fmt.Sprintf("http://localhost:%d%s/", cfg.WebPort, strings.TrimRight(cfg.WebBasePath, "/"))),
 	)
 
 	// Wait for shutdown signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	sig := <-sigChan
-	logger.Info("received shutdown signal",
-		zap.String("signal", sig.String()),
-	)
+	var runErr error
+	select {
+	case sig := <-sigChan:
+		logger.Info("received shutdown signal",
+			zap.String("signal", sig.String()),
+		)
+	case <-recovery.Panicked():
+		logger.Error("background goroutine panicked, shutting down")
+		runErr = errors.New("background goroutine panicked")
+	}
 
 	// Graceful shutdown
 	logger.Info("shutting down gracefully")
@@ -149,5 +278,41 @@ func run() error {
 		logger.Warn("shutdown timeout exceeded, forcing exit")
 	}
 
+	return runErr
+}
+
+// exportHomeKitPairing writes the HomeKit pairing backup to path. The file is
+// only readable by the owner as it holds the accessory's private key.
+func exportHomeKitPairing(storagePath, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create pairing backup: %w", err)
+	}
+	if err := homekit.ExportPairing(storagePath, f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return fmt.Errorf("failed to export homekit pairing: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write pairing backup: %w", err)
+	}
+
+	return nil
+}
+
+// importHomeKitPairing restores the HomeKit pairing backup at path.
+func importHomeKitPairing(storagePath, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open pairing backup: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if err := homekit.ImportPairing(storagePath, f); err != nil {
+		return fmt.Errorf("failed to import homekit pairing: %w", err)
+	}
+
 	return nil
 }
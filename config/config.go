@@ -3,10 +3,17 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/Netflix/go-env"
+	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/logging"
 )
 
 // Config holds all configuration for the nefit-homekit application.
@@ -16,33 +23,265 @@ type Config struct {
 	NefitAccessKey string `env:"NEFITHK_NEFIT_ACCESS_KEY,required=true"`
 	NefitPassword  string `env:"NEFITHK_NEFIT_PASSWORD,required=true"`
 
+	// Without a successful connection within this period after startup, the
+	// credentials are likely wrong and setup help is shown
+	NefitStartupGracePeriod time.Duration `env:"NEFITHK_NEFIT_STARTUP_GRACE_PERIOD,default=2m"`
+
+	// Creating the Nefit client is retried on transient errors, so a brief
+	// problem at boot does not abort startup. 0 tries once, like 1.
+	NefitCreateAttempts   int           `env:"NEFITHK_NEFIT_CREATE_ATTEMPTS,default=3"`
+	NefitCreateRetryDelay time.Duration `env:"NEFITHK_NEFIT_CREATE_RETRY_DELAY,default=2s"`
+
 	// HomeKit Configuration
 	HAPPin         string `env:"NEFITHK_HAP_PIN,default=00102003"`
 	HAPStoragePath string `env:"NEFITHK_HAP_STORAGE_PATH,default=/var/lib/nefit-homekit"`
 	HAPPort        int    `env:"NEFITHK_HAP_PORT,default=12345"`
+	HAPSetupID     string `env:"NEFITHK_HAP_SETUP_ID"`
+
+	// Step in Celsius for target temperatures, shared by the HomeKit accessory,
+	// the web UI slider and the setpoints written to Nefit so they agree
+	TargetTemperatureStep float64 `env:"NEFITHK_TARGET_TEMPERATURE_STEP,default=0.5"`
+
+	// Names shown in the Home app. The bridge name is advertised while pairing
+	// once the server is a bridge, otherwise the thermostat name is.
+	HAPThermostatName string `env:"NEFITHK_HAP_THERMOSTAT_NAME,default=Nefit Easy"`
+	HAPBridgeName     string `env:"NEFITHK_HAP_BRIDGE_NAME,default=Nefit Bridge"`
+
+	// Extra accessories exposed next to the thermostat. Enabling any of them
+	// turns the server into a bridge, which requires pairing again.
+	HAPOutdoorTemperatureEnabled bool `env:"NEFITHK_HAP_OUTDOOR_TEMPERATURE_ENABLED,default=false"`
+
+	// Shows the humidity measured by the thermostat on its tile. Not every
+	// thermostat has the sensor, so it is off unless asked for.
+	HAPHumidityEnabled bool `env:"NEFITHK_HAP_HUMIDITY_ENABLED,default=false"`
+
+	// A change of the heating state shown in HomeKit must hold this long, so a
+	// modulating boiler does not make it flicker, 0 shows every change. The
+	// status is polled every XMPPKeepaliveInterval, so the hold must be longer
+	// than that for a later poll to confirm a change.
+	HAPHeatingHysteresis time.Duration `env:"NEFITHK_HAP_HEATING_HYSTERESIS,default=90s"`
+
+	// Target shown in HomeKit while the thermostat is off, where Nefit reports
+	// its setback setpoint: "comfort" keeps the last comfort setpoint, "setback"
+	// shows the reported one and "fixed" shows HAPOffTargetTemperature, such as
+	// the frost protection temperature
+	HAPOffTarget            string  `env:"NEFITHK_HAP_OFF_TARGET,default=comfort"`
+	HAPOffTargetTemperature float64 `env:"NEFITHK_HAP_OFF_TARGET_TEMPERATURE,default=10"`
+
+	// With the Nefit connection down for this long, the accessories fail
+	// reads so the Home app shows them as not responding, 0 disables
+	HAPStaleTimeout time.Duration `env:"NEFITHK_HAP_STALE_TIMEOUT,default=30m"`
 
-	// Tailscale Configuration
+	// Tailscale Configuration. When enabled, the web interface is also served
+	// on port 80 of the bridge's own tailnet node.
 	TailscaleEnabled  bool   `env:"NEFITHK_TAILSCALE_ENABLED,default=false"`
 	TailscaleAuthKey  string `env:"NEFITHK_TAILSCALE_AUTHKEY"`
 	TailscaleHostname string `env:"NEFITHK_TAILSCALE_HOSTNAME,default=nefit-homekit"`
 
+	// Directory holding the tailnet node state, empty uses tsnet's default
+	// in the user config directory
+	TailscaleStateDir string `env:"NEFITHK_TAILSCALE_STATE_DIR"`
+
 	// Web Server Configuration
 	WebPort        int    `env:"NEFITHK_WEB_PORT,default=8080"`
 	WebBindAddress string `env:"NEFITHK_WEB_BIND_ADDRESS,default=0.0.0.0"`
+	WebDisplayUnit string `env:"NEFITHK_WEB_DISPLAY_UNIT,default=celsius"`
+	WebTitle       string `env:"NEFITHK_WEB_TITLE,default=Nefit Easy Thermostat"`
+
+	// Show the state without allowing changes, for a kiosk on a shared screen.
+	// The control endpoints answer 403 and the controls are disabled.
+	WebReadOnly bool `env:"NEFITHK_WEB_READONLY,default=false"`
+
+	// Largest request body accepted by the control endpoints, which only take
+	// small forms, larger ones answer 413. 0 disables the limit.
+	WebMaxBodyBytes int `env:"NEFITHK_WEB_MAX_BODY_BYTES,default=4096"`
+
+	// Path prefix for all web routes and links, for hosting behind a reverse
+	// proxy under a subpath such as /nefit. Empty serves from the root.
+	WebBasePath string `env:"NEFITHK_WEB_BASE_PATH"`
+
+	// SSE streams are closed when a write stalls for the write timeout, and after
+	// the max lifetime so clients reconnect, 0 disables the lifetime limit
+	WebSSEWriteTimeout time.Duration `env:"NEFITHK_WEB_SSE_WRITE_TIMEOUT,default=10s"`
+	WebSSEMaxLifetime  time.Duration `env:"NEFITHK_WEB_SSE_MAX_LIFETIME,default=1h"`
+
+	// SSE clients are sent the current state again at this interval, even when
+	// the eventbus deduplicated an identical update, so a client that missed
+	// one catches up. 0 only sends changes.
+	WebSSERefreshInterval time.Duration `env:"NEFITHK_WEB_SSE_REFRESH_INTERVAL,default=1m"`
+
+	// A command identical to the previous one of its type within this interval
+	// is dropped, such as a double-fired button, 0 publishes every command
+	WebCommandMinInterval time.Duration `env:"NEFITHK_WEB_COMMAND_MIN_INTERVAL,default=1s"`
+
+	// POST /admin/reconnect is refused within this interval of the previous
+	// accepted request, so it cannot be used to hammer the Nefit backend. The
+	// limit cannot be disabled, 0 uses the default.
+	WebReconnectMinInterval time.Duration `env:"NEFITHK_WEB_RECONNECT_MIN_INTERVAL,default=1m"`
+
+	// Temperature history kept for the web UI chart, one sample per interval,
+	// the default covers a day. A size of 0 disables the history.
+	WebHistorySize     int           `env:"NEFITHK_WEB_HISTORY_SIZE,default=288"`
+	WebHistoryInterval time.Duration `env:"NEFITHK_WEB_HISTORY_INTERVAL,default=5m"`
 
-	// XMPP Connection Configuration
+	// Setpoints in Celsius applied by POST /api/presence when home or away, so
+	// an external automation can drive geofencing. Both 0 disables presence.
+	PresenceComfortTemperature float64 `env:"NEFITHK_PRESENCE_COMFORT_TEMPERATURE"`
+	PresenceSetbackTemperature float64 `env:"NEFITHK_PRESENCE_SETBACK_TEMPERATURE"`
+
+	// XMPP Connection Configuration. The keepalive must be shorter than the max
+	// reconnect wait, so a dead connection is noticed before a reconnect would
+	// have been tried anyway.
 	XMPPKeepaliveInterval time.Duration `env:"NEFITHK_XMPP_KEEPALIVE_INTERVAL,default=30s"`
 	XMPPReconnectBackoff  time.Duration `env:"NEFITHK_XMPP_RECONNECT_BACKOFF,default=5s"`
 	XMPPMaxReconnectWait  time.Duration `env:"NEFITHK_XMPP_MAX_RECONNECT_WAIT,default=5m"`
 
+	// Gas usage is a larger read recorded once a day, so it is polled separately
+	// from the status and much less often, 0 disables it
+	EnergyPollInterval time.Duration `env:"NEFITHK_ENERGY_POLL_INTERVAL,default=1h"`
+
+	// Read and allow setting the boiler supply temperature setpoint, for tuning
+	// weather compensation. A poor setpoint hurts efficiency, so it is off
+	// unless asked for.
+	AdvancedSupplySetpointEnabled bool `env:"NEFITHK_ADVANCED_SUPPLY_SETPOINT_ENABLED,default=false"`
+
+	// Setpoint in Celsius and mode (heat, off or auto) written once on the first
+	// connect after startup, so the boiler is in a known state after a power
+	// outage. 0 and empty leave the thermostat as it is.
+	StartupSetpoint float64 `env:"NEFITHK_STARTUP_SETPOINT"`
+	StartupMode     string  `env:"NEFITHK_STARTUP_MODE"`
+
+	// Comma-separated code=meaning entries for the boiler indicator Nefit
+	// reports, added to or replacing the built-in CH=heating, HW=hotwater and
+	// No=idle, for boilers that report other codes. Meanings are "heating",
+	// "hotwater" and "idle".
+	BoilerIndicators string `env:"NEFITHK_BOILER_INDICATORS"`
+
+	// Comma-separated command sources the Nefit client executes commands from,
+	// such as "homekit" to make the web UI view-only. Empty allows all sources.
+	AllowedCommandSources string `env:"NEFITHK_ALLOWED_COMMAND_SOURCES"`
+
+	// Command Queue Configuration
+	CommandQueueEnabled bool          `env:"NEFITHK_COMMAND_QUEUE_ENABLED,default=false"`
+	CommandQueueMaxAge  time.Duration `env:"NEFITHK_COMMAND_QUEUE_MAX_AGE,default=2m"`
+
+	// Mode changes within this window are collapsed into one backend write, 0 disables
+	ModeChangeDebounce time.Duration `env:"NEFITHK_MODE_CHANGE_DEBOUNCE,default=2s"`
+
+	// Setpoint changes larger than the ramp step are written in steps of this
+	// many Celsius, one per interval, so a big jump does not short-cycle the
+	// boiler. 0 disables ramping.
+	SetpointRampStep     float64       `env:"NEFITHK_SETPOINT_RAMP_STEP,default=0"`
+	SetpointRampInterval time.Duration `env:"NEFITHK_SETPOINT_RAMP_INTERVAL,default=2m"`
+
+	// Time-series export: every state update is written as a sample to this
+	// sink, "none", "stdout" or "influxdb"
+	TimeSeriesSink string `env:"NEFITHK_TIMESERIES_SINK,default=none"`
+
+	// InfluxDB 2.x write API, used by the "influxdb" sink
+	InfluxDBURL    string `env:"NEFITHK_INFLUXDB_URL"`
+	InfluxDBToken  string `env:"NEFITHK_INFLUXDB_TOKEN"`
+	InfluxDBOrg    string `env:"NEFITHK_INFLUXDB_ORG"`
+	InfluxDBBucket string `env:"NEFITHK_INFLUXDB_BUCKET"`
+
 	// EventBus Configuration
-	EventBusDebugEnabled bool `env:"NEFITHK_EVENTBUS_DEBUG_ENABLED,default=true"`
+	EventBusDebugEnabled bool   `env:"NEFITHK_EVENTBUS_DEBUG_ENABLED,default=true"`
+	EventBusDedupScope   string `env:"NEFITHK_EVENTBUS_DEDUP_SCOPE,default=global"`
+
+	// Publish at most one state update per source in this interval, holding
+	// back the latest within it. Unlike deduplication this also limits
+	// changing values, such as a noisy reading. 0 disables it.
+	EventBusMinPublishInterval time.Duration `env:"NEFITHK_EVENTBUS_MIN_PUBLISH_INTERVAL,default=0"`
+
+	// On shutdown, write a JSON summary of event counts, reconnects and uptime
+	// to this file for your own records. Stats never leave the machine. Empty
+	// disables it.
+	StatsFilePath string `env:"NEFITHK_STATS_FILE"`
+
+	// Serve Go's pprof endpoints under /debug/pprof/ on the web server. They
+	// expose internals and have no authentication, so keep this off unless
+	// diagnosing a problem on a trusted network.
+	PprofEnabled bool `env:"NEFITHK_PPROF_ENABLED,default=false"`
+
+	// Serve the running configuration, secrets redacted, on GET /api/config.
+	// It describes the setup to anyone reaching the web port, so it is off
+	// unless asked for.
+	WebConfigEndpointEnabled bool `env:"NEFITHK_WEB_CONFIG_ENDPOINT_ENABLED,default=false"`
 
 	// Logging
 	LogLevel  string `env:"NEFITHK_LOG_LEVEL,default=info"`
 	LogFormat string `env:"NEFITHK_LOG_FORMAT,default=json"`
+
+	// Per-subsystem log levels, empty uses LogLevel
+	LogLevelEvents  string `env:"NEFITHK_LOG_LEVEL_EVENTS"`
+	LogLevelNefit   string `env:"NEFITHK_LOG_LEVEL_NEFIT"`
+	LogLevelHomeKit string `env:"NEFITHK_LOG_LEVEL_HOMEKIT"`
+	LogLevelWeb     string `env:"NEFITHK_LOG_LEVEL_WEB"`
+
+	// Sample debug logs as "initial,thereafter": each second, the first initial
+	// entries with the same message are logged, then every thereafter-th.
+	// Empty logs every entry.
+	LogSampling string `env:"NEFITHK_LOG_SAMPLING"`
+
+	// AllowRandomPorts accepts port 0 for HAPPort and WebPort, binding a random
+	// free port. It has no environment variable: only tests set it, so a
+	// production config with port 0 is rejected instead of serving on a port
+	// nobody can find.
+	AllowRandomPorts bool
+
+	// Log raw Nefit payloads at debug level. Payloads can contain personal data
+	// such as the thermostat location, so only enable this while debugging.
+	LogRawPayloads bool `env:"NEFITHK_LOG_RAW_PAYLOADS,default=false"`
 }
 
+// Errors returned by Validate, wrapped with the offending value, so callers
+// can tell them apart with errors.Is.
+var (
+	ErrInvalidHAPPin     = errors.New("invalid HAP pin")
+	ErrInvalidHAPSetupID = errors.New("invalid HAP setup ID")
+	ErrPortRange         = errors.New("port out of range")
+	ErrInvalidLogLevel   = errors.New("invalid log level")
+	ErrInvalidLogFormat  = errors.New("invalid log format")
+)
+
+// commandSources are the sources publishing commands, see AllowedCommandSources.
+var commandSources = []string{"homekit", "web", "presence"}
+
+// Values of HAPOffTarget. Empty is treated as HAPOffTargetComfort.
+const (
+	HAPOffTargetComfort = "comfort"
+	HAPOffTargetSetback = "setback"
+	HAPOffTargetFixed   = "fixed"
+)
+
+// Values of TimeSeriesSink. Empty is treated as TimeSeriesSinkNone.
+const (
+	TimeSeriesSinkNone     = "none"
+	TimeSeriesSinkStdout   = "stdout"
+	TimeSeriesSinkInfluxDB = "influxdb"
+)
+
+// Meanings of boiler indicator codes in BoilerIndicators.
+const (
+	BoilerIndicatorHeating  = "heating"
+	BoilerIndicatorHotWater = "hotwater"
+	BoilerIndicatorIdle     = "idle"
+)
+
+// boilerIndicatorMeanings are the meanings accepted in BoilerIndicators.
+var boilerIndicatorMeanings = []string{BoilerIndicatorHeating, BoilerIndicatorHotWater, BoilerIndicatorIdle}
+
+// hapPinPattern matches a normalized HAP pin: eight digits. Other characters
+// pass hap's own checks and only fail once a controller tries to pair.
+var hapPinPattern = regexp.MustCompile(`^[0-9]{8}$`)
+
+// hapSetupIDPattern matches a HomeKit setup ID: four uppercase alphanumeric characters.
+var hapSetupIDPattern = regexp.MustCompile(`^[0-9A-Z]{4}$`)
+
+// webBasePathPattern matches a web base path. The characters are limited so the
+// path can be used in ServeMux patterns and URLs without escaping.
+var webBasePathPattern = regexp.MustCompile(`^/[A-Za-z0-9._/-]*$`)
+
 // Load reads configuration from environment variables.
 func Load() (*Config, error) {
 	var cfg Config
@@ -59,31 +298,237 @@ func Load() (*Config, error) {
 	return &cfg, nil
 }
 
-// Validate checks that the configuration is valid.
+// Validate checks that the configuration is valid. The HAP pin is normalized
+// to its canonical form first, see NormalizeHAPPin.
+// Every problem is reported at once, joined with errors.Join, so errors.Is
+// still finds the sentinel errors of each.
 // Note: Required field validation is handled by go-env library.
 func (c *Config) Validate() error {
-	// Validate HAP pin format (must be 8 digits)
+	var errs []error
+	fail := func(err error) {
+		errs = append(errs, err)
+	}
+
+	// Validate HAP pin format (must be 8 digits), accepting it as shown on
+	// HomeKit labels such as 001-02-003
+	c.HAPPin = NormalizeHAPPin(c.HAPPin)
 	if len(c.HAPPin) != 8 {
-		return fmt.Errorf("HAP pin must be exactly 8 digits, got %d", len(c.HAPPin))
+		fail(fmt.Errorf("%w, must be exactly 8 digits, got %d", ErrInvalidHAPPin, len(c.HAPPin)))
+	} else if !hapPinPattern.MatchString(c.HAPPin) {
+		fail(fmt.Errorf("%w, must contain only digits, got %q", ErrInvalidHAPPin, c.HAPPin))
+	}
+
+	// Validate HAP setup ID format, if set
+	if c.HAPSetupID != "" && !hapSetupIDPattern.MatchString(c.HAPSetupID) {
+		fail(fmt.Errorf("%w %q, must be 4 uppercase letters or digits", ErrInvalidHAPSetupID, c.HAPSetupID))
+	}
+
+	// Validate file and directory paths
+	if err := validatePaths(c.paths()); err != nil {
+		fail(err)
 	}
 
 	// Validate port ranges
-	if c.HAPPort < 1 || c.HAPPort > 65535 {
-		return fmt.Errorf("HAP port must be between 1 and 65535, got %d", c.HAPPort)
+	minPort := 1
+	if c.AllowRandomPorts {
+		minPort = 0
+	}
+	if c.HAPPort < minPort || c.HAPPort > 65535 {
+		fail(fmt.Errorf("HAP %w, must be between %d and 65535, got %d", ErrPortRange, minPort, c.HAPPort))
+	}
+	if c.WebPort < minPort || c.WebPort > 65535 {
+		fail(fmt.Errorf("web %w, must be between %d and 65535, got %d", ErrPortRange, minPort, c.WebPort))
+	}
+
+	// Validate the target temperature step, in tenths so the rounded values
+	// stay representable in HomeKit and the UI
+	step := c.TemperatureStep()
+	if step < 0.1 || step > 5 {
+		fail(fmt.Errorf("target temperature step must be between 0.1 and 5, got %g", step))
+	}
+	if tenths := step * 10; math.Abs(tenths-math.Round(tenths)) > 1e-9 {
+		fail(fmt.Errorf("target temperature step must be a multiple of 0.1, got %g", step))
+	}
+
+	// Validate web display unit
+	validDisplayUnits := map[string]bool{
+		"celsius":    true,
+		"fahrenheit": true,
 	}
-	if c.WebPort < 1 || c.WebPort > 65535 {
-		return fmt.Errorf("web port must be between 1 and 65535, got %d", c.WebPort)
+	if !validDisplayUnits[c.WebDisplayUnit] {
+		fail(fmt.Errorf("invalid web display unit %q, must be one of: celsius, fahrenheit", c.WebDisplayUnit))
 	}
 
 	// Validate timing configurations
+	if c.NefitStartupGracePeriod < 0 {
+		fail(fmt.Errorf("nefit startup grace period must not be negative, got %s", c.NefitStartupGracePeriod))
+	}
+	if c.NefitCreateAttempts < 0 {
+		fail(fmt.Errorf("nefit create attempts must not be negative, got %d", c.NefitCreateAttempts))
+	}
+	if c.NefitCreateRetryDelay < 0 {
+		fail(fmt.Errorf("nefit create retry delay must not be negative, got %s", c.NefitCreateRetryDelay))
+	}
 	if c.XMPPKeepaliveInterval < time.Second {
-		return fmt.Errorf("XMPP keepalive interval must be at least 1 second, got %s", c.XMPPKeepaliveInterval)
+		fail(fmt.Errorf("XMPP keepalive interval must be at least 1 second, got %s", c.XMPPKeepaliveInterval))
 	}
 	if c.XMPPReconnectBackoff < time.Second {
-		return fmt.Errorf("XMPP reconnect backoff must be at least 1 second, got %s", c.XMPPReconnectBackoff)
+		fail(fmt.Errorf("XMPP reconnect backoff must be at least 1 second, got %s", c.XMPPReconnectBackoff))
 	}
 	if c.XMPPMaxReconnectWait < c.XMPPReconnectBackoff {
-		return fmt.Errorf("XMPP max reconnect wait (%s) must be >= reconnect backoff (%s)", c.XMPPMaxReconnectWait, c.XMPPReconnectBackoff)
+		fail(fmt.Errorf("XMPP max reconnect wait (%s) must be >= reconnect backoff (%s)", c.XMPPMaxReconnectWait, c.XMPPReconnectBackoff))
+	}
+	if c.XMPPKeepaliveInterval >= c.XMPPMaxReconnectWait {
+		fail(fmt.Errorf("XMPP keepalive interval (%s) must be less than max reconnect wait (%s)", c.XMPPKeepaliveInterval, c.XMPPMaxReconnectWait))
+	}
+	if c.EnergyPollInterval < 0 || (c.EnergyPollInterval > 0 && c.EnergyPollInterval < time.Minute) {
+		fail(fmt.Errorf("energy poll interval must be 0 or at least 1 minute, got %s", c.EnergyPollInterval))
+	}
+
+	// Validate web base path, which is used as a route prefix
+	if c.WebBasePath != "" && !webBasePathPattern.MatchString(c.WebBasePath) {
+		fail(fmt.Errorf("web base path must start with / and contain only letters, digits, '-', '_', '.' and '/', got %q", c.WebBasePath))
+	}
+
+	// Validate the request body limit
+	if c.WebMaxBodyBytes < 0 {
+		fail(fmt.Errorf("web max body bytes must not be negative, got %d", c.WebMaxBodyBytes))
+	}
+
+	// Validate SSE stream limits
+	if c.WebSSEWriteTimeout < time.Second {
+		fail(fmt.Errorf("web SSE write timeout must be at least 1 second, got %s", c.WebSSEWriteTimeout))
+	}
+	if c.WebSSERefreshInterval < 0 {
+		fail(fmt.Errorf("web SSE refresh interval must not be negative, got %s", c.WebSSERefreshInterval))
+	}
+	if c.WebSSEMaxLifetime < 0 {
+		fail(fmt.Errorf("web SSE max lifetime must not be negative, got %s", c.WebSSEMaxLifetime))
+	}
+
+	// Validate web history
+	if c.WebHistorySize < 0 {
+		fail(fmt.Errorf("web history size must not be negative, got %d", c.WebHistorySize))
+	}
+	if c.WebHistoryInterval < 0 {
+		fail(fmt.Errorf("web history interval must not be negative, got %s", c.WebHistoryInterval))
+	}
+
+	// Validate presence setpoints, within the range the thermostat accepts
+	if c.PresenceEnabled() {
+		if c.PresenceComfortTemperature < 10 || c.PresenceComfortTemperature > 30 {
+			fail(fmt.Errorf("presence comfort temperature must be between 10 and 30, got %g", c.PresenceComfortTemperature))
+		}
+		if c.PresenceSetbackTemperature < 10 || c.PresenceSetbackTemperature > 30 {
+			fail(fmt.Errorf("presence setback temperature must be between 10 and 30, got %g", c.PresenceSetbackTemperature))
+		}
+		if c.PresenceSetbackTemperature > c.PresenceComfortTemperature {
+			fail(fmt.Errorf("presence setback temperature (%g) must not be above comfort temperature (%g)", c.PresenceSetbackTemperature, c.PresenceComfortTemperature))
+		}
+	}
+
+	// Validate startup settings, within the range the thermostat accepts
+	if c.StartupSetpoint != 0 && (c.StartupSetpoint < 10 || c.StartupSetpoint > 30) {
+		fail(fmt.Errorf("startup setpoint must be 0 or between 10 and 30, got %g", c.StartupSetpoint))
+	}
+	if c.StartupMode != "" {
+		if _, err := events.ParseMode(c.StartupMode); err != nil {
+			fail(fmt.Errorf("startup mode: %w", err))
+		}
+	}
+
+	// Validate command queue staleness window
+	if c.CommandQueueEnabled && c.CommandQueueMaxAge < time.Second {
+		fail(fmt.Errorf("command queue max age must be at least 1 second, got %s", c.CommandQueueMaxAge))
+	}
+
+	// Validate HomeKit heating state hysteresis
+	if c.HAPHeatingHysteresis < 0 {
+		fail(fmt.Errorf("HAP heating hysteresis must not be negative, got %s", c.HAPHeatingHysteresis))
+	}
+	if c.HAPHeatingHysteresis > 0 && c.HAPHeatingHysteresis <= c.XMPPKeepaliveInterval {
+		fail(fmt.Errorf("HAP heating hysteresis (%s) must be 0 or longer than XMPP keepalive interval (%s), which polls the status", c.HAPHeatingHysteresis, c.XMPPKeepaliveInterval))
+	}
+
+	// Validate the target shown in HomeKit while off, within the HomeKit
+	// target temperature range
+	switch c.HAPOffTarget {
+	case "", HAPOffTargetComfort, HAPOffTargetSetback:
+	case HAPOffTargetFixed:
+		if c.HAPOffTargetTemperature < 10 || c.HAPOffTargetTemperature > 30 {
+			fail(fmt.Errorf("HAP off target temperature must be between 10 and 30, got %g", c.HAPOffTargetTemperature))
+		}
+	default:
+		fail(fmt.Errorf("invalid HAP off target %q, must be one of: %s, %s, %s", c.HAPOffTarget, HAPOffTargetComfort, HAPOffTargetSetback, HAPOffTargetFixed))
+	}
+
+	// Validate HomeKit staleness timeout
+	if c.HAPStaleTimeout < 0 {
+		fail(fmt.Errorf("HAP stale timeout must not be negative, got %s", c.HAPStaleTimeout))
+	}
+
+	// Validate web command interval
+	if c.WebCommandMinInterval < 0 {
+		fail(fmt.Errorf("web command min interval must not be negative, got %s", c.WebCommandMinInterval))
+	}
+	if c.WebReconnectMinInterval != 0 && c.WebReconnectMinInterval < time.Second {
+		fail(fmt.Errorf("web reconnect min interval must be at least 1 second, got %s", c.WebReconnectMinInterval))
+	}
+
+	// Validate Tailscale, which needs an auth key to join the tailnet
+	if c.TailscaleEnabled && c.TailscaleAuthKey == "" {
+		fail(fmt.Errorf("tailscale auth key is required when tailscale is enabled, set NEFITHK_TAILSCALE_AUTHKEY"))
+	}
+
+	// Validate setpoint ramping, with steps that move at least one target
+	// temperature step once rounded
+	if c.SetpointRampStep != 0 && c.SetpointRampStep < step {
+		fail(fmt.Errorf("setpoint ramp step must be 0 or at least the target temperature step %g, got %g", step, c.SetpointRampStep))
+	}
+	if c.SetpointRampStep > 0 && c.SetpointRampInterval < time.Second {
+		fail(fmt.Errorf("setpoint ramp interval must be at least 1 second, got %s", c.SetpointRampInterval))
+	}
+
+	// Validate mode change debounce window
+	if c.ModeChangeDebounce < 0 {
+		fail(fmt.Errorf("mode change debounce must not be negative, got %s", c.ModeChangeDebounce))
+	}
+
+	// Validate allowed command sources
+	for _, source := range c.allowedCommandSources() {
+		if !slices.Contains(commandSources, source) {
+			fail(fmt.Errorf("invalid command source %q in NEFITHK_ALLOWED_COMMAND_SOURCES, must be one of: %s", source, strings.Join(commandSources, ", ")))
+		}
+	}
+
+	// Validate boiler indicator overrides
+	if _, err := c.BoilerIndicatorOverrides(); err != nil {
+		fail(err)
+	}
+
+	// Validate the time-series sink
+	switch c.TimeSeriesSink {
+	case "", TimeSeriesSinkNone, TimeSeriesSinkStdout:
+	case TimeSeriesSinkInfluxDB:
+		if c.InfluxDBURL == "" || c.InfluxDBBucket == "" {
+			fail(fmt.Errorf("influxdb time-series sink requires NEFITHK_INFLUXDB_URL and NEFITHK_INFLUXDB_BUCKET"))
+		}
+	default:
+		fail(fmt.Errorf("invalid time-series sink %q, must be one of: %s, %s, %s", c.TimeSeriesSink, TimeSeriesSinkNone, TimeSeriesSinkStdout, TimeSeriesSinkInfluxDB))
+	}
+
+	// Validate eventbus publish interval
+	if c.EventBusMinPublishInterval < 0 {
+		fail(fmt.Errorf("eventbus min publish interval must not be negative, got %s", c.EventBusMinPublishInterval))
+	}
+
+	// Validate eventbus dedup scope
+	validDedupScopes := map[string]bool{
+		"global": true,
+		"source": true,
+	}
+	if !validDedupScopes[c.EventBusDedupScope] {
+		fail(fmt.Errorf("invalid eventbus dedup scope %q, must be one of: global, source", c.EventBusDedupScope))
 	}
 
 	// Validate log level
@@ -94,7 +539,12 @@ func (c *Config) Validate() error {
 		"error": true,
 	}
 	if !validLogLevels[c.LogLevel] {
-		return fmt.Errorf("invalid log level %q, must be one of: debug, info, warn, error", c.LogLevel)
+		fail(fmt.Errorf("%w %q, must be one of: debug, info, warn, error", ErrInvalidLogLevel, c.LogLevel))
+	}
+	for subsystem, level := range c.LogLevelOverrides() {
+		if !validLogLevels[level] {
+			fail(fmt.Errorf("%w %q for %s, must be one of: debug, info, warn, error", ErrInvalidLogLevel, level, subsystem))
+		}
 	}
 
 	// Validate log format
@@ -103,8 +553,121 @@ func (c *Config) Validate() error {
 		"console": true,
 	}
 	if !validLogFormats[c.LogFormat] {
-		return fmt.Errorf("invalid log format %q, must be one of: json, console", c.LogFormat)
+		fail(fmt.Errorf("%w %q, must be one of: json, console", ErrInvalidLogFormat, c.LogFormat))
+	}
+
+	// Validate log sampling
+	if _, _, err := logging.ParseSampling(c.LogSampling); err != nil {
+		fail(err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// LogLevelOverrides returns the configured per-subsystem log levels keyed by
+// subsystem name, leaving out subsystems that use the global level.
+func (c *Config) LogLevelOverrides() map[string]string {
+	overrides := make(map[string]string)
+	for subsystem, level := range map[string]string{
+		logging.SubsystemEvents:  c.LogLevelEvents,
+		logging.SubsystemNefit:   c.LogLevelNefit,
+		logging.SubsystemHomeKit: c.LogLevelHomeKit,
+		logging.SubsystemWeb:     c.LogLevelWeb,
+	} {
+		if level != "" {
+			overrides[subsystem] = level
+		}
+	}
+
+	return overrides
+}
+
+// CommandSourceAllowed reports whether commands from source are executed, see
+// AllowedCommandSources.
+func (c *Config) CommandSourceAllowed(source string) bool {
+	allowed := c.allowedCommandSources()
+	return len(allowed) == 0 || slices.Contains(allowed, source)
+}
+
+// allowedCommandSources returns the entries of AllowedCommandSources, nil
+// when all sources are allowed.
+func (c *Config) allowedCommandSources() []string {
+	var sources []string
+	for _, source := range strings.Split(c.AllowedCommandSources, ",") {
+		if source = strings.TrimSpace(source); source != "" {
+			sources = append(sources, source)
+		}
+	}
+
+	return sources
+}
+
+// BoilerIndicatorOverrides returns the meaning of each boiler indicator code
+// configured in BoilerIndicators, keyed by code.
+func (c *Config) BoilerIndicatorOverrides() (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, entry := range strings.Split(c.BoilerIndicators, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		code, meaning, ok := strings.Cut(entry, "=")
+		code, meaning = strings.TrimSpace(code), strings.TrimSpace(meaning)
+		if !ok || code == "" {
+			return nil, fmt.Errorf("invalid boiler indicator %q in NEFITHK_BOILER_INDICATORS, must be code=meaning", entry)
+		}
+		if !slices.Contains(boilerIndicatorMeanings, meaning) {
+			return nil, fmt.Errorf("invalid meaning %q for boiler indicator %s, must be one of: %s", meaning, code, strings.Join(boilerIndicatorMeanings, ", "))
+		}
+		overrides[code] = meaning
+	}
+
+	return overrides, nil
+}
+
+// NormalizeHAPPin returns pin without the hyphens and spaces users copy along
+// from HomeKit labels, turning 001-02-003 into 00102003.
+func NormalizeHAPPin(pin string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, pin)
+}
+
+// redacted replaces secrets in Redacted.
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the configuration with the Nefit credentials,
+// the HAP pin, the Tailscale auth key and the InfluxDB token masked, safe to
+// show when troubleshooting. Unset secrets stay empty, so it shows which are
+// missing.
+func (c *Config) Redacted() Config {
+	r := *c
+	for _, secret := range []*string{&r.NefitAccessKey, &r.NefitPassword, &r.HAPPin, &r.TailscaleAuthKey, &r.InfluxDBToken} {
+		if *secret != "" {
+			*secret = redacted
+		}
 	}
+	return r
+}
+
+// DefaultTargetTemperatureStep is the target temperature step used when
+// TargetTemperatureStep is unset, matching the Nefit Easy thermostat.
+const DefaultTargetTemperatureStep = 0.5
+
+// TemperatureStep returns the target temperature step in Celsius, or
+// DefaultTargetTemperatureStep when unset or 0.
+func (c *Config) TemperatureStep() float64 {
+	if c.TargetTemperatureStep == 0 {
+		return DefaultTargetTemperatureStep
+	}
+	return c.TargetTemperatureStep
+}
 
-	return nil
+// PresenceEnabled reports whether presence setpoints are configured. Setting
+// only one of them is rejected by Validate.
+func (c *Config) PresenceEnabled() bool {
+	return c.PresenceComfortTemperature != 0 || c.PresenceSetbackTemperature != 0
 }
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"testing"
 	"time"
@@ -12,6 +13,7 @@ func TestLoadConfig(t *testing.T) {
 		envVars map[string]string
 		wantErr bool
 		errMsg  string
+		errIs   error // Checked with errors.Is instead of errMsg
 	}{
 		{
 			name: "valid configuration with all required fields",
@@ -58,7 +60,7 @@ func TestLoadConfig(t *testing.T) {
 				"NEFITHK_HAP_PIN":          "123",
 			},
 			wantErr: true,
-			errMsg:  "HAP pin must be exactly 8 digits",
+			errIs:   ErrInvalidHAPPin,
 		},
 		{
 			name: "invalid HAP pin (too long)",
@@ -69,7 +71,39 @@ func TestLoadConfig(t *testing.T) {
 				"NEFITHK_HAP_PIN":          "123456789",
 			},
 			wantErr: true,
-			errMsg:  "HAP pin must be exactly 8 digits",
+			errIs:   ErrInvalidHAPPin,
+		},
+		{
+			name: "invalid HAP setup ID (lowercase)",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":     "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY": "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":   "password123",
+				"NEFITHK_HAP_SETUP_ID":     "ab12",
+			},
+			wantErr: true,
+			errIs:   ErrInvalidHAPSetupID,
+		},
+		{
+			name: "invalid HAP setup ID (too long)",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":     "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY": "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":   "password123",
+				"NEFITHK_HAP_SETUP_ID":     "AB123",
+			},
+			wantErr: true,
+			errIs:   ErrInvalidHAPSetupID,
+		},
+		{
+			name: "valid HAP setup ID",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":     "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY": "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":   "password123",
+				"NEFITHK_HAP_SETUP_ID":     "7XQ2",
+			},
+			wantErr: false,
 		},
 		{
 			name: "invalid HAP port (too low)",
@@ -80,7 +114,7 @@ func TestLoadConfig(t *testing.T) {
 				"NEFITHK_HAP_PORT":         "0",
 			},
 			wantErr: true,
-			errMsg:  "HAP port must be between 1 and 65535",
+			errIs:   ErrPortRange,
 		},
 		{
 			name: "invalid HAP port (too high)",
@@ -91,7 +125,7 @@ func TestLoadConfig(t *testing.T) {
 				"NEFITHK_HAP_PORT":         "65536",
 			},
 			wantErr: true,
-			errMsg:  "HAP port must be between 1 and 65535",
+			errIs:   ErrPortRange,
 		},
 		{
 			name: "invalid web port",
@@ -102,7 +136,194 @@ func TestLoadConfig(t *testing.T) {
 				"NEFITHK_WEB_PORT":         "100000",
 			},
 			wantErr: true,
-			errMsg:  "web port must be between 1 and 65535",
+			errIs:   ErrPortRange,
+		},
+		{
+			name: "command queue max age too short",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":          "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":      "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":        "password123",
+				"NEFITHK_COMMAND_QUEUE_ENABLED": "true",
+				"NEFITHK_COMMAND_QUEUE_MAX_AGE": "500ms",
+			},
+			wantErr: true,
+			errMsg:  "command queue max age must be at least 1 second",
+		},
+		{
+			name: "negative nefit startup grace period",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":               "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":           "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":             "password123",
+				"NEFITHK_NEFIT_STARTUP_GRACE_PERIOD": "-1m",
+			},
+			wantErr: true,
+			errMsg:  "nefit startup grace period must not be negative",
+		},
+		{
+			name: "negative nefit create attempts",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":          "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":      "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":        "password123",
+				"NEFITHK_NEFIT_CREATE_ATTEMPTS": "-1",
+			},
+			wantErr: true,
+			errMsg:  "nefit create attempts must not be negative",
+		},
+		{
+			name: "negative mode change debounce",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":         "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":     "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":       "password123",
+				"NEFITHK_MODE_CHANGE_DEBOUNCE": "-1s",
+			},
+			wantErr: true,
+			errMsg:  "mode change debounce must not be negative",
+		},
+		{
+			name: "negative eventbus min publish interval",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":                  "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":              "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":                "password123",
+				"NEFITHK_EVENTBUS_MIN_PUBLISH_INTERVAL": "-1s",
+			},
+			wantErr: true,
+			errMsg:  "eventbus min publish interval must not be negative",
+		},
+		{
+			name: "setpoint ramp step below target temperature step",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":       "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":   "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":     "password123",
+				"NEFITHK_SETPOINT_RAMP_STEP": "0.2",
+			},
+			wantErr: true,
+			errMsg:  "setpoint ramp step must be 0 or at least the target temperature step",
+		},
+		{
+			name: "setpoint ramp interval too short",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":           "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":       "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":         "password123",
+				"NEFITHK_SETPOINT_RAMP_STEP":     "1",
+				"NEFITHK_SETPOINT_RAMP_INTERVAL": "100ms",
+			},
+			wantErr: true,
+			errMsg:  "setpoint ramp interval must be at least 1 second",
+		},
+		{
+			name: "negative HAP heating hysteresis",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":           "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":       "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":         "password123",
+				"NEFITHK_HAP_HEATING_HYSTERESIS": "-1s",
+			},
+			wantErr: true,
+			errMsg:  "HAP heating hysteresis must not be negative",
+		},
+		{
+			name: "invalid log sampling",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":     "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY": "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":   "password123",
+				"NEFITHK_LOG_SAMPLING":     "100",
+			},
+			wantErr: true,
+			errMsg:  "invalid log sampling",
+		},
+		{
+			name: "negative HAP stale timeout",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":      "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":  "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":    "password123",
+				"NEFITHK_HAP_STALE_TIMEOUT": "-1m",
+			},
+			wantErr: true,
+			errMsg:  "HAP stale timeout must not be negative",
+		},
+		{
+			name: "negative web command min interval",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":             "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":         "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":           "password123",
+				"NEFITHK_WEB_COMMAND_MIN_INTERVAL": "-1s",
+			},
+			wantErr: true,
+			errMsg:  "web command min interval must not be negative",
+		},
+		{
+			name: "invalid web display unit",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":     "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY": "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":   "password123",
+				"NEFITHK_WEB_DISPLAY_UNIT": "kelvin",
+			},
+			wantErr: true,
+			errMsg:  "invalid web display unit",
+		},
+		{
+			name: "fahrenheit web display unit",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":     "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY": "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":   "password123",
+				"NEFITHK_WEB_DISPLAY_UNIT": "fahrenheit",
+			},
+			wantErr: false,
+		},
+		{
+			name: "web SSE write timeout too short",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":          "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":      "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":        "password123",
+				"NEFITHK_WEB_SSE_WRITE_TIMEOUT": "100ms",
+			},
+			wantErr: true,
+			errMsg:  "web SSE write timeout must be at least 1 second",
+		},
+		{
+			name: "negative web SSE max lifetime",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":         "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":     "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":       "password123",
+				"NEFITHK_WEB_SSE_MAX_LIFETIME": "-1m",
+			},
+			wantErr: true,
+			errMsg:  "web SSE max lifetime must not be negative",
+		},
+		{
+			name: "disabled web SSE max lifetime",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":         "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":     "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":       "password123",
+				"NEFITHK_WEB_SSE_MAX_LIFETIME": "0",
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative web history size",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":     "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY": "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":   "password123",
+				"NEFITHK_WEB_HISTORY_SIZE": "-1",
+			},
+			wantErr: true,
+			errMsg:  "web history size must not be negative",
 		},
 		{
 			name: "invalid log level",
@@ -115,6 +336,27 @@ func TestLoadConfig(t *testing.T) {
 			wantErr: true,
 			errMsg:  "invalid log level",
 		},
+		{
+			name: "subsystem log level override",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":     "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY": "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":   "password123",
+				"NEFITHK_LOG_LEVEL_NEFIT":  "debug",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid subsystem log level",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":     "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY": "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":   "password123",
+				"NEFITHK_LOG_LEVEL_WEB":    "verbose",
+			},
+			wantErr: true,
+			errMsg:  "invalid log level \"verbose\" for web",
+		},
 		{
 			name: "invalid log format",
 			envVars: map[string]string{
@@ -126,6 +368,115 @@ func TestLoadConfig(t *testing.T) {
 			wantErr: true,
 			errMsg:  "invalid log format",
 		},
+		{
+			name: "invalid eventbus dedup scope",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":         "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":     "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":       "password123",
+				"NEFITHK_EVENTBUS_DEDUP_SCOPE": "everything",
+			},
+			wantErr: true,
+			errMsg:  "invalid eventbus dedup scope",
+		},
+		{
+			name: "invalid HAP off target",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":     "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY": "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":   "password123",
+				"NEFITHK_HAP_OFF_TARGET":   "frost",
+			},
+			wantErr: true,
+			errMsg:  `invalid HAP off target "frost"`,
+		},
+		{
+			name: "fixed HAP off target out of range",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":               "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":           "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":             "password123",
+				"NEFITHK_HAP_OFF_TARGET":             "fixed",
+				"NEFITHK_HAP_OFF_TARGET_TEMPERATURE": "5",
+			},
+			wantErr: true,
+			errMsg:  "HAP off target temperature must be between 10 and 30",
+		},
+		{
+			name: "invalid startup mode",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":     "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY": "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":   "password123",
+				"NEFITHK_STARTUP_MODE":     "manual",
+			},
+			wantErr: true,
+			errMsg:  `startup mode: invalid mode "manual", must be one of: heat, off, auto`,
+		},
+		{
+			name: "startup setpoint out of range",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":     "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY": "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":   "password123",
+				"NEFITHK_STARTUP_SETPOINT": "35",
+			},
+			wantErr: true,
+			errMsg:  "startup setpoint must be 0 or between 10 and 30",
+		},
+		{
+			name: "invalid allowed command source",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":            "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":        "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":          "password123",
+				"NEFITHK_ALLOWED_COMMAND_SOURCES": "homekit,nefit",
+			},
+			wantErr: true,
+			errMsg:  `invalid command source "nefit"`,
+		},
+		{
+			name: "boiler indicator overrides",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":      "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":  "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":    "password123",
+				"NEFITHK_BOILER_INDICATORS": "DW=hotwater, OP = idle",
+			},
+			wantErr: false,
+		},
+		{
+			name: "boiler indicator without meaning",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":      "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":  "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":    "password123",
+				"NEFITHK_BOILER_INDICATORS": "DW",
+			},
+			wantErr: true,
+			errMsg:  `invalid boiler indicator "DW"`,
+		},
+		{
+			name: "unknown boiler indicator meaning",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":      "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":  "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":    "password123",
+				"NEFITHK_BOILER_INDICATORS": "DW=burning",
+			},
+			wantErr: true,
+			errMsg:  `invalid meaning "burning" for boiler indicator DW`,
+		},
+		{
+			name: "per-source eventbus dedup scope",
+			envVars: map[string]string{
+				"NEFITHK_NEFIT_SERIAL":         "123456789",
+				"NEFITHK_NEFIT_ACCESS_KEY":     "accesskey123",
+				"NEFITHK_NEFIT_PASSWORD":       "password123",
+				"NEFITHK_EVENTBUS_DEDUP_SCOPE": "source",
+			},
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -148,6 +499,9 @@ func TestLoadConfig(t *testing.T) {
 				if tt.errMsg != "" && !contains(err.Error(), tt.errMsg) {
 					t.Errorf("Load() error = %v, want error containing %q", err, tt.errMsg)
 				}
+				if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+					t.Errorf("Load() error = %v, want %v", err, tt.errIs)
+				}
 				return
 			}
 
@@ -183,19 +537,54 @@ func TestConfigDefaults(t *testing.T) {
 		got      interface{}
 		expected interface{}
 	}{
+		{"NefitStartupGracePeriod", cfg.NefitStartupGracePeriod, 2 * time.Minute},
+		{"NefitCreateAttempts", cfg.NefitCreateAttempts, 3},
+		{"NefitCreateRetryDelay", cfg.NefitCreateRetryDelay, 2 * time.Second},
 		{"HAPPin", cfg.HAPPin, "00102003"},
 		{"HAPStoragePath", cfg.HAPStoragePath, "/var/lib/nefit-homekit"},
 		{"HAPPort", cfg.HAPPort, 12345},
+		{"HAPSetupID", cfg.HAPSetupID, ""},
+		{"HAPThermostatName", cfg.HAPThermostatName, "Nefit Easy"},
+		{"HAPBridgeName", cfg.HAPBridgeName, "Nefit Bridge"},
+		{"HAPOutdoorTemperatureEnabled", cfg.HAPOutdoorTemperatureEnabled, false},
+		{"HAPHumidityEnabled", cfg.HAPHumidityEnabled, false},
+		{"WebConfigEndpointEnabled", cfg.WebConfigEndpointEnabled, false},
+		{"HAPOffTarget", cfg.HAPOffTarget, "comfort"},
+		{"HAPOffTargetTemperature", cfg.HAPOffTargetTemperature, 10.0},
+		{"TargetTemperatureStep", cfg.TargetTemperatureStep, 0.5},
 		{"TailscaleEnabled", cfg.TailscaleEnabled, false},
 		{"TailscaleHostname", cfg.TailscaleHostname, "nefit-homekit"},
 		{"WebPort", cfg.WebPort, 8080},
 		{"WebBindAddress", cfg.WebBindAddress, "0.0.0.0"},
+		{"WebDisplayUnit", cfg.WebDisplayUnit, "celsius"},
+		{"WebTitle", cfg.WebTitle, "Nefit Easy Thermostat"},
+		{"WebMaxBodyBytes", cfg.WebMaxBodyBytes, 4096},
+		{"WebSSEWriteTimeout", cfg.WebSSEWriteTimeout, 10 * time.Second},
+		{"WebSSEMaxLifetime", cfg.WebSSEMaxLifetime, time.Hour},
+		{"WebSSERefreshInterval", cfg.WebSSERefreshInterval, time.Minute},
+		{"WebCommandMinInterval", cfg.WebCommandMinInterval, time.Second},
+		{"WebReconnectMinInterval", cfg.WebReconnectMinInterval, time.Minute},
+		{"WebHistorySize", cfg.WebHistorySize, 288},
+		{"WebHistoryInterval", cfg.WebHistoryInterval, 5 * time.Minute},
 		{"XMPPKeepaliveInterval", cfg.XMPPKeepaliveInterval, 30 * time.Second},
 		{"XMPPReconnectBackoff", cfg.XMPPReconnectBackoff, 5 * time.Second},
 		{"XMPPMaxReconnectWait", cfg.XMPPMaxReconnectWait, 5 * time.Minute},
+		{"EnergyPollInterval", cfg.EnergyPollInterval, time.Hour},
+		{"AdvancedSupplySetpointEnabled", cfg.AdvancedSupplySetpointEnabled, false},
+		{"CommandQueueEnabled", cfg.CommandQueueEnabled, false},
+		{"CommandQueueMaxAge", cfg.CommandQueueMaxAge, 2 * time.Minute},
+		{"ModeChangeDebounce", cfg.ModeChangeDebounce, 2 * time.Second},
+		{"SetpointRampStep", cfg.SetpointRampStep, 0.0},
+		{"SetpointRampInterval", cfg.SetpointRampInterval, 2 * time.Minute},
+		{"TimeSeriesSink", cfg.TimeSeriesSink, "none"},
+		{"HAPHeatingHysteresis", cfg.HAPHeatingHysteresis, 90 * time.Second},
+		{"HAPStaleTimeout", cfg.HAPStaleTimeout, 30 * time.Minute},
 		{"EventBusDebugEnabled", cfg.EventBusDebugEnabled, true},
+		{"EventBusDedupScope", cfg.EventBusDedupScope, "global"},
+		{"EventBusMinPublishInterval", cfg.EventBusMinPublishInterval, time.Duration(0)},
 		{"LogLevel", cfg.LogLevel, "info"},
 		{"LogFormat", cfg.LogFormat, "json"},
+		{"LogRawPayloads", cfg.LogRawPayloads, false},
 	}
 
 	for _, tt := range tests {
@@ -240,6 +629,29 @@ func TestValidate_XMPPTimings(t *testing.T) {
 			wantErr:          true,
 			errMsg:           "XMPP max reconnect wait",
 		},
+		{
+			name:             "keepalive longer than max reconnect wait",
+			keepalive:        10 * time.Minute,
+			reconnectBackoff: 5 * time.Second,
+			maxReconnectWait: 5 * time.Minute,
+			wantErr:          true,
+			errMsg:           "XMPP keepalive interval (10m0s) must be less than max reconnect wait (5m0s)",
+		},
+		{
+			name:             "keepalive equal to max reconnect wait",
+			keepalive:        time.Minute,
+			reconnectBackoff: 5 * time.Second,
+			maxReconnectWait: time.Minute,
+			wantErr:          true,
+			errMsg:           "must be less than max reconnect wait",
+		},
+		{
+			name:             "keepalive just below max reconnect wait",
+			keepalive:        59 * time.Second,
+			reconnectBackoff: 5 * time.Second,
+			maxReconnectWait: time.Minute,
+			wantErr:          false,
+		},
 		{
 			name:             "valid timings",
 			keepalive:        30 * time.Second,
@@ -258,9 +670,12 @@ func TestValidate_XMPPTimings(t *testing.T) {
 				HAPPin:                "00102003",
 				HAPPort:               12345,
 				WebPort:               8080,
+				WebDisplayUnit:        "celsius",
+				WebSSEWriteTimeout:    10 * time.Second,
 				XMPPKeepaliveInterval: tt.keepalive,
 				XMPPReconnectBackoff:  tt.reconnectBackoff,
 				XMPPMaxReconnectWait:  tt.maxReconnectWait,
+				EventBusDedupScope:    "global",
 				LogLevel:              "info",
 				LogFormat:             "json",
 			}
@@ -284,20 +699,508 @@ func TestValidate_XMPPTimings(t *testing.T) {
 	}
 }
 
-// clearEnv clears all NEFITHK_* environment variables.
-func clearEnv(t *testing.T) {
-	t.Helper()
-	for _, env := range os.Environ() {
-		if len(env) > 14 && env[:14] == "NEFITHK_" {
-			key := env[:indexByte(env, '=')]
-			if err := os.Unsetenv(key); err != nil {
-				t.Fatalf("failed to unset env var %s: %v", key, err)
+func TestValidate_AllowRandomPorts(t *testing.T) {
+	tests := []struct {
+		name             string
+		allowRandomPorts bool
+		hapPort          int
+		webPort          int
+		wantErr          bool
+	}{
+		{
+			name:    "production rejects random HAP port",
+			hapPort: 0,
+			webPort: 8080,
+			wantErr: true,
+		},
+		{
+			name:    "production rejects random web port",
+			hapPort: 12345,
+			webPort: 0,
+			wantErr: true,
+		},
+		{
+			name:             "tests allow random ports",
+			allowRandomPorts: true,
+			hapPort:          0,
+			webPort:          0,
+			wantErr:          false,
+		},
+		{
+			name:             "tests still reject negative ports",
+			allowRandomPorts: true,
+			hapPort:          -1,
+			webPort:          0,
+			wantErr:          true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				NefitSerial:           "123456789",
+				NefitAccessKey:        "accesskey123",
+				NefitPassword:         "password123",
+				HAPPin:                "00102003",
+				HAPPort:               tt.hapPort,
+				WebPort:               tt.webPort,
+				WebDisplayUnit:        "celsius",
+				WebSSEWriteTimeout:    10 * time.Second,
+				XMPPKeepaliveInterval: 30 * time.Second,
+				XMPPReconnectBackoff:  5 * time.Second,
+				XMPPMaxReconnectWait:  5 * time.Minute,
+				EventBusDedupScope:    "global",
+				LogLevel:              "info",
+				LogFormat:             "json",
+				AllowRandomPorts:      tt.allowRandomPorts,
 			}
-		}
+
+			err := cfg.Validate()
+			if tt.wantErr {
+				if !errors.Is(err, ErrPortRange) {
+					t.Errorf("Validate() error = %v, want %v", err, ErrPortRange)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Validate() unexpected error = %v", err)
+			}
+		})
 	}
 }
 
-// contains checks if a string contains a substring.
+func TestValidate_Presence(t *testing.T) {
+	tests := []struct {
+		name    string
+		comfort float64
+		setback float64
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "disabled",
+			wantErr: false,
+		},
+		{
+			name:    "valid setpoints",
+			comfort: 21,
+			setback: 17,
+			wantErr: false,
+		},
+		{
+			name:    "only comfort set",
+			comfort: 21,
+			wantErr: true,
+			errMsg:  "presence setback temperature must be between 10 and 30",
+		},
+		{
+			name:    "comfort too high",
+			comfort: 31,
+			setback: 17,
+			wantErr: true,
+			errMsg:  "presence comfort temperature must be between 10 and 30",
+		},
+		{
+			name:    "setback above comfort",
+			comfort: 18,
+			setback: 20,
+			wantErr: true,
+			errMsg:  "must not be above comfort temperature",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				NefitSerial:                "123456789",
+				NefitAccessKey:             "accesskey123",
+				NefitPassword:              "password123",
+				HAPPin:                     "00102003",
+				HAPPort:                    12345,
+				WebPort:                    8080,
+				WebDisplayUnit:             "celsius",
+				WebSSEWriteTimeout:         10 * time.Second,
+				XMPPKeepaliveInterval:      30 * time.Second,
+				XMPPReconnectBackoff:       5 * time.Second,
+				XMPPMaxReconnectWait:       5 * time.Minute,
+				EventBusDedupScope:         "global",
+				LogLevel:                   "info",
+				LogFormat:                  "json",
+				PresenceComfortTemperature: tt.comfort,
+				PresenceSetbackTemperature: tt.setback,
+			}
+
+			err := cfg.Validate()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Validate() expected error containing %q, got nil", tt.errMsg)
+					return
+				}
+				if !contains(err.Error(), tt.errMsg) {
+					t.Errorf("Validate() error = %v, want error containing %q", err, tt.errMsg)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Validate() unexpected error = %v", err)
+			}
+		})
+	}
+}
+
+func TestValidate_WebBasePath(t *testing.T) {
+	tests := []struct {
+		basePath string
+		wantErr  bool
+	}{
+		{basePath: "", wantErr: false},
+		{basePath: "/nefit", wantErr: false},
+		{basePath: "/home/nefit-homekit/", wantErr: false},
+		{basePath: "nefit", wantErr: true},
+		{basePath: "/nefit/{id}", wantErr: true},
+		{basePath: "/nefit?x=1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.basePath, func(t *testing.T) {
+			cfg := &Config{
+				NefitSerial:           "123456789",
+				NefitAccessKey:        "accesskey123",
+				NefitPassword:         "password123",
+				HAPPin:                "00102003",
+				HAPPort:               12345,
+				WebPort:               8080,
+				WebDisplayUnit:        "celsius",
+				WebBasePath:           tt.basePath,
+				WebSSEWriteTimeout:    10 * time.Second,
+				XMPPKeepaliveInterval: 30 * time.Second,
+				XMPPReconnectBackoff:  5 * time.Second,
+				XMPPMaxReconnectWait:  5 * time.Minute,
+				EventBusDedupScope:    "global",
+				LogLevel:              "info",
+				LogFormat:             "json",
+			}
+
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Tailscale(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		authKey string
+		wantErr bool
+	}{
+		{name: "disabled without auth key", enabled: false, authKey: "", wantErr: false},
+		{name: "enabled with auth key", enabled: true, authKey: "tskey-auth-123", wantErr: false},
+		{name: "enabled without auth key", enabled: true, authKey: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				NefitSerial:           "123456789",
+				NefitAccessKey:        "accesskey123",
+				NefitPassword:         "password123",
+				HAPPin:                "00102003",
+				HAPPort:               12345,
+				TailscaleEnabled:      tt.enabled,
+				TailscaleAuthKey:      tt.authKey,
+				TailscaleHostname:     "nefit-homekit",
+				WebPort:               8080,
+				WebDisplayUnit:        "celsius",
+				WebSSEWriteTimeout:    10 * time.Second,
+				XMPPKeepaliveInterval: 30 * time.Second,
+				XMPPReconnectBackoff:  5 * time.Second,
+				XMPPMaxReconnectWait:  5 * time.Minute,
+				EventBusDedupScope:    "global",
+				LogLevel:              "info",
+				LogFormat:             "json",
+			}
+
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !contains(err.Error(), "NEFITHK_TAILSCALE_AUTHKEY") {
+				t.Errorf("Validate() error = %v, want it to name NEFITHK_TAILSCALE_AUTHKEY", err)
+			}
+		})
+	}
+}
+
+func TestValidate_TimeSeriesSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		sink    string
+		url     string
+		bucket  string
+		wantErr bool
+	}{
+		{name: "unset", sink: "", wantErr: false},
+		{name: "none", sink: TimeSeriesSinkNone, wantErr: false},
+		{name: "stdout", sink: TimeSeriesSinkStdout, wantErr: false},
+		{name: "influxdb", sink: TimeSeriesSinkInfluxDB, url: "http://influxdb:8086", bucket: "nefit", wantErr: false},
+		{name: "influxdb without url", sink: TimeSeriesSinkInfluxDB, bucket: "nefit", wantErr: true},
+		{name: "influxdb without bucket", sink: TimeSeriesSinkInfluxDB, url: "http://influxdb:8086", wantErr: true},
+		{name: "unknown", sink: "graphite", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				NefitSerial:           "123456789",
+				NefitAccessKey:        "accesskey123",
+				NefitPassword:         "password123",
+				HAPPin:                "00102003",
+				HAPPort:               12345,
+				WebPort:               8080,
+				WebDisplayUnit:        "celsius",
+				WebSSEWriteTimeout:    10 * time.Second,
+				XMPPKeepaliveInterval: 30 * time.Second,
+				XMPPReconnectBackoff:  5 * time.Second,
+				XMPPMaxReconnectWait:  5 * time.Minute,
+				TimeSeriesSink:        tt.sink,
+				InfluxDBURL:           tt.url,
+				InfluxDBBucket:        tt.bucket,
+				EventBusDedupScope:    "global",
+				LogLevel:              "info",
+				LogFormat:             "json",
+			}
+
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_EnergyPollInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		wantErr  bool
+	}{
+		{name: "disabled", interval: 0, wantErr: false},
+		{name: "one minute", interval: time.Minute, wantErr: false},
+		{name: "hourly", interval: time.Hour, wantErr: false},
+		{name: "too short", interval: 30 * time.Second, wantErr: true},
+		{name: "negative", interval: -time.Hour, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				NefitSerial:           "123456789",
+				NefitAccessKey:        "accesskey123",
+				NefitPassword:         "password123",
+				HAPPin:                "00102003",
+				HAPPort:               12345,
+				WebPort:               8080,
+				WebDisplayUnit:        "celsius",
+				WebSSEWriteTimeout:    10 * time.Second,
+				XMPPKeepaliveInterval: 30 * time.Second,
+				XMPPReconnectBackoff:  5 * time.Second,
+				XMPPMaxReconnectWait:  5 * time.Minute,
+				EnergyPollInterval:    tt.interval,
+				EventBusDedupScope:    "global",
+				LogLevel:              "info",
+				LogFormat:             "json",
+			}
+
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_HAPHeatingHysteresis(t *testing.T) {
+	tests := []struct {
+		name       string
+		hysteresis time.Duration
+		wantErr    bool
+	}{
+		{name: "disabled", hysteresis: 0, wantErr: false},
+		{name: "longer than keepalive", hysteresis: 90 * time.Second, wantErr: false},
+		{name: "equal to keepalive", hysteresis: 30 * time.Second, wantErr: true},
+		{name: "shorter than keepalive", hysteresis: 10 * time.Second, wantErr: true},
+		{name: "negative", hysteresis: -time.Second, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				NefitSerial:           "123456789",
+				NefitAccessKey:        "accesskey123",
+				NefitPassword:         "password123",
+				HAPPin:                "00102003",
+				HAPPort:               12345,
+				WebPort:               8080,
+				WebDisplayUnit:        "celsius",
+				WebSSEWriteTimeout:    10 * time.Second,
+				XMPPKeepaliveInterval: 30 * time.Second,
+				XMPPReconnectBackoff:  5 * time.Second,
+				XMPPMaxReconnectWait:  5 * time.Minute,
+				HAPHeatingHysteresis:  tt.hysteresis,
+				EventBusDedupScope:    "global",
+				LogLevel:              "info",
+				LogFormat:             "json",
+			}
+
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_TargetTemperatureStep(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    float64
+		wantErr bool
+	}{
+		{name: "unset uses default", step: 0, wantErr: false},
+		{name: "tenths", step: 0.1, wantErr: false},
+		{name: "half degrees", step: 0.5, wantErr: false},
+		{name: "whole degrees", step: 1.0, wantErr: false},
+		{name: "not a multiple of a tenth", step: 0.25, wantErr: true},
+		{name: "too small", step: 0.05, wantErr: true},
+		{name: "too large", step: 10, wantErr: true},
+		{name: "negative", step: -0.5, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				NefitSerial:           "123456789",
+				NefitAccessKey:        "accesskey123",
+				NefitPassword:         "password123",
+				HAPPin:                "00102003",
+				HAPPort:               12345,
+				TargetTemperatureStep: tt.step,
+				WebPort:               8080,
+				WebDisplayUnit:        "celsius",
+				WebSSEWriteTimeout:    10 * time.Second,
+				XMPPKeepaliveInterval: 30 * time.Second,
+				XMPPReconnectBackoff:  5 * time.Second,
+				XMPPMaxReconnectWait:  5 * time.Minute,
+				EventBusDedupScope:    "global",
+				LogLevel:              "info",
+				LogFormat:             "json",
+			}
+
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_HAPPin(t *testing.T) {
+	tests := []struct {
+		name    string
+		pin     string
+		want    string
+		wantErr bool
+		errMsg  string // Defaults to the length error
+	}{
+		{name: "canonical", pin: "00102003", want: "00102003"},
+		{name: "hyphenated", pin: "001-02-003", want: "00102003"},
+		{name: "spaces", pin: "001 02 003", want: "00102003"},
+		{name: "padded", pin: " 00102003 ", want: "00102003"},
+		{name: "too short after normalization", pin: "001-02-03", wantErr: true},
+		{name: "too long after normalization", pin: "001-02-0033", wantErr: true},
+		{name: "only separators", pin: "--- -- ---", wantErr: true},
+		{name: "empty", pin: "", wantErr: true},
+		{name: "letters", pin: "abcdefgh", wantErr: true, errMsg: "must contain only digits"},
+		{name: "digits and letters", pin: "0010200a", wantErr: true, errMsg: "must contain only digits"},
+		{name: "hyphenated with letters", pin: "001-02-00x", wantErr: true, errMsg: "must contain only digits"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				NefitSerial:           "123456789",
+				NefitAccessKey:        "accesskey123",
+				NefitPassword:         "password123",
+				HAPPin:                tt.pin,
+				HAPPort:               12345,
+				WebPort:               8080,
+				WebDisplayUnit:        "celsius",
+				WebSSEWriteTimeout:    10 * time.Second,
+				XMPPKeepaliveInterval: 30 * time.Second,
+				XMPPReconnectBackoff:  5 * time.Second,
+				XMPPMaxReconnectWait:  5 * time.Minute,
+				EventBusDedupScope:    "global",
+				LogLevel:              "info",
+				LogFormat:             "json",
+			}
+
+			err := cfg.Validate()
+			if tt.wantErr {
+				errMsg := tt.errMsg
+				if errMsg == "" {
+					errMsg = "must be exactly 8 digits"
+				}
+				if !errors.Is(err, ErrInvalidHAPPin) || !contains(err.Error(), errMsg) {
+					t.Errorf("Validate() error = %v, want %v containing %q", err, ErrInvalidHAPPin, errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() unexpected error = %v", err)
+			}
+			if cfg.HAPPin != tt.want {
+				t.Errorf("HAPPin = %q, want %q", cfg.HAPPin, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadNormalizesHAPPin(t *testing.T) {
+	clearEnv(t)
+
+	t.Setenv("NEFITHK_NEFIT_SERIAL", "123456789")
+	t.Setenv("NEFITHK_NEFIT_ACCESS_KEY", "accesskey123")
+	t.Setenv("NEFITHK_NEFIT_PASSWORD", "password123")
+	t.Setenv("NEFITHK_HAP_PIN", "001-02-003")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+	if cfg.HAPPin != "00102003" {
+		t.Errorf("HAPPin = %q, want 00102003", cfg.HAPPin)
+	}
+}
+
+// clearEnv clears all NEFITHK_* environment variables.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, env := range os.Environ() {
+		if len(env) > 14 && env[:14] == "NEFITHK_" {
+			key := env[:indexByte(env, '=')]
+			if err := os.Unsetenv(key); err != nil {
+				t.Fatalf("failed to unset env var %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// contains checks if a string contains a substring.
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && indexString(s, substr) >= 0
 }
@@ -328,3 +1231,82 @@ func indexString(s, substr string) int {
 	}
 	return -1
 }
+
+func TestLogLevelOverrides(t *testing.T) {
+	cfg := &Config{
+		LogLevel:        "info",
+		LogLevelNefit:   "debug",
+		LogLevelHomeKit: "warn",
+	}
+
+	got := cfg.LogLevelOverrides()
+	want := map[string]string{
+		"nefit":   "debug",
+		"homekit": "warn",
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("LogLevelOverrides() = %v, want %v", got, want)
+	}
+	for subsystem, level := range want {
+		if got[subsystem] != level {
+			t.Errorf("LogLevelOverrides()[%q] = %q, want %q", subsystem, got[subsystem], level)
+		}
+	}
+}
+
+func TestCommandSourceAllowed(t *testing.T) {
+	tests := []struct {
+		allowed string
+		source  string
+		want    bool
+	}{
+		{allowed: "", source: "web", want: true},
+		{allowed: "homekit", source: "homekit", want: true},
+		{allowed: "homekit", source: "web", want: false},
+		{allowed: " homekit , web ", source: "web", want: true},
+		{allowed: "homekit,", source: "", want: false},
+	}
+
+	for _, tt := range tests {
+		cfg := &Config{AllowedCommandSources: tt.allowed}
+		if got := cfg.CommandSourceAllowed(tt.source); got != tt.want {
+			t.Errorf("CommandSourceAllowed(%q) with %q = %v, want %v", tt.source, tt.allowed, got, tt.want)
+		}
+	}
+}
+
+func TestValidate_ReportsAllErrors(t *testing.T) {
+	cfg := &Config{
+		NefitSerial:           "123456789",
+		NefitAccessKey:        "accesskey123",
+		NefitPassword:         "password123",
+		HAPPin:                "1234",
+		HAPPort:               70000,
+		WebPort:               8080,
+		WebDisplayUnit:        "celsius",
+		WebSSEWriteTimeout:    10 * time.Second,
+		XMPPKeepaliveInterval: 30 * time.Second,
+		XMPPReconnectBackoff:  5 * time.Second,
+		XMPPMaxReconnectWait:  5 * time.Minute,
+		EventBusDedupScope:    "global",
+		LogLevel:              "verbose",
+		LogFormat:             "json",
+	}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() expected an error")
+	}
+
+	for _, want := range []error{ErrInvalidHAPPin, ErrPortRange, ErrInvalidLogLevel} {
+		if !errors.Is(err, want) {
+			t.Errorf("Validate() error = %v, want it to wrap %v", err, want)
+		}
+	}
+	for _, msg := range []string{"must be exactly 8 digits", "HAP port", `"verbose"`} {
+		if !contains(err.Error(), msg) {
+			t.Errorf("Validate() error = %v, want it to contain %q", err, msg)
+		}
+	}
+}
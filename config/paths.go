@@ -0,0 +1,118 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pathSetting is a configured file or directory path, named by its
+// environment variable for error messages.
+type pathSetting struct {
+	name string
+	path string
+	dir  bool // Directories own their contents, nothing else may live inside
+}
+
+// paths returns the configured file and directory paths, leaving out unset
+// ones. Settings for new files belong here so they are checked for collisions.
+func (c *Config) paths() []pathSetting {
+	var paths []pathSetting
+	for _, p := range []pathSetting{
+		{name: "NEFITHK_HAP_STORAGE_PATH", path: c.HAPStoragePath, dir: true},
+		{name: "NEFITHK_STATS_FILE", path: c.StatsFilePath},
+		{name: "NEFITHK_TAILSCALE_STATE_DIR", path: c.TailscaleStateDir, dir: true},
+	} {
+		if p.path != "" {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths
+}
+
+// validatePaths checks that no two paths are the same, that no path lies
+// inside a directory setting, and that each path could be created and, where
+// its directory exists, written.
+func validatePaths(paths []pathSetting) error {
+	abs := make([]string, len(paths))
+	for i, p := range paths {
+		a, err := filepath.Abs(p.path)
+		if err != nil {
+			return fmt.Errorf("invalid path %q for %s: %w", p.path, p.name, err)
+		}
+		abs[i] = a
+
+		if err := checkCreatable(p.name, a); err != nil {
+			return err
+		}
+		if err := checkWritable(p, a); err != nil {
+			return err
+		}
+	}
+
+	for i, p := range paths {
+		for j, other := range paths {
+			if i == j {
+				continue
+			}
+			if i < j && abs[i] == abs[j] {
+				return fmt.Errorf("%s and %s are both set to %q, they must be distinct", p.name, other.name, abs[i])
+			}
+			if p.dir && strings.HasPrefix(abs[j], abs[i]+string(filepath.Separator)) {
+				return fmt.Errorf("%s %q must not be inside %s %q", other.name, abs[j], p.name, abs[i])
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkCreatable returns an error when the nearest existing ancestor of path
+// is not a directory, so the path can never be created.
+func checkCreatable(name, path string) error {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		switch {
+		case err == nil && !info.IsDir():
+			return fmt.Errorf("%s %q cannot be created, %q is not a directory", name, path, dir)
+		case err == nil:
+			return nil
+		case !errors.Is(err, fs.ErrNotExist):
+			return fmt.Errorf("failed to check %s %q: %w", name, path, err)
+		}
+
+		if filepath.Dir(dir) == dir {
+			return nil
+		}
+	}
+}
+
+// checkWritable creates and removes a temporary file in the directory path is
+// written to: a directory setting itself, or the parent of a file. Missing
+// directories are left to startup, which creates them, and are often created
+// by the service manager beforehand.
+func checkWritable(p pathSetting, path string) error {
+	dir := filepath.Dir(path)
+	if p.dir {
+		dir = path
+	}
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil
+	}
+
+	f, err := os.CreateTemp(dir, ".nefithk-write-probe-*")
+	if err != nil {
+		return fmt.Errorf("%s %q is not writable: %w", p.name, path, err)
+	}
+	_ = f.Close()
+
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("failed to remove write probe for %s: %w", p.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidatePaths(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		paths   []pathSetting
+		wantErr string
+	}{
+		{
+			name: "distinct paths",
+			paths: []pathSetting{
+				{name: "NEFITHK_A", path: filepath.Join(root, "hap"), dir: true},
+				{name: "NEFITHK_B", path: filepath.Join(root, "stats.json")},
+			},
+		},
+		{
+			name: "missing parents are created later",
+			paths: []pathSetting{
+				{name: "NEFITHK_A", path: filepath.Join(root, "a", "b", "hap"), dir: true},
+			},
+		},
+		{
+			name: "same path",
+			paths: []pathSetting{
+				{name: "NEFITHK_A", path: filepath.Join(root, "data")},
+				{name: "NEFITHK_B", path: filepath.Join(root, "other", "..", "data")},
+			},
+			wantErr: "NEFITHK_A and NEFITHK_B are both set to",
+		},
+		{
+			name: "file inside directory setting",
+			paths: []pathSetting{
+				{name: "NEFITHK_B", path: filepath.Join(root, "hap", "stats.json")},
+				{name: "NEFITHK_A", path: filepath.Join(root, "hap"), dir: true},
+			},
+			wantErr: "NEFITHK_B",
+		},
+		{
+			name: "parent is a file",
+			paths: []pathSetting{
+				{name: "NEFITHK_A", path: filepath.Join(file, "hap"), dir: true},
+			},
+			wantErr: "is not a directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePaths(tt.paths)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validatePaths() unexpected error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validatePaths() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Write probes are removed again
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d entries in %s, want only %s", len(entries), root, file)
+	}
+}
+
+func TestValidatePathsReadOnlyDirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to read-only directories")
+	}
+
+	root := filepath.Join(t.TempDir(), "readonly")
+	if err := os.Mkdir(root, 0o500); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	for _, p := range []pathSetting{
+		{name: "NEFITHK_A", path: filepath.Join(root, "stats.json")},
+		{name: "NEFITHK_A", path: root, dir: true},
+	} {
+		err := validatePaths([]pathSetting{p})
+		if err == nil || !strings.Contains(err.Error(), "NEFITHK_A") || !strings.Contains(err.Error(), "not writable") {
+			t.Errorf("validatePaths(%q) error = %v, want a not writable error naming NEFITHK_A", p.path, err)
+		}
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%d files left in %s, want none", len(entries), root)
+	}
+}
+
+func TestValidateHAPStoragePath(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	cfg := &Config{HAPPin: "00102003", HAPStoragePath: filepath.Join(file, "hap")}
+
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "NEFITHK_HAP_STORAGE_PATH") {
+		t.Errorf("Validate() error = %v, want error naming NEFITHK_HAP_STORAGE_PATH", err)
+	}
+}
+
+func TestValidateStatsFileInsideHAPStorage(t *testing.T) {
+	storage := t.TempDir()
+	cfg := &Config{
+		HAPPin:         "00102003",
+		HAPStoragePath: storage,
+		StatsFilePath:  filepath.Join(storage, "stats.json"),
+	}
+
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "NEFITHK_STATS_FILE") {
+		t.Errorf("Validate() error = %v, want error naming NEFITHK_STATS_FILE", err)
+	}
+}
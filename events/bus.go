@@ -2,14 +2,20 @@ package events
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"sync"
+	"time"
 
+	"github.com/kradalby/nefit-homekit/clock"
 	"go.uber.org/zap"
 	"tailscale.com/util/eventbus"
 )
 
-// ClientName represents a named eventbus client.
+// ClientName represents a named eventbus client. Each subsystem publishes and
+// subscribes through its own client, so a consumer of an event type, such as
+// the command results, subscribes with its own name rather than a publisher's.
 type ClientName string
 
 const (
@@ -22,24 +28,108 @@ const (
 	// ClientWeb is the Web server client.
 	ClientWeb ClientName = "web"
 
-	// ClientMetrics is the metrics client.
+	// ClientMetrics is the metrics client, for consumers that only count events.
 	ClientMetrics ClientName = "metrics"
 )
 
+// DedupScope controls which previously published state a new state update
+// is compared against for deduplication.
+type DedupScope string
+
+const (
+	// DedupScopeGlobal deduplicates against the last state published by any source.
+	DedupScopeGlobal DedupScope = "global"
+
+	// DedupScopeSource deduplicates against the last state published by the same source,
+	// so a nefit confirmation is never hidden by an identical optimistic web update.
+	DedupScopeSource DedupScope = "source"
+)
+
+// Errors returned when registering or looking up clients.
+var (
+	ErrBusClosed        = errors.New("eventbus is closed")
+	ErrClientRegistered = errors.New("client already registered")
+	ErrClientNotFound   = errors.New("client not found")
+)
+
 // Bus manages the eventbus and named clients.
 type Bus struct {
-	bus       *eventbus.Bus
-	clients   map[ClientName]*eventbus.Client
-	mu        sync.RWMutex
-	logger    *zap.Logger
-	ctx       context.Context
-	cancel    context.CancelFunc
-	lastState *StateUpdateEvent // For deduplication
-	stateMu   sync.Mutex        // Protects lastState
+	bus          *eventbus.Bus
+	clients      map[ClientName]*eventbus.Client
+	mu           sync.RWMutex
+	logger       *zap.Logger
+	ctx          context.Context
+	cancel       context.CancelFunc
+	dedupScope   DedupScope
+	lastState    *StateUpdateEvent           // For deduplication
+	lastBySource map[string]StateUpdateEvent // For per-source deduplication
+	stateMu      sync.Mutex                  // Protects lastState, lastBySource and the rate limit state
+	history      *History                    // Recently published events
+	clock        clock.Clock                 // Stamps events published without a timestamp
+	publishers   map[publisherKey]any        // Reused publishers, *eventbus.Publisher[T]
+	pubMu        sync.Mutex                  // Protects publishers
+	stats        *stats                      // Counts published events for Stats
+	statsFile    string                      // Stats written here on Close, empty disables
+
+	// State updates from a source within minPublishInterval of its last
+	// published one are held back, the latest is published once it passes
+	minPublishInterval time.Duration
+	lastPublishAt      map[string]time.Time    // Last published state update per source
+	pendingStates      map[string]pendingState // Held back state update per source
+}
+
+// pendingState is a state update held back by the minimum publish interval.
+type pendingState struct {
+	client *eventbus.Client
+	event  StateUpdateEvent
+}
+
+// publisherKey identifies a cached publisher by client and event type.
+type publisherKey struct {
+	client    *eventbus.Client
+	eventType reflect.Type
+}
+
+// Option configures optional Bus behavior.
+type Option func(*Bus)
+
+// WithDedupScope sets the deduplication scope for state updates.
+// The default is DedupScopeGlobal.
+func WithDedupScope(scope DedupScope) Option {
+	return func(b *Bus) {
+		b.dedupScope = scope
+	}
+}
+
+// WithClock sets the clock used to timestamp published events and the event
+// history. The default is the real clock.
+func WithClock(c clock.Clock) Option {
+	return func(b *Bus) {
+		b.clock = c
+		b.history.clock = c
+	}
+}
+
+// WithMinPublishInterval publishes at most one state update per source in
+// each interval d. Updates within the interval are held back and only the
+// latest is published when it ends, so a noisy reading that passes
+// deduplication does not flood subscribers. 0, the default, disables it.
+func WithMinPublishInterval(d time.Duration) Option {
+	return func(b *Bus) {
+		b.minPublishInterval = d
+	}
+}
+
+// WithStatsFile writes the bus Stats as JSON to path when the bus is closed,
+// for keeping local records. Nothing is sent anywhere. An empty path disables it.
+func WithStatsFile(path string) Option {
+	return func(b *Bus) {
+		b.statsFile = path
+	}
 }
 
 // New creates a new eventbus with named clients.
-func New(logger *zap.Logger) (*Bus, error) {
+func New(logger *zap.Logger, opts ...Option) (*Bus, error) {
 	if logger == nil {
 		return nil, fmt.Errorf("logger is required")
 	}
@@ -49,11 +139,39 @@ func New(logger *zap.Logger) (*Bus, error) {
 	bus := eventbus.New()
 
 	b := &Bus{
-		bus:     bus,
-		clients: make(map[ClientName]*eventbus.Client),
-		logger:  logger,
-		ctx:     ctx,
-		cancel:  cancel,
+		bus:          bus,
+		clients:      make(map[ClientName]*eventbus.Client),
+		logger:       logger,
+		ctx:          ctx,
+		cancel:       cancel,
+		dedupScope:   DedupScopeGlobal,
+		lastBySource: make(map[string]StateUpdateEvent),
+		history:      NewHistory(DefaultHistorySize),
+		clock:        clock.Real(),
+		publishers:   make(map[publisherKey]any),
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	// Started after the options so a configured clock is used
+	b.stats = newStats(b.clock.Now())
+
+	if b.dedupScope != DedupScopeGlobal && b.dedupScope != DedupScopeSource {
+		cancel()
+		bus.Close()
+		return nil, fmt.Errorf("invalid dedup scope %q", b.dedupScope)
+	}
+
+	if b.minPublishInterval < 0 {
+		cancel()
+		bus.Close()
+		return nil, fmt.Errorf("invalid min publish interval %s", b.minPublishInterval)
+	}
+	if b.minPublishInterval > 0 {
+		b.lastPublishAt = make(map[string]time.Time)
+		b.pendingStates = make(map[string]pendingState)
 	}
 
 	// Create named clients
@@ -61,6 +179,7 @@ func New(logger *zap.Logger) (*Bus, error) {
 
 	logger.Info("eventbus initialized",
 		zap.Int("client_count", len(b.clients)),
+		zap.String("dedup_scope", string(b.dedupScope)),
 	)
 
 	return b, nil
@@ -81,6 +200,33 @@ func (b *Bus) createClients() {
 	}
 }
 
+// RegisterClient creates a new named eventbus client at runtime, so subsystems
+// outside this package can publish and subscribe under their own name.
+// Registering a name that is already in use returns an error.
+func (b *Bus) RegisterClient(name ClientName) (*eventbus.Client, error) {
+	if name == "" {
+		return nil, fmt.Errorf("client name is required")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ctx.Err() != nil {
+		return nil, ErrBusClosed
+	}
+
+	if _, ok := b.clients[name]; ok {
+		return nil, fmt.Errorf("%w: %q", ErrClientRegistered, name)
+	}
+
+	client := b.bus.Client(string(name))
+	b.clients[name] = client
+
+	b.logger.Debug("registered eventbus client", zap.String("name", string(name)))
+
+	return client, nil
+}
+
 // Client returns the eventbus client for the given name.
 func (b *Bus) Client(name ClientName) (*eventbus.Client, error) {
 	b.mu.RLock()
@@ -88,65 +234,308 @@ func (b *Bus) Client(name ClientName) (*eventbus.Client, error) {
 
 	client, ok := b.clients[name]
 	if !ok {
-		return nil, fmt.Errorf("client %q not found", name)
+		return nil, fmt.Errorf("%w: %q", ErrClientNotFound, name)
 	}
 
 	return client, nil
 }
 
 // PublishStateUpdate publishes a state update event with deduplication.
+// Like the other Publish methods, it stamps events without a timestamp with
+// the bus clock.
 // If the event is identical to the last published event (ignoring timestamp and source),
-// it will be skipped to reduce unnecessary updates.
+// it will be skipped to reduce unnecessary updates. With DedupScopeSource only the last
+// event from the same source is considered.
+// With WithMinPublishInterval, updates arriving too soon after the last one
+// from the same source are held back, see publishLatestState.
 func (b *Bus) PublishStateUpdate(client *eventbus.Client, event StateUpdateEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = b.clock.Now()
+	}
+
 	b.stateMu.Lock()
 	defer b.stateMu.Unlock()
 
+	if b.minPublishInterval > 0 {
+		_, pending := b.pendingStates[event.Source]
+		wait := b.lastPublishAt[event.Source].Add(b.minPublishInterval).Sub(b.clock.Now())
+		if pending || wait > 0 {
+			if ce := b.logger.Check(zap.DebugLevel, "holding back state update until the min publish interval passes"); ce != nil {
+				ce.Write(
+					zap.String("source", event.Source),
+					zap.Duration("wait", wait),
+				)
+			}
+			if !pending {
+				go b.publishLatestState(event.Source, wait)
+			}
+			b.pendingStates[event.Source] = pendingState{client: client, event: event}
+			return
+		}
+	}
+
+	b.publishStateLocked(client, event)
+}
+
+// publishLatestState publishes the latest state update held back for source
+// once wait has passed, at the end of the interval that held it back.
+func (b *Bus) publishLatestState(source string, wait time.Duration) {
+	select {
+	case <-b.clock.After(wait):
+	case <-b.ctx.Done():
+		return
+	}
+
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+
+	pending, ok := b.pendingStates[source]
+	if !ok || b.ctx.Err() != nil {
+		return
+	}
+	delete(b.pendingStates, source)
+
+	b.publishStateLocked(pending.client, pending.event)
+}
+
+// publishStateLocked publishes a state update unless it duplicates the last
+// one. stateMu must be held.
+func (b *Bus) publishStateLocked(client *eventbus.Client, event StateUpdateEvent) {
+	previous := b.lastState
+	if b.dedupScope == DedupScopeSource {
+		previous = nil
+		if last, ok := b.lastBySource[event.Source]; ok {
+			previous = &last
+		}
+	}
+
 	// Check if this event is a duplicate of the last published state
-	if b.lastState != nil && event.Equals(*b.lastState) {
-		b.logger.Debug("skipping duplicate state update event",
+	if previous != nil && event.Equals(*previous) {
+		if ce := b.logger.Check(zap.DebugLevel, "skipping duplicate state update event"); ce != nil {
+			ce.Write(
+				zap.String("source", event.Source),
+				zap.Float64("current_temp", event.CurrentTemperature),
+				zap.Float64("target_temp", event.TargetTemperature),
+			)
+		}
+		b.stats.stateUpdate(true)
+		return
+	}
+
+	if ce := b.logger.Check(zap.DebugLevel, "publishing state update event"); ce != nil {
+		ce.Write(
 			zap.String("source", event.Source),
 			zap.Float64("current_temp", event.CurrentTemperature),
 			zap.Float64("target_temp", event.TargetTemperature),
 		)
+	}
+
+	publisherFor[StateUpdateEvent](b, client).Publish(event)
+	b.history.Record(EventTypeStateUpdate, event)
+	b.stats.stateUpdate(false)
+
+	// Update last state for future deduplication
+	last := event
+	b.lastState = &last
+	b.lastBySource[event.Source] = event
+	if b.minPublishInterval > 0 {
+		b.lastPublishAt[event.Source] = b.clock.Now()
+	}
+}
+
+// StateSubscriber receives state update events for one client. Unlike a plain
+// eventbus subscriber it can start with the last published state, so a late
+// subscriber does not have to wait for the next poll to learn the current one.
+type StateSubscriber struct {
+	sub    *eventbus.Subscriber[StateUpdateEvent]
+	events chan StateUpdateEvent
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// SubscribeStateUpdates subscribes client to state update events. With replay
+// set, the last state published on the bus, if any, is delivered first. No
+// update is lost or delivered twice between the replay and live events.
+// Like eventbus.Subscribe, it panics if client already subscribes to state
+// updates.
+func (b *Bus) SubscribeStateUpdates(client *eventbus.Client, replay bool) *StateSubscriber {
+	s := &StateSubscriber{
+		events: make(chan StateUpdateEvent),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	// Publishing holds stateMu, so the snapshot is exactly the last event
+	// published before the subscription started
+	b.stateMu.Lock()
+	s.sub = eventbus.Subscribe[StateUpdateEvent](client)
+	var last *StateUpdateEvent
+	if replay && b.lastState != nil {
+		event := *b.lastState
+		last = &event
+	}
+	b.stateMu.Unlock()
+
+	go s.run(last)
+
+	return s
+}
+
+// run forwards events to the subscriber, starting with the replayed state.
+func (s *StateSubscriber) run(last *StateUpdateEvent) {
+	defer close(s.done)
+
+	if last != nil && !s.deliver(*last) {
 		return
 	}
 
-	b.logger.Debug("publishing state update event",
-		zap.String("source", event.Source),
-		zap.Float64("current_temp", event.CurrentTemperature),
-		zap.Float64("target_temp", event.TargetTemperature),
-	)
+	for {
+		select {
+		case event := <-s.sub.Events():
+			if !s.deliver(event) {
+				return
+			}
+		case <-s.sub.Done():
+			return
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// deliver hands event to the subscriber, reporting false once it is closed.
+func (s *StateSubscriber) deliver(event StateUpdateEvent) bool {
+	select {
+	case s.events <- event:
+		return true
+	case <-s.sub.Done():
+		return false
+	case <-s.stop:
+		return false
+	}
+}
 
-	publisher := eventbus.Publish[StateUpdateEvent](client)
-	defer publisher.Close()
-	publisher.Publish(event)
+// Events returns the channel state updates are delivered on.
+func (s *StateSubscriber) Events() <-chan StateUpdateEvent {
+	return s.events
+}
 
-	// Update last state for future deduplication
-	b.lastState = &event
+// Done returns a channel that is closed once the subscriber stops delivering,
+// either because it was closed or because its client was.
+func (s *StateSubscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close stops the subscription. It is safe to call more than once.
+func (s *StateSubscriber) Close() {
+	s.once.Do(func() {
+		close(s.stop)
+		s.sub.Close()
+	})
+	<-s.done
 }
 
 // PublishCommand publishes a command event.
 func (b *Bus) PublishCommand(client *eventbus.Client, event CommandEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = b.clock.Now()
+	}
+
 	b.logger.Debug("publishing command event",
 		zap.String("source", event.Source),
 		zap.String("command_type", string(event.CommandType)),
 	)
 
-	publisher := eventbus.Publish[CommandEvent](client)
-	defer publisher.Close()
-	publisher.Publish(event)
+	publisherFor[CommandEvent](b, client).Publish(event)
+	b.history.Record(EventTypeCommand, event)
+	b.stats.command(event)
 }
 
 // PublishConnectionStatus publishes a connection status event.
 func (b *Bus) PublishConnectionStatus(client *eventbus.Client, event ConnectionStatusEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = b.clock.Now()
+	}
+
 	b.logger.Debug("publishing connection status event",
 		zap.String("component", event.Component),
 		zap.String("status", string(event.Status)),
 	)
 
-	publisher := eventbus.Publish[ConnectionStatusEvent](client)
-	defer publisher.Close()
-	publisher.Publish(event)
+	publisherFor[ConnectionStatusEvent](b, client).Publish(event)
+	b.history.Record(EventTypeConnectionStatus, event)
+	b.stats.connectionStatus(event)
+}
+
+// PublishEnergy publishes an energy event.
+func (b *Bus) PublishEnergy(client *eventbus.Client, event EnergyEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = b.clock.Now()
+	}
+
+	b.logger.Debug("publishing energy event",
+		zap.String("source", event.Source),
+		zap.Int("days", len(event.Days)),
+	)
+
+	publisherFor[EnergyEvent](b, client).Publish(event)
+	b.history.Record(EventTypeEnergy, event)
+	b.stats.energy()
+}
+
+// PublishCommandResult publishes the result of executing a command.
+func (b *Bus) PublishCommandResult(client *eventbus.Client, event CommandResultEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = b.clock.Now()
+	}
+
+	b.logger.Debug("publishing command result event",
+		zap.String("command_source", event.CommandSource),
+		zap.String("command_type", string(event.CommandType)),
+		zap.Bool("success", event.Success),
+	)
+
+	publisherFor[CommandResultEvent](b, client).Publish(event)
+	b.history.Record(EventTypeCommandResult, event)
+	b.stats.commandResult(event)
+}
+
+// PublishReconnect publishes a reconnect request.
+func (b *Bus) PublishReconnect(client *eventbus.Client, event ReconnectEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = b.clock.Now()
+	}
+
+	b.logger.Debug("publishing reconnect event",
+		zap.String("source", event.Source),
+	)
+
+	publisherFor[ReconnectEvent](b, client).Publish(event)
+	b.history.Record(EventTypeReconnect, event)
+}
+
+// publisherFor returns the publisher for events of type T on client, creating
+// it on first use. Publishers are kept for the lifetime of the bus rather than
+// created and closed per event, and are closed along with their client.
+func publisherFor[T any](b *Bus, client *eventbus.Client) *eventbus.Publisher[T] {
+	key := publisherKey{client: client, eventType: reflect.TypeFor[T]()}
+
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+
+	if p, ok := b.publishers[key]; ok {
+		return p.(*eventbus.Publisher[T])
+	}
+
+	p := eventbus.Publish[T](client)
+	b.publishers[key] = p
+	return p
+}
+
+// RecentEvents returns the most recently published events, oldest first.
+func (b *Bus) RecentEvents() []RecordedEvent {
+	return b.history.Events()
 }
 
 // Close gracefully shuts down the eventbus.
@@ -164,6 +553,24 @@ func (b *Bus) Close() error {
 		delete(b.clients, name)
 	}
 
+	b.pubMu.Lock()
+	clear(b.publishers)
+	b.pubMu.Unlock()
+
+	// Stops the bus goroutine, clients registered later are closed with it
+	b.bus.Close()
+
+	if b.statsFile != "" {
+		if err := writeStatsFile(b.statsFile, b.Stats()); err != nil {
+			b.logger.Warn("failed to write stats file",
+				zap.String("path", b.statsFile),
+				zap.Error(err),
+			)
+		} else {
+			b.logger.Info("wrote stats file", zap.String("path", b.statsFile))
+		}
+	}
+
 	b.logger.Info("eventbus shut down complete")
 	return nil
 }
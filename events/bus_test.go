@@ -1,9 +1,11 @@
 package events
 
 import (
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/kradalby/nefit-homekit/clock"
 	"go.uber.org/zap"
 	"tailscale.com/util/eventbus"
 )
@@ -63,8 +65,8 @@ func TestClientNotFound(t *testing.T) {
 	}()
 
 	_, err = bus.Client("nonexistent")
-	if err == nil {
-		t.Error("Client(nonexistent) expected error, got nil")
+	if !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("Client(nonexistent) error = %v, want %v", err, ErrClientNotFound)
 	}
 }
 
@@ -172,6 +174,34 @@ func TestPublishAndSubscribe(t *testing.T) {
 			t.Fatal("timeout waiting for event")
 		}
 	})
+
+	// Test CommandResultEvent
+	t.Run("CommandResultEvent", func(t *testing.T) {
+		sub := eventbus.Subscribe[CommandResultEvent](subscriber)
+		defer sub.Close()
+
+		expectedEvent := CommandResultEvent{
+			Source:        "nefit",
+			CommandSource: "homekit",
+			CommandType:   CommandTypeSetTemperature,
+			Error:         "failed to set temperature: timeout",
+		}
+
+		bus.PublishCommandResult(publisher, expectedEvent)
+
+		select {
+		case receivedEvent := <-sub.Events():
+			if receivedEvent.Timestamp.IsZero() {
+				t.Error("receivedEvent.Timestamp not set")
+			}
+			receivedEvent.Timestamp = time.Time{}
+			if receivedEvent != expectedEvent {
+				t.Errorf("receivedEvent = %+v, want %+v", receivedEvent, expectedEvent)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+	})
 }
 
 func TestClose(t *testing.T) {
@@ -339,3 +369,609 @@ func TestPublishStateUpdateDeduplication(t *testing.T) {
 		t.Fatal("timeout waiting for changed event")
 	}
 }
+
+func TestPublishStateUpdateDedupScope(t *testing.T) {
+	tests := []struct {
+		name                  string
+		opts                  []Option
+		wantNefitConfirmation bool
+	}{
+		{
+			name:                  "global scope suppresses identical nefit confirmation",
+			opts:                  nil,
+			wantNefitConfirmation: false,
+		},
+		{
+			name:                  "source scope passes nefit confirmation",
+			opts:                  []Option{WithDedupScope(DedupScopeSource)},
+			wantNefitConfirmation: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus, err := New(zap.NewNop(), tt.opts...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() { _ = bus.Close() }()
+
+			client, err := bus.Client(ClientWeb)
+			if err != nil {
+				t.Fatalf("Client() error = %v", err)
+			}
+
+			sub := eventbus.Subscribe[StateUpdateEvent](client)
+			defer sub.Close()
+
+			optimistic := StateUpdateEvent{
+				Source:             "web",
+				CurrentTemperature: 21.0,
+				TargetTemperature:  23.0,
+				Mode:               "heat",
+			}
+			bus.PublishStateUpdate(client, optimistic)
+
+			select {
+			case <-sub.Events():
+			case <-time.After(100 * time.Millisecond):
+				t.Fatal("timeout waiting for optimistic event")
+			}
+
+			confirmation := optimistic
+			confirmation.Source = "nefit"
+			bus.PublishStateUpdate(client, confirmation)
+
+			select {
+			case got := <-sub.Events():
+				if !tt.wantNefitConfirmation {
+					t.Errorf("received %s confirmation, want it deduplicated", got.Source)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.wantNefitConfirmation {
+					t.Fatal("nefit confirmation was deduplicated")
+				}
+			}
+
+			// A repeated nefit state is a duplicate in both scopes
+			bus.PublishStateUpdate(client, confirmation)
+
+			select {
+			case <-sub.Events():
+				t.Error("repeated nefit state should have been filtered")
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestNewWithInvalidDedupScope(t *testing.T) {
+	bus, err := New(zap.NewNop(), WithDedupScope("everything"))
+	if err == nil {
+		_ = bus.Close()
+		t.Fatal("New() with invalid dedup scope expected error, got nil")
+	}
+}
+
+func TestNewWithInvalidMinPublishInterval(t *testing.T) {
+	bus, err := New(zap.NewNop(), WithMinPublishInterval(-time.Second))
+	if err == nil {
+		_ = bus.Close()
+		t.Fatal("New() with negative min publish interval expected error, got nil")
+	}
+}
+
+// expectState waits for the next state update and checks its current
+// temperature.
+func expectState(t *testing.T, sub *eventbus.Subscriber[StateUpdateEvent], wantCurrent float64) StateUpdateEvent {
+	t.Helper()
+
+	select {
+	case got := <-sub.Events():
+		if got.CurrentTemperature != wantCurrent {
+			t.Errorf("CurrentTemperature = %v, want %v", got.CurrentTemperature, wantCurrent)
+		}
+		return got
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting for state update with current temperature %v", wantCurrent)
+		return StateUpdateEvent{}
+	}
+}
+
+// expectNoState checks that no state update is delivered.
+func expectNoState(t *testing.T, sub *eventbus.Subscriber[StateUpdateEvent]) {
+	t.Helper()
+
+	select {
+	case got := <-sub.Events():
+		t.Errorf("unexpected state update %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPublishStateUpdateMinPublishInterval(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	bus, err := New(zap.NewNop(), WithClock(fake), WithMinPublishInterval(10*time.Second))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = bus.Close() }()
+
+	client, err := bus.Client(ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[StateUpdateEvent](client)
+	defer sub.Close()
+
+	state := func(current float64) StateUpdateEvent {
+		return StateUpdateEvent{Source: "nefit", CurrentTemperature: current, TargetTemperature: 21, Mode: ModeHeat}
+	}
+
+	// The first update is published right away
+	bus.PublishStateUpdate(client, state(20.0))
+	expectState(t, sub, 20.0)
+
+	// A burst within the interval is held back
+	bus.PublishStateUpdate(client, state(20.5))
+	fake.BlockUntil(1)
+	fake.Advance(3 * time.Second)
+	bus.PublishStateUpdate(client, state(19.5))
+	fake.Advance(3 * time.Second)
+	bus.PublishStateUpdate(client, state(21.0))
+	expectNoState(t, sub)
+
+	// and collapses into one update with the latest values when it ends
+	fake.Advance(4 * time.Second)
+	got := expectState(t, sub, 21.0)
+	if want := fake.Now().Add(-4 * time.Second); !got.Timestamp.Equal(want) {
+		t.Errorf("Timestamp = %v, want %v of the latest update", got.Timestamp, want)
+	}
+	expectNoState(t, sub)
+
+	// The next interval starts at that publish
+	bus.PublishStateUpdate(client, state(21.5))
+	fake.BlockUntil(1)
+	expectNoState(t, sub)
+	fake.Advance(10 * time.Second)
+	expectState(t, sub, 21.5)
+
+	// Once the interval has passed, updates are published right away again
+	fake.Advance(10 * time.Second)
+	bus.PublishStateUpdate(client, state(22.0))
+	expectState(t, sub, 22.0)
+}
+
+func TestPublishStateUpdateMinPublishIntervalDedup(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	bus, err := New(zap.NewNop(), WithClock(fake), WithMinPublishInterval(10*time.Second))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = bus.Close() }()
+
+	client, err := bus.Client(ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[StateUpdateEvent](client)
+	defer sub.Close()
+
+	bus.PublishStateUpdate(client, StateUpdateEvent{Source: "nefit", CurrentTemperature: 20.0})
+	expectState(t, sub, 20.0)
+
+	// A reading that goes back to the published one within the interval
+	// leaves nothing to publish
+	bus.PublishStateUpdate(client, StateUpdateEvent{Source: "nefit", CurrentTemperature: 20.5})
+	fake.BlockUntil(1)
+	bus.PublishStateUpdate(client, StateUpdateEvent{Source: "nefit", CurrentTemperature: 20.0})
+	fake.Advance(10 * time.Second)
+	expectNoState(t, sub)
+}
+
+func TestPublishStateUpdateMinPublishIntervalPerSource(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	bus, err := New(zap.NewNop(), WithClock(fake), WithMinPublishInterval(10*time.Second), WithDedupScope(DedupScopeSource))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = bus.Close() }()
+
+	client, err := bus.Client(ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[StateUpdateEvent](client)
+	defer sub.Close()
+
+	// An optimistic web update does not hold back the nefit confirmation
+	bus.PublishStateUpdate(client, StateUpdateEvent{Source: "web", CurrentTemperature: 20.0, TargetTemperature: 22})
+	expectState(t, sub, 20.0)
+	bus.PublishStateUpdate(client, StateUpdateEvent{Source: "nefit", CurrentTemperature: 20.0, TargetTemperature: 22})
+	if got := expectState(t, sub, 20.0); got.Source != "nefit" {
+		t.Errorf("Source = %q, want nefit", got.Source)
+	}
+}
+
+func TestRegisterClient(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := New(logger)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	registered, err := bus.RegisterClient("mqtt")
+	if err != nil {
+		t.Fatalf("RegisterClient(mqtt) error = %v", err)
+	}
+
+	got, err := bus.Client("mqtt")
+	if err != nil {
+		t.Fatalf("Client(mqtt) error = %v", err)
+	}
+	if got != registered {
+		t.Error("Client(mqtt) returned a different client than RegisterClient")
+	}
+
+	// The new client can receive events published by a built-in client
+	sub := eventbus.Subscribe[ConnectionStatusEvent](registered)
+	defer sub.Close()
+
+	nefitClient, err := bus.Client(ClientNefit)
+	if err != nil {
+		t.Fatalf("Client(nefit) error = %v", err)
+	}
+	bus.PublishConnectionStatus(nefitClient, ConnectionStatusEvent{Component: "nefit", Status: ConnectionStatusConnected})
+
+	select {
+	case event := <-sub.Events():
+		if event.Status != ConnectionStatusConnected {
+			t.Errorf("event.Status = %v, want %v", event.Status, ConnectionStatusConnected)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for event on registered client")
+	}
+}
+
+func TestRegisterClientErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		clientName ClientName
+		closed     bool
+	}{
+		{name: "built-in name", clientName: ClientWeb},
+		{name: "empty name", clientName: ""},
+		{name: "after close", clientName: "webhook", closed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus, err := New(zap.NewNop())
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			if tt.closed {
+				_ = bus.Close()
+			}
+
+			if _, err := bus.RegisterClient(tt.clientName); err == nil {
+				t.Errorf("RegisterClient(%q) expected error, got nil", tt.clientName)
+			}
+		})
+	}
+}
+
+func TestRegisterClientDuplicate(t *testing.T) {
+	bus, err := New(zap.NewNop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	if _, err := bus.RegisterClient("webhook"); err != nil {
+		t.Fatalf("first RegisterClient() error = %v", err)
+	}
+
+	if _, err := bus.RegisterClient("webhook"); !errors.Is(err, ErrClientRegistered) {
+		t.Errorf("duplicate RegisterClient() error = %v, want %v", err, ErrClientRegistered)
+	}
+}
+
+// benchmarkPublishStateUpdate publishes state updates to a draining subscriber.
+// With distinct set, every event differs from the previous one so none are
+// deduplicated; otherwise all but the first are skipped as duplicates.
+//
+// Creating and closing a publisher per event cost, on a typical laptop:
+//
+//	BenchmarkPublishStateUpdate              3900 ns/op   720 B/op   6 allocs/op
+//	BenchmarkPublishStateUpdateDeduplicated   215 ns/op   320 B/op   2 allocs/op
+//
+// With reused publishers and debug fields built only when debug logging is on:
+//
+//	BenchmarkPublishStateUpdate              3300 ns/op   384 B/op   3 allocs/op
+//	BenchmarkPublishStateUpdateDeduplicated    35 ns/op     0 B/op   0 allocs/op
+func benchmarkPublishStateUpdate(b *testing.B, distinct bool) {
+	bus, err := New(zap.NewNop())
+	if err != nil {
+		b.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	publisher, err := bus.Client(ClientNefit)
+	if err != nil {
+		b.Fatalf("Client(ClientNefit) error = %v", err)
+	}
+
+	subscriber, err := bus.Client(ClientHomeKit)
+	if err != nil {
+		b.Fatalf("Client(ClientHomeKit) error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[StateUpdateEvent](subscriber)
+	defer sub.Close()
+
+	go func() {
+		for {
+			select {
+			case <-sub.Events():
+			case <-sub.Done():
+				return
+			}
+		}
+	}()
+
+	event := StateUpdateEvent{
+		Source:             "nefit",
+		CurrentTemperature: 21.0,
+		TargetTemperature:  22.0,
+		Mode:               ModeHeat,
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if distinct {
+			event.CurrentTemperature = float64(i % 100)
+		}
+		bus.PublishStateUpdate(publisher, event)
+	}
+}
+
+func BenchmarkPublishStateUpdate(b *testing.B) {
+	benchmarkPublishStateUpdate(b, true)
+}
+
+func BenchmarkPublishStateUpdateDeduplicated(b *testing.B) {
+	benchmarkPublishStateUpdate(b, false)
+}
+
+func TestPublishStateUpdateDuplicateDoesNotAllocate(t *testing.T) {
+	bus, err := New(zap.NewNop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	client, err := bus.Client(ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	event := StateUpdateEvent{Source: "nefit", CurrentTemperature: 21.0, Mode: ModeHeat}
+	bus.PublishStateUpdate(client, event)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		bus.PublishStateUpdate(client, event)
+	})
+	if allocs != 0 {
+		t.Errorf("duplicate PublishStateUpdate allocs = %v, want 0", allocs)
+	}
+}
+
+func TestSubscribeStateUpdatesReplay(t *testing.T) {
+	tests := []struct {
+		name       string
+		replay     bool
+		wantReplay bool
+	}{
+		{name: "replay last state", replay: true, wantReplay: true},
+		{name: "no replay", replay: false, wantReplay: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus, err := New(zap.NewNop())
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			publisher, err := bus.Client(ClientNefit)
+			if err != nil {
+				t.Fatalf("Client(ClientNefit) error = %v", err)
+			}
+			subscriber, err := bus.Client(ClientWeb)
+			if err != nil {
+				t.Fatalf("Client(ClientWeb) error = %v", err)
+			}
+
+			// Published before the subscriber exists
+			bus.PublishStateUpdate(publisher, StateUpdateEvent{
+				Source:             "nefit",
+				CurrentTemperature: 20.5,
+				TargetTemperature:  21.0,
+			})
+
+			sub := bus.SubscribeStateUpdates(subscriber, tt.replay)
+			defer sub.Close()
+
+			if tt.wantReplay {
+				select {
+				case event := <-sub.Events():
+					if event.CurrentTemperature != 20.5 {
+						t.Errorf("replayed CurrentTemperature = %v, want 20.5", event.CurrentTemperature)
+					}
+				case <-time.After(1 * time.Second):
+					t.Fatal("timeout waiting for replayed state")
+				}
+			}
+
+			// Live events follow the replay
+			bus.PublishStateUpdate(publisher, StateUpdateEvent{
+				Source:             "nefit",
+				CurrentTemperature: 21.5,
+				TargetTemperature:  21.0,
+			})
+
+			select {
+			case event := <-sub.Events():
+				if event.CurrentTemperature != 21.5 {
+					t.Errorf("live CurrentTemperature = %v, want 21.5", event.CurrentTemperature)
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatal("timeout waiting for live state")
+			}
+		})
+	}
+}
+
+func TestSubscribeStateUpdatesNoStateYet(t *testing.T) {
+	bus, err := New(zap.NewNop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	subscriber, err := bus.Client(ClientWeb)
+	if err != nil {
+		t.Fatalf("Client(ClientWeb) error = %v", err)
+	}
+
+	sub := bus.SubscribeStateUpdates(subscriber, true)
+
+	select {
+	case event := <-sub.Events():
+		t.Fatalf("unexpected event before any publish: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sub.Close()
+	sub.Close()
+
+	select {
+	case <-sub.Done():
+	default:
+		t.Error("Done() not closed after Close()")
+	}
+}
+
+func TestPublishStampsTimestamp(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	bus, err := New(zap.NewNop(), WithClock(clock.NewFake(now)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	publisher, err := bus.Client(ClientNefit)
+	if err != nil {
+		t.Fatalf("Client(ClientNefit) error = %v", err)
+	}
+	subscriber, err := bus.Client(ClientWeb)
+	if err != nil {
+		t.Fatalf("Client(ClientWeb) error = %v", err)
+	}
+
+	stateSub := eventbus.Subscribe[StateUpdateEvent](subscriber)
+	commandSub := eventbus.Subscribe[CommandEvent](subscriber)
+	connSub := eventbus.Subscribe[ConnectionStatusEvent](subscriber)
+	resultSub := eventbus.Subscribe[CommandResultEvent](subscriber)
+
+	explicit := now.Add(-time.Hour)
+	temp := 21.0
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		want      time.Time
+	}{
+		{name: "unset is stamped", want: now},
+		{name: "set is kept", timestamp: explicit, want: explicit},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus.PublishStateUpdate(publisher, StateUpdateEvent{
+				Timestamp:          tt.timestamp,
+				Source:             "nefit",
+				CurrentTemperature: 20.0 + float64(i),
+			})
+			bus.PublishCommand(publisher, CommandEvent{
+				Timestamp:         tt.timestamp,
+				Source:            "web",
+				CommandType:       CommandTypeSetTemperature,
+				TargetTemperature: &temp,
+			})
+			bus.PublishConnectionStatus(publisher, ConnectionStatusEvent{
+				Timestamp: tt.timestamp,
+				Component: "nefit",
+				Status:    ConnectionStatusConnected,
+			})
+			bus.PublishCommandResult(publisher, CommandResultEvent{
+				Timestamp:     tt.timestamp,
+				Source:        "nefit",
+				CommandSource: "web",
+				CommandType:   CommandTypeSetTemperature,
+				Success:       true,
+			})
+
+			for _, name := range []string{"state update", "command", "connection status", "command result"} {
+				var got time.Time
+				select {
+				case event := <-stateSub.Events():
+					got = event.Timestamp
+				case event := <-commandSub.Events():
+					got = event.Timestamp
+				case event := <-connSub.Events():
+					got = event.Timestamp
+				case event := <-resultSub.Events():
+					got = event.Timestamp
+				case <-time.After(1 * time.Second):
+					t.Fatalf("timeout waiting for %s", name)
+				}
+				if !got.Equal(tt.want) {
+					t.Errorf("Timestamp = %v, want %v", got, tt.want)
+				}
+			}
+
+			for _, recorded := range bus.RecentEvents() {
+				if !recorded.RecordedAt.Equal(now) {
+					t.Errorf("RecordedAt = %v, want %v", recorded.RecordedAt, now)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/clock"
+)
+
+// DefaultHistorySize is the number of recent events kept by the bus.
+const DefaultHistorySize = 100
+
+// RecordedEvent is an event captured in the bus history.
+type RecordedEvent struct {
+	RecordedAt time.Time `json:"recorded_at"`
+	Type       EventType `json:"type"`
+	Event      any       `json:"event"`
+}
+
+// History is a fixed-size ring buffer of recently published events.
+// Once full, the oldest event is overwritten.
+type History struct {
+	mu     sync.Mutex
+	clock  clock.Clock
+	events []RecordedEvent
+	next   int
+	full   bool
+}
+
+// NewHistory creates a history holding at most size events.
+func NewHistory(size int) *History {
+	if size < 1 {
+		size = 1
+	}
+
+	return &History{
+		clock:  clock.Real(),
+		events: make([]RecordedEvent, size),
+	}
+}
+
+// Record adds an event to the history.
+func (h *History) Record(eventType EventType, event any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.events[h.next] = RecordedEvent{
+		RecordedAt: h.clock.Now(),
+		Type:       eventType,
+		Event:      event,
+	}
+
+	h.next = (h.next + 1) % len(h.events)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Events returns the recorded events, oldest first.
+func (h *History) Events() []RecordedEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.full {
+		out := make([]RecordedEvent, h.next)
+		copy(out, h.events[:h.next])
+		return out
+	}
+
+	out := make([]RecordedEvent, 0, len(h.events))
+	out = append(out, h.events[h.next:]...)
+	out = append(out, h.events[:h.next]...)
+	return out
+}
+
+// Len returns the number of recorded events.
+func (h *History) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.full {
+		return len(h.events)
+	}
+	return h.next
+}
@@ -0,0 +1,111 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/clock"
+	"go.uber.org/zap"
+)
+
+func TestHistoryRecordsInOrder(t *testing.T) {
+	h := NewHistory(3)
+
+	if got := h.Len(); got != 0 {
+		t.Errorf("Len() = %d, want 0", got)
+	}
+
+	h.Record(EventTypeStateUpdate, StateUpdateEvent{CurrentTemperature: 1})
+	h.Record(EventTypeStateUpdate, StateUpdateEvent{CurrentTemperature: 2})
+
+	got := h.Events()
+	if len(got) != 2 {
+		t.Fatalf("Events() len = %d, want 2", len(got))
+	}
+	for i, want := range []float64{1, 2} {
+		event, ok := got[i].Event.(StateUpdateEvent)
+		if !ok {
+			t.Fatalf("Events()[%d].Event is %T, want StateUpdateEvent", i, got[i].Event)
+		}
+		if event.CurrentTemperature != want {
+			t.Errorf("Events()[%d].CurrentTemperature = %v, want %v", i, event.CurrentTemperature, want)
+		}
+		if got[i].RecordedAt.IsZero() {
+			t.Errorf("Events()[%d].RecordedAt is zero", i)
+		}
+	}
+}
+
+func TestHistoryOverwritesOldest(t *testing.T) {
+	h := NewHistory(3)
+
+	for i := 1; i <= 5; i++ {
+		h.Record(EventTypeStateUpdate, StateUpdateEvent{CurrentTemperature: float64(i)})
+	}
+
+	if got := h.Len(); got != 3 {
+		t.Errorf("Len() = %d, want 3", got)
+	}
+
+	got := h.Events()
+	for i, want := range []float64{3, 4, 5} {
+		event := got[i].Event.(StateUpdateEvent)
+		if event.CurrentTemperature != want {
+			t.Errorf("Events()[%d].CurrentTemperature = %v, want %v", i, event.CurrentTemperature, want)
+		}
+	}
+}
+
+func TestBusRecentEvents(t *testing.T) {
+	bus, err := New(zap.NewNop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = bus.Close() }()
+
+	client, err := bus.Client(ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	bus.PublishStateUpdate(client, StateUpdateEvent{Source: "nefit", CurrentTemperature: 21.0})
+	bus.PublishStateUpdate(client, StateUpdateEvent{Source: "nefit", CurrentTemperature: 21.0}) // deduplicated
+	bus.PublishConnectionStatus(client, ConnectionStatusEvent{Component: "nefit", Status: ConnectionStatusConnected})
+
+	got := bus.RecentEvents()
+	if len(got) != 2 {
+		t.Fatalf("RecentEvents() len = %d, want 2", len(got))
+	}
+	if got[0].Type != EventTypeStateUpdate {
+		t.Errorf("RecentEvents()[0].Type = %v, want %v", got[0].Type, EventTypeStateUpdate)
+	}
+	if got[1].Type != EventTypeConnectionStatus {
+		t.Errorf("RecentEvents()[1].Type = %v, want %v", got[1].Type, EventTypeConnectionStatus)
+	}
+}
+
+func TestBusWithClockTimestampsHistory(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(now)
+
+	bus, err := New(zap.NewNop(), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = bus.Close() }()
+
+	client, err := bus.Client(ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	bus.PublishConnectionStatus(client, ConnectionStatusEvent{Component: "nefit", Status: ConnectionStatusConnected})
+
+	got := bus.RecentEvents()
+	if len(got) != 1 {
+		t.Fatalf("RecentEvents() len = %d, want 1", len(got))
+	}
+	if !got[0].RecordedAt.Equal(now) {
+		t.Errorf("RecordedAt = %v, want %v", got[0].RecordedAt, now)
+	}
+}
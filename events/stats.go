@@ -0,0 +1,180 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Stats summarizes the activity on the bus since it was created.
+type Stats struct {
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+
+	// State updates published and skipped as duplicates of the last state
+	StateUpdates          int `json:"state_updates"`
+	DuplicateStateUpdates int `json:"duplicate_state_updates"`
+
+	// Commands published, by command type and by source
+	Commands       map[CommandType]int `json:"commands"`
+	CommandSources map[string]int      `json:"command_sources"`
+
+	// Commands that failed on the backend, by command type
+	CommandFailures map[CommandType]int `json:"command_failures"`
+
+	// Reconnects counts reconnecting statuses, by component
+	Reconnects map[string]int `json:"reconnects"`
+
+	EnergyReads int `json:"energy_reads"`
+}
+
+// stats counts published events for Bus.Stats.
+type stats struct {
+	mu                    sync.Mutex
+	startedAt             time.Time
+	stateUpdates          int
+	duplicateStateUpdates int
+	commands              map[CommandType]int
+	commandSources        map[string]int
+	commandFailures       map[CommandType]int
+	reconnects            map[string]int
+	energyReads           int
+}
+
+// newStats creates empty stats starting at startedAt.
+func newStats(startedAt time.Time) *stats {
+	return &stats{
+		startedAt:       startedAt,
+		commands:        make(map[CommandType]int),
+		commandSources:  make(map[string]int),
+		commandFailures: make(map[CommandType]int),
+		reconnects:      make(map[string]int),
+	}
+}
+
+// stateUpdate counts a state update, duplicate if it was skipped.
+func (s *stats) stateUpdate(duplicate bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if duplicate {
+		s.duplicateStateUpdates++
+		return
+	}
+	s.stateUpdates++
+}
+
+// command counts a published command.
+func (s *stats) command(event CommandEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commands[event.CommandType]++
+	s.commandSources[event.Source]++
+}
+
+// commandResult counts failed commands.
+func (s *stats) commandResult(event CommandResultEvent) {
+	if event.Success {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commandFailures[event.CommandType]++
+}
+
+// connectionStatus counts reconnects.
+func (s *stats) connectionStatus(event ConnectionStatusEvent) {
+	if event.Status != ConnectionStatusReconnecting {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reconnects[event.Component]++
+}
+
+// energy counts a gas usage read.
+func (s *stats) energy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.energyReads++
+}
+
+// snapshot returns a copy of the stats as of now.
+func (s *stats) snapshot(now time.Time) Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := Stats{
+		StartedAt:             s.startedAt,
+		UptimeSeconds:         now.Sub(s.startedAt).Seconds(),
+		StateUpdates:          s.stateUpdates,
+		DuplicateStateUpdates: s.duplicateStateUpdates,
+		Commands:              make(map[CommandType]int, len(s.commands)),
+		CommandSources:        make(map[string]int, len(s.commandSources)),
+		CommandFailures:       make(map[CommandType]int, len(s.commandFailures)),
+		Reconnects:            make(map[string]int, len(s.reconnects)),
+		EnergyReads:           s.energyReads,
+	}
+	for k, v := range s.commands {
+		out.Commands[k] = v
+	}
+	for k, v := range s.commandSources {
+		out.CommandSources[k] = v
+	}
+	for k, v := range s.commandFailures {
+		out.CommandFailures[k] = v
+	}
+	for k, v := range s.reconnects {
+		out.Reconnects[k] = v
+	}
+
+	return out
+}
+
+// Stats returns a summary of the activity on the bus since it was created.
+func (b *Bus) Stats() Stats {
+	return b.stats.snapshot(b.clock.Now())
+}
+
+// writeStatsFile writes the stats as JSON to path. The file is written next
+// to path and renamed, so an interrupted shutdown never leaves half a file.
+func writeStatsFile(path string, s Stats) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode stats: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create stats file directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".stats-*")
+	if err != nil {
+		return fmt.Errorf("failed to create stats file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write stats file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write stats file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write stats file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package events
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/clock"
+	"go.uber.org/zap"
+)
+
+func TestStats(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	bus, err := New(zap.NewNop(), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	client, err := bus.Client(ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	state := StateUpdateEvent{Source: "nefit", CurrentTemperature: 20.0}
+	bus.PublishStateUpdate(client, state)
+	bus.PublishStateUpdate(client, state)
+	state.CurrentTemperature = 20.5
+	bus.PublishStateUpdate(client, state)
+
+	temp := 21.0
+	bus.PublishCommand(client, CommandEvent{Source: "web", CommandType: CommandTypeSetTemperature, TargetTemperature: &temp})
+	bus.PublishCommand(client, CommandEvent{Source: "homekit", CommandType: CommandTypeSetTemperature, TargetTemperature: &temp})
+	mode := ModeOff
+	bus.PublishCommand(client, CommandEvent{Source: "web", CommandType: CommandTypeSetMode, Mode: &mode})
+
+	bus.PublishConnectionStatus(client, ConnectionStatusEvent{Component: "nefit", Status: ConnectionStatusReconnecting})
+	bus.PublishConnectionStatus(client, ConnectionStatusEvent{Component: "nefit", Status: ConnectionStatusConnected})
+	bus.PublishConnectionStatus(client, ConnectionStatusEvent{Component: "nefit", Status: ConnectionStatusReconnecting})
+
+	bus.PublishEnergy(client, EnergyEvent{Source: "nefit"})
+
+	bus.PublishCommandResult(client, CommandResultEvent{Source: "nefit", CommandType: CommandTypeSetMode, Success: true})
+	bus.PublishCommandResult(client, CommandResultEvent{Source: "nefit", CommandType: CommandTypeSetTemperature, Error: "timeout"})
+
+	fake.Advance(90 * time.Second)
+
+	got := bus.Stats()
+	if got.UptimeSeconds != 90 {
+		t.Errorf("UptimeSeconds = %v, want 90", got.UptimeSeconds)
+	}
+	if got.StateUpdates != 2 || got.DuplicateStateUpdates != 1 {
+		t.Errorf("StateUpdates = %d, DuplicateStateUpdates = %d, want 2 and 1", got.StateUpdates, got.DuplicateStateUpdates)
+	}
+	if got.Commands[CommandTypeSetTemperature] != 2 || got.Commands[CommandTypeSetMode] != 1 {
+		t.Errorf("Commands = %v, want 2 set_temperature and 1 set_mode", got.Commands)
+	}
+	if got.CommandSources["web"] != 2 || got.CommandSources["homekit"] != 1 {
+		t.Errorf("CommandSources = %v, want 2 web and 1 homekit", got.CommandSources)
+	}
+	if got.Reconnects["nefit"] != 2 {
+		t.Errorf("Reconnects = %v, want 2 for nefit", got.Reconnects)
+	}
+	if got.EnergyReads != 1 {
+		t.Errorf("EnergyReads = %d, want 1", got.EnergyReads)
+	}
+	if len(got.CommandFailures) != 1 || got.CommandFailures[CommandTypeSetTemperature] != 1 {
+		t.Errorf("CommandFailures = %v, want 1 set_temperature", got.CommandFailures)
+	}
+}
+
+func TestStatsFileWrittenOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "stats.json")
+
+	bus, err := New(zap.NewNop(), WithStatsFile(path))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	client, err := bus.Client(ClientWeb)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	temp := 21.0
+	bus.PublishCommand(client, CommandEvent{Source: "web", CommandType: CommandTypeSetTemperature, TargetTemperature: &temp})
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("stats file exists before Close, stat error = %v", err)
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read stats file: %v", err)
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("stats file is not JSON: %v", err)
+	}
+	for _, key := range []string{
+		"started_at",
+		"uptime_seconds",
+		"state_updates",
+		"duplicate_state_updates",
+		"commands",
+		"command_sources",
+		"reconnects",
+		"energy_reads",
+	} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("stats file missing %q", key)
+		}
+	}
+
+	commands, _ := fields["commands"].(map[string]any)
+	if commands["set_temperature"] != 1.0 {
+		t.Errorf("commands = %v, want one set_temperature", fields["commands"])
+	}
+
+	// No temporary files are left next to it
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("stats directory has %d entries, want only the stats file", len(entries))
+	}
+}
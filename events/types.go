@@ -2,6 +2,11 @@
 package events
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -17,6 +22,15 @@ const (
 
 	// EventTypeConnectionStatus is emitted when connection status changes.
 	EventTypeConnectionStatus EventType = "connection_status"
+
+	// EventTypeEnergy is emitted when gas usage is read from the thermostat.
+	EventTypeEnergy EventType = "energy"
+
+	// EventTypeCommandResult is emitted when a command was executed or failed.
+	EventTypeCommandResult EventType = "command_result"
+
+	// EventTypeReconnect is emitted when a reconnect to Nefit is requested.
+	EventTypeReconnect EventType = "reconnect"
 )
 
 // StateUpdateEvent is published when the thermostat state changes.
@@ -26,41 +40,123 @@ type StateUpdateEvent struct {
 	CurrentTemperature  float64 // Celsius
 	TargetTemperature   float64 // Celsius
 	HeatingActive       bool
-	Mode                string // "heat", "off"
+	Mode                Mode
 	Pressure            float64 // Bar
 	HotWaterActive      bool
 	HotWaterTemperature float64 // Celsius
+	ComfortTemperature  float64 // Celsius, last setpoint chosen while heating
+	SetpointSource      string  // Who last changed the setpoint: "homekit", "web", "presence", "nefit"
+	FirmwareVersion     string  // Thermostat firmware, empty if unknown
+	SupplySetpoint      float64 // Celsius, boiler supply temperature setpoint, 0 unless advanced features are enabled
+	OutdoorTemperature  float64 // Celsius, from the outdoor sensor, 0 if unknown
+	Humidity            float64 // Percent relative humidity measured by the thermostat, 0 if unknown
 }
 
 // Equals compares two StateUpdateEvent for equality, ignoring Timestamp and Source.
-// This is used for event deduplication.
+// This is used for event deduplication. Two NaN readings count as equal, so a
+// malformed status that slips through does not publish as a change every poll.
 func (e StateUpdateEvent) Equals(other StateUpdateEvent) bool {
-	const epsilon = 0.01 // Temperature comparison tolerance
-
-	return abs(e.CurrentTemperature-other.CurrentTemperature) < epsilon &&
-		abs(e.TargetTemperature-other.TargetTemperature) < epsilon &&
+	return nearlyEqual(e.CurrentTemperature, other.CurrentTemperature) &&
+		nearlyEqual(e.TargetTemperature, other.TargetTemperature) &&
 		e.HeatingActive == other.HeatingActive &&
 		e.Mode == other.Mode &&
-		abs(e.Pressure-other.Pressure) < epsilon &&
+		nearlyEqual(e.Pressure, other.Pressure) &&
 		e.HotWaterActive == other.HotWaterActive &&
-		abs(e.HotWaterTemperature-other.HotWaterTemperature) < epsilon
+		nearlyEqual(e.HotWaterTemperature, other.HotWaterTemperature) &&
+		nearlyEqual(e.ComfortTemperature, other.ComfortTemperature) &&
+		e.SetpointSource == other.SetpointSource &&
+		e.FirmwareVersion == other.FirmwareVersion &&
+		nearlyEqual(e.SupplySetpoint, other.SupplySetpoint) &&
+		nearlyEqual(e.OutdoorTemperature, other.OutdoorTemperature) &&
+		nearlyEqual(e.Humidity, other.Humidity)
+}
+
+// DisplayTargetTemperature returns the target temperature that should be shown to users.
+// While the thermostat is off, Nefit reports its setback setpoint as the target, which
+// looks like a user choice, so the remembered comfort setpoint is shown instead.
+func (e StateUpdateEvent) DisplayTargetTemperature() float64 {
+	if e.Mode == ModeOff && e.ComfortTemperature > 0 {
+		return e.ComfortTemperature
+	}
+	return e.TargetTemperature
+}
+
+// nearlyEqual reports whether a and b differ by less than the comparison
+// tolerance, or are both NaN.
+func nearlyEqual(a, b float64) bool {
+	const epsilon = 0.01 // Temperature comparison tolerance
+
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return math.Abs(a-b) < epsilon
+}
+
+// Mode represents the thermostat operating mode.
+type Mode string
+
+const (
+	// ModeHeat means the thermostat heats to the target temperature.
+	ModeHeat Mode = "heat"
+
+	// ModeOff means the thermostat is off.
+	ModeOff Mode = "off"
+
+	// ModeAuto means the thermostat follows its clock program.
+	ModeAuto Mode = "auto"
+)
+
+// modes are the known modes.
+var modes = []Mode{ModeHeat, ModeOff, ModeAuto}
+
+// Valid reports whether m is a known mode.
+func (m Mode) Valid() bool {
+	return slices.Contains(modes, m)
 }
 
-func abs(x float64) float64 {
-	if x < 0 {
-		return -x
+// ErrInvalidMode is returned by ParseMode for unknown modes.
+var ErrInvalidMode = errors.New("invalid mode")
+
+// ParseMode parses a mode string, returning an error for unknown modes.
+func ParseMode(s string) (Mode, error) {
+	m := Mode(s)
+	if !m.Valid() {
+		names := make([]string, len(modes))
+		for i, mode := range modes {
+			names[i] = string(mode)
+		}
+		return "", fmt.Errorf("%w %q, must be one of: %s", ErrInvalidMode, s, strings.Join(names, ", "))
 	}
-	return x
+	return m, nil
 }
 
 // CommandEvent is published when a command should be executed.
 type CommandEvent struct {
 	Timestamp         time.Time
-	Source            string // "homekit", "web"
+	Source            string // "homekit", "web", "presence"
 	CommandType       CommandType
-	TargetTemperature *float64 // For SetTemperature
-	Mode              *string  // For SetMode
+	TargetTemperature *float64 // For SetTemperature, and optionally SetMode heat
+	Mode              *Mode    // For SetMode
 	HotWaterEnabled   *bool    // For SetHotWater
+	SupplySetpoint    *float64 // For SetSupplySetpoint
+}
+
+// CommandResultEvent is published by the Nefit client once a command was
+// executed on the backend, or failed.
+type CommandResultEvent struct {
+	Timestamp     time.Time
+	Source        string // "nefit"
+	CommandSource string // Source of the command, the last one for debounced mode changes
+	CommandType   CommandType
+	Success       bool
+	Error         string // Why the command failed, empty on success
+}
+
+// ReconnectEvent is published to make the Nefit client drop its backend
+// connection and connect again, for a connection that is up but wedged.
+type ReconnectEvent struct {
+	Timestamp time.Time
+	Source    string // "web"
 }
 
 // CommandType represents the type of command.
@@ -75,15 +171,32 @@ const (
 
 	// CommandTypeSetHotWater enables/disables hot water.
 	CommandTypeSetHotWater CommandType = "set_hot_water"
+
+	// CommandTypeSetSupplySetpoint sets the boiler supply temperature setpoint.
+	CommandTypeSetSupplySetpoint CommandType = "set_supply_setpoint"
+)
+
+// Bounds in Celsius of the supply temperature setpoint accepted by
+// CommandTypeSetSupplySetpoint. Below the minimum the boiler cannot heat the
+// house, above the maximum it stops condensing and wastes gas.
+const (
+	MinSupplySetpoint = 30.0
+	MaxSupplySetpoint = 80.0
 )
 
 // ConnectionStatusEvent is published when connection status changes.
 type ConnectionStatusEvent struct {
-	Timestamp  time.Time
-	Component  string // "nefit", "homekit", "web"
-	Status     ConnectionStatus
-	Error      string // Empty if no error
-	Reconnects int    // Number of reconnection attempts
+	Timestamp   time.Time
+	Component   string // "nefit", "homekit", "web"
+	Status      ConnectionStatus
+	Error       string        // Empty if no error
+	Reconnects  int           // Number of reconnection attempts
+	NextRetryIn time.Duration // Backoff before the next attempt, set when reconnecting
+	Downtime    time.Duration // Time since the connection was last up, set when reconnecting
+
+	// NeverConnected is set while not connected once the startup grace period
+	// has passed without any successful connection, hinting at misconfiguration
+	NeverConnected bool
 }
 
 // ConnectionStatus represents the connection status.
@@ -105,3 +218,40 @@ const (
 	// ConnectionStatusFailed means connection failed.
 	ConnectionStatusFailed ConnectionStatus = "failed"
 )
+
+// EnergyEvent is published when the gas usage recorded by the thermostat is read.
+type EnergyEvent struct {
+	Timestamp time.Time
+	Source    string        // "nefit"
+	Days      []EnergyUsage // Oldest first
+}
+
+// Latest returns the most recent day, or false if no days were recorded.
+func (e EnergyEvent) Latest() (EnergyUsage, bool) {
+	if len(e.Days) == 0 {
+		return EnergyUsage{}, false
+	}
+	return e.Days[len(e.Days)-1], true
+}
+
+// Total returns the gas used over all days, in kWh.
+func (e EnergyEvent) Total() float64 {
+	var total float64
+	for _, day := range e.Days {
+		total += day.Total()
+	}
+	return total
+}
+
+// EnergyUsage is the gas usage the thermostat recorded for one day.
+type EnergyUsage struct {
+	Date               time.Time // Midnight UTC of the recorded day
+	CentralHeating     float64   // kWh
+	HotWater           float64   // kWh
+	OutdoorTemperature float64   // Celsius, daily average
+}
+
+// Total returns the gas used for heating and hot water, in kWh.
+func (u EnergyUsage) Total() float64 {
+	return u.CentralHeating + u.HotWater
+}
@@ -1,6 +1,8 @@
 package events
 
 import (
+	"errors"
+	"math"
 	"testing"
 	"time"
 )
@@ -33,7 +35,7 @@ func TestStateUpdateEvent(t *testing.T) {
 func TestCommandEvent(t *testing.T) {
 	now := time.Now()
 	temp := 23.0
-	mode := "heat"
+	mode := ModeHeat
 	hotWater := true
 
 	event := CommandEvent{
@@ -86,6 +88,8 @@ func TestEventTypes(t *testing.T) {
 		{"state update", EventTypeStateUpdate, "state_update"},
 		{"command", EventTypeCommand, "command"},
 		{"connection status", EventTypeConnectionStatus, "connection_status"},
+		{"energy", EventTypeEnergy, "energy"},
+		{"command result", EventTypeCommandResult, "command_result"},
 	}
 
 	for _, tt := range tests {
@@ -117,6 +121,60 @@ func TestCommandTypes(t *testing.T) {
 	}
 }
 
+func TestModeValid(t *testing.T) {
+	tests := []struct {
+		name string
+		mode Mode
+		want bool
+	}{
+		{"heat", ModeHeat, true},
+		{"off", ModeOff, true},
+		{"auto", ModeAuto, true},
+		{"empty", "", false},
+		{"clock", "clock", false},
+		{"uppercase", "HEAT", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mode.Valid(); got != tt.want {
+				t.Errorf("Mode(%q).Valid() = %v, want %v", tt.mode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Mode
+		wantErr bool
+	}{
+		{"heat", ModeHeat, false},
+		{"off", ModeOff, false},
+		{"auto", ModeAuto, false},
+		{"", "", true},
+		{"frost", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseMode(tt.input)
+			if errors.Is(err, ErrInvalidMode) != tt.wantErr {
+				t.Fatalf("ParseMode(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMode(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	_, err := ParseMode("frost")
+	if want := `invalid mode "frost", must be one of: heat, off, auto`; err == nil || err.Error() != want {
+		t.Errorf("ParseMode(%q) error = %v, want %q", "frost", err, want)
+	}
+}
+
 func TestConnectionStatuses(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -252,6 +310,55 @@ func TestStateUpdateEventEquals(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "different outdoor temperature",
+			event: StateUpdateEvent{
+				Timestamp:           baseEvent.Timestamp,
+				Source:              baseEvent.Source,
+				CurrentTemperature:  baseEvent.CurrentTemperature,
+				TargetTemperature:   baseEvent.TargetTemperature,
+				HeatingActive:       baseEvent.HeatingActive,
+				Mode:                baseEvent.Mode,
+				Pressure:            baseEvent.Pressure,
+				HotWaterActive:      baseEvent.HotWaterActive,
+				HotWaterTemperature: baseEvent.HotWaterTemperature,
+				OutdoorTemperature:  baseEvent.OutdoorTemperature + 0.5,
+			},
+			want: false,
+		},
+		{
+			name: "tiny outdoor temperature difference within epsilon",
+			event: StateUpdateEvent{
+				Timestamp:           baseEvent.Timestamp,
+				Source:              baseEvent.Source,
+				CurrentTemperature:  baseEvent.CurrentTemperature,
+				TargetTemperature:   baseEvent.TargetTemperature,
+				HeatingActive:       baseEvent.HeatingActive,
+				Mode:                baseEvent.Mode,
+				Pressure:            baseEvent.Pressure,
+				HotWaterActive:      baseEvent.HotWaterActive,
+				HotWaterTemperature: baseEvent.HotWaterTemperature,
+				OutdoorTemperature:  baseEvent.OutdoorTemperature + 0.005,
+			},
+			want: true,
+		},
+		{
+			name: "different humidity",
+			event: StateUpdateEvent{
+				Timestamp:           baseEvent.Timestamp,
+				Source:              baseEvent.Source,
+				CurrentTemperature:  baseEvent.CurrentTemperature,
+				TargetTemperature:   baseEvent.TargetTemperature,
+				HeatingActive:       baseEvent.HeatingActive,
+				Mode:                baseEvent.Mode,
+				Pressure:            baseEvent.Pressure,
+				HotWaterActive:      baseEvent.HotWaterActive,
+				HotWaterTemperature: baseEvent.HotWaterTemperature,
+				OutdoorTemperature:  baseEvent.OutdoorTemperature,
+				Humidity:            baseEvent.Humidity + 1,
+			},
+			want: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -263,3 +370,89 @@ func TestStateUpdateEventEquals(t *testing.T) {
 		})
 	}
 }
+
+func TestStateUpdateEventEqualsNaN(t *testing.T) {
+	nan := math.NaN()
+
+	tests := []struct {
+		name string
+		a, b float64
+		want bool
+	}{
+		{name: "both NaN", a: nan, b: nan, want: true},
+		{name: "NaN and number", a: nan, b: 21.5, want: false},
+		{name: "number and NaN", a: 21.5, b: nan, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := StateUpdateEvent{CurrentTemperature: tt.a, Pressure: tt.a}
+			b := StateUpdateEvent{CurrentTemperature: tt.b, Pressure: tt.b}
+			if got := a.Equals(b); got != tt.want {
+				t.Errorf("Equals() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateUpdateEventDisplayTargetTemperature(t *testing.T) {
+	tests := []struct {
+		name  string
+		event StateUpdateEvent
+		want  float64
+	}{
+		{
+			name:  "heating shows reported setpoint",
+			event: StateUpdateEvent{Mode: "heat", TargetTemperature: 22.0, ComfortTemperature: 21.0},
+			want:  22.0,
+		},
+		{
+			name:  "off shows comfort setpoint",
+			event: StateUpdateEvent{Mode: "off", TargetTemperature: 15.0, ComfortTemperature: 22.0},
+			want:  22.0,
+		},
+		{
+			name:  "off without comfort setpoint falls back to reported",
+			event: StateUpdateEvent{Mode: "off", TargetTemperature: 15.0},
+			want:  15.0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.event.DisplayTargetTemperature(); got != tt.want {
+				t.Errorf("DisplayTargetTemperature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnergyEvent(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2026, time.October, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	event := EnergyEvent{
+		Source: "nefit",
+		Days: []EnergyUsage{
+			{Date: day(14), CentralHeating: 10.5, HotWater: 2.0},
+			{Date: day(15), CentralHeating: 12.0, HotWater: 1.5},
+		},
+	}
+
+	if got := event.Total(); got != 26.0 {
+		t.Errorf("Total() = %v, want 26", got)
+	}
+
+	latest, ok := event.Latest()
+	if !ok {
+		t.Fatal("Latest() ok = false, want true")
+	}
+	if !latest.Date.Equal(day(15)) || latest.Total() != 13.5 {
+		t.Errorf("Latest() = %+v, want 15 October with 13.5 kWh", latest)
+	}
+
+	if _, ok := (EnergyEvent{}).Latest(); ok {
+		t.Error("Latest() on an empty event ok = true, want false")
+	}
+}
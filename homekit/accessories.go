@@ -0,0 +1,127 @@
+package homekit
+
+import (
+	"strings"
+
+	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	"github.com/kradalby/nefit-homekit/config"
+)
+
+// Accessory IDs are fixed so that enabling or disabling a feature does not
+// renumber the other accessories and break automations in the Home app.
+const (
+	aidBridge             uint64 = 1
+	aidThermostat         uint64 = 2
+	aidOutdoorTemperature uint64 = 3
+)
+
+// Names used when NEFITHK_HAP_THERMOSTAT_NAME or NEFITHK_HAP_BRIDGE_NAME is blank.
+const (
+	defaultThermostatName = "Nefit Easy"
+	defaultBridgeName     = "Nefit Bridge"
+)
+
+// accessories holds the HomeKit accessories exposed by the server.
+type accessories struct {
+	thermostat *accessory.Thermostat
+
+	// Only set when the matching feature is enabled
+	humidity           *characteristic.CurrentRelativeHumidity // On the thermostat service
+	bridge             *accessory.Bridge
+	outdoorTemperature *accessory.Thermometer
+}
+
+// newAccessories builds the accessories for the features enabled in cfg.
+// Without extra accessories the thermostat is served on its own, as it always
+// has been, so existing pairings keep working.
+func newAccessories(cfg *config.Config) *accessories {
+	a := &accessories{
+		thermostat: newThermostat(cfg),
+	}
+
+	// Thermostats with a humidity sensor show it on the thermostat tile.
+	// Adding it changes the accessory configuration, which controllers pick
+	// up without pairing again.
+	if cfg.HAPHumidityEnabled {
+		a.humidity = characteristic.NewCurrentRelativeHumidity()
+		a.thermostat.Thermostat.AddC(a.humidity.C)
+	}
+
+	if cfg.HAPOutdoorTemperatureEnabled {
+		a.outdoorTemperature = accessory.NewTemperatureSensor(accessory.Info{
+			Name:         "Outdoor Temperature",
+			Manufacturer: "Bosch",
+			Model:        "Nefit Easy",
+			SerialNumber: cfg.NefitSerial + "-outdoor",
+		})
+		// hap creates temperature sensors with the thermostat category
+		a.outdoorTemperature.Type = accessory.TypeSensor
+		a.outdoorTemperature.TempSensor.CurrentTemperature.SetMinValue(-50.0)
+	}
+
+	if a.outdoorTemperature != nil {
+		a.bridge = accessory.NewBridge(accessory.Info{
+			Name:         nameOr(cfg.HAPBridgeName, defaultBridgeName),
+			Manufacturer: "Bosch",
+			Model:        "Nefit Easy",
+			SerialNumber: cfg.NefitSerial + "-bridge",
+		})
+		a.bridge.Id = aidBridge
+		a.thermostat.Id = aidThermostat
+		a.outdoorTemperature.Id = aidOutdoorTemperature
+	}
+
+	return a
+}
+
+// newThermostat creates the thermostat accessory.
+func newThermostat(cfg *config.Config) *accessory.Thermostat {
+	info := accessory.Info{
+		Name:         nameOr(cfg.HAPThermostatName, defaultThermostatName),
+		Manufacturer: "Bosch",
+		Model:        "Nefit Easy",
+		SerialNumber: cfg.NefitSerial,
+	}
+
+	thermostat := accessory.NewThermostat(info)
+
+	// Set temperature range
+	thermostat.Thermostat.TargetTemperature.SetMinValue(10.0)
+	thermostat.Thermostat.TargetTemperature.SetMaxValue(30.0)
+	thermostat.Thermostat.TargetTemperature.SetStepValue(cfg.TemperatureStep())
+	thermostat.Thermostat.TargetTemperature.SetValue(20.0)
+
+	return thermostat
+}
+
+// list returns the primary accessory, which determines the category shown
+// while pairing, followed by the remaining accessories, in the order
+// hap.NewServer expects them.
+func (a *accessories) list() (*accessory.A, []*accessory.A) {
+	if a.bridge == nil {
+		return a.thermostat.A, nil
+	}
+
+	others := []*accessory.A{a.thermostat.A}
+	if a.outdoorTemperature != nil {
+		others = append(others, a.outdoorTemperature.A)
+	}
+
+	return a.bridge.A, others
+}
+
+// advertisedName returns the name advertised over Bonjour while pairing,
+// which hap takes from the primary accessory.
+func (a *accessories) advertisedName() string {
+	primary, _ := a.list()
+	return primary.Name()
+}
+
+// nameOr returns name, or fallback if name is blank.
+func nameOr(name, fallback string) string {
+	if strings.TrimSpace(name) == "" {
+		return fallback
+	}
+	return name
+}
@@ -0,0 +1,180 @@
+package homekit
+
+import (
+	"testing"
+
+	"github.com/brutella/hap/accessory"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+func TestNewAccessories(t *testing.T) {
+	tests := []struct {
+		name            string
+		outdoor         bool
+		wantPrimaryType byte
+		wantTypes       []byte
+	}{
+		{
+			name:            "thermostat only",
+			wantPrimaryType: accessory.TypeThermostat,
+		},
+		{
+			name:            "outdoor temperature",
+			outdoor:         true,
+			wantPrimaryType: accessory.TypeBridge,
+			wantTypes:       []byte{accessory.TypeThermostat, accessory.TypeSensor},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:                  "TEST123",
+				HAPPin:                       "12345678",
+				HAPStoragePath:               t.TempDir(),
+				HAPPort:                      0,
+				HAPOutdoorTemperatureEnabled: tt.outdoor,
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+
+			primary, others := server.accessories.list()
+			if primary.Type != tt.wantPrimaryType {
+				t.Errorf("primary type = %d, want %d", primary.Type, tt.wantPrimaryType)
+			}
+			if len(others) != len(tt.wantTypes) {
+				t.Fatalf("got %d additional accessories, want %d", len(others), len(tt.wantTypes))
+			}
+			for i, a := range others {
+				if a.Type != tt.wantTypes[i] {
+					t.Errorf("accessory %d type = %d, want %d", i, a.Type, tt.wantTypes[i])
+				}
+			}
+
+			// Accessory IDs must be unique, hap assigns them when unset
+			seen := make(map[uint64]bool)
+			for _, a := range append([]*accessory.A{primary}, others...) {
+				if seen[a.Id] {
+					t.Errorf("duplicate accessory ID %d", a.Id)
+				}
+				seen[a.Id] = true
+			}
+
+			if (server.accessories.outdoorTemperature != nil) != tt.outdoor {
+				t.Errorf("outdoor temperature accessory present = %v, want %v",
+					server.accessories.outdoorTemperature != nil, tt.outdoor)
+			}
+		})
+	}
+}
+
+func TestNewAccessoriesStableIDs(t *testing.T) {
+	a := newAccessories(&config.Config{
+		NefitSerial:                  "TEST123",
+		HAPOutdoorTemperatureEnabled: true,
+	})
+
+	if a.bridge.Id != aidBridge {
+		t.Errorf("bridge ID = %d, want %d", a.bridge.Id, aidBridge)
+	}
+	if a.thermostat.Id != aidThermostat {
+		t.Errorf("thermostat ID = %d, want %d", a.thermostat.Id, aidThermostat)
+	}
+	if a.outdoorTemperature.Id != aidOutdoorTemperature {
+		t.Errorf("outdoor temperature ID = %d, want %d", a.outdoorTemperature.Id, aidOutdoorTemperature)
+	}
+}
+
+func TestThermostatTemperatureStep(t *testing.T) {
+	tests := []struct {
+		name string
+		step float64
+		want float64
+	}{
+		{name: "default", want: 0.5},
+		{name: "whole degrees", step: 1.0, want: 1.0},
+		{name: "tenths", step: 0.1, want: 0.1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thermostat := newThermostat(&config.Config{
+				NefitSerial:           "TEST123",
+				TargetTemperatureStep: tt.step,
+			})
+
+			if got := thermostat.Thermostat.TargetTemperature.StepVal; got != tt.want {
+				t.Errorf("target temperature step = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessoryNames(t *testing.T) {
+	tests := []struct {
+		name           string
+		cfg            config.Config
+		wantAdvertised string
+		wantThermostat string
+	}{
+		{
+			name:           "defaults",
+			cfg:            config.Config{},
+			wantAdvertised: "Nefit Easy",
+			wantThermostat: "Nefit Easy",
+		},
+		{
+			name:           "thermostat only advertises the thermostat name",
+			cfg:            config.Config{HAPThermostatName: "Living Room", HAPBridgeName: "Home Bridge"},
+			wantAdvertised: "Living Room",
+			wantThermostat: "Living Room",
+		},
+		{
+			name: "bridge advertises the bridge name",
+			cfg: config.Config{
+				HAPThermostatName:            "Living Room",
+				HAPBridgeName:                "Home Bridge",
+				HAPOutdoorTemperatureEnabled: true,
+			},
+			wantAdvertised: "Home Bridge",
+			wantThermostat: "Living Room",
+		},
+		{
+			name:           "blank names use the defaults",
+			cfg:            config.Config{HAPThermostatName: " ", HAPBridgeName: "", HAPOutdoorTemperatureEnabled: true},
+			wantAdvertised: "Nefit Bridge",
+			wantThermostat: "Nefit Easy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.NefitSerial = "TEST123"
+			a := newAccessories(&tt.cfg)
+
+			if got := a.advertisedName(); got != tt.wantAdvertised {
+				t.Errorf("advertised name = %q, want %q", got, tt.wantAdvertised)
+			}
+			if got := a.thermostat.Name(); got != tt.wantThermostat {
+				t.Errorf("thermostat name = %q, want %q", got, tt.wantThermostat)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package homekit
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/brutella/hap"
+)
+
+// maxBackupKeySize bounds a single key read from a pairing backup. HAP store
+// values are small, so anything larger is not a pairing backup.
+const maxBackupKeySize = 64 << 10
+
+// requiredBackupKeys are the keys without which a restored store would not be
+// the same accessory to HomeKit controllers.
+var requiredBackupKeys = []string{"uuid", "keypair"}
+
+// ExportPairing writes the HomeKit identity and pairings stored in the HAP
+// storage path to w as a gzipped tar, for restoring with ImportPairing after a
+// reinstall so existing automations keep working. The archive holds the
+// accessory's private key, so keep it as safe as the storage path itself.
+func ExportPairing(storagePath string, w io.Writer) error {
+	store := hap.NewFsStore(storagePath)
+
+	keys, err := pairingKeys(store)
+	if err != nil {
+		return fmt.Errorf("failed to list HAP store keys in %q: %w", storagePath, err)
+	}
+
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		b, err := store.Get(key)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read HAP store key %q: %w", key, err)
+		}
+		values[key] = b
+	}
+
+	for _, key := range requiredBackupKeys {
+		if _, ok := values[key]; !ok {
+			return fmt.Errorf("no HomeKit identity in %q to export, start the server once to create one", storagePath)
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, key := range keys {
+		b, ok := values[key]
+		if !ok {
+			continue
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name: key,
+			Mode: 0o600,
+			Size: int64(len(b)),
+		}); err != nil {
+			return fmt.Errorf("failed to write pairing backup: %w", err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			return fmt.Errorf("failed to write pairing backup: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write pairing backup: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write pairing backup: %w", err)
+	}
+
+	return nil
+}
+
+// ImportPairing restores a backup written by ExportPairing into the HAP
+// storage path, replacing the identity and pairings stored there. The whole
+// archive is validated before anything is written, so a bad backup leaves the
+// current identity untouched.
+func ImportPairing(storagePath string, r io.Reader) error {
+	values, err := readPairingBackup(r)
+	if err != nil {
+		return fmt.Errorf("invalid pairing backup: %w", err)
+	}
+
+	// Drop pairings that are not in the backup, they belong to another identity
+	if err := Reset(storagePath); err != nil {
+		return err
+	}
+
+	store := hap.NewFsStore(storagePath)
+	for key, b := range values {
+		if err := store.Set(key, b); err != nil {
+			return fmt.Errorf("failed to write HAP store key %q: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// readPairingBackup reads and validates the keys in a pairing backup.
+func readPairingBackup(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a gzip archive: %w", err)
+	}
+	defer func() {
+		_ = gz.Close()
+	}()
+
+	values := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not a tar archive: %w", err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("entry %q is not a regular file", hdr.Name)
+		}
+		if !isPairingKey(hdr.Name) {
+			return nil, fmt.Errorf("entry %q is not a HomeKit identity or pairing key", hdr.Name)
+		}
+		if hdr.Size > maxBackupKeySize {
+			return nil, fmt.Errorf("entry %q is %d bytes, larger than %d", hdr.Name, hdr.Size, maxBackupKeySize)
+		}
+		if _, ok := values[hdr.Name]; ok {
+			return nil, fmt.Errorf("entry %q appears twice", hdr.Name)
+		}
+
+		b, err := io.ReadAll(io.LimitReader(tr, maxBackupKeySize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read entry %q: %w", hdr.Name, err)
+		}
+		values[hdr.Name] = b
+	}
+
+	for _, key := range requiredBackupKeys {
+		if _, ok := values[key]; !ok {
+			return nil, fmt.Errorf("missing %q", key)
+		}
+	}
+
+	return values, nil
+}
+
+// pairingKeys returns the HAP store keys making up the HomeKit identity and
+// pairings, the same keys Reset forgets.
+func pairingKeys(store hap.Store) ([]string, error) {
+	keys := append([]string(nil), identityKeys...)
+	for _, suffix := range identitySuffixes {
+		matched, err := store.KeysWithSuffix(suffix)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, matched...)
+	}
+
+	return keys, nil
+}
+
+// isPairingKey reports whether name is a key pairingKeys can return. Names
+// with path separators are rejected so an archive cannot write outside the
+// storage path.
+func isPairingKey(name string) bool {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return false
+	}
+	if slices.Contains(identityKeys, name) {
+		return true
+	}
+	for _, suffix := range identitySuffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,169 @@
+package homekit
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+// newIdentity creates a HomeKit identity in storagePath by creating a server.
+func newIdentity(t *testing.T, storagePath string) *Server {
+	t.Helper()
+
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: storagePath,
+		HAPPort:        0,
+	}
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	_ = server.Close()
+
+	return server
+}
+
+// readStore returns the files in dir keyed by name.
+func readStore(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", dir, err)
+	}
+
+	files := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", entry.Name(), err)
+		}
+		files[entry.Name()] = string(b)
+	}
+
+	return files
+}
+
+func TestExportImportPairingRoundTrip(t *testing.T) {
+	source := t.TempDir()
+	original := newIdentity(t, source)
+
+	// hap only creates the keypair once it serves, then a controller pairs
+	if err := os.WriteFile(filepath.Join(source, "keypair"), []byte(`{"Public":"cHVi","Private":"cHJpdg=="}`), 0o600); err != nil {
+		t.Fatalf("failed to write keypair: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "6f6c64.pairing"), []byte(`{"Name":"iPhone"}`), 0o600); err != nil {
+		t.Fatalf("failed to write pairing: %v", err)
+	}
+
+	var backup bytes.Buffer
+	if err := ExportPairing(source, &backup); err != nil {
+		t.Fatalf("ExportPairing() error = %v", err)
+	}
+
+	// Restore over a fresh install with its own identity and pairing
+	target := t.TempDir()
+	newIdentity(t, target)
+	if err := os.WriteFile(filepath.Join(target, "6e6577.pairing"), []byte("{}"), 0o600); err != nil {
+		t.Fatalf("failed to write pairing: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "notes.txt"), []byte("keep"), 0o600); err != nil {
+		t.Fatalf("failed to write unrelated file: %v", err)
+	}
+
+	if err := ImportPairing(target, &backup); err != nil {
+		t.Fatalf("ImportPairing() error = %v", err)
+	}
+
+	want := readStore(t, source)
+	want["notes.txt"] = "keep"
+	got := readStore(t, target)
+	if len(got) != len(want) {
+		t.Errorf("restored store has %d files, want %d: %v", len(got), len(want), got)
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("restored %s = %q, want %q", name, got[name], content)
+		}
+	}
+
+	// The restored store serves the same accessory
+	restored := newIdentity(t, target)
+	if restored.server.SetupId != original.server.SetupId {
+		t.Errorf("restored setup ID = %s, want %s", restored.server.SetupId, original.server.SetupId)
+	}
+}
+
+func TestExportPairingWithoutIdentity(t *testing.T) {
+	var backup bytes.Buffer
+	if err := ExportPairing(t.TempDir(), &backup); err == nil {
+		t.Error("ExportPairing() of an empty store expected error, got nil")
+	}
+}
+
+func TestImportPairingRejectsInvalidArchives(t *testing.T) {
+	archive := func(files map[string]string) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for name, content := range files {
+			_ = tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(content))})
+			_, _ = tw.Write([]byte(content))
+		}
+		_ = tw.Close()
+		_ = gz.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "not gzip", data: []byte("not a backup")},
+		{name: "missing keypair", data: archive(map[string]string{"uuid": "u"})},
+		{name: "path traversal", data: archive(map[string]string{"uuid": "u", "keypair": "k", "../uuid": "x"})},
+		{name: "unknown key", data: archive(map[string]string{"uuid": "u", "keypair": "k", "notes.txt": "x"})},
+		{name: "bare suffix", data: archive(map[string]string{"uuid": "u", "keypair": "k", ".pairing": "x"})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storagePath := t.TempDir()
+			newIdentity(t, storagePath)
+			before := readStore(t, storagePath)
+
+			if err := ImportPairing(storagePath, bytes.NewReader(tt.data)); err == nil {
+				t.Fatal("ImportPairing() expected error, got nil")
+			}
+
+			// The current identity is left untouched
+			after := readStore(t, storagePath)
+			if len(after) != len(before) {
+				t.Fatalf("store changed from %d to %d files", len(before), len(after))
+			}
+			for name, content := range before {
+				if after[name] != content {
+					t.Errorf("%s changed to %q", name, after[name])
+				}
+			}
+		})
+	}
+}
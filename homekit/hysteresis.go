@@ -0,0 +1,96 @@
+package homekit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/clock"
+)
+
+// heatingHysteresis holds back changes of the heating active state until the
+// new state has been reported for the hold duration, so a modulating boiler
+// whose indicator briefly toggles does not flip CurrentHeatingCoolingState and
+// fill the HomeKit history. A state that flips back within the hold is dropped.
+type heatingHysteresis struct {
+	ctx   context.Context
+	clock clock.Clock
+	hold  time.Duration
+	apply func(active bool) // Called for changes that outlasted the hold
+
+	mu      sync.Mutex
+	known   bool // Whether a state has been applied yet
+	applied bool // Last state applied
+	pending bool // Whether a change is waiting out the hold
+	gen     uint64
+}
+
+// newHeatingHysteresis creates a hysteresis that calls apply once a changed
+// state has been stable for hold. A hold of 0 applies every change at once.
+func newHeatingHysteresis(ctx context.Context, c clock.Clock, hold time.Duration, apply func(active bool)) *heatingHysteresis {
+	return &heatingHysteresis{
+		ctx:   ctx,
+		clock: c,
+		hold:  hold,
+		apply: apply,
+	}
+}
+
+// Update reports the latest heating active state. It returns true if the
+// caller should apply the state right away, which is the case for the first
+// state and without a hold. Otherwise a change is applied through apply once
+// it has lasted for the hold, and repeats of a waiting change keep its
+// original start so steady reports do not postpone it.
+func (h *heatingHysteresis) Update(active bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.known || h.hold <= 0 {
+		h.known = true
+		h.applied = active
+		h.pending = false
+		h.gen++
+		return true
+	}
+
+	if active == h.applied {
+		// Flipped back before the hold passed, drop the waiting change
+		if h.pending {
+			h.pending = false
+			h.gen++
+		}
+		return false
+	}
+
+	if h.pending {
+		return false
+	}
+
+	h.pending = true
+	h.gen++
+
+	// Registered before returning so the hold starts with this report
+	go h.applyAfterHold(h.gen, active, h.clock.After(h.hold))
+	return false
+}
+
+// applyAfterHold applies active once the hold passes, unless the change was
+// dropped or superseded in the meantime.
+func (h *heatingHysteresis) applyAfterHold(gen uint64, active bool, after <-chan time.Time) {
+	select {
+	case <-after:
+	case <-h.ctx.Done():
+		return
+	}
+
+	h.mu.Lock()
+	if gen != h.gen {
+		h.mu.Unlock()
+		return
+	}
+	h.applied = active
+	h.pending = false
+	h.mu.Unlock()
+
+	h.apply(active)
+}
@@ -0,0 +1,211 @@
+package homekit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/clock"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+func TestHeatingHysteresis(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	applied := make(chan bool, 10)
+	h := newHeatingHysteresis(ctx, fake, 30*time.Second, func(active bool) {
+		applied <- active
+	})
+
+	expectApplied := func(want bool) {
+		t.Helper()
+		select {
+		case got := <-applied:
+			if got != want {
+				t.Fatalf("applied %v, want %v", got, want)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timeout waiting for %v to be applied", want)
+		}
+	}
+
+	expectNone := func() {
+		t.Helper()
+		select {
+		case got := <-applied:
+			t.Fatalf("unexpected apply of %v", got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// The first state is shown right away
+	if !h.Update(false) {
+		t.Fatal("Update(false) for the first state = false, want true")
+	}
+
+	// A boiler toggling every few seconds never holds a state long enough
+	for i := 0; i < 10; i++ {
+		if h.Update(i%2 == 0) {
+			t.Fatalf("Update() while toggling applied at once, step %d", i)
+		}
+		fake.Advance(5 * time.Second)
+	}
+	fake.Advance(time.Minute)
+	expectNone()
+
+	// A sustained change is applied once after the hold, steady reports in
+	// between do not postpone it
+	h.Update(true)
+	fake.Advance(20 * time.Second)
+	h.Update(true)
+	fake.Advance(10 * time.Second)
+	expectApplied(true)
+
+	fake.Advance(time.Minute)
+	expectNone()
+
+	// Repeating the applied state starts nothing
+	h.Update(true)
+	fake.Advance(time.Minute)
+	expectNone()
+}
+
+func TestHeatingHysteresisPollCadence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The defaults: the status is polled every 30s with a 90s hold
+	const poll = 30 * time.Second
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	applied := make(chan bool, 10)
+	h := newHeatingHysteresis(ctx, fake, 3*poll, func(active bool) {
+		applied <- active
+	})
+
+	expectNone := func() {
+		t.Helper()
+		select {
+		case got := <-applied:
+			t.Fatalf("unexpected apply of %v", got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	h.Update(false)
+
+	// A change reported by a single poll is gone by the next one
+	for _, active := range []bool{true, false, false, false, false} {
+		h.Update(active)
+		fake.Advance(poll)
+	}
+	expectNone()
+
+	// A change confirmed by the following polls is applied once the hold passes
+	for i := 0; i < 3; i++ {
+		h.Update(true)
+		expectNone()
+		fake.Advance(poll)
+	}
+	select {
+	case got := <-applied:
+		if !got {
+			t.Fatal("applied false, want true")
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for true to be applied")
+	}
+}
+
+func TestHeatingHysteresisDisabled(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := newHeatingHysteresis(context.Background(), fake, 0, func(active bool) {
+		t.Errorf("unexpected apply of %v", active)
+	})
+
+	for _, active := range []bool{false, true, false, true} {
+		if !h.Update(active) {
+			t.Errorf("Update(%v) = false, want true without a hold", active)
+		}
+	}
+	if got := fake.Waiters(); got != 0 {
+		t.Errorf("Waiters() = %d, want 0", got)
+	}
+}
+
+func TestUpdateAccessoryHeatingHysteresis(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:          "TEST123",
+		HAPPin:               "12345678",
+		HAPStoragePath:       t.TempDir(),
+		HAPPort:              0,
+		HAPHeatingHysteresis: 30 * time.Second,
+	}
+
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	server, err := New(cfg, logger, bus, WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	update := func(heating bool) {
+		server.updateAccessory(events.StateUpdateEvent{
+			Source:             "nefit",
+			CurrentTemperature: 20.5,
+			TargetTemperature:  21.0,
+			HeatingActive:      heating,
+			Mode:               events.ModeHeat,
+		})
+	}
+
+	heatingState := func() int {
+		server.charMu.Lock()
+		defer server.charMu.Unlock()
+		return server.accessory.Thermostat.CurrentHeatingCoolingState.Value()
+	}
+
+	update(false)
+	if got := heatingState(); got != 0 {
+		t.Fatalf("CurrentHeatingCoolingState = %d after first update, want 0", got)
+	}
+
+	// Rapid toggling settles on off, so the characteristic never changes
+	for i := 0; i < 10; i++ {
+		update(i%2 == 0)
+		fake.Advance(5 * time.Second)
+		if got := heatingState(); got != 0 {
+			t.Fatalf("CurrentHeatingCoolingState = %d while toggling at step %d, want 0", got, i)
+		}
+	}
+
+	// A sustained change shows once the hold has passed
+	update(true)
+	fake.Advance(29 * time.Second)
+	if got := heatingState(); got != 0 {
+		t.Fatalf("CurrentHeatingCoolingState = %d before the hold passed, want 0", got)
+	}
+	fake.Advance(time.Second)
+
+	deadline := time.Now().Add(1 * time.Second)
+	for heatingState() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("CurrentHeatingCoolingState did not change to 1 after the hold")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,75 @@
+package homekit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/brutella/hap"
+	"go.uber.org/zap"
+)
+
+// serialKey is the HAP store key holding the Nefit serial the stored HomeKit
+// identity was created for.
+const serialKey = "nefithk-serial"
+
+// identityKeys are the HAP store keys making up the accessory identity: the
+// keys written by hap itself and the setup ID and serial written by us.
+var identityKeys = []string{
+	"uuid",
+	"version",
+	"configHash",
+	"keypair",
+	"schema",
+	setupIDKey,
+	serialKey,
+}
+
+// identitySuffixes match the per-controller keys in the HAP store: pairings,
+// and entities left behind by the older hc library.
+var identitySuffixes = []string{".pairing", ".entity"}
+
+// Reset forgets the HomeKit identity stored in the HAP storage path, so the
+// next server starts unpaired, with a new setup ID and identity created from
+// the configured serial. Files not written by hap or this package are kept.
+func Reset(storagePath string) error {
+	store := hap.NewFsStore(storagePath)
+
+	keys, err := pairingKeys(store)
+	if err != nil {
+		return fmt.Errorf("failed to list HAP store keys in %q: %w", storagePath, err)
+	}
+
+	for _, key := range keys {
+		if err := store.Delete(key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete HAP store key %q: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// checkSerial records serial as the owner of a new HomeKit identity and warns
+// on every start while the stored identity belongs to a different boiler, as
+// controllers keep showing the old unit until the identity is reset.
+func checkSerial(store hap.Store, serial string, logger *zap.Logger) error {
+	b, err := store.Get(serialKey)
+	switch {
+	case err == nil:
+		if string(b) != serial {
+			logger.Warn("HomeKit identity was created for a different Nefit serial, restart with -reset-homekit to pair as the new boiler",
+				zap.String("stored_serial", string(b)),
+				zap.String("serial", serial),
+			)
+		}
+		return nil
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("failed to read stored serial: %w", err)
+	}
+
+	if err := store.Set(serialKey, []byte(serial)); err != nil {
+		return fmt.Errorf("failed to persist serial: %w", err)
+	}
+
+	return nil
+}
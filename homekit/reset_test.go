@@ -0,0 +1,133 @@
+package homekit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestResetForgetsIdentity(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	storagePath := t.TempDir()
+	newServer := func(serial string) *Server {
+		t.Helper()
+		cfg := &config.Config{
+			NefitSerial:    serial,
+			HAPPin:         "12345678",
+			HAPStoragePath: storagePath,
+			HAPPort:        0,
+		}
+		server, err := New(cfg, logger, bus)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		_ = server.Close()
+		return server
+	}
+	readKey := func(key string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(storagePath, key))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", key, err)
+		}
+		return string(b)
+	}
+
+	newServer("OLD123")
+	oldUUID := readKey("uuid")
+
+	// A controller paired with the old boiler, and an unrelated file
+	pairing := filepath.Join(storagePath, "6f6c64.pairing")
+	if err := os.WriteFile(pairing, []byte("{}"), 0o600); err != nil {
+		t.Fatalf("failed to write pairing: %v", err)
+	}
+	unrelated := filepath.Join(storagePath, "notes.txt")
+	if err := os.WriteFile(unrelated, []byte("keep"), 0o600); err != nil {
+		t.Fatalf("failed to write unrelated file: %v", err)
+	}
+
+	if err := Reset(storagePath); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+
+	if _, err := os.Stat(pairing); !os.IsNotExist(err) {
+		t.Errorf("pairing still exists after Reset(), stat error = %v", err)
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Errorf("unrelated file removed by Reset(): %v", err)
+	}
+
+	server := newServer("NEW456")
+
+	if got := server.accessory.A.Info.SerialNumber.Value(); got != "NEW456" {
+		t.Errorf("accessory serial = %q, want %q", got, "NEW456")
+	}
+	if got := readKey(serialKey); got != "NEW456" {
+		t.Errorf("stored serial = %q, want %q", got, "NEW456")
+	}
+	if got := readKey("uuid"); got == oldUUID {
+		t.Errorf("uuid = %q, want a new identity", got)
+	}
+	if server.server.IsPaired() {
+		t.Error("server is still paired after Reset()")
+	}
+}
+
+func TestResetMissingStore(t *testing.T) {
+	if err := Reset(filepath.Join(t.TempDir(), "hap")); err != nil {
+		t.Errorf("Reset() on an empty store error = %v", err)
+	}
+}
+
+func TestCheckSerialWarnsOnDifferentBoiler(t *testing.T) {
+	bus, err := events.New(zap.NewNop())
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	storagePath := t.TempDir()
+
+	for i, serial := range []string{"OLD123", "NEW456", "NEW456"} {
+		core, logs := observer.New(zap.WarnLevel)
+		cfg := &config.Config{
+			NefitSerial:    serial,
+			HAPPin:         "12345678",
+			HAPStoragePath: storagePath,
+			HAPPort:        0,
+		}
+		server, err := New(cfg, zap.New(core), bus)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		_ = server.Close()
+
+		// Only the first serial owns the identity; later ones keep warning
+		wantWarning := i > 0
+		gotWarning := false
+		for _, entry := range logs.All() {
+			if strings.Contains(entry.Message, "different Nefit serial") {
+				gotWarning = true
+			}
+		}
+		if gotWarning != wantWarning {
+			t.Errorf("start %d with serial %s: warning = %v, want %v", i, serial, gotWarning, wantWarning)
+		}
+	}
+}
@@ -4,34 +4,58 @@ package homekit
 import (
 	"context"
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/brutella/hap"
 	"github.com/brutella/hap/accessory"
+	"github.com/kradalby/nefit-homekit/clock"
 	"github.com/kradalby/nefit-homekit/config"
 	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/netutil"
+	"github.com/kradalby/nefit-homekit/recovery"
 	"go.uber.org/zap"
 	"tailscale.com/util/eventbus"
 )
 
-const (
-	modeOff  = "off"
-	modeHeat = "heat"
-)
+// serveShutdownTimeout bounds how long Close waits for the HAP server to
+// return after its context is cancelled.
+const serveShutdownTimeout = 5 * time.Second
 
-// Server manages the HomeKit HAP server and accessory.
+// Server manages the HomeKit HAP server and accessories.
 type Server struct {
-	cfg       *config.Config
-	logger    *zap.Logger
-	bus       *events.Bus
-	client    *eventbus.Client
-	server    *hap.Server
-	accessory *accessory.Thermostat
-	ctx       context.Context
-	cancel    context.CancelFunc
+	cfg         *config.Config
+	logger      *zap.Logger
+	bus         *events.Bus
+	client      *eventbus.Client
+	server      *hap.Server
+	accessories *accessories
+	accessory   *accessory.Thermostat
+	charMu      sync.Mutex // Serializes characteristic access between state updates and HomeKit writes
+	clock       clock.Clock
+	heating     *heatingHysteresis
+	stale       *staleness
+	connSub     *eventbus.Subscriber[events.ConnectionStatusEvent] // Subscribed in New so a Nefit connect before Start is not missed
+	serve       func(context.Context) error
+	serveDone   chan struct{} // Closed when serve returns, nil until Start
+	ctx         context.Context
+	cancel      context.CancelFunc
+}
+
+// Option configures optional Server behavior.
+type Option func(*Server)
+
+// WithClock sets the clock used for the heating state hysteresis and staleness.
+// The default is the real clock.
+func WithClock(c clock.Clock) Option {
+	return func(s *Server) {
+		s.clock = c
+	}
 }
 
 // New creates a new HomeKit server.
-func New(cfg *config.Config, logger *zap.Logger, bus *events.Bus) (*Server, error) {
+func New(cfg *config.Config, logger *zap.Logger, bus *events.Bus, opts ...Option) (*Server, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("config is required")
 	}
@@ -56,68 +80,149 @@ func New(cfg *config.Config, logger *zap.Logger, bus *events.Bus) (*Server, erro
 		logger: logger,
 		bus:    bus,
 		client: client,
+		clock:  clock.Real(),
 		ctx:    ctx,
 		cancel: cancel,
 	}
 
-	// Create thermostat accessory
-	info := accessory.Info{
-		Name:         "Nefit Easy",
-		Manufacturer: "Bosch",
-		Model:        "Nefit Easy",
-		SerialNumber: cfg.NefitSerial,
+	for _, opt := range opts {
+		opt(s)
 	}
 
-	s.accessory = accessory.NewThermostat(info)
+	// Create accessories for the enabled features
+	s.accessories = newAccessories(cfg)
+	s.accessory = s.accessories.thermostat
+	primary, others := s.accessories.list()
+
+	s.heating = newHeatingHysteresis(ctx, s.clock, cfg.HAPHeatingHysteresis, s.setHeatingActive)
 
-	// Set temperature range
-	s.accessory.Thermostat.TargetTemperature.SetMinValue(10.0)
-	s.accessory.Thermostat.TargetTemperature.SetMaxValue(30.0)
-	s.accessory.Thermostat.TargetTemperature.SetStepValue(0.5)
-	s.accessory.Thermostat.TargetTemperature.SetValue(20.0)
+	// Report the Nefit-backed accessories as not responding when updates stop
+	s.stale = newStaleness(s.clock, cfg.HAPStaleTimeout, logger)
+	failReadsWhenStale(s.accessory.A, s.stale)
+	if s.accessories.outdoorTemperature != nil {
+		failReadsWhenStale(s.accessories.outdoorTemperature.A, s.stale)
+	}
 
 	// Create HAP server
-	s.server, err = hap.NewServer(
-		hap.NewFsStore(cfg.HAPStoragePath),
-		s.accessory.A,
-	)
+	store := hap.NewFsStore(cfg.HAPStoragePath)
+	s.server, err = hap.NewServer(store, primary, others...)
 	if err != nil {
 		cancel()
 		return nil, fmt.Errorf("failed to create HAP server: %w", err)
 	}
 
+	if err := checkSerial(store, cfg.NefitSerial, logger); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to check HAP identity: %w", err)
+	}
+
 	// Set pin
 	s.server.Pin = cfg.HAPPin
 
+	// Set setup ID, generating a persistent one if not configured
+	s.server.SetupId = cfg.HAPSetupID
+	if s.server.SetupId == "" {
+		s.server.SetupId, err = loadOrCreateSetupID(store)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to load HAP setup ID: %w", err)
+		}
+	}
+
 	// Set port
 	s.server.Addr = fmt.Sprintf(":%d", cfg.HAPPort)
+	s.serve = s.server.ListenAndServe
+
+	// The Nefit connection tells whether the accessories are stale
+	s.connSub = eventbus.Subscribe[events.ConnectionStatusEvent](client)
 
 	logger.Info("homekit server created",
-		zap.String("name", info.Name),
-		zap.String("serial", info.SerialNumber),
+		zap.String("name", s.accessories.advertisedName()),
+		zap.String("thermostat_name", s.accessory.Name()),
+		zap.String("serial", cfg.NefitSerial),
+		zap.Int("accessories", 1+len(others)),
 		zap.String("pin", cfg.HAPPin),
+		zap.String("setup_id", s.server.SetupId),
 		zap.Int("port", cfg.HAPPort),
 	)
 
 	return s, nil
 }
 
+// SetupURI returns the X-HM:// pairing payload to encode in a HomeKit QR code.
+func (s *Server) SetupURI() (string, error) {
+	primary, _ := s.accessories.list()
+	return setupURI(s.server.Pin, s.server.SetupId, primary.Type)
+}
+
+// Thermostat returns the thermostat accessory served over HAP.
+func (s *Server) Thermostat() *accessory.Thermostat {
+	return s.accessory
+}
+
+// Preflight verifies that the HAP storage path is writable and the HAP
+// port can be bound, so misconfiguration fails at startup instead of
+// surfacing later in the server goroutine.
+func (s *Server) Preflight() error {
+	if err := os.MkdirAll(s.cfg.HAPStoragePath, 0o755); err != nil {
+		return fmt.Errorf("HAP storage path %q is not usable, check NEFITHK_HAP_STORAGE_PATH: %w", s.cfg.HAPStoragePath, err)
+	}
+
+	f, err := os.CreateTemp(s.cfg.HAPStoragePath, ".preflight-*")
+	if err != nil {
+		return fmt.Errorf("HAP storage path %q is not writable, check permissions or NEFITHK_HAP_STORAGE_PATH: %w", s.cfg.HAPStoragePath, err)
+	}
+	name := f.Name()
+	_ = f.Close()
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("failed to clean up preflight file in HAP storage path %q: %w", s.cfg.HAPStoragePath, err)
+	}
+
+	// hap binds the port itself with net.Listen, which also sets SO_REUSEADDR
+	// on Unix, so a restart can rebind while old connections are in TIME_WAIT
+	ln, err := netutil.Listen(s.ctx, "tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("HAP port %d is not available, stop the process using it or change NEFITHK_HAP_PORT: %w", s.cfg.HAPPort, err)
+	}
+	if err := ln.Close(); err != nil {
+		return fmt.Errorf("failed to release HAP port %d after preflight: %w", s.cfg.HAPPort, err)
+	}
+
+	s.logger.Debug("homekit preflight passed",
+		zap.String("storage_path", s.cfg.HAPStoragePath),
+		zap.Int("port", s.cfg.HAPPort),
+	)
+
+	return nil
+}
+
 // Start starts the HomeKit server and begins handling events.
 func (s *Server) Start() error {
 	s.logger.Info("starting homekit server")
 
+	if err := s.Preflight(); err != nil {
+		s.publishConnectionStatus(events.ConnectionStatusFailed, err.Error())
+		return fmt.Errorf("homekit preflight failed: %w", err)
+	}
+
 	// Subscribe to state update events
-	go s.handleStateUpdates()
+	recovery.Go(s.logger, "homekit state updates", s.handleStateUpdates)
+
+	// Track the Nefit connection for the staleness of the accessories
+	recovery.Go(s.logger, "homekit connection status", s.handleConnectionStatus)
 
 	// Setup accessory callbacks for user interactions
 	s.setupAccessoryCallbacks()
 
-	// Start HAP server in background
-	go func() {
-		if err := s.server.ListenAndServe(s.ctx); err != nil {
+	// Start HAP server in background. Close waits for serveDone, so the
+	// server cannot touch the bus after main has closed it.
+	s.serveDone = make(chan struct{})
+	recovery.Go(s.logger, "homekit server", func() {
+		defer close(s.serveDone)
+		if err := s.serve(s.ctx); err != nil {
 			s.logger.Error("HAP server error", zap.Error(err))
 		}
-	}()
+	})
 
 	// Publish connection status
 	s.publishConnectionStatus(events.ConnectionStatusConnected, "")
@@ -128,54 +233,65 @@ func (s *Server) Start() error {
 
 // setupAccessoryCallbacks sets up callbacks for user interactions.
 func (s *Server) setupAccessoryCallbacks() {
-	// Target temperature changed
-	s.accessory.Thermostat.TargetTemperature.OnValueRemoteUpdate(func(temp float64) {
-		s.logger.Info("target temperature changed via HomeKit",
-			zap.Float64("temperature", temp),
-		)
+	s.accessory.Thermostat.TargetTemperature.OnValueRemoteUpdate(s.onRemoteTargetTemperature)
+	s.accessory.Thermostat.TargetHeatingCoolingState.OnValueRemoteUpdate(s.onRemoteTargetHeatingCoolingState)
+}
 
-		// Publish command event
-		event := events.CommandEvent{
-			Source:            "homekit",
-			CommandType:       events.CommandTypeSetTemperature,
-			TargetTemperature: &temp,
-		}
-		s.bus.PublishCommand(s.client, event)
-	})
+// onRemoteTargetTemperature handles a target temperature written by a HomeKit controller.
+func (s *Server) onRemoteTargetTemperature(temp float64) {
+	s.charMu.Lock()
+	defer s.charMu.Unlock()
 
-	// Target heating cooling state changed
-	s.accessory.Thermostat.TargetHeatingCoolingState.OnValueRemoteUpdate(func(state int) {
-		s.logger.Info("heating mode changed via HomeKit",
-			zap.Int("state", state),
-		)
+	s.logger.Info("target temperature changed via HomeKit",
+		zap.Float64("temperature", temp),
+	)
 
-		// Map HomeKit state to mode string
-		var mode string
-		switch state {
-		case 0: // Off
-			mode = modeOff
-		case 1: // Heat
-			mode = modeHeat
-		case 3: // Auto
-			mode = modeHeat // Nefit only supports heat, not auto
-		default:
-			s.logger.Warn("unknown heating state", zap.Int("state", state))
-			return
-		}
+	// Publish command event
+	event := events.CommandEvent{
+		Source:            "homekit",
+		CommandType:       events.CommandTypeSetTemperature,
+		TargetTemperature: &temp,
+	}
+	s.bus.PublishCommand(s.client, event)
+}
 
-		// Publish command event
-		event := events.CommandEvent{
-			Source:      "homekit",
-			CommandType: events.CommandTypeSetMode,
-			Mode:        &mode,
-		}
-		s.bus.PublishCommand(s.client, event)
-	})
+// onRemoteTargetHeatingCoolingState handles a heating mode written by a HomeKit controller.
+func (s *Server) onRemoteTargetHeatingCoolingState(state int) {
+	s.charMu.Lock()
+	defer s.charMu.Unlock()
+
+	s.logger.Info("heating mode changed via HomeKit",
+		zap.Int("state", state),
+	)
+
+	// Map HomeKit state to mode string
+	var mode events.Mode
+	switch state {
+	case 0: // Off
+		mode = events.ModeOff
+	case 1: // Heat
+		mode = events.ModeHeat
+	case 3: // Auto, the Nefit clock program
+		mode = events.ModeAuto
+	default:
+		s.logger.Warn("unknown heating state", zap.Int("state", state))
+		return
+	}
+
+	// Publish command event
+	event := events.CommandEvent{
+		Source:      "homekit",
+		CommandType: events.CommandTypeSetMode,
+		Mode:        &mode,
+	}
+	s.bus.PublishCommand(s.client, event)
 }
 
 // handleStateUpdates subscribes to state update events and updates the accessory.
 func (s *Server) handleStateUpdates() {
-	sub := eventbus.Subscribe[events.StateUpdateEvent](s.client)
+	// Replay the last state so a restarted server does not show defaults
+	// until the next poll
+	sub := s.bus.SubscribeStateUpdates(s.client, true)
 	defer sub.Close()
 
 	s.logger.Info("subscribed to state update events")
@@ -191,13 +307,61 @@ func (s *Server) handleStateUpdates() {
 	}
 }
 
+// handleConnectionStatus follows the Nefit connection, so the accessories
+// only turn stale while it is down.
+func (s *Server) handleConnectionStatus() {
+	s.logger.Info("subscribed to connection status events")
+
+	for {
+		select {
+		case event := <-s.connSub.Events():
+			if event.Component == "nefit" {
+				s.stale.setConnected(event.Status == events.ConnectionStatusConnected)
+			}
+		case <-s.ctx.Done():
+			s.logger.Info("stopping connection status handler")
+			return
+		}
+	}
+}
+
+// setHeatingActive shows whether the boiler is heating, once a change has
+// outlasted the heating hysteresis.
+func (s *Server) setHeatingActive(active bool) {
+	s.charMu.Lock()
+	defer s.charMu.Unlock()
+
+	s.logger.Debug("updating heating state", zap.Bool("heating", active))
+	s.setHeatingActiveLocked(active)
+}
+
+// setHeatingActiveLocked sets CurrentHeatingCoolingState. charMu must be held.
+func (s *Server) setHeatingActiveLocked(active bool) {
+	if active {
+		_ = s.accessory.Thermostat.CurrentHeatingCoolingState.SetValue(1) // Heating
+	} else {
+		_ = s.accessory.Thermostat.CurrentHeatingCoolingState.SetValue(0) // Off
+	}
+}
+
 // updateAccessory updates the accessory with new state.
+// The SetValue calls here do not echo back as commands: hap only runs
+// OnValueRemoteUpdate callbacks for writes that come from a HomeKit request.
+// hap locks each characteristic on its own, so charMu keeps the
+// read-then-write sequences below from interleaving with the remote update
+// handlers. hap's own change notifications still read values unlocked,
+// which only hap can fix.
 func (s *Server) updateAccessory(event events.StateUpdateEvent) {
 	// Only update if event is from nefit (avoid loops)
 	if event.Source != "nefit" {
 		return
 	}
 
+	s.stale.touch()
+
+	s.charMu.Lock()
+	defer s.charMu.Unlock()
+
 	s.logger.Debug("updating accessory from state event",
 		zap.Float64("current_temp", event.CurrentTemperature),
 		zap.Float64("target_temp", event.TargetTemperature),
@@ -207,24 +371,58 @@ func (s *Server) updateAccessory(event events.StateUpdateEvent) {
 	// Update current temperature
 	s.accessory.Thermostat.CurrentTemperature.SetValue(event.CurrentTemperature)
 
-	// Update target temperature
-	s.accessory.Thermostat.TargetTemperature.SetValue(event.TargetTemperature)
+	// Update humidity when shown, unless the thermostat has not reported it
+	if s.accessories.humidity != nil && event.Humidity > 0 {
+		s.accessories.humidity.SetValue(event.Humidity)
+	}
 
-	// Update current heating cooling state
-	if event.HeatingActive {
-		_ = s.accessory.Thermostat.CurrentHeatingCoolingState.SetValue(1) // Heating
-	} else {
-		_ = s.accessory.Thermostat.CurrentHeatingCoolingState.SetValue(0) // Off
+	// Update target temperature, while off as configured with NEFITHK_HAP_OFF_TARGET
+	s.accessory.Thermostat.TargetTemperature.SetValue(s.targetTemperature(event))
+
+	// Update current heating cooling state, changes wait out the hysteresis
+	if s.heating.Update(event.HeatingActive) {
+		s.setHeatingActiveLocked(event.HeatingActive)
+	}
+
+	// Reflect firmware updates. Characteristic values are not part of the accessory
+	// configuration hash, so this does not affect pairing.
+	firmware := s.accessory.A.Info.FirmwareRevision
+	if event.FirmwareVersion != "" && event.FirmwareVersion != firmware.Value() {
+		s.logger.Info("updating firmware revision",
+			zap.String("firmware", event.FirmwareVersion),
+			zap.String("previous", firmware.Value()),
+		)
+		firmware.SetValue(event.FirmwareVersion)
 	}
 
 	// Update target heating cooling state based on mode
 	switch event.Mode {
-	case modeOff:
+	case events.ModeOff:
 		_ = s.accessory.Thermostat.TargetHeatingCoolingState.SetValue(0) // Off
-	case modeHeat:
+	case events.ModeHeat:
 		_ = s.accessory.Thermostat.TargetHeatingCoolingState.SetValue(1) // Heat
+	case events.ModeAuto:
+		_ = s.accessory.Thermostat.TargetHeatingCoolingState.SetValue(3) // Auto
 	default:
-		s.logger.Warn("unknown mode", zap.String("mode", event.Mode))
+		s.logger.Warn("unknown mode", zap.String("mode", string(event.Mode)))
+	}
+}
+
+// targetTemperature returns the target temperature to show for event. While
+// off, Nefit reports its setback setpoint, which looks like a user choice, so
+// by default the comfort setpoint is kept instead.
+func (s *Server) targetTemperature(event events.StateUpdateEvent) float64 {
+	if event.Mode != events.ModeOff {
+		return event.TargetTemperature
+	}
+
+	switch s.cfg.HAPOffTarget {
+	case config.HAPOffTargetSetback:
+		return event.TargetTemperature
+	case config.HAPOffTargetFixed:
+		return s.cfg.HAPOffTargetTemperature
+	default:
+		return event.DisplayTargetTemperature()
 	}
 }
 
@@ -245,8 +443,18 @@ func (s *Server) Close() error {
 	s.publishConnectionStatus(events.ConnectionStatusDisconnected, "")
 
 	s.cancel()
+	s.connSub.Close()
 
-	// The server stops when the context is cancelled
+	// The server stops when the context is cancelled, wait for it to return
+	if s.serveDone != nil {
+		select {
+		case <-s.serveDone:
+		case <-time.After(serveShutdownTimeout):
+			s.logger.Warn("timed out waiting for HAP server to stop",
+				zap.Duration("timeout", serveShutdownTimeout),
+			)
+		}
+	}
 
 	s.logger.Info("homekit server shut down complete")
 	return nil
@@ -1,11 +1,23 @@
 package homekit
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/brutella/hap/characteristic"
 	"github.com/kradalby/nefit-homekit/config"
 	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/leaktest"
 	"go.uber.org/zap"
 	"tailscale.com/util/eventbus"
 )
@@ -176,6 +188,20 @@ func TestUpdateAccessory(t *testing.T) {
 			wantHeating:   0, // Off
 			wantTargetMode: 0, // Off
 		},
+		{
+			name: "clock program",
+			event: events.StateUpdateEvent{
+				Source:             "nefit",
+				CurrentTemperature: 20.5,
+				TargetTemperature:  21.0,
+				HeatingActive:      true,
+				Mode:               "auto",
+			},
+			wantCurrent:   20.5,
+			wantTarget:    21.0,
+			wantHeating:   1, // Heating
+			wantTargetMode: 3, // Auto
+		},
 	}
 
 	for _, tt := range tests {
@@ -201,6 +227,256 @@ func TestUpdateAccessory(t *testing.T) {
 	}
 }
 
+func TestUpdateAccessoryHumidity(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:        "TEST123",
+		HAPPin:             "12345678",
+		HAPStoragePath:     t.TempDir(),
+		HAPPort:            0,
+		HAPHumidityEnabled: true,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	// The characteristic is served on the thermostat service
+	found := false
+	for _, c := range server.accessory.Thermostat.Cs {
+		if c == server.accessories.humidity.C {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("thermostat service has no CurrentRelativeHumidity characteristic")
+	}
+
+	sequence := []struct {
+		humidity float64
+		want     float64
+	}{
+		{humidity: 48.5, want: 48.5},
+		{humidity: 52, want: 52},
+		{humidity: 0, want: 52}, // Not reported, the last reading stays
+	}
+
+	for i, step := range sequence {
+		server.updateAccessory(events.StateUpdateEvent{
+			Source:             "nefit",
+			CurrentTemperature: 21.0,
+			TargetTemperature:  21.0,
+			Mode:               "heat",
+			Humidity:           step.humidity,
+		})
+
+		if got := server.accessories.humidity.Value(); got != step.want {
+			t.Errorf("step %d: CurrentRelativeHumidity = %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+func TestUpdateAccessoryHumidityDisabled(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	// Thermostats without the sensor do not show a humidity stuck at 0
+	if server.accessories.humidity != nil {
+		t.Fatal("humidity characteristic created without NEFITHK_HAP_HUMIDITY_ENABLED")
+	}
+	for _, c := range server.accessory.Thermostat.Cs {
+		if c.Type == characteristic.TypeCurrentRelativeHumidity {
+			t.Fatal("thermostat service has a CurrentRelativeHumidity characteristic")
+		}
+	}
+
+	server.updateAccessory(events.StateUpdateEvent{
+		Source:             "nefit",
+		CurrentTemperature: 21.0,
+		TargetTemperature:  21.0,
+		Mode:               "heat",
+		Humidity:           48.5,
+	})
+}
+
+func TestUpdateAccessoryPreservesComfortTargetWhileOff(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	sequence := []events.StateUpdateEvent{
+		{Source: "nefit", CurrentTemperature: 20.0, TargetTemperature: 22.0, ComfortTemperature: 22.0, Mode: "heat"},
+		{Source: "nefit", CurrentTemperature: 20.0, TargetTemperature: 15.0, ComfortTemperature: 22.0, Mode: "off"},
+		{Source: "nefit", CurrentTemperature: 20.0, TargetTemperature: 22.0, ComfortTemperature: 22.0, Mode: "heat"},
+	}
+
+	for i, event := range sequence {
+		server.updateAccessory(event)
+
+		if got := server.accessory.Thermostat.TargetTemperature.Value(); got != 22.0 {
+			t.Errorf("step %d: TargetTemperature = %v, want 22.0", i, got)
+		}
+	}
+}
+
+func TestUpdateAccessoryOffTarget(t *testing.T) {
+	tests := []struct {
+		name       string
+		offTarget  string
+		offTemp    float64
+		wantOff    float64
+		wantResume float64
+	}{
+		{name: "default keeps comfort", wantOff: 22.0, wantResume: 22.0},
+		{name: "comfort", offTarget: config.HAPOffTargetComfort, wantOff: 22.0, wantResume: 22.0},
+		{name: "setback", offTarget: config.HAPOffTargetSetback, wantOff: 15.0, wantResume: 22.0},
+		{name: "fixed", offTarget: config.HAPOffTargetFixed, offTemp: 10.0, wantOff: 10.0, wantResume: 22.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:             "TEST123",
+				HAPPin:                  "12345678",
+				HAPStoragePath:          t.TempDir(),
+				HAPPort:                 0,
+				HAPOffTarget:            tt.offTarget,
+				HAPOffTargetTemperature: tt.offTemp,
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+
+			server.updateAccessory(events.StateUpdateEvent{Source: "nefit", CurrentTemperature: 20.0, TargetTemperature: 15.0, ComfortTemperature: 22.0, Mode: "off"})
+			if got := server.accessory.Thermostat.TargetTemperature.Value(); got != tt.wantOff {
+				t.Errorf("TargetTemperature while off = %v, want %v", got, tt.wantOff)
+			}
+
+			server.updateAccessory(events.StateUpdateEvent{Source: "nefit", CurrentTemperature: 20.0, TargetTemperature: 22.0, ComfortTemperature: 22.0, Mode: "heat"})
+			if got := server.accessory.Thermostat.TargetTemperature.Value(); got != tt.wantResume {
+				t.Errorf("TargetTemperature after heating resumed = %v, want %v", got, tt.wantResume)
+			}
+		})
+	}
+}
+
+func TestUpdateAccessoryFirmwareRevision(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	steps := []struct {
+		name     string
+		firmware string
+		want     string
+	}{
+		{"initial firmware", "02.03.01", "02.03.01"},
+		{"firmware updated", "02.04.00", "02.04.00"},
+		{"unknown firmware keeps last", "", "02.04.00"},
+	}
+
+	for _, step := range steps {
+		server.updateAccessory(events.StateUpdateEvent{
+			Source:             "nefit",
+			CurrentTemperature: 20.0,
+			TargetTemperature:  21.0,
+			Mode:               "heat",
+			FirmwareVersion:    step.firmware,
+		})
+
+		if got := server.accessory.A.Info.FirmwareRevision.Value(); got != step.want {
+			t.Errorf("%s: FirmwareRevision = %q, want %q", step.name, got, step.want)
+		}
+	}
+}
+
 func TestUpdateAccessoryIgnoresNonNefitSource(t *testing.T) {
 	logger := zap.NewNop()
 	bus, err := events.New(logger)
@@ -245,6 +521,129 @@ func TestUpdateAccessoryIgnoresNonNefitSource(t *testing.T) {
 	}
 }
 
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, cfg *config.Config)
+		wantErr string
+	}{
+		{
+			name:  "writable store and free port",
+			setup: func(t *testing.T, cfg *config.Config) {},
+		},
+		{
+			name: "storage path is not a directory",
+			setup: func(t *testing.T, cfg *config.Config) {
+				file := filepath.Join(t.TempDir(), "not-a-dir")
+				if err := os.WriteFile(file, nil, 0o600); err != nil {
+					t.Fatalf("WriteFile() error = %v", err)
+				}
+				cfg.HAPStoragePath = file
+			},
+			wantErr: "NEFITHK_HAP_STORAGE_PATH",
+		},
+		{
+			name: "port already in use",
+			setup: func(t *testing.T, cfg *config.Config) {
+				ln, err := net.Listen("tcp", ":0")
+				if err != nil {
+					t.Fatalf("Listen() error = %v", err)
+				}
+				t.Cleanup(func() {
+					_ = ln.Close()
+				})
+				cfg.HAPPort = ln.Addr().(*net.TCPAddr).Port
+			},
+			wantErr: "NEFITHK_HAP_PORT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:    "TEST123",
+				HAPPin:         "12345678",
+				HAPStoragePath: t.TempDir(),
+				HAPPort:        0,
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+
+			// Apply the scenario after New so the HAP store is created normally
+			tt.setup(t, cfg)
+			server.server.Addr = fmt.Sprintf(":%d", cfg.HAPPort)
+
+			err = server.Preflight()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Preflight() error = %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("Preflight() expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Preflight() error = %q, want it to mention %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStartFailsPreflight(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        ln.Addr().(*net.TCPAddr).Port,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	if err := server.Start(); err == nil {
+		t.Fatal("Start() expected preflight error, got nil")
+	}
+}
+
 func TestStateUpdatePubSub(t *testing.T) {
 	logger := zap.NewNop()
 	bus, err := events.New(logger)
@@ -407,3 +806,215 @@ func TestClose(t *testing.T) {
 		t.Error("context was not cancelled")
 	}
 }
+
+func TestUpdateAccessoryDoesNotEchoCommands(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	server.setupAccessoryCallbacks()
+
+	subscriberClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.CommandEvent](subscriberClient)
+	defer sub.Close()
+
+	// Nefit-originated state changing both target and mode must not become a command
+	server.updateAccessory(events.StateUpdateEvent{
+		Source:             "nefit",
+		CurrentTemperature: 20.0,
+		TargetTemperature:  24.0,
+		Mode:               events.ModeOff,
+	})
+
+	select {
+	case event := <-sub.Events():
+		t.Fatalf("unexpected command from nefit state update: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// A write from a HomeKit request still publishes a command
+	req := httptest.NewRequest(http.MethodPut, "/characteristics", nil)
+	server.accessory.Thermostat.TargetTemperature.SetValueRequest(22.5, req)
+
+	select {
+	case event := <-sub.Events():
+		if event.Source != "homekit" || event.CommandType != events.CommandTypeSetTemperature {
+			t.Errorf("command = %+v, want homekit set_temperature", event)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for command from HomeKit request")
+	}
+}
+
+func TestConcurrentStateAndRemoteUpdates(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	const iterations = 200
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// State updates from nefit
+	go func() {
+		defer wg.Done()
+		for i := range iterations {
+			server.updateAccessory(events.StateUpdateEvent{
+				Source:             "nefit",
+				CurrentTemperature: 18.0 + float64(i%5),
+				TargetTemperature:  20.0 + float64(i%3),
+				HeatingActive:      i%2 == 0,
+				Mode:               events.ModeHeat,
+				FirmwareVersion:    fmt.Sprintf("1.%d", i%4),
+			})
+		}
+	}()
+
+	// Writes from HomeKit controllers, as delivered by the remote update callbacks
+	go func() {
+		defer wg.Done()
+		for i := range iterations {
+			server.onRemoteTargetTemperature(21.0 + float64(i%4)/2)
+			server.onRemoteTargetHeatingCoolingState(i % 2)
+		}
+	}()
+
+	wg.Wait()
+
+	// The last state update is applied in full
+	if got, want := server.accessory.Thermostat.TargetTemperature.Value(), 20.0+float64((iterations-1)%3); got != want {
+		t.Errorf("TargetTemperature = %v, want %v", got, want)
+	}
+}
+
+func TestLifecycleDoesNotLeakGoroutines(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	publisher, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	bus.PublishStateUpdate(publisher, events.StateUpdateEvent{
+		Source:             "nefit",
+		CurrentTemperature: 20.5,
+		TargetTemperature:  21.0,
+		Mode:               events.ModeHeat,
+	})
+	time.Sleep(50 * time.Millisecond)
+}
+
+func TestCloseWaitsForServe(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// Stand in for a HAP server that is still unwinding after cancellation
+	var exited atomic.Bool
+	server.serve = func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(100 * time.Millisecond)
+		exited.Store(true)
+		return nil
+	}
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !exited.Load() {
+		t.Error("Close() returned before the serve goroutine exited")
+	}
+}
@@ -0,0 +1,104 @@
+package homekit
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/brutella/hap"
+)
+
+const (
+	// setupIDKey is the HAP store key holding the generated setup ID.
+	setupIDKey = "nefithk-setupid"
+
+	// setupIDAlphabet lists the characters allowed in a setup ID.
+	setupIDAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+	// setupIDLength is the length of a setup ID.
+	setupIDLength = 4
+
+	// setupFlagIP marks the accessory as supporting HAP over IP in the setup payload.
+	setupFlagIP = 2
+)
+
+// loadOrCreateSetupID returns the setup ID persisted in the HAP store, generating
+// and storing a random one on first use so it is stable across restarts but unique
+// per install.
+func loadOrCreateSetupID(store hap.Store) (string, error) {
+	b, err := store.Get(setupIDKey)
+	switch {
+	case err == nil && validSetupID(string(b)):
+		return string(b), nil
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return "", fmt.Errorf("failed to read setup ID: %w", err)
+	}
+
+	id, err := generateSetupID()
+	if err != nil {
+		return "", err
+	}
+
+	if err := store.Set(setupIDKey, []byte(id)); err != nil {
+		return "", fmt.Errorf("failed to persist setup ID: %w", err)
+	}
+
+	return id, nil
+}
+
+// generateSetupID returns a random setup ID.
+func generateSetupID() (string, error) {
+	var sb strings.Builder
+	alphabetLen := big.NewInt(int64(len(setupIDAlphabet)))
+
+	for i := 0; i < setupIDLength; i++ {
+		n, err := rand.Int(rand.Reader, alphabetLen)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate setup ID: %w", err)
+		}
+		sb.WriteByte(setupIDAlphabet[n.Int64()])
+	}
+
+	return sb.String(), nil
+}
+
+// validSetupID reports whether id is a well-formed setup ID.
+func validSetupID(id string) bool {
+	if len(id) != setupIDLength {
+		return false
+	}
+
+	for _, r := range id {
+		if !strings.ContainsRune(setupIDAlphabet, r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setupURI builds the X-HM:// setup payload encoded in HomeKit pairing QR codes.
+// The payload packs the accessory category, the IP transport flag and the setup
+// code into a base36 number, followed by the setup ID.
+func setupURI(pin, setupID string, category byte) (string, error) {
+	code, err := strconv.ParseUint(pin, 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid HAP pin %q: %w", pin, err)
+	}
+	if !validSetupID(setupID) {
+		return "", fmt.Errorf("invalid setup ID %q", setupID)
+	}
+
+	payload := uint64(category)<<31 | uint64(setupFlagIP)<<27 | code
+
+	encoded := strings.ToUpper(strconv.FormatUint(payload, 36))
+	if len(encoded) < 9 {
+		encoded = strings.Repeat("0", 9-len(encoded)) + encoded
+	}
+
+	return "X-HM://" + encoded + setupID, nil
+}
@@ -0,0 +1,178 @@
+package homekit
+
+import (
+	"testing"
+
+	"github.com/brutella/hap"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+func TestLoadOrCreateSetupIDPersists(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := loadOrCreateSetupID(hap.NewFsStore(dir))
+	if err != nil {
+		t.Fatalf("loadOrCreateSetupID() error = %v", err)
+	}
+	if !validSetupID(first) {
+		t.Fatalf("loadOrCreateSetupID() = %q, want 4 uppercase letters or digits", first)
+	}
+
+	// A fresh store on the same path simulates a restart
+	second, err := loadOrCreateSetupID(hap.NewFsStore(dir))
+	if err != nil {
+		t.Fatalf("loadOrCreateSetupID() second call error = %v", err)
+	}
+	if second != first {
+		t.Errorf("setup ID after reload = %q, want %q", second, first)
+	}
+}
+
+func TestLoadOrCreateSetupIDReplacesInvalid(t *testing.T) {
+	store := hap.NewFsStore(t.TempDir())
+	if err := store.Set(setupIDKey, []byte("bad!")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	id, err := loadOrCreateSetupID(store)
+	if err != nil {
+		t.Fatalf("loadOrCreateSetupID() error = %v", err)
+	}
+	if !validSetupID(id) {
+		t.Errorf("loadOrCreateSetupID() = %q, want a regenerated valid ID", id)
+	}
+}
+
+func TestValidSetupID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"7XQ2", true},
+		{"0000", true},
+		{"ZZZZ", true},
+		{"7xq2", false},
+		{"7XQ", false},
+		{"7XQ22", false},
+		{"7X-2", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			if got := validSetupID(tt.id); got != tt.want {
+				t.Errorf("validSetupID(%q) = %v, want %v", tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetupURI(t *testing.T) {
+	tests := []struct {
+		name     string
+		pin      string
+		setupID  string
+		category byte
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "thermostat",
+			pin:      "00102003",
+			setupID:  "7XQ2",
+			category: 9,
+			want:     "X-HM://00902VXPV7XQ2",
+		},
+		{
+			name:     "non-numeric pin",
+			pin:      "0010200A",
+			setupID:  "7XQ2",
+			category: 9,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid setup ID",
+			pin:      "00102003",
+			setupID:  "7xq2",
+			category: 9,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := setupURI(tt.pin, tt.setupID, tt.category)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("setupURI() = %q, expected error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("setupURI() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("setupURI() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerSetupID(t *testing.T) {
+	tests := []struct {
+		name    string
+		setupID string
+		wantID  string
+	}{
+		{name: "configured", setupID: "AB12", wantID: "AB12"},
+		{name: "generated", setupID: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:    "TEST123",
+				HAPPin:         "12345678",
+				HAPStoragePath: t.TempDir(),
+				HAPSetupID:     tt.setupID,
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+
+			if !validSetupID(server.server.SetupId) {
+				t.Fatalf("SetupId = %q, want a valid setup ID", server.server.SetupId)
+			}
+			if tt.wantID != "" && server.server.SetupId != tt.wantID {
+				t.Errorf("SetupId = %q, want %q", server.server.SetupId, tt.wantID)
+			}
+
+			uri, err := server.SetupURI()
+			if err != nil {
+				t.Fatalf("SetupURI() error = %v", err)
+			}
+			if want := "X-HM://"; uri[:len(want)] != want {
+				t.Errorf("SetupURI() = %q, want %s prefix", uri, want)
+			}
+			if got := uri[len(uri)-4:]; got != server.server.SetupId {
+				t.Errorf("SetupURI() suffix = %q, want setup ID %q", got, server.server.SetupId)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package homekit
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/brutella/hap"
+	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+	"github.com/kradalby/nefit-homekit/clock"
+	"go.uber.org/zap"
+)
+
+// staleness tracks whether the Nefit link is alive, so the accessories can
+// report themselves as not responding once it has been down for longer than
+// the timeout. HomeKit otherwise keeps showing the last values as long as the
+// HAP server answers. The link is alive while the Nefit client is connected:
+// the bus drops unchanged states, so a healthy thermostat can go hours
+// without a state update.
+type staleness struct {
+	clock   clock.Clock
+	timeout time.Duration // 0 disables
+	logger  *zap.Logger
+
+	mu        sync.Mutex
+	last      time.Time // Last time the link was known to be alive
+	connected bool      // Whether the Nefit client is connected
+	stale     bool      // Whether reads were last reported as failing, for logging
+}
+
+// newStaleness creates a staleness tracker counting from now, so a Nefit link
+// that never comes up also turns stale.
+func newStaleness(c clock.Clock, timeout time.Duration, logger *zap.Logger) *staleness {
+	return &staleness{
+		clock:   c,
+		timeout: timeout,
+		logger:  logger,
+		last:    c.Now(),
+	}
+}
+
+// touch records a Nefit update.
+func (st *staleness) touch() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.last = st.clock.Now()
+	st.resumedLocked()
+}
+
+// setConnected records the Nefit connection state. The timeout counts from
+// when the connection was lost, repeated reconnect attempts do not restart it.
+func (st *staleness) setConnected(connected bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if connected == st.connected {
+		return
+	}
+	st.connected = connected
+	st.last = st.clock.Now()
+	if connected {
+		st.resumedLocked()
+	}
+}
+
+// resumedLocked logs that the accessories respond again. st.mu must be held.
+func (st *staleness) resumedLocked() {
+	if st.stale {
+		st.stale = false
+		st.logger.Info("nefit link alive again, accessories responding again")
+	}
+}
+
+// check reports whether the Nefit link has been down for the timeout.
+func (st *staleness) check() bool {
+	if st.timeout <= 0 {
+		return false
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.connected {
+		return false
+	}
+
+	since := st.clock.Now().Sub(st.last)
+	stale := since >= st.timeout
+	if stale && !st.stale {
+		st.logger.Warn("nefit link down, reporting accessories as not responding",
+			zap.Duration("since_alive", since),
+			zap.Duration("timeout", st.timeout),
+		)
+	}
+	st.stale = stale
+
+	return stale
+}
+
+// failReadsWhenStale makes reads of the characteristics of a, other than its
+// accessory information, fail with a communication failure while st is stale,
+// which the Home app shows as "Not Responding".
+func failReadsWhenStale(a *accessory.A, st *staleness) {
+	for _, s := range a.Ss {
+		if s.Type == service.TypeAccessoryInformation {
+			continue
+		}
+		for _, c := range s.Cs {
+			c.ValueRequestFunc = staleValueRequest(c, st)
+		}
+	}
+}
+
+// staleValueRequest returns the value of c, or a communication failure while
+// st is stale.
+func staleValueRequest(c *characteristic.C, st *staleness) func(*http.Request) (interface{}, int) {
+	return func(*http.Request) (interface{}, int) {
+		if st.check() {
+			return nil, hap.JsonStatusServiceCommunicationFailure
+		}
+		return c.Value(), 0
+	}
+}
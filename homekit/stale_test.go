@@ -0,0 +1,199 @@
+package homekit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brutella/hap"
+	"github.com/kradalby/nefit-homekit/clock"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+func TestStaleAccessoryNotResponding(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:     "TEST123",
+		HAPPin:          "12345678",
+		HAPStoragePath:  t.TempDir(),
+		HAPPort:         0,
+		HAPStaleTimeout: 30 * time.Minute,
+	}
+
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	server, err := New(cfg, logger, bus, WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	update := func() {
+		server.updateAccessory(events.StateUpdateEvent{
+			Source:             "nefit",
+			CurrentTemperature: 20.5,
+			TargetTemperature:  21.0,
+			Mode:               events.ModeHeat,
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/characteristics", nil)
+	readCurrent := func() (interface{}, int) {
+		return server.accessory.Thermostat.CurrentTemperature.ValueRequest(req)
+	}
+
+	update()
+	fake.Advance(29 * time.Minute)
+	if v, code := readCurrent(); code != 0 || v != 20.5 {
+		t.Fatalf("read before the timeout = %v, %d, want 20.5, 0", v, code)
+	}
+
+	// A dead Nefit link turns the accessory unresponsive
+	fake.Advance(time.Minute)
+	if _, code := readCurrent(); code != hap.JsonStatusServiceCommunicationFailure {
+		t.Errorf("read after the timeout code = %d, want %d", code, hap.JsonStatusServiceCommunicationFailure)
+	}
+	if _, code := server.accessory.Thermostat.TargetHeatingCoolingState.ValueRequest(req); code != hap.JsonStatusServiceCommunicationFailure {
+		t.Errorf("TargetHeatingCoolingState read after the timeout code = %d, want %d", code, hap.JsonStatusServiceCommunicationFailure)
+	}
+
+	// The accessory information stays readable
+	if _, code := server.accessory.A.Info.Name.ValueRequest(req); code != 0 {
+		t.Errorf("Name read after the timeout code = %d, want 0", code)
+	}
+
+	// The next update makes it respond again
+	update()
+	if v, code := readCurrent(); code != 0 || v != 20.5 {
+		t.Errorf("read after an update = %v, %d, want 20.5, 0", v, code)
+	}
+}
+
+func TestStaleAccessoryFollowsNefitConnection(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:           "TEST123",
+		HAPPin:                "12345678",
+		HAPStoragePath:        t.TempDir(),
+		HAPPort:               0,
+		HAPStaleTimeout:       30 * time.Minute,
+		XMPPKeepaliveInterval: 30 * time.Second,
+	}
+
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	server, err := New(cfg, logger, bus, WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	server.serve = func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	nefitClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	publishStatus := func(status events.ConnectionStatus) {
+		t.Helper()
+		bus.PublishConnectionStatus(nefitClient, events.ConnectionStatusEvent{Component: "nefit", Status: status})
+
+		connected := status == events.ConnectionStatusConnected
+		deadline := time.Now().Add(time.Second)
+		for {
+			server.stale.mu.Lock()
+			got := server.stale.connected
+			server.stale.mu.Unlock()
+			if got == connected {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("connected = %v after a %s status, want %v", got, status, connected)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/characteristics", nil)
+	readCode := func() int {
+		_, code := server.accessory.Thermostat.CurrentTemperature.ValueRequest(req)
+		return code
+	}
+
+	publishStatus(events.ConnectionStatusConnected)
+
+	// A healthy thermostat reports the same state on every poll, which the
+	// bus drops as duplicates
+	state := events.StateUpdateEvent{
+		Source:             "nefit",
+		CurrentTemperature: 20.5,
+		TargetTemperature:  21.0,
+		Mode:               events.ModeHeat,
+	}
+	for elapsed := time.Duration(0); elapsed <= 2*cfg.HAPStaleTimeout; elapsed += cfg.XMPPKeepaliveInterval {
+		bus.PublishStateUpdate(nefitClient, state)
+		fake.Advance(cfg.XMPPKeepaliveInterval)
+	}
+	if code := readCode(); code != 0 {
+		t.Fatalf("read while connected with an unchanged state code = %d, want 0", code)
+	}
+
+	// Reconnect attempts do not restart the timeout
+	publishStatus(events.ConnectionStatusReconnecting)
+	fake.Advance(cfg.HAPStaleTimeout / 2)
+	publishStatus(events.ConnectionStatusConnecting)
+	publishStatus(events.ConnectionStatusReconnecting)
+	if code := readCode(); code != 0 {
+		t.Fatalf("read before the timeout code = %d, want 0", code)
+	}
+
+	fake.Advance(cfg.HAPStaleTimeout / 2)
+	if code := readCode(); code != hap.JsonStatusServiceCommunicationFailure {
+		t.Errorf("read with the connection down code = %d, want %d", code, hap.JsonStatusServiceCommunicationFailure)
+	}
+
+	// Connecting again makes it respond
+	publishStatus(events.ConnectionStatusConnected)
+	if code := readCode(); code != 0 {
+		t.Errorf("read after reconnecting code = %d, want 0", code)
+	}
+}
+
+func TestStaleAccessoryDisabled(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	st := newStaleness(fake, 0, zap.NewNop())
+
+	fake.Advance(24 * time.Hour)
+	if st.check() {
+		t.Error("check() = true with the timeout disabled, want false")
+	}
+}
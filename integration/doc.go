@@ -0,0 +1,4 @@
+// Package integration tests the nefit, homekit and web components wired
+// together on one event bus, as the main command runs them, with the Nefit
+// backend replaced by a fake.
+package integration
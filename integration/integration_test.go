@@ -0,0 +1,250 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	nefitclient "github.com/kradalby/nefit-go/client"
+	"github.com/kradalby/nefit-go/types"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/homekit"
+	"github.com/kradalby/nefit-homekit/nefit"
+	"github.com/kradalby/nefit-homekit/web"
+	"go.uber.org/zap"
+)
+
+// fakePut is a Put call recorded by fakeBackend.
+type fakePut struct {
+	uri  string
+	data interface{}
+}
+
+// fakeBackend is a nefit.Backend that connects at once, answers Gets from a
+// fixed set of responses and records Puts.
+type fakeBackend struct {
+	connected chan struct{} // Closed on the first Connect
+	puts      chan fakePut
+	gets      map[string]interface{}
+
+	mu      sync.Mutex
+	once    sync.Once
+	handler nefitclient.EventHandler
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		connected: make(chan struct{}),
+		puts:      make(chan fakePut, 10),
+		gets: map[string]interface{}{
+			"/gateway/versionFirmware": map[string]interface{}{"value": "04.08"},
+		},
+	}
+}
+
+func (f *fakeBackend) Connect(ctx context.Context) error {
+	f.once.Do(func() { close(f.connected) })
+	return nil
+}
+
+func (f *fakeBackend) Subscribe(handler nefitclient.EventHandler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handler = handler
+}
+
+// push delivers a push notification to the subscribed handler, if any.
+func (f *fakeBackend) push(uri string, data interface{}) {
+	f.mu.Lock()
+	handler := f.handler
+	f.mu.Unlock()
+
+	if handler != nil {
+		handler(uri, data)
+	}
+}
+
+func (f *fakeBackend) Get(ctx context.Context, uri string) (interface{}, error) {
+	if data, ok := f.gets[uri]; ok {
+		return data, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (f *fakeBackend) Put(ctx context.Context, uri string, data interface{}) error {
+	f.puts <- fakePut{uri: uri, data: data}
+	return nil
+}
+
+func (f *fakeBackend) Close() error {
+	return nil
+}
+
+// waitFor polls cond until it holds, failing the test after timeout.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNefitHomeKitWebLoop(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:           "TEST123",
+		NefitAccessKey:        "TESTKEY",
+		NefitPassword:         "TESTPASS",
+		HAPPin:                "12345678",
+		HAPStoragePath:        t.TempDir(),
+		HAPPort:               0,
+		WebBindAddress:        "127.0.0.1",
+		WebPort:               0,
+		WebSSEWriteTimeout:    10 * time.Second,
+		XMPPKeepaliveInterval: time.Hour,
+		XMPPReconnectBackoff:  time.Second,
+		XMPPMaxReconnectWait:  time.Minute,
+	}
+
+	backend := newFakeBackend()
+
+	nefitClient, err := nefit.New(cfg, logger, bus, nefit.WithBackend(backend))
+	if err != nil {
+		t.Fatalf("nefit.New() error = %v", err)
+	}
+	defer func() {
+		_ = nefitClient.Close()
+	}()
+
+	hkServer, err := homekit.New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("homekit.New() error = %v", err)
+	}
+	defer func() {
+		_ = hkServer.Close()
+	}()
+
+	webServer, err := web.New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("web.New() error = %v", err)
+	}
+	defer func() {
+		_ = webServer.Close()
+	}()
+
+	if err := nefitClient.Start(); err != nil {
+		t.Fatalf("nefit Start() error = %v", err)
+	}
+	if err := hkServer.Start(); err != nil {
+		t.Fatalf("homekit Start() error = %v", err)
+	}
+	if err := webServer.Start(); err != nil {
+		t.Fatalf("web Start() error = %v", err)
+	}
+
+	select {
+	case <-backend.connected:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the nefit client to connect")
+	}
+
+	ts := httptest.NewServer(webServer.Handler())
+	defer ts.Close()
+
+	// A status pushed by Nefit reaches both the accessory and the web state
+	backend.push(types.URIStatus, map[string]interface{}{
+		"in_house_temp":    21.5,
+		"temp_setpoint":    20.0,
+		"boiler_indicator": "CH",
+		"user_mode":        "manual",
+	})
+
+	thermostat := hkServer.Thermostat().Thermostat
+	waitFor(t, time.Second, "accessory update", func() bool {
+		return thermostat.CurrentTemperature.Value() == 21.5
+	})
+	if got := thermostat.TargetTemperature.Value(); got != 20.0 {
+		t.Errorf("TargetTemperature = %v, want 20", got)
+	}
+	if got := thermostat.CurrentHeatingCoolingState.Value(); got != 1 {
+		t.Errorf("CurrentHeatingCoolingState = %v, want 1", got)
+	}
+
+	var state events.StateUpdateEvent
+	waitFor(t, time.Second, "web state update", func() bool {
+		resp, err := ts.Client().Get(ts.URL + "/api/state")
+		if err != nil {
+			t.Fatalf("GET /api/state error = %v", err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		if resp.StatusCode != http.StatusOK {
+			return false
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+			t.Fatalf("failed to decode state: %v", err)
+		}
+		return state.CurrentTemperature == 21.5
+	})
+	if state.TargetTemperature != 20.0 || !state.HeatingActive || state.Mode != events.ModeHeat {
+		t.Errorf("web state = %+v, want target 20, heating, mode heat", state)
+	}
+
+	// Applying the state must not echo it back as commands to Nefit
+	select {
+	case put := <-backend.puts:
+		t.Fatalf("state update echoed to Nefit as %s %v", put.uri, put.data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// A setpoint from the web UI reaches the backend. The command subscription
+	// starts in the background, so the request is repeated until it lands.
+	var put fakePut
+	waitFor(t, 2*time.Second, "setpoint write", func() bool {
+		resp, err := ts.Client().PostForm(ts.URL+"/api/temperature", url.Values{"temperature": {"22.5"}})
+		if err != nil {
+			t.Fatalf("POST /api/temperature error = %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST /api/temperature status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+
+		select {
+		case put = <-backend.puts:
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	})
+	if put.uri != types.URIManualSetpoint || put.data != 22.5 {
+		t.Errorf("put = %s %v, want %s 22.5", put.uri, put.data, types.URIManualSetpoint)
+	}
+
+	// Only the web issued commands, none came from HomeKit reacting to state
+	for _, recorded := range bus.RecentEvents() {
+		if cmd, ok := recorded.Event.(events.CommandEvent); ok && cmd.Source != "web" {
+			t.Errorf("unexpected command from %q: %+v", cmd.Source, cmd)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Package leaktest checks that tests stop the goroutines they start, so
+// lifecycle regressions in poll loops, stream handlers and subscriptions fail
+// a test instead of piling up in a long-running process.
+package leaktest
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+const (
+	// DefaultGracePeriod is how long goroutines get to finish after the test.
+	DefaultGracePeriod = 2 * time.Second
+
+	// pollInterval is how often the goroutine count is sampled while waiting.
+	pollInterval = 10 * time.Millisecond
+)
+
+// Option configures a Check.
+type Option func(*checker)
+
+// checker holds the baseline taken when Check is called.
+type checker struct {
+	grace     time.Duration
+	threshold int
+	before    int
+}
+
+// WithGracePeriod sets how long goroutines get to finish after the test, for
+// components whose teardown waits on timers or network deadlines.
+func WithGracePeriod(d time.Duration) Option {
+	return func(c *checker) {
+		c.grace = d
+	}
+}
+
+// WithThreshold allows n goroutines more than the baseline, for goroutines
+// started by libraries on first use that live for the rest of the process.
+func WithThreshold(n int) Option {
+	return func(c *checker) {
+		c.threshold = n
+	}
+}
+
+// Check records the number of running goroutines and returns a function that
+// fails t if, after the grace period, more are running than before. Use it as
+//
+//	defer leaktest.Check(t)()
+//
+// before starting the component, so its deferred Close runs first. Tests
+// using it must not run in parallel, as their goroutines would be counted.
+func Check(t testing.TB, opts ...Option) func() {
+	t.Helper()
+
+	c := &checker{grace: DefaultGracePeriod}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.before = runtime.NumGoroutine()
+
+	return func() {
+		t.Helper()
+
+		deadline := time.Now().Add(c.grace)
+		for {
+			after := runtime.NumGoroutine()
+			if after <= c.before+c.threshold {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("leaked %d goroutines (%d before, %d after, %d allowed), running goroutines:\n%s",
+					after-c.before, c.before, after, c.threshold, stacks())
+				return
+			}
+			time.Sleep(pollInterval)
+		}
+	}
+}
+
+// stacks returns the stacks of all running goroutines.
+func stacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,70 @@
+package leaktest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// recorder is a testing.TB that records failures instead of failing the test.
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		run      func(stop <-chan struct{})
+		opts     []Option
+		wantLeak bool
+	}{
+		{
+			name: "no goroutines",
+			run:  func(<-chan struct{}) {},
+		},
+		{
+			name: "goroutine exits within grace period",
+			run: func(<-chan struct{}) {
+				go time.Sleep(20 * time.Millisecond)
+			},
+		},
+		{
+			name: "goroutine outlives grace period",
+			run: func(stop <-chan struct{}) {
+				go func() { <-stop }()
+			},
+			opts:     []Option{WithGracePeriod(50 * time.Millisecond)},
+			wantLeak: true,
+		},
+		{
+			name: "goroutine within threshold",
+			run: func(stop <-chan struct{}) {
+				go func() { <-stop }()
+			},
+			opts: []Option{WithGracePeriod(50 * time.Millisecond), WithThreshold(1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stop := make(chan struct{})
+			defer close(stop)
+
+			r := &recorder{TB: t}
+			check := Check(r, tt.opts...)
+			tt.run(stop)
+			check()
+
+			if gotLeak := len(r.failures) > 0; gotLeak != tt.wantLeak {
+				t.Errorf("leak reported = %v, want %v, failures: %v", gotLeak, tt.wantLeak, r.failures)
+			}
+		})
+	}
+}
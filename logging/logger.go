@@ -3,20 +3,97 @@ package logging
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// Subsystem logger names. Entries logged by a subsystem carry its name, so
+// logs can be filtered by origin.
+const (
+	SubsystemEvents  = "events"
+	SubsystemNefit   = "nefit"
+	SubsystemHomeKit = "homekit"
+	SubsystemWeb     = "web"
+	SubsystemMetrics = "metrics"
+)
+
+// Loggers holds the root logger and the named loggers for each subsystem.
+type Loggers struct {
+	// Root logs at the global level and is used outside of the subsystems.
+	Root *zap.Logger
+
+	base   *zap.Logger
+	global zap.AtomicLevel
+	levels map[string]zap.AtomicLevel
+}
+
+// Option configures optional logger behavior.
+type Option func(*options)
+
+// options holds the settings applied by Option.
+type options struct {
+	samplingInitial    int // 0 disables debug sampling
+	samplingThereafter int
+}
+
+// WithDebugSampling samples debug entries: each second, the first initial
+// entries with the same message are logged, then every thereafter-th one.
+// Other levels are never sampled. An initial of 0 disables sampling.
+func WithDebugSampling(initial, thereafter int) Option {
+	return func(o *options) {
+		o.samplingInitial = initial
+		o.samplingThereafter = thereafter
+	}
+}
+
+// ParseSampling parses a sampling setting of the form "initial,thereafter",
+// such as "10,100". An empty setting disables sampling and returns zeros.
+func ParseSampling(s string) (initial, thereafter int, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+
+	first, second, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid log sampling %q, must be \"initial,thereafter\"", s)
+	}
+	initial, err = strconv.Atoi(strings.TrimSpace(first))
+	if err != nil || initial < 1 {
+		return 0, 0, fmt.Errorf("invalid log sampling %q, initial must be a positive number", s)
+	}
+	thereafter, err = strconv.Atoi(strings.TrimSpace(second))
+	if err != nil || thereafter < 0 {
+		return 0, 0, fmt.Errorf("invalid log sampling %q, thereafter must not be negative", s)
+	}
+
+	return initial, thereafter, nil
+}
+
 // New creates a new logger with the specified level and format.
 // Level can be "debug", "info", "warn", or "error".
 // Format can be "json" or "console".
-func New(level, format string) (*zap.Logger, error) {
-	zapLevel, err := parseLevel(level)
+func New(level, format string, opts ...Option) (*zap.Logger, error) {
+	loggers, err := NewLoggers(level, format, nil, opts...)
 	if err != nil {
 		return nil, err
 	}
 
+	return loggers.Root, nil
+}
+
+// NewLoggers creates the root logger with the specified level and format, and
+// per-subsystem level overrides keyed by subsystem name.
+func NewLoggers(level, format string, overrides map[string]string, opts ...Option) (*Loggers, error) {
+	// Validate levels before building the logger
+	if _, err := parseLevel(level); err != nil {
+		return nil, err
+	}
+
 	var config zap.Config
 	switch format {
 	case "json":
@@ -28,14 +105,161 @@ func New(level, format string) (*zap.Logger, error) {
 		return nil, fmt.Errorf("invalid log format %q, must be 'json' or 'console'", format)
 	}
 
-	config.Level = zap.NewAtomicLevelAt(zapLevel)
+	// The base core lets everything through; each logger filters on its own level
+	config.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+
+	base := buildBase(config, zapcore.Lock(os.Stderr))
 
-	logger, err := config.Build()
+	return newLoggers(base, level, overrides, opts...)
+}
+
+// buildBase builds the logger for config. If the configured output cannot be
+// built, such as an unwritable log file, it falls back to JSON on fallback so
+// the application can still start and report why.
+func buildBase(config zap.Config, fallback zapcore.WriteSyncer) *zap.Logger {
+	base, err := config.Build()
+	if err == nil {
+		return base
+	}
+
+	base = zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		fallback,
+		zapcore.DebugLevel,
+	), zap.ErrorOutput(fallback))
+	base.Warn("failed to build configured logger, logging JSON to stderr instead",
+		zap.Strings("output_paths", config.OutputPaths),
+		zap.Error(err),
+	)
+
+	return base
+}
+
+// newLoggers sets up sampling and the level filtering on top of base.
+func newLoggers(base *zap.Logger, level string, overrides map[string]string, opts ...Option) (*Loggers, error) {
+	globalLevel, err := parseLevel(level)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build logger: %w", err)
+		return nil, err
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.samplingInitial > 0 {
+		base = base.WithOptions(withDebugSampling(o.samplingInitial, o.samplingThereafter))
+	}
+
+	l := &Loggers{
+		base:   base,
+		global: zap.NewAtomicLevelAt(globalLevel),
+		levels: make(map[string]zap.AtomicLevel),
+	}
+	l.Root = base.WithOptions(withLevel(l.global))
+
+	for name, override := range overrides {
+		if override == "" {
+			continue
+		}
+		if err := l.SetLevel(name, override); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// Named returns the logger for a subsystem. It logs at the subsystem's
+// override level if one is set, and follows the global level otherwise.
+func (l *Loggers) Named(name string) *zap.Logger {
+	level, ok := l.levels[name]
+	if !ok {
+		level = l.global
+	}
+
+	return l.base.Named(name).WithOptions(withLevel(level))
+}
+
+// SetLevel overrides the level of a subsystem. Loggers already returned by
+// Named for a subsystem with an override pick up the change.
+func (l *Loggers) SetLevel(name, level string) error {
+	zapLevel, err := parseLevel(level)
+	if err != nil {
+		return fmt.Errorf("subsystem %s: %w", name, err)
+	}
+
+	if atomic, ok := l.levels[name]; ok {
+		atomic.SetLevel(zapLevel)
+		return nil
+	}
+
+	l.levels[name] = zap.NewAtomicLevelAt(zapLevel)
+	return nil
+}
+
+// withLevel filters a logger's entries below level.
+func withLevel(level zapcore.LevelEnabler) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &levelCore{Core: core, level: level}
+	})
+}
+
+// levelCore filters entries on its own level before passing them to the
+// wrapped core. Unlike zapcore.NewIncreaseLevelCore it can also lower the level
+// relative to other loggers sharing the same base core.
+type levelCore struct {
+	zapcore.Core
+	level zapcore.LevelEnabler
+}
+
+// Enabled implements zapcore.LevelEnabler.
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level) && c.Core.Enabled(level)
+}
+
+// With implements zapcore.Core.
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+// Check implements zapcore.Core.
+func (c *levelCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(entry.Level) {
+		return ce
+	}
+
+	return c.Core.Check(entry, ce)
+}
+
+// withDebugSampling samples a logger's debug entries, see WithDebugSampling.
+func withDebugSampling(initial, thereafter int) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &debugSamplingCore{
+			Core:    core,
+			sampled: zapcore.NewSamplerWithOptions(core, time.Second, initial, thereafter),
+		}
+	})
+}
+
+// debugSamplingCore sends debug entries through a sampler and everything
+// else straight to the wrapped core, so sampling never drops warnings.
+type debugSamplingCore struct {
+	zapcore.Core
+	sampled zapcore.Core
+}
+
+// With implements zapcore.Core.
+func (c *debugSamplingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &debugSamplingCore{Core: c.Core.With(fields), sampled: c.sampled.With(fields)}
+}
+
+// Check implements zapcore.Core.
+func (c *debugSamplingCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level == zapcore.DebugLevel {
+		return c.sampled.Check(entry, ce)
 	}
 
-	return logger, nil
+	return c.Core.Check(entry, ce)
 }
 
 // parseLevel converts a string level to a zapcore.Level.
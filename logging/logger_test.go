@@ -1,9 +1,15 @@
 package logging
 
 import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestNew(t *testing.T) {
@@ -169,6 +175,139 @@ func TestLoggerOutput(t *testing.T) {
 	logger.Error("test error message")
 }
 
+func TestBuildBaseFallback(t *testing.T) {
+	config := zap.NewProductionConfig()
+	config.OutputPaths = []string{filepath.Join(t.TempDir(), "missing", "nefit-homekit.log")}
+
+	var out bytes.Buffer
+	logger := buildBase(config, zapcore.AddSync(&out))
+	logger.Info("starting nefit-homekit")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("fallback logged %d lines, want 2:\n%s", len(lines), out.String())
+	}
+
+	// The degradation is logged first, with the reason
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("fallback output is not JSON: %v", err)
+	}
+	if entry["level"] != "warn" || entry["error"] == nil {
+		t.Errorf("degradation entry = %v, want a warning with the error", entry)
+	}
+
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatalf("fallback output is not JSON: %v", err)
+	}
+	if entry["msg"] != "starting nefit-homekit" {
+		t.Errorf("msg = %v, want starting nefit-homekit", entry["msg"])
+	}
+}
+
+func TestSubsystemLevelOverride(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+
+	loggers, err := newLoggers(zap.New(core), "info", map[string]string{"nefit": "debug"})
+	if err != nil {
+		t.Fatalf("newLoggers() error = %v", err)
+	}
+
+	loggers.Root.Debug("root debug")
+	loggers.Root.Info("root info")
+	loggers.Named("nefit").Debug("nefit debug")
+	loggers.Named("web").Debug("web debug")
+	loggers.Named("web").Info("web info")
+
+	want := []struct {
+		logger  string
+		message string
+	}{
+		{"", "root info"},
+		{"nefit", "nefit debug"},
+		{"web", "web info"},
+	}
+
+	entries := logs.All()
+	if len(entries) != len(want) {
+		t.Fatalf("logged %d entries, want %d: %v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		if entries[i].LoggerName != w.logger || entries[i].Message != w.message {
+			t.Errorf("entry %d = %s %q, want %s %q",
+				i, entries[i].LoggerName, entries[i].Message, w.logger, w.message)
+		}
+	}
+}
+
+func TestNamedLoggers(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+
+	loggers, err := newLoggers(zap.New(core), "info", nil)
+	if err != nil {
+		t.Fatalf("newLoggers() error = %v", err)
+	}
+
+	subsystems := []string{SubsystemEvents, SubsystemNefit, SubsystemHomeKit, SubsystemWeb}
+	for _, subsystem := range subsystems {
+		loggers.Named(subsystem).With(zap.String("key", "value")).Info("hello")
+	}
+	loggers.Root.Info("hello")
+
+	entries := logs.All()
+	if len(entries) != len(subsystems)+1 {
+		t.Fatalf("logged %d entries, want %d", len(entries), len(subsystems)+1)
+	}
+	for i, subsystem := range subsystems {
+		if entries[i].LoggerName != subsystem {
+			t.Errorf("entry %d logger name = %q, want %q", i, entries[i].LoggerName, subsystem)
+		}
+	}
+	if name := entries[len(subsystems)].LoggerName; name != "" {
+		t.Errorf("root logger name = %q, want empty", name)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+
+	loggers, err := newLoggers(zap.New(core), "info", nil)
+	if err != nil {
+		t.Fatalf("newLoggers() error = %v", err)
+	}
+
+	if err := loggers.SetLevel("homekit", "error"); err != nil {
+		t.Fatalf("SetLevel() error = %v", err)
+	}
+	homekit := loggers.Named("homekit")
+	homekit.Warn("suppressed")
+
+	// Existing loggers follow later changes to the override
+	if err := loggers.SetLevel("homekit", "debug"); err != nil {
+		t.Fatalf("SetLevel() error = %v", err)
+	}
+	homekit.Debug("shown")
+
+	if err := loggers.SetLevel("homekit", "verbose"); err == nil {
+		t.Error("SetLevel() with invalid level expected error, got nil")
+	}
+
+	entries := logs.All()
+	if len(entries) != 1 || entries[0].Message != "shown" {
+		t.Errorf("entries = %v, want only %q", entries, "shown")
+	}
+}
+
+func TestNewLoggersInvalidOverride(t *testing.T) {
+	_, err := NewLoggers("info", "json", map[string]string{"nefit": "verbose"})
+	if err == nil {
+		t.Fatal("NewLoggers() with invalid override expected error, got nil")
+	}
+	if !contains(err.Error(), "subsystem nefit") {
+		t.Errorf("NewLoggers() error = %v, want error naming the subsystem", err)
+	}
+}
+
 // contains checks if a string contains a substring.
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && indexString(s, substr) >= 0
@@ -190,3 +329,70 @@ func indexString(s, substr string) int {
 	}
 	return -1
 }
+
+func TestDebugSampling(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+
+	loggers, err := newLoggers(zap.New(core), "debug", nil, WithDebugSampling(2, 10))
+	if err != nil {
+		t.Fatalf("newLoggers() error = %v", err)
+	}
+
+	// Within one second, entries 1, 2 and 12 of the same message are logged
+	logger := loggers.Named(SubsystemNefit)
+	for i := 0; i < 15; i++ {
+		logger.Debug("publishing state update")
+	}
+	if got := logs.FilterMessage("publishing state update").Len(); got != 3 {
+		t.Errorf("logged %d sampled debug entries, want 3", got)
+	}
+
+	// Other levels are never sampled
+	for i := 0; i < 15; i++ {
+		logger.Warn("failed to fetch status")
+	}
+	if got := logs.FilterMessage("failed to fetch status").Len(); got != 15 {
+		t.Errorf("logged %d warn entries, want 15", got)
+	}
+
+	// Without sampling every entry is logged
+	core, logs = observer.New(zapcore.DebugLevel)
+	loggers, err = newLoggers(zap.New(core), "debug", nil)
+	if err != nil {
+		t.Fatalf("newLoggers() error = %v", err)
+	}
+	for i := 0; i < 15; i++ {
+		loggers.Root.Debug("publishing state update")
+	}
+	if got := logs.Len(); got != 15 {
+		t.Errorf("logged %d entries without sampling, want 15", got)
+	}
+}
+
+func TestParseSampling(t *testing.T) {
+	tests := []struct {
+		input          string
+		wantInitial    int
+		wantThereafter int
+		wantErr        bool
+	}{
+		{input: ""},
+		{input: "10,100", wantInitial: 10, wantThereafter: 100},
+		{input: "5, 0", wantInitial: 5},
+		{input: "10", wantErr: true},
+		{input: "0,100", wantErr: true},
+		{input: "10,-1", wantErr: true},
+		{input: "ten,100", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		initial, thereafter, err := ParseSampling(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSampling(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if initial != tt.wantInitial || thereafter != tt.wantThereafter {
+			t.Errorf("ParseSampling(%q) = %d, %d, want %d, %d", tt.input, initial, thereafter, tt.wantInitial, tt.wantThereafter)
+		}
+	}
+}
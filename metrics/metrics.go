@@ -0,0 +1,227 @@
+// Package metrics exports the thermostat state and the Nefit connection as
+// Prometheus metrics, served by the web server on /metrics.
+package metrics
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/recovery"
+	"github.com/kradalby/nefit-homekit/temperature"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"tailscale.com/util/eventbus"
+)
+
+// Collector updates the Prometheus metrics from the events on the bus.
+type Collector struct {
+	logger *zap.Logger
+	bus    *events.Bus
+	client *eventbus.Client
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started bool
+	done    chan struct{}
+
+	currentTemperature prometheus.Gauge
+	targetTemperature  prometheus.Gauge
+	heatingActive      prometheus.Gauge
+	hotWaterActive     prometheus.Gauge
+	systemPressure     prometheus.Gauge
+	humidity           prometheus.Gauge
+	reconnects         prometheus.Counter
+	commandResults     *prometheus.CounterVec
+
+	// Fahrenheit copies of the Celsius gauges, nil unless WithFahrenheit is set
+	currentTemperatureF prometheus.Gauge
+	targetTemperatureF  prometheus.Gauge
+}
+
+// Option configures optional Collector behavior.
+type Option func(*Collector)
+
+// WithFahrenheit also exports the temperatures in Fahrenheit, as
+// nefit_current_temperature_fahrenheit and nefit_target_temperature_fahrenheit,
+// for dashboards of a Fahrenheit deployment. The Celsius gauges are always
+// exported.
+func WithFahrenheit() Option {
+	return func(c *Collector) {
+		c.currentTemperatureF = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nefit_current_temperature_fahrenheit",
+			Help: "Room temperature measured by the thermostat, in Fahrenheit.",
+		})
+		c.targetTemperatureF = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nefit_target_temperature_fahrenheit",
+			Help: "Target temperature of the thermostat, in Fahrenheit.",
+		})
+	}
+}
+
+// New creates a collector and registers its metrics with reg, which is
+// prometheus.DefaultRegisterer outside of tests.
+func New(logger *zap.Logger, bus *events.Bus, reg prometheus.Registerer, opts ...Option) (*Collector, error) {
+	client, err := bus.Client(events.ClientMetrics)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get eventbus client: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := &Collector{
+		logger: logger,
+		bus:    bus,
+		client: client,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		currentTemperature: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nefit_current_temperature_celsius",
+			Help: "Room temperature measured by the thermostat.",
+		}),
+		targetTemperature: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nefit_target_temperature_celsius",
+			Help: "Target temperature of the thermostat.",
+		}),
+		heatingActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nefit_heating_active",
+			Help: "1 while the boiler is heating, 0 otherwise.",
+		}),
+		hotWaterActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nefit_hotwater_active",
+			Help: "1 while the boiler heats hot water, 0 otherwise.",
+		}),
+		systemPressure: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nefit_system_pressure_bar",
+			Help: "Water pressure of the heating system.",
+		}),
+		humidity: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nefit_humidity_percent",
+			Help: "Relative humidity measured by the thermostat, 0 until known.",
+		}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "nefit_connection_reconnects_total",
+			Help: "Number of times the connection to the Nefit backend was lost and retried.",
+		}),
+		commandResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nefit_command_results_total",
+			Help: "Number of commands executed on the Nefit backend, by command type, source and result.",
+		}, []string{"command_type", "source", "result"}),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	collectors := []prometheus.Collector{
+		c.currentTemperature,
+		c.targetTemperature,
+		c.heatingActive,
+		c.hotWaterActive,
+		c.systemPressure,
+		c.humidity,
+		c.reconnects,
+		c.commandResults,
+	}
+	if c.currentTemperatureF != nil {
+		collectors = append(collectors, c.currentTemperatureF, c.targetTemperatureF)
+	}
+
+	for _, collector := range collectors {
+		if err := reg.Register(collector); err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to register metric: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Start subscribes to the bus and updates the metrics in the background.
+func (c *Collector) Start() error {
+	c.logger.Info("starting metrics collector")
+
+	// Subscribe before returning, so no event published after Start is missed
+	stateSub := c.bus.SubscribeStateUpdates(c.client, true)
+	connSub := eventbus.Subscribe[events.ConnectionStatusEvent](c.client)
+	resultSub := eventbus.Subscribe[events.CommandResultEvent](c.client)
+	c.started = true
+
+	recovery.Go(c.logger, "metrics updates", func() {
+		defer close(c.done)
+		defer stateSub.Close()
+		defer connSub.Close()
+		defer resultSub.Close()
+
+		for {
+			select {
+			case event := <-stateSub.Events():
+				c.updateState(event)
+			case event := <-connSub.Events():
+				c.updateConnection(event)
+			case event := <-resultSub.Events():
+				c.updateCommandResult(event)
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// updateState sets the gauges from a state update.
+func (c *Collector) updateState(event events.StateUpdateEvent) {
+	c.currentTemperature.Set(event.CurrentTemperature)
+	c.targetTemperature.Set(event.TargetTemperature)
+	c.heatingActive.Set(boolValue(event.HeatingActive))
+	c.hotWaterActive.Set(boolValue(event.HotWaterActive))
+	c.systemPressure.Set(event.Pressure)
+	// 0 means the thermostat has no humidity sensor, or it was not read yet
+	if event.Humidity > 0 {
+		c.humidity.Set(event.Humidity)
+	}
+
+	if c.currentTemperatureF != nil {
+		c.currentTemperatureF.Set(temperature.CtoF(event.CurrentTemperature))
+		c.targetTemperatureF.Set(temperature.CtoF(event.TargetTemperature))
+	}
+}
+
+// updateConnection counts the reconnects of the Nefit client. Every attempt
+// publishes a reconnecting status, so each one is a reconnect.
+func (c *Collector) updateConnection(event events.ConnectionStatusEvent) {
+	if event.Component == "nefit" && event.Status == events.ConnectionStatusReconnecting {
+		c.reconnects.Inc()
+	}
+}
+
+// updateCommandResult counts an executed command by its result.
+func (c *Collector) updateCommandResult(event events.CommandResultEvent) {
+	result := "success"
+	if !event.Success {
+		result = "failure"
+	}
+	c.commandResults.WithLabelValues(string(event.CommandType), event.CommandSource, result).Inc()
+}
+
+// Close stops updating the metrics. The metrics stay registered with their
+// last values.
+func (c *Collector) Close() error {
+	c.logger.Info("shutting down metrics collector")
+
+	c.cancel()
+	if c.started {
+		<-c.done
+	}
+	return nil
+}
+
+// boolValue returns 1 for true and 0 for false.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
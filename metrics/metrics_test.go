@@ -0,0 +1,273 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/leaktest"
+	"github.com/kradalby/nefit-homekit/temperature"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// scrape returns the value of every series in reg, by the metric name with
+// its labels as in the text format, e.g. name{label="value"}.
+func scrape(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			name := family.GetName()
+			if len(metric.GetLabel()) > 0 {
+				labels := make([]string, 0, len(metric.GetLabel()))
+				for _, label := range metric.GetLabel() {
+					labels = append(labels, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+				}
+				name += "{" + strings.Join(labels, ",") + "}"
+			}
+
+			switch {
+			case metric.GetGauge() != nil:
+				values[name] = metric.GetGauge().GetValue()
+			case metric.GetCounter() != nil:
+				values[name] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+// waitForValues scrapes reg until it reports want, failing the test after a
+// second.
+func waitForValues(t *testing.T, reg *prometheus.Registry, want map[string]float64) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		got := scrape(t, reg)
+		matches := true
+		for name, value := range want {
+			if got[name] != value {
+				matches = false
+			}
+		}
+		if matches {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics = %v, want %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() { _ = bus.Close() }()
+
+	reg := prometheus.NewRegistry()
+	collector, err := New(logger, bus, reg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = collector.Close() }()
+
+	if err := collector.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	nefitClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	bus.PublishStateUpdate(nefitClient, events.StateUpdateEvent{
+		Source:             "nefit",
+		CurrentTemperature: 20.5,
+		TargetTemperature:  21,
+		HeatingActive:      true,
+		Mode:               events.ModeHeat,
+		Pressure:           1.6,
+		HotWaterActive:     false,
+		Humidity:           45,
+	})
+
+	waitForValues(t, reg, map[string]float64{
+		"nefit_current_temperature_celsius": 20.5,
+		"nefit_target_temperature_celsius":  21,
+		"nefit_heating_active":              1,
+		"nefit_hotwater_active":             0,
+		"nefit_system_pressure_bar":         1.6,
+		"nefit_humidity_percent":            45,
+		"nefit_connection_reconnects_total": 0,
+	})
+
+	// Only reconnects of the Nefit client count
+	for _, event := range []events.ConnectionStatusEvent{
+		{Component: "nefit", Status: events.ConnectionStatusReconnecting, Reconnects: 1},
+		{Component: "nefit", Status: events.ConnectionStatusConnected},
+		{Component: "web", Status: events.ConnectionStatusReconnecting, Reconnects: 1},
+		{Component: "nefit", Status: events.ConnectionStatusReconnecting, Reconnects: 1},
+		{Component: "nefit", Status: events.ConnectionStatusReconnecting, Reconnects: 2},
+	} {
+		bus.PublishConnectionStatus(nefitClient, event)
+	}
+
+	bus.PublishStateUpdate(nefitClient, events.StateUpdateEvent{
+		Source:             "nefit",
+		CurrentTemperature: 21,
+		TargetTemperature:  21,
+		Mode:               events.ModeHeat,
+		Pressure:           1.5,
+		HotWaterActive:     true,
+	})
+
+	waitForValues(t, reg, map[string]float64{
+		"nefit_current_temperature_celsius": 21,
+		"nefit_heating_active":              0,
+		"nefit_hotwater_active":             1,
+		"nefit_system_pressure_bar":         1.5,
+		"nefit_humidity_percent":            45,
+		"nefit_connection_reconnects_total": 3,
+	})
+}
+
+func TestCollectorCommandResults(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() { _ = bus.Close() }()
+
+	reg := prometheus.NewRegistry()
+	collector, err := New(logger, bus, reg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = collector.Close() }()
+
+	if err := collector.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	nefitClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	bus.PublishCommandResult(nefitClient, events.CommandResultEvent{
+		Source:        "nefit",
+		CommandSource: "homekit",
+		CommandType:   events.CommandTypeSetTemperature,
+		Success:       true,
+	})
+	bus.PublishCommandResult(nefitClient, events.CommandResultEvent{
+		Source:        "nefit",
+		CommandSource: "web",
+		CommandType:   events.CommandTypeSetMode,
+		Error:         "connection refused",
+	})
+
+	waitForValues(t, reg, map[string]float64{
+		`nefit_command_results_total{command_type="set_temperature",result="success",source="homekit"}`: 1,
+		`nefit_command_results_total{command_type="set_mode",result="failure",source="web"}`:            1,
+	})
+}
+
+func TestCollectorFahrenheit(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want bool
+	}{
+		{name: "celsius"},
+		{name: "fahrenheit", opts: []Option{WithFahrenheit()}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer leaktest.Check(t)()
+
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() { _ = bus.Close() }()
+
+			reg := prometheus.NewRegistry()
+			collector, err := New(logger, bus, reg, tt.opts...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() { _ = collector.Close() }()
+
+			if err := collector.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+
+			nefitClient, err := bus.Client(events.ClientNefit)
+			if err != nil {
+				t.Fatalf("Client() error = %v", err)
+			}
+
+			bus.PublishStateUpdate(nefitClient, events.StateUpdateEvent{
+				Source:             "nefit",
+				CurrentTemperature: 20.5,
+				TargetTemperature:  21,
+				Mode:               events.ModeHeat,
+			})
+
+			want := map[string]float64{
+				"nefit_current_temperature_celsius": 20.5,
+				"nefit_target_temperature_celsius":  21,
+			}
+			if tt.want {
+				want["nefit_current_temperature_fahrenheit"] = temperature.CtoF(20.5)
+				want["nefit_target_temperature_fahrenheit"] = temperature.CtoF(21)
+			}
+			waitForValues(t, reg, want)
+
+			got := scrape(t, reg)
+			for _, name := range []string{"nefit_current_temperature_fahrenheit", "nefit_target_temperature_fahrenheit"} {
+				if _, ok := got[name]; ok != tt.want {
+					t.Errorf("%s exported = %v, want %v", name, ok, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewDuplicateRegistration(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() { _ = bus.Close() }()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "nefit_heating_active"}))
+
+	if _, err := New(logger, bus, reg); err == nil {
+		t.Fatal("New() error = nil, want an error for an already registered metric")
+	}
+}
@@ -3,35 +3,132 @@ package nefit
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"sync"
 	"time"
 
 	nefitclient "github.com/kradalby/nefit-go/client"
 	"github.com/kradalby/nefit-go/types"
+	"github.com/kradalby/nefit-homekit/clock"
 	"github.com/kradalby/nefit-homekit/config"
 	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/recovery"
+	"github.com/kradalby/nefit-homekit/temperature"
 	"go.uber.org/zap"
 	"tailscale.com/util/eventbus"
 )
 
 const (
-	modeOff = "off"
+	// nefitOff is the Nefit backend value for the off user mode and hot water setting.
+	nefitOff = "off"
+
+	// nefitManual and nefitClock are the Nefit user modes for a fixed setpoint
+	// and for following the clock program.
+	nefitManual = "manual"
+	nefitClock  = "clock"
+
+	// sourceNefit identifies events and changes originating from the Nefit side.
+	sourceNefit = "nefit"
+
+	// sourceStartup identifies the setpoint written from NEFITHK_STARTUP_SETPOINT.
+	sourceStartup = "startup"
+
+	// uriFirmwareVersion is the Nefit endpoint reporting the thermostat firmware version.
+	uriFirmwareVersion = "/gateway/versionFirmware"
 )
 
+// Backend is the subset of the nefit-go client used to talk to the Nefit Easy backend.
+type Backend interface {
+	Connect(ctx context.Context) error
+	Subscribe(handler nefitclient.EventHandler)
+	Get(ctx context.Context, uri string) (interface{}, error)
+	Put(ctx context.Context, uri string, data interface{}) error
+	Close() error
+}
+
 // Client manages the persistent connection to the Nefit Easy thermostat.
 type Client struct {
 	cfg          *config.Config
 	logger       *zap.Logger
 	bus          *events.Bus
 	client       *eventbus.Client
-	nefitClient  *nefitclient.Client
+	nefitClient  Backend
+	clock        clock.Clock
+	conn         *connState
+	connected    bool           // Whether a connection succeeded since startup
+	startupDone  bool           // Startup setpoint and mode were applied
+	queue        *commandQueue  // nil unless the command queue is enabled
+	modeDebounce *modeDebouncer // nil unless mode change debouncing is enabled
+	ramp         *setpointRamp  // nil unless setpoint ramping is enabled
+	newBackend   BackendFactory // Creates nefitClient unless set by WithBackend
 	ctx          context.Context
 	cancel       context.CancelFunc
+
+	// indicators maps the boiler indicator codes Nefit reports to their meaning
+	indicators map[string]string
+
+	// reconnect receives requests to drop a connected backend and connect again
+	reconnect chan struct{}
+
+	// cmdMu is held while executing a command, and after connecting until
+	// the startup settings and queued commands are written, so commands
+	// issued meanwhile wait and are not overwritten by older ones
+	cmdMu sync.Mutex
+
+	// pushMu is read-locked by push callbacks while they run and locked by
+	// Close around cancelling ctx. nefit-go cannot unsubscribe, so this is
+	// what keeps a late callback from publishing on a closing bus.
+	pushMu sync.RWMutex
+
+	// comfortSetpoint is the last setpoint chosen while heating, remembered so it
+	// can be reported while the thermostat is off and showing its setback setpoint.
+	// setpointSource records who last changed it.
+	mu              sync.Mutex
+	comfortSetpoint float64
+	setpointSource  string
+	mode            events.Mode // Last known mode, to restore comfortSetpoint after off
+	heatSetpoint    float64     // Setpoint requested with the next heat mode change, 0 if none
+	firmwareVersion string      // Read on every connect, reported with state updates
+	supplySetpoint  float64     // Read with each status poll when advanced features are enabled
+	pressure        float64     // Bar, read with each status read, kept when a read fails
+	outdoorTemp     float64     // Celsius, read with each status read, kept when a read fails
+	humidity        float64     // Percent, read with each status read, kept when a read fails
+
+	// Sensors by URI whose read was answered without a reading, such as by a
+	// thermostat without the sensor. They are no longer read.
+	unavailableSensors map[string]bool
+
+	// Reported with the connection status. Written by the connect loop and
+	// read by whichever goroutine changes the connection state, such as
+	// Close, so they are guarded by mu.
 	reconnectNum int
+	nextRetryIn  time.Duration // Backoff reported with the reconnecting status
+	downtime     time.Duration // Downtime reported with the reconnecting status
+	graceExpired bool          // Startup grace period passed without connecting
+}
+
+// Option configures optional Client behavior.
+type Option func(*Client)
+
+// WithClock sets the clock used for reconnect backoff and status polling.
+// The default is the real clock.
+func WithClock(c clock.Clock) Option {
+	return func(client *Client) {
+		client.clock = c
+	}
+}
+
+// WithBackend replaces the nefit-go client, for example with a fake in tests.
+func WithBackend(backend Backend) Option {
+	return func(client *Client) {
+		client.nefitClient = backend
+	}
 }
 
 // New creates a new Nefit client.
-func New(cfg *config.Config, logger *zap.Logger, bus *events.Bus) (*Client, error) {
+func New(cfg *config.Config, logger *zap.Logger, bus *events.Bus, opts ...Option) (*Client, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("config is required")
 	}
@@ -42,6 +139,11 @@ func New(cfg *config.Config, logger *zap.Logger, bus *events.Bus) (*Client, erro
 		return nil, fmt.Errorf("eventbus is required")
 	}
 
+	indicators, err := boilerIndicators(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid boiler indicators: %w", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Get eventbus client
@@ -51,27 +153,51 @@ func New(cfg *config.Config, logger *zap.Logger, bus *events.Bus) (*Client, erro
 		return nil, fmt.Errorf("failed to get eventbus client: %w", err)
 	}
 
-	// Create nefit-go client
-	nefitCfg := nefitclient.Config{
-		SerialNumber: cfg.NefitSerial,
-		AccessKey:    cfg.NefitAccessKey,
-		Password:     cfg.NefitPassword,
+	c := &Client{
+		cfg:        cfg,
+		logger:     logger,
+		bus:        bus,
+		client:     busClient,
+		clock:      clock.Real(),
+		ctx:        ctx,
+		newBackend: newNefitBackend,
+		cancel:     cancel,
+		indicators: indicators,
+		reconnect:  make(chan struct{}, 1),
 	}
 
-	nefitClient, err := nefitclient.NewClient(nefitCfg)
-	if err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to create nefit client: %w", err)
+	c.conn = newConnState(c.publishConnectionStatus)
+
+	for _, opt := range opts {
+		opt(c)
 	}
 
-	c := &Client{
-		cfg:         cfg,
-		logger:      logger,
-		bus:         bus,
-		client:      busClient,
-		nefitClient: nefitClient,
-		ctx:         ctx,
-		cancel:      cancel,
+	if cfg.CommandQueueEnabled {
+		c.queue = newCommandQueue(c.clock, cfg.CommandQueueMaxAge)
+	}
+
+	if cfg.ModeChangeDebounce > 0 {
+		c.modeDebounce = newModeDebouncer(ctx, c.clock, cfg.ModeChangeDebounce, c.setMode)
+	}
+
+	if cfg.SetpointRampStep > 0 {
+		c.ramp = newSetpointRamp(ctx, c.clock, logger, cfg.SetpointRampStep, cfg.TemperatureStep(), cfg.SetpointRampInterval, &c.cmdMu, c.writeSetpoint)
+	}
+
+	if c.nefitClient == nil {
+		// Create nefit-go client
+		nefitCfg := nefitclient.Config{
+			SerialNumber: cfg.NefitSerial,
+			AccessKey:    cfg.NefitAccessKey,
+			Password:     cfg.NefitPassword,
+		}
+
+		nefitClient, err := c.createBackend(nefitCfg)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		c.nefitClient = nefitClient
 	}
 
 	logger.Info("nefit client created",
@@ -89,18 +215,22 @@ func (c *Client) Start() error {
 	c.nefitClient.Subscribe(c.handleNefitEvent)
 
 	// Subscribe to command events from eventbus
-	go c.handleCommands()
+	recovery.Go(c.logger, "nefit commands", c.handleCommands)
 
 	// Connect with retry logic
-	go c.connectWithRetry()
+	recovery.Go(c.logger, "nefit connection", c.connectWithRetry)
 
 	c.logger.Info("nefit client started successfully")
 	return nil
 }
 
-// connectWithRetry attempts to connect to the Nefit backend with exponential backoff.
+// connectWithRetry attempts to connect to the Nefit backend with exponential
+// backoff. Once connected it waits for a reconnect request, then closes the
+// backend and connects again.
 func (c *Client) connectWithRetry() {
 	backoff := c.cfg.XMPPReconnectBackoff
+	startedAt := c.clock.Now()
+	downSince := startedAt
 
 	for {
 		select {
@@ -114,34 +244,121 @@ func (c *Client) connectWithRetry() {
 			zap.Int("attempt", c.reconnectNum+1),
 		)
 
-		c.publishConnectionStatus(events.ConnectionStatusConnecting, "")
+		c.logTransition(c.conn.Connecting())
 
 		err := c.nefitClient.Connect(c.ctx)
 		if err == nil {
 			c.logger.Info("connected to nefit backend")
-			c.publishConnectionStatus(events.ConnectionStatusConnected, "")
+			c.connected = true
+			c.mu.Lock()
 			c.reconnectNum = 0
+			c.graceExpired = false
+			c.mu.Unlock()
+
+			// Commands arriving from here on wait for the startup settings
+			// and queued commands, so they are written last
+			c.cmdMu.Lock()
+			c.logTransition(c.conn.Connected())
+
+			// Read the firmware on every connect so boiler updates show up after a reconnect
+			if err := c.fetchFirmwareVersion(); err != nil {
+				c.logger.Warn("failed to fetch firmware version", zap.Error(err))
+			}
+
+			if c.cfg.AdvancedSupplySetpointEnabled {
+				if err := c.fetchSupplySetpoint(); err != nil {
+					c.logger.Warn("failed to fetch supply setpoint", zap.Error(err))
+				}
+			}
+
+			// Put the boiler in a known state, before queued commands so those win
+			c.applyStartupSettings()
+
+			// Deliver commands issued while disconnected
+			c.flushCommandQueue()
+			c.cmdMu.Unlock()
+
+			// Pollers run for this connection only, so a reconnect does not
+			// leave them running twice
+			connCtx, connCancel := context.WithCancel(c.ctx)
 
 			// Start periodic status polling to keep connection alive
-			go c.pollStatus()
+			recovery.Go(c.logger, "nefit status poll", func() { c.pollStatus(connCtx) })
 
-			// Wait for connection to close or context to be cancelled
-			<-c.ctx.Done()
-			return
+			// Gas usage is polled separately, on its own slower interval
+			if c.cfg.EnergyPollInterval > 0 {
+				recovery.Go(c.logger, "nefit energy poll", func() { c.pollEnergy(connCtx) })
+			}
+
+			// Wait for a reconnect request or context to be cancelled
+			select {
+			case <-c.reconnect:
+			case <-c.ctx.Done():
+				connCancel()
+				return
+			}
+			connCancel()
+
+			c.logger.Info("reconnecting to nefit backend on request")
+			if err := c.nefitClient.Close(); err != nil {
+				c.logger.Warn("error closing nefit client", zap.Error(err))
+			}
+			c.logTransition(c.conn.Reconnecting(errReconnectRequested))
+
+			backoff = c.cfg.XMPPReconnectBackoff
+			downSince = c.clock.Now()
+			continue
 		}
 
+		c.mu.Lock()
 		c.reconnectNum++
+		c.nextRetryIn = backoff
+		c.downtime = c.clock.Now().Sub(downSince)
+		attempt, downtime := c.reconnectNum, c.downtime
+		graceExpired := !c.connected && !c.graceExpired && c.clock.Now().Sub(startedAt) >= c.cfg.NefitStartupGracePeriod
+		if graceExpired {
+			c.graceExpired = true
+		}
+		c.mu.Unlock()
+
 		c.logger.Error("failed to connect to nefit backend",
 			zap.Error(err),
-			zap.Int("attempt", c.reconnectNum),
+			zap.Int("attempt", attempt),
 			zap.Duration("backoff", backoff),
+			zap.Duration("downtime", downtime),
 		)
 
-		c.publishConnectionStatus(events.ConnectionStatusReconnecting, err.Error())
+		if graceExpired {
+			c.logger.Warn("never connected to nefit backend since startup, check NEFITHK_NEFIT_SERIAL, NEFITHK_NEFIT_ACCESS_KEY and NEFITHK_NEFIT_PASSWORD",
+				zap.Duration("grace_period", c.cfg.NefitStartupGracePeriod),
+				zap.Int("attempts", attempt),
+				zap.Error(err),
+			)
+		}
+
+		// Retrying soon cannot fix rejected credentials, so mark the
+		// connection failed and only try again after the max reconnect wait,
+		// in case the password was changed back, or on a reconnect request
+		if c.ctx.Err() == nil && isAuthError(err) {
+			c.logTransition(c.conn.Failed(err))
+
+			select {
+			case <-c.reconnect:
+				c.logger.Info("reconnecting to nefit backend on request")
+				backoff = c.cfg.XMPPReconnectBackoff
+				downSince = c.clock.Now()
+			case <-c.clock.After(c.cfg.XMPPMaxReconnectWait):
+			case <-c.ctx.Done():
+				return
+			}
+			continue
+		}
+
+		c.logTransition(c.conn.Reconnecting(err))
 
 		// Exponential backoff with max
 		select {
-		case <-time.After(backoff):
+		case <-c.clock.After(backoff):
 			backoff *= 2
 			if backoff > c.cfg.XMPPMaxReconnectWait {
 				backoff = c.cfg.XMPPMaxReconnectWait
@@ -152,9 +369,10 @@ func (c *Client) connectWithRetry() {
 	}
 }
 
-// pollStatus periodically requests status to keep connection alive and get latest state.
-func (c *Client) pollStatus() {
-	ticker := time.NewTicker(c.cfg.XMPPKeepaliveInterval)
+// pollStatus periodically requests status to keep connection alive and get
+// latest state, until ctx is done.
+func (c *Client) pollStatus(ctx context.Context) {
+	ticker := c.clock.NewTicker(c.cfg.XMPPKeepaliveInterval)
 	defer ticker.Stop()
 
 	c.logger.Debug("starting status polling",
@@ -163,11 +381,16 @@ func (c *Client) pollStatus() {
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
+			if c.cfg.AdvancedSupplySetpointEnabled {
+				if err := c.fetchSupplySetpoint(); err != nil {
+					c.logger.Warn("failed to fetch supply setpoint", zap.Error(err))
+				}
+			}
 			if err := c.fetchAndPublishStatus(); err != nil {
 				c.logger.Warn("failed to fetch status", zap.Error(err))
 			}
-		case <-c.ctx.Done():
+		case <-ctx.Done():
 			c.logger.Debug("stopping status polling")
 			return
 		}
@@ -175,27 +398,178 @@ func (c *Client) pollStatus() {
 }
 
 // fetchAndPublishStatus retrieves current status and publishes it to eventbus.
+// The sensor reads published with it share its deadline, so a slow backend
+// cannot stretch one poll into the next.
 func (c *Client) fetchAndPublishStatus() error {
 	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
 	defer cancel()
 
-	var status types.Status
-	_, err := c.nefitClient.Get(ctx, types.URIStatus)
+	data, err := c.get(ctx, types.URIStatus)
 	if err != nil {
 		return fmt.Errorf("failed to get status: %w", err)
 	}
+	c.logRawPayload("get", types.URIStatus, data)
+
+	status, err := parseStatus(data)
+	if err != nil {
+		return err
+	}
+
+	c.fetchSensors(ctx)
 
-	// For now, just publish a zero status since we can't unmarshal the response yet
-	// TODO: Properly unmarshal the status response
 	c.publishStateUpdate(status)
 	return nil
 }
 
+// errSensorUnavailable marks a sensor read answered without a valid reading.
+// Unlike a failed request it will not succeed on the next poll.
+var errSensorUnavailable = errors.New("sensor unavailable")
+
+// fetchSensors reads the sensors published along with the status, keeping
+// the last known values when a read fails. A sensor answered without a
+// reading is logged once and skipped from then on.
+func (c *Client) fetchSensors(ctx context.Context) {
+	sensors := []struct {
+		name  string
+		uri   string
+		fetch func(ctx context.Context) error
+	}{
+		{name: "system pressure", uri: uriSystemPressure, fetch: c.fetchPressure},
+		{name: "outdoor temperature", uri: uriOutdoorTemperature, fetch: c.fetchOutdoorTemperature},
+		{name: "indoor humidity", uri: uriIndoorHumidity, fetch: c.fetchHumidity},
+	}
+
+	for _, sensor := range sensors {
+		c.mu.Lock()
+		skip := c.unavailableSensors[sensor.uri]
+		c.mu.Unlock()
+		if skip {
+			continue
+		}
+
+		err := sensor.fetch(ctx)
+		switch {
+		case errors.Is(err, errSensorUnavailable):
+			c.mu.Lock()
+			if c.unavailableSensors == nil {
+				c.unavailableSensors = make(map[string]bool)
+			}
+			c.unavailableSensors[sensor.uri] = true
+			c.mu.Unlock()
+
+			c.logger.Info("sensor not available, no longer reading it",
+				zap.String("sensor", sensor.name),
+				zap.Error(err),
+			)
+		case err != nil:
+			c.logger.Warn("failed to fetch "+sensor.name, zap.Error(err))
+		}
+	}
+}
+
+// applyStartupSettings writes NEFITHK_STARTUP_MODE and NEFITHK_STARTUP_SETPOINT
+// after reading the initial status, once per run so a reconnect does not undo
+// changes made since startup.
+func (c *Client) applyStartupSettings() {
+	if c.startupDone || (c.cfg.StartupMode == "" && c.cfg.StartupSetpoint == 0) {
+		return
+	}
+	c.startupDone = true
+
+	// The status tells writeMode whether to restore the comfort setpoint
+	if err := c.fetchAndPublishStatus(); err != nil {
+		c.logger.Warn("failed to fetch initial status", zap.Error(err))
+	}
+
+	if c.cfg.StartupMode != "" {
+		c.logger.Info("applying startup mode", zap.String("mode", c.cfg.StartupMode))
+		if err := c.writeMode(events.Mode(c.cfg.StartupMode)); err != nil {
+			c.logger.Error("failed to apply startup mode", zap.Error(err))
+		}
+	}
+
+	if c.cfg.StartupSetpoint != 0 {
+		temp := temperature.RoundToStep(c.cfg.StartupSetpoint, c.cfg.TemperatureStep())
+		c.logger.Info("applying startup setpoint", zap.Float64("temperature", temp))
+
+		ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+		defer cancel()
+
+		if err := c.put(ctx, types.URIManualSetpoint, temp); err != nil {
+			c.logger.Error("failed to apply startup setpoint", zap.Error(err))
+			return
+		}
+		c.setComfortSetpoint(temp, sourceStartup)
+
+		if err := c.fetchAndPublishStatus(); err != nil {
+			c.logger.Warn("failed to fetch status after startup setpoint", zap.Error(err))
+		}
+	}
+}
+
+// fetchFirmwareVersion reads the thermostat firmware version to include in state updates.
+func (c *Client) fetchFirmwareVersion() error {
+	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+	defer cancel()
+
+	data, err := c.get(ctx, uriFirmwareVersion)
+	if err != nil {
+		return fmt.Errorf("failed to get firmware version: %w", err)
+	}
+	c.logRawPayload("get", uriFirmwareVersion, data)
+
+	response, ok := data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("unexpected firmware version response type %T", data)
+	}
+	version, ok := response["value"].(string)
+	if !ok || version == "" {
+		return fmt.Errorf("firmware version response has no value")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.firmwareVersion != version {
+		c.logger.Info("thermostat firmware version",
+			zap.String("version", version),
+			zap.String("previous", c.firmwareVersion),
+		)
+	}
+	c.firmwareVersion = version
+
+	return nil
+}
+
+// logRawPayload logs a payload received from Nefit as is, when enabled with
+// NEFITHK_LOG_RAW_PAYLOADS. Payloads may contain personal data, so this is
+// off by default and, like other debug output, needs the debug log level.
+func (c *Client) logRawPayload(kind, uri string, data interface{}) {
+	if !c.cfg.LogRawPayloads {
+		return
+	}
+
+	c.logger.Debug("raw nefit payload",
+		zap.String("kind", kind),
+		zap.String("uri", uri),
+		zap.Any("payload", data),
+	)
+}
+
 // handleNefitEvent is called when the Nefit backend sends a push notification.
 func (c *Client) handleNefitEvent(uri string, data interface{}) {
+	c.pushMu.RLock()
+	defer c.pushMu.RUnlock()
+
+	if c.ctx.Err() != nil {
+		c.logger.Debug("dropping nefit event after close", zap.String("uri", uri))
+		return
+	}
+
 	c.logger.Debug("received nefit event",
 		zap.String("uri", uri),
 	)
+	c.logRawPayload("push", uri, data)
 
 	// For status updates, publish to eventbus
 	if uri == types.URIStatus {
@@ -221,23 +595,37 @@ func (c *Client) handleNefitEvent(uri string, data interface{}) {
 }
 
 // publishStateUpdate converts Nefit status to our event format and publishes it.
+// A status with a NaN or infinite temperature is malformed and dropped, so it
+// neither shows up in the UI nor defeats deduplication.
 func (c *Client) publishStateUpdate(status types.Status) {
-	// Determine if heating is active
-	heatingActive := status.BoilerIndicator == "CH" || status.BoilerIndicator == "HW"
-
-	// Determine mode
-	mode := "heat"
-	if status.UserMode == modeOff {
-		mode = modeOff
+	if !finite(status.InHouseTemp) || !finite(status.TempSetpoint) {
+		c.logger.Warn("dropping nefit status with invalid temperature",
+			zap.Float64("current_temp", status.InHouseTemp),
+			zap.Float64("target_temp", status.TempSetpoint),
+		)
+		return
 	}
 
+	heatingActive, hotWaterActive := c.boilerState(status.BoilerIndicator)
+
+	mode := c.modeFromUserMode(status.UserMode)
+
+	comfort, setpointSource := c.trackComfortSetpoint(mode, status.TempSetpoint)
+
 	event := events.StateUpdateEvent{
 		Source:             "nefit",
 		CurrentTemperature: status.InHouseTemp,
 		TargetTemperature:  status.TempSetpoint,
 		HeatingActive:      heatingActive,
 		Mode:               mode,
-		HotWaterActive:     status.HotWaterActive,
+		HotWaterActive:     status.HotWaterActive || hotWaterActive,
+		ComfortTemperature: comfort,
+		SetpointSource:     setpointSource,
+		FirmwareVersion:    c.currentFirmwareVersion(),
+		SupplySetpoint:     c.currentSupplySetpoint(),
+		Pressure:           c.currentPressure(),
+		OutdoorTemperature: c.currentOutdoorTemperature(),
+		Humidity:           c.currentHumidity(),
 	}
 
 	c.logger.Debug("publishing state update",
@@ -249,11 +637,126 @@ func (c *Client) publishStateUpdate(status types.Status) {
 	c.bus.PublishStateUpdate(c.client, event)
 }
 
+// finite reports whether v is neither NaN nor infinite.
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// modeFromUserMode maps a Nefit user mode to our mode. Unknown values are
+// reported as heat, since the thermostat is not off, and logged so new
+// modes get noticed.
+func (c *Client) modeFromUserMode(userMode string) events.Mode {
+	switch userMode {
+	case nefitManual:
+		return events.ModeHeat
+	case nefitClock:
+		return events.ModeAuto
+	case nefitOff:
+		return events.ModeOff
+	case "":
+		// Not reported, as in push notifications without a user mode
+		return events.ModeHeat
+	default:
+		c.logger.Warn("unknown nefit user mode, reporting heat",
+			zap.String("user_mode", userMode),
+		)
+		return events.ModeHeat
+	}
+}
+
+// trackComfortSetpoint remembers the setpoint while heating and returns the comfort
+// setpoint to report along with who last changed it. While off, the remembered value
+// is kept so the setback setpoint reported by Nefit does not replace the user's choice.
+// A heating setpoint that differs from the remembered one was changed outside this
+// bridge, for example on the thermostat itself or by its clock program. While a
+// ramp runs, the setpoints it writes on the way are not such changes, so the
+// remembered value stays the ramp target.
+func (c *Client) trackComfortSetpoint(mode events.Mode, setpoint float64) (float64, string) {
+	ramping := c.ramp != nil && c.ramp.Running()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.mode = mode
+	if mode != events.ModeOff && setpoint > 0 && !ramping {
+		if c.comfortSetpoint != 0 && setpoint != c.comfortSetpoint {
+			c.setpointSource = sourceNefit
+		}
+		c.comfortSetpoint = setpoint
+	}
+
+	if c.comfortSetpoint == 0 {
+		return setpoint, c.setpointSource
+	}
+
+	return c.comfortSetpoint, c.setpointSource
+}
+
+// currentFirmwareVersion returns the last firmware version read from the thermostat.
+func (c *Client) currentFirmwareVersion() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.firmwareVersion
+}
+
+// currentComfortSetpoint returns the last setpoint chosen while heating, 0 if unknown.
+func (c *Client) currentComfortSetpoint() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.comfortSetpoint
+}
+
+// setComfortSetpoint records a setpoint explicitly chosen by the given command source.
+func (c *Client) setComfortSetpoint(setpoint float64, source string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.comfortSetpoint = setpoint
+	c.setpointSource = source
+}
+
+// setHeatSetpoint records a setpoint sent along with a mode command, written
+// with the next change to heat and remembered as the comfort setpoint.
+func (c *Client) setHeatSetpoint(setpoint float64, source string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.heatSetpoint = setpoint
+	c.comfortSetpoint = setpoint
+	c.setpointSource = source
+}
+
+// setpointToRestore returns the setpoint to write along with a change to mode,
+// or 0 for none. Turning heat back on after off restores the remembered comfort
+// setpoint rather than the setback one Nefit reports while off, unless the mode
+// command came with its own setpoint.
+func (c *Client) setpointToRestore(mode events.Mode) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if mode != events.ModeHeat {
+		return 0
+	}
+
+	setpoint := c.heatSetpoint
+	c.heatSetpoint = 0
+	if setpoint == 0 && c.mode == events.ModeOff {
+		setpoint = c.comfortSetpoint
+	}
+
+	return setpoint
+}
+
 // handleCommands subscribes to command events and executes them on the Nefit backend.
 func (c *Client) handleCommands() {
 	sub := eventbus.Subscribe[events.CommandEvent](c.client)
 	defer sub.Close()
 
+	reconnectSub := eventbus.Subscribe[events.ReconnectEvent](c.client)
+	defer reconnectSub.Close()
+
 	c.logger.Info("subscribed to command events")
 
 	for {
@@ -265,6 +768,8 @@ func (c *Client) handleCommands() {
 			}
 
 			c.handleCommand(event)
+		case event := <-reconnectSub.Events():
+			c.requestReconnect(event.Source)
 		case <-c.ctx.Done():
 			c.logger.Info("stopping command handler")
 			return
@@ -272,27 +777,134 @@ func (c *Client) handleCommands() {
 	}
 }
 
-// handleCommand executes a single command on the Nefit backend.
+// errReconnectRequested is reported with the reconnecting status of a
+// reconnect requested with a ReconnectEvent.
+var errReconnectRequested = errors.New("reconnect requested")
+
+// requestReconnect makes a connected client drop its backend connection and
+// connect again, and a failed client try again. While connecting, the client
+// already retries on its own with backoff, so the request is ignored rather
+// than adding attempts.
+func (c *Client) requestReconnect(source string) {
+	if current := c.conn.Current(); current != events.ConnectionStatusConnected && current != events.ConnectionStatusFailed {
+		c.logger.Info("ignoring reconnect request while not connected",
+			zap.String("source", source),
+		)
+		return
+	}
+
+	c.logger.Info("reconnect requested", zap.String("source", source))
+
+	select {
+	case c.reconnect <- struct{}{}:
+	default:
+		// A reconnect is already pending
+	}
+}
+
+// flushCommandQueue executes commands queued while disconnected, dropping
+// stale ones. cmdMu must be held.
+func (c *Client) flushCommandQueue() {
+	if c.queue == nil {
+		return
+	}
+
+	cmds, dropped := c.queue.Drain()
+	if dropped > 0 {
+		c.logger.Warn("dropped stale queued commands",
+			zap.Int("count", dropped),
+			zap.Duration("max_age", c.cfg.CommandQueueMaxAge),
+		)
+	}
+
+	for _, cmd := range cmds {
+		c.logger.Info("delivering queued command",
+			zap.String("type", string(cmd.CommandType)),
+		)
+		c.runCommand(cmd)
+	}
+}
+
+// handleCommand executes a single command on the Nefit backend and publishes
+// its result. Commands from sources not in NEFITHK_ALLOWED_COMMAND_SOURCES are
+// dropped. With the command queue enabled, commands issued while disconnected
+// are queued instead.
 func (c *Client) handleCommand(cmd events.CommandEvent) {
+	if !c.cfg.CommandSourceAllowed(cmd.Source) {
+		c.logger.Warn("ignoring command from a source that is not allowed",
+			zap.String("type", string(cmd.CommandType)),
+			zap.String("source", cmd.Source),
+		)
+		c.publishCommandResult(cmd.Source, cmd.CommandType, fmt.Errorf("commands from %s are not allowed", cmd.Source))
+		return
+	}
+
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+
+	if c.queue != nil && c.conn.Current() != events.ConnectionStatusConnected {
+		c.queue.Enqueue(cmd)
+		c.logger.Info("queued command until reconnected",
+			zap.String("type", string(cmd.CommandType)),
+		)
+		return
+	}
+
+	c.runCommand(cmd)
+}
+
+// runCommand executes a command and publishes its result. cmdMu must be held.
+func (c *Client) runCommand(cmd events.CommandEvent) {
+	err := c.executeCommand(cmd)
+	if errors.Is(err, errCommandDebounced) {
+		// The debounced write publishes the result
+		return
+	}
+	if err != nil {
+		c.logger.Error("command failed",
+			zap.String("type", string(cmd.CommandType)),
+			zap.String("source", cmd.Source),
+			zap.Error(err),
+		)
+	}
+
+	c.publishCommandResult(cmd.Source, cmd.CommandType, err)
+}
+
+// errCommandDebounced is returned by executeCommand for mode changes handed
+// to the debouncer, which writes them later.
+var errCommandDebounced = errors.New("mode change debounced")
+
+// executeCommand executes a single command on the Nefit backend.
+func (c *Client) executeCommand(cmd events.CommandEvent) error {
 	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
 	defer cancel()
 
 	switch cmd.CommandType {
 	case events.CommandTypeSetTemperature:
 		if cmd.TargetTemperature == nil {
-			c.logger.Warn("set temperature command missing temperature value")
-			return
+			return errors.New("set temperature command missing temperature value")
 		}
 
+		// Sources may send values between steps, e.g. Fahrenheit input from
+		// the web UI, so round to a setpoint the thermostat accepts
+		temp := temperature.RoundToStep(*cmd.TargetTemperature, c.cfg.TemperatureStep())
+
 		c.logger.Info("setting target temperature",
-			zap.Float64("temperature", *cmd.TargetTemperature),
+			zap.Float64("temperature", temp),
+			zap.Float64("requested", *cmd.TargetTemperature),
 		)
 
-		if err := c.nefitClient.Put(ctx, types.URIManualSetpoint, *cmd.TargetTemperature); err != nil {
-			c.logger.Error("failed to set temperature", zap.Error(err))
-			return
+		if c.ramp != nil {
+			if err := c.ramp.Start(c.currentComfortSetpoint(), temp); err != nil {
+				return fmt.Errorf("failed to set temperature: %w", err)
+			}
+		} else if err := c.put(ctx, types.URIManualSetpoint, temp); err != nil {
+			return fmt.Errorf("failed to set temperature: %w", err)
 		}
 
+		c.setComfortSetpoint(temp, cmd.Source)
+
 		// Fetch updated status to confirm change
 		if err := c.fetchAndPublishStatus(); err != nil {
 			c.logger.Warn("failed to fetch status after temperature change", zap.Error(err))
@@ -300,65 +912,181 @@ func (c *Client) handleCommand(cmd events.CommandEvent) {
 
 	case events.CommandTypeSetMode:
 		if cmd.Mode == nil {
-			c.logger.Warn("set mode command missing mode value")
-			return
+			return errors.New("set mode command missing mode value")
 		}
 
-		c.logger.Info("setting mode",
-			zap.String("mode", *cmd.Mode),
-		)
-
-		// Map our mode to Nefit mode
-		nefitMode := "manual"
-		if *cmd.Mode == modeOff {
-			nefitMode = modeOff
+		// A setpoint on the mode command replaces the remembered one
+		if cmd.TargetTemperature != nil {
+			c.setHeatSetpoint(temperature.RoundToStep(*cmd.TargetTemperature, c.cfg.TemperatureStep()), cmd.Source)
 		}
 
-		if err := c.nefitClient.Put(ctx, types.URIUserMode, nefitMode); err != nil {
-			c.logger.Error("failed to set mode", zap.Error(err))
-			return
+		if c.modeDebounce != nil {
+			if !c.modeDebounce.Submit(*cmd.Mode, cmd.Source) {
+				c.logger.Info("ignoring repeated mode change",
+					zap.String("mode", string(*cmd.Mode)),
+				)
+				return nil
+			}
+			return errCommandDebounced
 		}
 
-		// Fetch updated status to confirm change
-		if err := c.fetchAndPublishStatus(); err != nil {
-			c.logger.Warn("failed to fetch status after mode change", zap.Error(err))
-		}
+		return c.writeMode(*cmd.Mode)
 
 	case events.CommandTypeSetHotWater:
 		if cmd.HotWaterEnabled == nil {
-			c.logger.Warn("set hot water command missing value")
-			return
+			return errors.New("set hot water command missing value")
 		}
 
 		c.logger.Info("setting hot water",
 			zap.Bool("enabled", *cmd.HotWaterEnabled),
 		)
 
-		mode := modeOff
+		mode := nefitOff
 		if *cmd.HotWaterEnabled {
 			mode = "on"
 		}
 
-		if err := c.nefitClient.Put(ctx, types.URIHotWaterManualMode, mode); err != nil {
-			c.logger.Error("failed to set hot water", zap.Error(err))
-			return
+		if err := c.put(ctx, types.URIHotWaterManualMode, mode); err != nil {
+			return fmt.Errorf("failed to set hot water: %w", err)
+		}
+
+	case events.CommandTypeSetSupplySetpoint:
+		if cmd.SupplySetpoint == nil {
+			return errors.New("set supply setpoint command missing value")
+		}
+
+		if err := c.setSupplySetpoint(ctx, *cmd.SupplySetpoint); err != nil {
+			return err
+		}
+
+		if err := c.fetchAndPublishStatus(); err != nil {
+			c.logger.Warn("failed to fetch status after supply setpoint change", zap.Error(err))
 		}
 
 	default:
-		c.logger.Warn("unknown command type",
-			zap.String("type", string(cmd.CommandType)),
+		return fmt.Errorf("unknown command type %q", cmd.CommandType)
+	}
+
+	return nil
+}
+
+// publishCommandResult publishes the result of a command from source.
+func (c *Client) publishCommandResult(source string, commandType events.CommandType, err error) {
+	event := events.CommandResultEvent{
+		Source:        sourceNefit,
+		CommandSource: source,
+		CommandType:   commandType,
+		Success:       err == nil,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	c.bus.PublishCommandResult(c.client, event)
+}
+
+// logTransition logs a rejected connection state transition.
+func (c *Client) logTransition(err error) {
+	if err != nil {
+		c.logger.Warn("ignoring connection state change", zap.Error(err))
+	}
+}
+
+// setMode writes a debounced mode change from source, publishes its result
+// and reports whether it succeeded. It holds cmdMu like any other command.
+func (c *Client) setMode(mode events.Mode, source string) bool {
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+
+	err := c.writeMode(mode)
+	if err != nil {
+		c.logger.Error("debounced mode change failed",
+			zap.String("source", source),
+			zap.Error(err),
 		)
 	}
+
+	c.publishCommandResult(source, events.CommandTypeSetMode, err)
+
+	return err == nil
+}
+
+// writeMode writes the mode to the Nefit backend.
+func (c *Client) writeMode(mode events.Mode) error {
+	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+	defer cancel()
+
+	c.logger.Info("setting mode",
+		zap.String("mode", string(mode)),
+	)
+
+	// Map our mode to Nefit mode
+	nefitMode := nefitManual
+	switch mode {
+	case events.ModeOff:
+		nefitMode = nefitOff
+	case events.ModeAuto:
+		nefitMode = nefitClock
+	}
+
+	// The mode change replaces any setpoint still being ramped towards
+	if c.ramp != nil {
+		c.ramp.Cancel()
+	}
+
+	restore := c.setpointToRestore(mode)
+
+	if err := c.put(ctx, types.URIUserMode, nefitMode); err != nil {
+		return fmt.Errorf("failed to set mode: %w", err)
+	}
+
+	c.mu.Lock()
+	c.mode = mode
+	c.mu.Unlock()
+
+	if restore > 0 {
+		c.logger.Info("restoring comfort setpoint",
+			zap.Float64("temperature", restore),
+		)
+		if err := c.put(ctx, types.URIManualSetpoint, restore); err != nil {
+			c.logger.Error("failed to restore comfort setpoint", zap.Error(err))
+		}
+	}
+
+	// Fetch updated status to confirm change
+	if err := c.fetchAndPublishStatus(); err != nil {
+		c.logger.Warn("failed to fetch status after mode change", zap.Error(err))
+	}
+
+	return nil
+}
+
+// writeSetpoint writes a setpoint ramp step to the Nefit backend.
+func (c *Client) writeSetpoint(setpoint float64) error {
+	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+	defer cancel()
+
+	return c.put(ctx, types.URIManualSetpoint, setpoint)
 }
 
 // publishConnectionStatus publishes a connection status event.
 func (c *Client) publishConnectionStatus(status events.ConnectionStatus, errMsg string) {
+	c.mu.Lock()
 	event := events.ConnectionStatusEvent{
 		Component:  "nefit",
 		Status:     status,
 		Error:      errMsg,
 		Reconnects: c.reconnectNum,
 	}
+	if status == events.ConnectionStatusReconnecting {
+		event.NextRetryIn = c.nextRetryIn
+		event.Downtime = c.downtime
+	}
+	if status != events.ConnectionStatusConnected {
+		event.NeverConnected = c.graceExpired
+	}
+	c.mu.Unlock()
+
 	c.bus.PublishConnectionStatus(c.client, event)
 }
 
@@ -366,9 +1094,14 @@ func (c *Client) publishConnectionStatus(status events.ConnectionStatus, errMsg
 func (c *Client) Close() error {
 	c.logger.Info("shutting down nefit client")
 
-	c.publishConnectionStatus(events.ConnectionStatusDisconnected, "")
+	if c.conn.Current() != events.ConnectionStatusDisconnected {
+		c.logTransition(c.conn.Disconnected())
+	}
 
+	// Wait for running push callbacks, later ones see the cancelled context
+	c.pushMu.Lock()
 	c.cancel()
+	c.pushMu.Unlock()
 
 	if c.nefitClient != nil {
 		if err := c.nefitClient.Close(); err != nil {
@@ -1,21 +1,27 @@
 package nefit
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	nefitclient "github.com/kradalby/nefit-go/client"
 	"github.com/kradalby/nefit-go/types"
+	"github.com/kradalby/nefit-homekit/clock"
 	"github.com/kradalby/nefit-homekit/config"
 	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/leaktest"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 	"tailscale.com/util/eventbus"
 )
 
-const (
-	sourceNefit = "nefit"
-	testModeOff = "off"
-)
-
 func TestNew(t *testing.T) {
 	logger := zap.NewNop()
 	bus, err := events.New(logger)
@@ -155,7 +161,7 @@ func TestPublishStateUpdate(t *testing.T) {
 		wantTemp       float64
 		wantSetpoint   float64
 		wantHeating    bool
-		wantMode       string
+		wantMode       events.Mode
 		wantHotWater   bool
 	}{
 		{
@@ -170,7 +176,7 @@ func TestPublishStateUpdate(t *testing.T) {
 			wantTemp:     21.5,
 			wantSetpoint: 22.0,
 			wantHeating:  true,
-			wantMode:     "heat",
+			wantMode:     events.ModeHeat,
 			wantHotWater: false,
 		},
 		{
@@ -185,7 +191,7 @@ func TestPublishStateUpdate(t *testing.T) {
 			wantTemp:     22.0,
 			wantSetpoint: 22.0,
 			wantHeating:  false,
-			wantMode:     "heat",
+			wantMode:     events.ModeHeat,
 			wantHotWater: false,
 		},
 		{
@@ -200,7 +206,7 @@ func TestPublishStateUpdate(t *testing.T) {
 			wantTemp:     21.0,
 			wantSetpoint: 21.0,
 			wantHeating:  true, // HW indicator means heating
-			wantMode:     "heat",
+			wantMode:     events.ModeHeat,
 			wantHotWater: true,
 		},
 		{
@@ -209,13 +215,13 @@ func TestPublishStateUpdate(t *testing.T) {
 				InHouseTemp:    20.0,
 				TempSetpoint:   15.0,
 				BoilerIndicator: "No",
-				UserMode:       testModeOff,
+				UserMode:       nefitOff,
 				HotWaterActive: false,
 			},
 			wantTemp:     20.0,
 			wantSetpoint: 15.0,
 			wantHeating:  false,
-			wantMode:     testModeOff,
+			wantMode:     events.ModeOff,
 			wantHotWater: false,
 		},
 	}
@@ -296,7 +302,7 @@ func TestHandleCommand(t *testing.T) {
 			command: events.CommandEvent{
 				Source:      "homekit",
 				CommandType: events.CommandTypeSetMode,
-				Mode:        func() *string { v := "heat"; return &v }(),
+				Mode:        func() *events.Mode { v := events.ModeHeat; return &v }(),
 			},
 		},
 		{
@@ -304,7 +310,7 @@ func TestHandleCommand(t *testing.T) {
 			command: events.CommandEvent{
 				Source:      "homekit",
 				CommandType: events.CommandTypeSetMode,
-				Mode:        func() *string { v := testModeOff; return &v }(),
+				Mode:        func() *events.Mode { v := events.ModeOff; return &v }(),
 			},
 		},
 		{
@@ -504,3 +510,1791 @@ func TestClose(t *testing.T) {
 		t.Error("context was not cancelled")
 	}
 }
+
+func TestComfortSetpointPreservedWhileOff(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+		WebPort:        0,
+	}
+
+	client, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.StateUpdateEvent](subscriberClient)
+	defer sub.Close()
+
+	steps := []struct {
+		name        string
+		status      types.Status
+		wantTarget  float64
+		wantComfort float64
+		wantDisplay float64
+	}{
+		{
+			name:        "heating at comfort setpoint",
+			status:      types.Status{InHouseTemp: 20.0, TempSetpoint: 22.0, UserMode: "manual"},
+			wantTarget:  22.0,
+			wantComfort: 22.0,
+			wantDisplay: 22.0,
+		},
+		{
+			name:        "turned off reports setback",
+			status:      types.Status{InHouseTemp: 20.0, TempSetpoint: 15.0, UserMode: nefitOff},
+			wantTarget:  15.0,
+			wantComfort: 22.0,
+			wantDisplay: 22.0,
+		},
+		{
+			name:        "turned back on",
+			status:      types.Status{InHouseTemp: 20.5, TempSetpoint: 22.0, UserMode: "manual"},
+			wantTarget:  22.0,
+			wantComfort: 22.0,
+			wantDisplay: 22.0,
+		},
+	}
+
+	for _, step := range steps {
+		client.publishStateUpdate(step.status)
+
+		select {
+		case event := <-sub.Events():
+			if event.TargetTemperature != step.wantTarget {
+				t.Errorf("%s: TargetTemperature = %v, want %v", step.name, event.TargetTemperature, step.wantTarget)
+			}
+			if event.ComfortTemperature != step.wantComfort {
+				t.Errorf("%s: ComfortTemperature = %v, want %v", step.name, event.ComfortTemperature, step.wantComfort)
+			}
+			if got := event.DisplayTargetTemperature(); got != step.wantDisplay {
+				t.Errorf("%s: DisplayTargetTemperature() = %v, want %v", step.name, got, step.wantDisplay)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("%s: timeout waiting for state update event", step.name)
+		}
+	}
+}
+
+func TestHeatRestoresComfortSetpoint(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []types.Status
+		setpoint     *float64 // Sent with the heat command
+		wantSetpoint float64  // Written after the mode, 0 for none
+	}{
+		{
+			name: "off to heat restores the remembered setpoint",
+			statuses: []types.Status{
+				{InHouseTemp: 20.0, TempSetpoint: 22.0, UserMode: nefitManual},
+				{InHouseTemp: 20.0, TempSetpoint: 15.0, UserMode: nefitOff},
+			},
+			wantSetpoint: 22.0,
+		},
+		{
+			name: "setpoint on the heat command takes precedence",
+			statuses: []types.Status{
+				{InHouseTemp: 20.0, TempSetpoint: 22.0, UserMode: nefitManual},
+				{InHouseTemp: 20.0, TempSetpoint: 15.0, UserMode: nefitOff},
+			},
+			setpoint:     func() *float64 { v := 23.5; return &v }(),
+			wantSetpoint: 23.5,
+		},
+		{
+			name: "heat while not off keeps the setpoint",
+			statuses: []types.Status{
+				{InHouseTemp: 20.0, TempSetpoint: 22.0, UserMode: nefitClock},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:    "TEST123",
+				NefitAccessKey: "TESTKEY",
+				NefitPassword:  "TESTPASS",
+			}
+
+			backend := &fakeBackend{puts: make(chan fakePut, 10)}
+
+			client, err := New(cfg, logger, bus, WithBackend(backend))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = client.Close()
+			}()
+
+			for _, status := range tt.statuses {
+				client.publishStateUpdate(status)
+			}
+
+			mode := events.ModeHeat
+			client.handleCommand(events.CommandEvent{
+				Source:            "homekit",
+				CommandType:       events.CommandTypeSetMode,
+				Mode:              &mode,
+				TargetTemperature: tt.setpoint,
+			})
+
+			var got []fakePut
+			for len(backend.puts) > 0 {
+				got = append(got, <-backend.puts)
+			}
+
+			want := []fakePut{{uri: types.URIUserMode, data: nefitManual}}
+			if tt.wantSetpoint != 0 {
+				want = append(want, fakePut{uri: types.URIManualSetpoint, data: tt.wantSetpoint})
+			}
+			if len(got) != len(want) {
+				t.Fatalf("puts = %v, want %v", got, want)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("put %d = %v, want %v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCommandResult(t *testing.T) {
+	temp := 22.5
+
+	tests := []struct {
+		name        string
+		command     events.CommandEvent
+		putErrs     []error
+		wantSuccess bool
+		wantError   string
+	}{
+		{
+			name:        "success",
+			command:     events.CommandEvent{Source: "homekit", CommandType: events.CommandTypeSetTemperature, TargetTemperature: &temp},
+			wantSuccess: true,
+		},
+		{
+			name:      "backend rejects the command",
+			command:   events.CommandEvent{Source: "web", CommandType: events.CommandTypeSetTemperature, TargetTemperature: &temp},
+			putErrs:   []error{errors.New("HTTP error 400")},
+			wantError: "failed to set temperature: HTTP error 400",
+		},
+		{
+			name:      "missing value",
+			command:   events.CommandEvent{Source: "web", CommandType: events.CommandTypeSetHotWater},
+			wantError: "set hot water command missing value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:    "TEST123",
+				NefitAccessKey: "TESTKEY",
+				NefitPassword:  "TESTPASS",
+			}
+
+			client, err := New(cfg, logger, bus, WithBackend(&fakeBackend{putErrs: tt.putErrs}))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = client.Close()
+			}()
+
+			subscriberClient, err := bus.Client(events.ClientWeb)
+			if err != nil {
+				t.Fatalf("Client() error = %v", err)
+			}
+
+			sub := eventbus.Subscribe[events.CommandResultEvent](subscriberClient)
+			defer sub.Close()
+
+			client.handleCommand(tt.command)
+
+			select {
+			case result := <-sub.Events():
+				if result.CommandSource != tt.command.Source || result.CommandType != tt.command.CommandType {
+					t.Errorf("result for %s %s, want %s %s", result.CommandSource, result.CommandType, tt.command.Source, tt.command.CommandType)
+				}
+				if result.Success != tt.wantSuccess || result.Error != tt.wantError {
+					t.Errorf("result = success %v error %q, want success %v error %q", result.Success, result.Error, tt.wantSuccess, tt.wantError)
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatal("timeout waiting for command result")
+			}
+		})
+	}
+}
+
+func TestAllowedCommandSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		source  string
+		wantPut bool
+	}{
+		{name: "all sources allowed by default", source: "web", wantPut: true},
+		{name: "allowed source", allowed: "homekit", source: "homekit", wantPut: true},
+		{name: "disallowed source", allowed: "homekit", source: "web"},
+		{name: "one of several", allowed: "homekit, presence", source: "presence", wantPut: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:           "TEST123",
+				NefitAccessKey:        "TESTKEY",
+				NefitPassword:         "TESTPASS",
+				AllowedCommandSources: tt.allowed,
+			}
+
+			backend := &fakeBackend{puts: make(chan fakePut, 10)}
+
+			client, err := New(cfg, logger, bus, WithBackend(backend))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = client.Close()
+			}()
+
+			temp := 22.5
+			client.handleCommand(events.CommandEvent{
+				Source:            tt.source,
+				CommandType:       events.CommandTypeSetTemperature,
+				TargetTemperature: &temp,
+			})
+
+			select {
+			case put := <-backend.puts:
+				if !tt.wantPut {
+					t.Fatalf("command from %s executed: %s %v", tt.source, put.uri, put.data)
+				}
+			default:
+				if tt.wantPut {
+					t.Fatalf("command from %s dropped", tt.source)
+				}
+			}
+		})
+	}
+}
+
+func TestSetpointSourceTracking(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+		WebPort:        0,
+	}
+
+	client, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.StateUpdateEvent](subscriberClient)
+	defer sub.Close()
+
+	steps := []struct {
+		name       string
+		command    string // Source of a setpoint command applied before the status, if any
+		status     types.Status
+		wantSource string
+	}{
+		{
+			name:       "initial status has no known source",
+			status:     types.Status{InHouseTemp: 20.0, TempSetpoint: 21.0, UserMode: "manual"},
+			wantSource: "",
+		},
+		{
+			name:       "changed from the web UI",
+			command:    "web",
+			status:     types.Status{InHouseTemp: 20.0, TempSetpoint: 22.0, UserMode: "manual"},
+			wantSource: "web",
+		},
+		{
+			name:       "changed from HomeKit",
+			command:    "homekit",
+			status:     types.Status{InHouseTemp: 20.0, TempSetpoint: 23.0, UserMode: "manual"},
+			wantSource: "homekit",
+		},
+		{
+			name:       "turned off keeps last source",
+			status:     types.Status{InHouseTemp: 20.0, TempSetpoint: 15.0, UserMode: nefitOff},
+			wantSource: "homekit",
+		},
+		{
+			name:       "changed on the thermostat",
+			status:     types.Status{InHouseTemp: 20.0, TempSetpoint: 19.5, UserMode: "manual"},
+			wantSource: sourceNefit,
+		},
+	}
+
+	for _, step := range steps {
+		if step.command != "" {
+			client.setComfortSetpoint(step.status.TempSetpoint, step.command)
+		}
+		client.publishStateUpdate(step.status)
+
+		select {
+		case event := <-sub.Events():
+			if event.SetpointSource != step.wantSource {
+				t.Errorf("%s: SetpointSource = %q, want %q", step.name, event.SetpointSource, step.wantSource)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("%s: timeout waiting for state update event", step.name)
+		}
+	}
+}
+
+// fakePut is a Put call recorded by fakeBackend.
+type fakePut struct {
+	uri  string
+	data interface{}
+}
+
+// fakeBackend is a Backend whose Connect fails a fixed number of times.
+type fakeBackend struct {
+	failures   int
+	connectErr error // Optional, returned by failing Connects instead of "connection refused"
+	attempts   chan int
+	puts       chan fakePut           // Optional, receives every Put
+	gets       map[string]interface{} // Optional, responses returned by Get per URI
+	getErrs    []error                // Optional, returned by the first Gets in order
+	getCtxs    chan context.Context   // Optional, receives the context of every Get
+	putErrs    []error                // Optional, returned by the first Puts in order
+
+	mu      sync.Mutex
+	calls   int
+	handler nefitclient.EventHandler
+}
+
+func (f *fakeBackend) Connect(ctx context.Context) error {
+	f.mu.Lock()
+	f.calls++
+	n := f.calls
+	f.mu.Unlock()
+
+	f.attempts <- n
+
+	if n <= f.failures {
+		if f.connectErr != nil {
+			return f.connectErr
+		}
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (f *fakeBackend) Subscribe(handler nefitclient.EventHandler) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handler = handler
+}
+
+// push delivers a push notification to the subscribed handler, if any.
+func (f *fakeBackend) push(uri string, data interface{}) {
+	f.mu.Lock()
+	handler := f.handler
+	f.mu.Unlock()
+
+	if handler != nil {
+		handler(uri, data)
+	}
+}
+
+func (f *fakeBackend) Get(ctx context.Context, uri string) (interface{}, error) {
+	if f.getCtxs != nil {
+		f.getCtxs <- ctx
+	}
+	if err := f.nextErr(&f.getErrs); err != nil {
+		return nil, err
+	}
+	if data, ok := f.gets[uri]; ok {
+		return data, nil
+	}
+	return nil, errors.New("not connected")
+}
+
+func (f *fakeBackend) Put(ctx context.Context, uri string, data interface{}) error {
+	if f.puts != nil {
+		f.puts <- fakePut{uri: uri, data: data}
+	}
+	return f.nextErr(&f.putErrs)
+}
+
+// nextErr pops the next configured error, or returns nil once they are used up.
+func (f *fakeBackend) nextErr(errs *[]error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+func (f *fakeBackend) Close() error {
+	return nil
+}
+
+func TestConnectWithRetryBackoff(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:           "TEST123",
+		NefitAccessKey:        "TESTKEY",
+		NefitPassword:         "TESTPASS",
+		XMPPKeepaliveInterval: time.Minute,
+		XMPPReconnectBackoff:  time.Second,
+		XMPPMaxReconnectWait:  3 * time.Second,
+	}
+
+	backend := &fakeBackend{failures: 3, attempts: make(chan int, 10)}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	waitForAttempt := func(want int) {
+		t.Helper()
+		select {
+		case got := <-backend.attempts:
+			if got != want {
+				t.Fatalf("connect attempt = %d, want %d", got, want)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timeout waiting for connect attempt %d", want)
+		}
+	}
+
+	waitForAttempt(1)
+
+	// Backoff doubles after each failure and is capped at XMPPMaxReconnectWait
+	for i, backoff := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		fake.BlockUntil(1)
+
+		fake.Advance(backoff - time.Millisecond)
+		if got := fake.Waiters(); got != 1 {
+			t.Fatalf("retry %d fired before %v backoff elapsed", i+1, backoff)
+		}
+
+		fake.Advance(time.Millisecond)
+		waitForAttempt(i + 2)
+	}
+
+	// Once connected, the only pending timer is the status poll ticker
+	fake.BlockUntil(1)
+	client.mu.Lock()
+	got := client.reconnectNum
+	client.mu.Unlock()
+	if got != 0 {
+		t.Errorf("reconnectNum after connect = %d, want 0", got)
+	}
+}
+
+// TestCloseWhileReconnecting closes the client while the connect loop keeps
+// failing, for the race detector: Close publishes the disconnected status
+// with the reconnect details the loop writes.
+func TestCloseWhileReconnecting(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:           "TEST123",
+		NefitAccessKey:        "TESTKEY",
+		NefitPassword:         "TESTPASS",
+		XMPPKeepaliveInterval: time.Minute,
+		XMPPReconnectBackoff:  time.Millisecond,
+		XMPPMaxReconnectWait:  time.Millisecond,
+	}
+
+	backend := &fakeBackend{failures: math.MaxInt, attempts: make(chan int, 1000)}
+
+	client, err := New(cfg, logger, bus, WithBackend(backend))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	for range 3 {
+		select {
+		case <-backend.attempts:
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout waiting for connect attempt")
+		}
+	}
+
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestCommandQueueDeliversAfterReconnect(t *testing.T) {
+	tests := []struct {
+		name        string
+		advance     time.Duration
+		wantPut     bool
+		wantPutData float64
+	}{
+		{
+			name:        "delivered within staleness window",
+			advance:     time.Second,
+			wantPut:     true,
+			wantPutData: 22.5,
+		},
+		{
+			name:    "dropped when stale",
+			advance: 31 * time.Second,
+			wantPut: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:           "TEST123",
+				NefitAccessKey:        "TESTKEY",
+				NefitPassword:         "TESTPASS",
+				XMPPKeepaliveInterval: time.Minute,
+				XMPPReconnectBackoff:  time.Second,
+				XMPPMaxReconnectWait:  time.Minute,
+				CommandQueueEnabled:   true,
+				CommandQueueMaxAge:    30 * time.Second,
+			}
+
+			backend := &fakeBackend{
+				failures: 1,
+				attempts: make(chan int, 10),
+				puts:     make(chan fakePut, 10),
+			}
+			fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+			client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = client.Close()
+			}()
+
+			if err := client.Start(); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+
+			// Wait for the first attempt to fail and the backoff timer to start
+			<-backend.attempts
+			fake.BlockUntil(1)
+
+			temp := 22.5
+			client.handleCommand(events.CommandEvent{
+				Source:            "web",
+				CommandType:       events.CommandTypeSetTemperature,
+				TargetTemperature: &temp,
+			})
+
+			select {
+			case put := <-backend.puts:
+				t.Fatalf("command sent while disconnected: %+v", put)
+			default:
+			}
+
+			fake.Advance(tt.advance)
+			<-backend.attempts
+
+			// Once connected, the status poll ticker is registered after the queue is flushed
+			fake.BlockUntil(1)
+
+			select {
+			case put := <-backend.puts:
+				if !tt.wantPut {
+					t.Fatalf("stale command delivered: %+v", put)
+				}
+				if put.uri != types.URIManualSetpoint {
+					t.Errorf("put.uri = %s, want %s", put.uri, types.URIManualSetpoint)
+				}
+				if put.data != tt.wantPutData {
+					t.Errorf("put.data = %v, want %v", put.data, tt.wantPutData)
+				}
+			default:
+				if tt.wantPut {
+					t.Fatal("queued command was not delivered after reconnect")
+				}
+			}
+		})
+	}
+}
+
+func TestCommandDuringQueueFlushRunsLast(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:           "TEST123",
+		NefitAccessKey:        "TESTKEY",
+		NefitPassword:         "TESTPASS",
+		XMPPKeepaliveInterval: time.Minute,
+		XMPPReconnectBackoff:  time.Second,
+		XMPPMaxReconnectWait:  time.Minute,
+		CommandQueueEnabled:   true,
+		CommandQueueMaxAge:    time.Minute,
+		StartupSetpoint:       18,
+	}
+
+	// Unbuffered, so each write blocks until the test reads it
+	backend := &fakeBackend{
+		failures: 1,
+		attempts: make(chan int, 10),
+		puts:     make(chan fakePut),
+	}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	<-backend.attempts
+	fake.BlockUntil(1)
+
+	setTemperature := func(temp float64) {
+		client.handleCommand(events.CommandEvent{
+			Source:            "web",
+			CommandType:       events.CommandTypeSetTemperature,
+			TargetTemperature: &temp,
+		})
+	}
+
+	// Queued while disconnected
+	setTemperature(22.5)
+
+	fake.Advance(time.Second)
+	<-backend.attempts
+
+	expectPut := func(want float64) {
+		t.Helper()
+		select {
+		case put := <-backend.puts:
+			if put.uri != types.URIManualSetpoint || put.data != want {
+				t.Errorf("put = %+v, want %s = %v", put, types.URIManualSetpoint, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for put of %v", want)
+		}
+	}
+
+	// The startup setpoint is being written, with the queued command still
+	// to come, when a new command arrives
+	expectPut(18)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		setTemperature(23)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The new command is written after the older queued one
+	expectPut(22.5)
+	expectPut(23)
+	<-done
+}
+
+func TestAuthConnectErrorFails(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:           "TEST123",
+		NefitAccessKey:        "TESTKEY",
+		NefitPassword:         "TESTPASS",
+		XMPPKeepaliveInterval: time.Minute,
+		XMPPReconnectBackoff:  time.Second,
+		XMPPMaxReconnectWait:  time.Minute,
+	}
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	sub := eventbus.Subscribe[events.ConnectionStatusEvent](subscriberClient)
+	defer sub.Close()
+
+	backend := &fakeBackend{
+		failures:   2,
+		connectErr: errors.New("decryption failed"),
+		attempts:   make(chan int, 10),
+	}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	nextStatus := func() events.ConnectionStatusEvent {
+		t.Helper()
+		for {
+			select {
+			case event := <-sub.Events():
+				if event.Component == "nefit" && event.Status != events.ConnectionStatusConnecting {
+					return event
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatal("timeout waiting for connection status event")
+			}
+		}
+	}
+
+	<-backend.attempts
+	if event := nextStatus(); event.Status != events.ConnectionStatusFailed || event.Error != "decryption failed" {
+		t.Fatalf("status = %s %q, want %s %q", event.Status, event.Error, events.ConnectionStatusFailed, "decryption failed")
+	}
+
+	// Failed connections are only retried after the max reconnect wait
+	fake.BlockUntil(1)
+	fake.Advance(cfg.XMPPMaxReconnectWait - time.Second)
+	select {
+	case n := <-backend.attempts:
+		t.Fatalf("connect attempt %d before the max reconnect wait", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fake.Advance(time.Second)
+	<-backend.attempts
+	if event := nextStatus(); event.Status != events.ConnectionStatusFailed {
+		t.Fatalf("status = %s after a retry, want %s", event.Status, events.ConnectionStatusFailed)
+	}
+
+	// A reconnect request tries again right away
+	client.requestReconnect("web")
+	<-backend.attempts
+	if event := nextStatus(); event.Status != events.ConnectionStatusConnected {
+		t.Errorf("status = %s after a reconnect request, want %s", event.Status, events.ConnectionStatusConnected)
+	}
+}
+
+func TestConnectTimeoutIsRetried(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:           "TEST123",
+		NefitAccessKey:        "TESTKEY",
+		NefitPassword:         "TESTPASS",
+		XMPPKeepaliveInterval: time.Minute,
+		XMPPReconnectBackoff:  time.Second,
+		XMPPMaxReconnectWait:  time.Minute,
+	}
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	sub := eventbus.Subscribe[events.ConnectionStatusEvent](subscriberClient)
+	defer sub.Close()
+
+	// A dial timeout is an error a later attempt can overcome
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: context.DeadlineExceeded}
+	backend := &fakeBackend{
+		failures:   1,
+		connectErr: fmt.Errorf("failed to connect: %w", dialErr),
+		attempts:   make(chan int, 10),
+	}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	nextStatus := func() events.ConnectionStatusEvent {
+		t.Helper()
+		for {
+			select {
+			case event := <-sub.Events():
+				if event.Component == "nefit" && event.Status != events.ConnectionStatusConnecting {
+					return event
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatal("timeout waiting for connection status event")
+			}
+		}
+	}
+
+	<-backend.attempts
+	if event := nextStatus(); event.Status != events.ConnectionStatusReconnecting {
+		t.Fatalf("status = %s after a dial timeout, want %s", event.Status, events.ConnectionStatusReconnecting)
+	}
+
+	fake.BlockUntil(1)
+	fake.Advance(cfg.XMPPReconnectBackoff)
+	<-backend.attempts
+	if event := nextStatus(); event.Status != events.ConnectionStatusConnected {
+		t.Errorf("status = %s after the backoff, want %s", event.Status, events.ConnectionStatusConnected)
+	}
+}
+
+func TestReconnectingEventCarriesBackoffSchedule(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:           "TEST123",
+		NefitAccessKey:        "TESTKEY",
+		NefitPassword:         "TESTPASS",
+		XMPPKeepaliveInterval: time.Minute,
+		XMPPReconnectBackoff:  time.Second,
+		XMPPMaxReconnectWait:  3 * time.Second,
+	}
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	sub := eventbus.Subscribe[events.ConnectionStatusEvent](subscriberClient)
+	defer sub.Close()
+
+	backend := &fakeBackend{failures: 3, attempts: make(chan int, 10)}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	nextReconnecting := func() events.ConnectionStatusEvent {
+		t.Helper()
+		for {
+			select {
+			case event := <-sub.Events():
+				if event.Status == events.ConnectionStatusReconnecting {
+					return event
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatal("timeout waiting for reconnecting event")
+			}
+		}
+	}
+
+	want := []struct {
+		nextRetryIn time.Duration
+		downtime    time.Duration
+	}{
+		{nextRetryIn: time.Second, downtime: 0},
+		{nextRetryIn: 2 * time.Second, downtime: time.Second},
+		{nextRetryIn: 3 * time.Second, downtime: 3 * time.Second},
+	}
+
+	for i, w := range want {
+		event := nextReconnecting()
+		if event.Reconnects != i+1 {
+			t.Errorf("attempt %d: Reconnects = %d, want %d", i+1, event.Reconnects, i+1)
+		}
+		if event.NextRetryIn != w.nextRetryIn {
+			t.Errorf("attempt %d: NextRetryIn = %v, want %v", i+1, event.NextRetryIn, w.nextRetryIn)
+		}
+		if event.Downtime != w.downtime {
+			t.Errorf("attempt %d: Downtime = %v, want %v", i+1, event.Downtime, w.downtime)
+		}
+
+		fake.BlockUntil(1)
+		fake.Advance(w.nextRetryIn)
+	}
+}
+
+func TestFirmwareVersionInStateUpdates(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+	}
+
+	backend := &fakeBackend{
+		gets: map[string]interface{}{
+			uriFirmwareVersion: map[string]interface{}{"id": uriFirmwareVersion, "value": "02.04.00"},
+		},
+	}
+
+	client, err := New(cfg, logger, bus, WithBackend(backend))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.fetchFirmwareVersion(); err != nil {
+		t.Fatalf("fetchFirmwareVersion() error = %v", err)
+	}
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.StateUpdateEvent](subscriberClient)
+	defer sub.Close()
+
+	client.publishStateUpdate(types.Status{InHouseTemp: 20.0, TempSetpoint: 21.0, UserMode: "manual"})
+
+	select {
+	case event := <-sub.Events():
+		if event.FirmwareVersion != "02.04.00" {
+			t.Errorf("FirmwareVersion = %q, want 02.04.00", event.FirmwareVersion)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for state update event")
+	}
+
+	backend.gets[uriFirmwareVersion] = "unexpected"
+	if err := client.fetchFirmwareVersion(); err == nil {
+		t.Error("fetchFirmwareVersion() expected error for malformed response, got nil")
+	}
+}
+
+func TestLogRawPayloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		want    int
+	}{
+		{"disabled", false, 0},
+		{"enabled", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.DebugLevel)
+			logger := zap.New(core)
+
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:    "TEST123",
+				NefitAccessKey: "TESTKEY",
+				NefitPassword:  "TESTPASS",
+				LogRawPayloads: tt.enabled,
+			}
+
+			client, err := New(cfg, logger, bus, WithBackend(&fakeBackend{}))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = client.Close()
+			}()
+
+			payload := map[string]interface{}{"in_house_temp": 20.5, "user_mode": "manual"}
+			client.handleNefitEvent(types.URIStatus, payload)
+
+			entries := logs.FilterMessage("raw nefit payload").All()
+			if len(entries) != tt.want {
+				t.Fatalf("logged %d raw payloads, want %d", len(entries), tt.want)
+			}
+			if tt.want == 0 {
+				return
+			}
+
+			fields := entries[0].ContextMap()
+			if fields["uri"] != types.URIStatus {
+				t.Errorf("uri = %v, want %v", fields["uri"], types.URIStatus)
+			}
+			if fields["kind"] != "push" {
+				t.Errorf("kind = %v, want push", fields["kind"])
+			}
+			if _, ok := fields["payload"]; !ok {
+				t.Error("payload field missing")
+			}
+		})
+	}
+}
+
+func TestNeverConnectedAfterStartupGracePeriod(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	logger := zap.New(core)
+
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:             "TEST123",
+		NefitAccessKey:          "TESTKEY",
+		NefitPassword:           "TESTPASS",
+		NefitStartupGracePeriod: 2 * time.Second,
+		XMPPKeepaliveInterval:   time.Minute,
+		XMPPReconnectBackoff:    time.Second,
+		XMPPMaxReconnectWait:    time.Second,
+	}
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	sub := eventbus.Subscribe[events.ConnectionStatusEvent](subscriberClient)
+	defer sub.Close()
+
+	backend := &fakeBackend{failures: 10, attempts: make(chan int, 20)}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	nextReconnecting := func() events.ConnectionStatusEvent {
+		t.Helper()
+		for {
+			select {
+			case event := <-sub.Events():
+				if event.Status == events.ConnectionStatusReconnecting {
+					return event
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatal("timeout waiting for reconnecting event")
+			}
+		}
+	}
+
+	// Failures at 0s and 1s are within the grace period, the one at 2s is not
+	for i, want := range []bool{false, false, true} {
+		event := nextReconnecting()
+		if event.NeverConnected != want {
+			t.Errorf("attempt %d: NeverConnected = %v, want %v", i+1, event.NeverConnected, want)
+		}
+
+		fake.BlockUntil(1)
+		fake.Advance(time.Second)
+	}
+
+	if got := logs.FilterMessageSnippet("never connected to nefit backend").Len(); got != 1 {
+		t.Errorf("logged %d never-connected warnings, want 1", got)
+	}
+}
+
+func TestUserModeMapping(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	logger := zap.New(core)
+
+	bus, err := events.New(zap.NewNop())
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+	}
+
+	client, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	tests := []struct {
+		userMode string
+		wantMode events.Mode
+		wantWarn bool
+	}{
+		{userMode: nefitManual, wantMode: events.ModeHeat},
+		{userMode: nefitClock, wantMode: events.ModeAuto},
+		{userMode: nefitOff, wantMode: events.ModeOff},
+		{userMode: "holiday", wantMode: events.ModeHeat, wantWarn: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userMode, func(t *testing.T) {
+			before := logs.Len()
+
+			if got := client.modeFromUserMode(tt.userMode); got != tt.wantMode {
+				t.Errorf("modeFromUserMode(%q) = %q, want %q", tt.userMode, got, tt.wantMode)
+			}
+
+			warned := logs.Len() > before
+			if warned != tt.wantWarn {
+				t.Errorf("warned = %v, want %v", warned, tt.wantWarn)
+			}
+			if warned {
+				entry := logs.All()[before]
+				if got := entry.ContextMap()["user_mode"]; got != tt.userMode {
+					t.Errorf("logged user_mode = %v, want %q", got, tt.userMode)
+				}
+			}
+		})
+	}
+}
+
+func TestSetModeWritesUserMode(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+	}
+
+	backend := &fakeBackend{attempts: make(chan int, 10), puts: make(chan fakePut, 10)}
+
+	client, err := New(cfg, logger, bus, WithBackend(backend))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	tests := []struct {
+		mode events.Mode
+		want string
+	}{
+		{events.ModeHeat, nefitManual},
+		{events.ModeAuto, nefitClock},
+		{events.ModeOff, nefitOff},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			if !client.setMode(tt.mode, "homekit") {
+				t.Fatal("setMode() = false, want true")
+			}
+
+			select {
+			case put := <-backend.puts:
+				if put.uri != types.URIUserMode || put.data != tt.want {
+					t.Errorf("put = %s %v, want %s %s", put.uri, put.data, types.URIUserMode, tt.want)
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatal("timeout waiting for user mode write")
+			}
+		})
+	}
+}
+
+func TestPushDuringCloseDoesNotPublish(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:           "TEST123",
+		NefitAccessKey:        "TESTKEY",
+		NefitPassword:         "TESTPASS",
+		XMPPKeepaliveInterval: time.Minute,
+		XMPPReconnectBackoff:  time.Second,
+		XMPPMaxReconnectWait:  time.Minute,
+	}
+
+	backend := &fakeBackend{attempts: make(chan int, 10)}
+
+	client, err := New(cfg, logger, bus, WithBackend(backend))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	pushTemperature := func(temp float64) {
+		backend.push(types.URIStatus, map[string]interface{}{
+			"in_house_temp": temp,
+			"temp_setpoint": 20.0,
+			"user_mode":     nefitManual,
+		})
+	}
+	published := func(temp float64) bool {
+		for _, recorded := range bus.RecentEvents() {
+			if event, ok := recorded.Event.(events.StateUpdateEvent); ok && event.CurrentTemperature == temp {
+				return true
+			}
+		}
+		return false
+	}
+
+	pushTemperature(18.5)
+	if !published(18.5) {
+		t.Fatal("push before Close() did not publish a state update")
+	}
+
+	// Every push carries a new temperature so none are deduplicated
+	var pushes atomic.Int64
+	push := func() {
+		pushTemperature(15.0 + float64(pushes.Add(1)%1000)/100)
+	}
+
+	// Callbacks racing Close
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					push()
+				}
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	pushTemperature(30.5)
+	if published(30.5) {
+		t.Error("push after Close() published a state update")
+	}
+}
+
+func TestLifecycleDoesNotLeakGoroutines(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:           "TEST123",
+		NefitAccessKey:        "TESTKEY",
+		NefitPassword:         "TESTPASS",
+		XMPPKeepaliveInterval: time.Minute,
+		XMPPReconnectBackoff:  time.Second,
+		XMPPMaxReconnectWait:  time.Minute,
+	}
+
+	// The first connect fails, so both the reconnect and the poll loop run
+	backend := &fakeBackend{failures: 1, attempts: make(chan int, 10)}
+	fake := clock.NewFake(time.Now())
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	<-backend.attempts
+	fake.BlockUntil(1)
+	fake.Advance(time.Second)
+	<-backend.attempts
+
+	// A command in flight through the command subscription
+	publisher, err := bus.Client(events.ClientWeb)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	temp := 21.0
+	bus.PublishCommand(publisher, events.CommandEvent{
+		Source:            "web",
+		CommandType:       events.CommandTypeSetTemperature,
+		TargetTemperature: &temp,
+	})
+}
+
+func TestSetTemperatureRoundsToStep(t *testing.T) {
+	tests := []struct {
+		name string
+		step float64
+		temp float64
+		want float64
+	}{
+		{name: "default step rounds down", temp: 21.2, want: 21.0},
+		{name: "default step rounds up", temp: 21.3, want: 21.5},
+		{name: "whole degrees round down", step: 1.0, temp: 21.3, want: 21.0},
+		{name: "whole degrees round half up", step: 1.0, temp: 21.5, want: 22.0},
+		{name: "fahrenheit input", step: 1.0, temp: (70.0 - 32) * 5 / 9, want: 21.0},
+		{name: "tenths keep value", step: 0.1, temp: 21.3, want: 21.3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:           "TEST123",
+				NefitAccessKey:        "TESTKEY",
+				NefitPassword:         "TESTPASS",
+				TargetTemperatureStep: tt.step,
+			}
+
+			backend := &fakeBackend{puts: make(chan fakePut, 10)}
+
+			client, err := New(cfg, logger, bus, WithBackend(backend))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = client.Close()
+			}()
+
+			temp := tt.temp
+			client.handleCommand(events.CommandEvent{
+				Source:            "web",
+				CommandType:       events.CommandTypeSetTemperature,
+				TargetTemperature: &temp,
+			})
+
+			select {
+			case put := <-backend.puts:
+				if put.data != tt.want {
+					t.Errorf("put.data = %v, want %v", put.data, tt.want)
+				}
+			default:
+				t.Fatal("no setpoint written")
+			}
+		})
+	}
+}
+
+func TestStartupSettingsAppliedOnce(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:           "TEST123",
+		NefitAccessKey:        "TESTKEY",
+		NefitPassword:         "TESTPASS",
+		XMPPKeepaliveInterval: time.Minute,
+		XMPPReconnectBackoff:  time.Second,
+		XMPPMaxReconnectWait:  time.Minute,
+		StartupSetpoint:       20.3,
+		StartupMode:           "heat",
+	}
+
+	backend := &fakeBackend{attempts: make(chan int, 10), puts: make(chan fakePut, 10)}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	<-backend.attempts
+
+	// The status poll ticker is registered once the startup settings are written
+	fake.BlockUntil(1)
+
+	want := []fakePut{
+		{uri: types.URIUserMode, data: nefitManual},
+		{uri: types.URIManualSetpoint, data: 20.5},
+	}
+	for _, w := range want {
+		select {
+		case put := <-backend.puts:
+			if put != w {
+				t.Errorf("put = %s %v, want %s %v", put.uri, put.data, w.uri, w.data)
+			}
+		default:
+			t.Fatalf("startup write %s %v not issued on first connect", w.uri, w.data)
+		}
+	}
+
+	// A later connect must not write them again
+	client.applyStartupSettings()
+
+	select {
+	case put := <-backend.puts:
+		t.Fatalf("startup settings written again: %s %v", put.uri, put.data)
+	default:
+	}
+
+	comfort, source := client.trackComfortSetpoint(events.ModeHeat, 0)
+	if comfort != 20.5 || source != sourceStartup {
+		t.Errorf("comfort setpoint = %v from %q, want 20.5 from %q", comfort, source, sourceStartup)
+	}
+}
+
+func TestPublishStateUpdateDropsInvalidTemperatures(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+	}
+
+	client, err := New(cfg, logger, bus, WithBackend(&fakeBackend{}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.StateUpdateEvent](subscriberClient)
+	defer sub.Close()
+
+	// A malformed status on every poll must not flood consumers
+	for _, status := range []types.Status{
+		{InHouseTemp: math.NaN(), TempSetpoint: 21.0, UserMode: nefitManual},
+		{InHouseTemp: math.NaN(), TempSetpoint: 21.0, UserMode: nefitManual},
+		{InHouseTemp: 20.0, TempSetpoint: math.Inf(1), UserMode: nefitManual},
+	} {
+		client.publishStateUpdate(status)
+	}
+	client.publishStateUpdate(types.Status{InHouseTemp: 20.0, TempSetpoint: 21.0, UserMode: nefitManual})
+
+	select {
+	case event := <-sub.Events():
+		if event.CurrentTemperature != 20.0 || event.TargetTemperature != 21.0 {
+			t.Errorf("first event = %v/%v, want the valid status 20/21", event.CurrentTemperature, event.TargetTemperature)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for state update event")
+	}
+
+	select {
+	case event := <-sub.Events():
+		t.Errorf("unexpected extra state update: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReconnectRequest(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:           "TEST123",
+		NefitAccessKey:        "TESTKEY",
+		NefitPassword:         "TESTPASS",
+		XMPPKeepaliveInterval: time.Minute,
+		XMPPReconnectBackoff:  time.Second,
+		XMPPMaxReconnectWait:  time.Minute,
+	}
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	sub := eventbus.Subscribe[events.ConnectionStatusEvent](subscriberClient)
+	defer sub.Close()
+
+	webClient, err := bus.Client(events.ClientWeb)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	backend := &fakeBackend{attempts: make(chan int, 10)}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	// Waits for status, skipping others, and reports whether it arrived in time
+	waitForStatus := func(status events.ConnectionStatus, timeout time.Duration) (events.ConnectionStatusEvent, bool) {
+		deadline := time.After(timeout)
+		for {
+			select {
+			case event := <-sub.Events():
+				if event.Component == sourceNefit && event.Status == status {
+					return event, true
+				}
+			case <-deadline:
+				return events.ConnectionStatusEvent{}, false
+			}
+		}
+	}
+
+	if err := client.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if _, ok := waitForStatus(events.ConnectionStatusConnected, time.Second); !ok {
+		t.Fatal("timeout waiting for the first connection")
+	}
+	<-backend.attempts
+
+	// The command handler subscribes asynchronously, so repeat the request
+	// until it is seen
+	var event events.ConnectionStatusEvent
+	for i := 0; ; i++ {
+		if i == 10 {
+			t.Fatal("timeout waiting for the requested reconnect")
+		}
+		bus.PublishReconnect(webClient, events.ReconnectEvent{Source: "web"})
+
+		var ok bool
+		if event, ok = waitForStatus(events.ConnectionStatusReconnecting, 100*time.Millisecond); ok {
+			break
+		}
+	}
+	if event.Error != errReconnectRequested.Error() {
+		t.Errorf("reconnecting error = %q, want %q", event.Error, errReconnectRequested)
+	}
+
+	select {
+	case n := <-backend.attempts:
+		if n != 2 {
+			t.Errorf("connect attempt = %d, want 2", n)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for the backend to connect again")
+	}
+	if _, ok := waitForStatus(events.ConnectionStatusConnected, time.Second); !ok {
+		t.Fatal("timeout waiting for the reconnected status")
+	}
+}
@@ -0,0 +1,123 @@
+package nefit
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/kradalby/nefit-homekit/events"
+)
+
+// errInvalidTransition is returned for connection state changes that
+// connTransitions does not allow.
+var errInvalidTransition = errors.New("invalid connection state transition")
+
+// connTransitions lists the states each connection state may move to.
+var connTransitions = map[events.ConnectionStatus][]events.ConnectionStatus{
+	events.ConnectionStatusDisconnected: {
+		events.ConnectionStatusConnecting,
+	},
+	events.ConnectionStatusConnecting: {
+		events.ConnectionStatusConnected,
+		events.ConnectionStatusReconnecting,
+		events.ConnectionStatusFailed,
+		events.ConnectionStatusDisconnected,
+	},
+	events.ConnectionStatusConnected: {
+		events.ConnectionStatusReconnecting,
+		events.ConnectionStatusDisconnected,
+	},
+	events.ConnectionStatusReconnecting: {
+		events.ConnectionStatusConnecting,
+		events.ConnectionStatusFailed,
+		events.ConnectionStatusDisconnected,
+	},
+	events.ConnectionStatusFailed: {
+		events.ConnectionStatusConnecting,
+		events.ConnectionStatusDisconnected,
+	},
+}
+
+// connState tracks the connection state of the Nefit backend and publishes
+// a ConnectionStatusEvent exactly once for every valid transition.
+type connState struct {
+	mu      sync.Mutex
+	state   events.ConnectionStatus
+	publish func(status events.ConnectionStatus, errMsg string)
+}
+
+// newConnState creates a connection state machine starting in the disconnected state.
+func newConnState(publish func(status events.ConnectionStatus, errMsg string)) *connState {
+	return &connState{
+		state:   events.ConnectionStatusDisconnected,
+		publish: publish,
+	}
+}
+
+// Current returns the current connection state.
+func (s *connState) Current() events.ConnectionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state
+}
+
+// transition moves to the given state and publishes it. Invalid transitions,
+// including transitions to the current state, return an error and publish nothing.
+func (s *connState) transition(to events.ConnectionStatus, errMsg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !canTransition(s.state, to) {
+		return fmt.Errorf("%w from %s to %s", errInvalidTransition, s.state, to)
+	}
+
+	s.state = to
+	s.publish(to, errMsg)
+
+	return nil
+}
+
+// Connecting marks the start of a connection attempt.
+func (s *connState) Connecting() error {
+	return s.transition(events.ConnectionStatusConnecting, "")
+}
+
+// Connected marks a successful connection.
+func (s *connState) Connected() error {
+	return s.transition(events.ConnectionStatusConnected, "")
+}
+
+// Reconnecting marks a failed or lost connection that will be retried.
+func (s *connState) Reconnecting(err error) error {
+	return s.transition(events.ConnectionStatusReconnecting, errString(err))
+}
+
+// Failed marks a connection that the usual backoff cannot fix, such as one
+// rejected for its credentials.
+func (s *connState) Failed(err error) error {
+	return s.transition(events.ConnectionStatusFailed, errString(err))
+}
+
+// Disconnected marks an intentional disconnect.
+func (s *connState) Disconnected() error {
+	return s.transition(events.ConnectionStatusDisconnected, "")
+}
+
+// canTransition reports whether from may move to to.
+func canTransition(from, to events.ConnectionStatus) bool {
+	for _, allowed := range connTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// errString returns the error message, or an empty string for a nil error.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
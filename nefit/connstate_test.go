@@ -0,0 +1,171 @@
+package nefit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kradalby/nefit-homekit/events"
+)
+
+type publishedStatus struct {
+	status events.ConnectionStatus
+	errMsg string
+}
+
+func newRecordingConnState() (*connState, *[]publishedStatus) {
+	var published []publishedStatus
+	s := newConnState(func(status events.ConnectionStatus, errMsg string) {
+		published = append(published, publishedStatus{status: status, errMsg: errMsg})
+	})
+	return s, &published
+}
+
+func TestConnStateTransitions(t *testing.T) {
+	errRefused := errors.New("connection refused")
+
+	tests := []struct {
+		name  string
+		steps []func(s *connState) error
+		want  []publishedStatus
+	}{
+		{
+			name: "connect successfully",
+			steps: []func(s *connState) error{
+				(*connState).Connecting,
+				(*connState).Connected,
+			},
+			want: []publishedStatus{
+				{status: events.ConnectionStatusConnecting},
+				{status: events.ConnectionStatusConnected},
+			},
+		},
+		{
+			name: "retry after failed attempt",
+			steps: []func(s *connState) error{
+				(*connState).Connecting,
+				func(s *connState) error { return s.Reconnecting(errRefused) },
+				(*connState).Connecting,
+				(*connState).Connected,
+			},
+			want: []publishedStatus{
+				{status: events.ConnectionStatusConnecting},
+				{status: events.ConnectionStatusReconnecting, errMsg: "connection refused"},
+				{status: events.ConnectionStatusConnecting},
+				{status: events.ConnectionStatusConnected},
+			},
+		},
+		{
+			name: "give up and disconnect",
+			steps: []func(s *connState) error{
+				(*connState).Connecting,
+				func(s *connState) error { return s.Failed(errRefused) },
+				(*connState).Disconnected,
+			},
+			want: []publishedStatus{
+				{status: events.ConnectionStatusConnecting},
+				{status: events.ConnectionStatusFailed, errMsg: "connection refused"},
+				{status: events.ConnectionStatusDisconnected},
+			},
+		},
+		{
+			name: "disconnect while connected",
+			steps: []func(s *connState) error{
+				(*connState).Connecting,
+				(*connState).Connected,
+				(*connState).Disconnected,
+			},
+			want: []publishedStatus{
+				{status: events.ConnectionStatusConnecting},
+				{status: events.ConnectionStatusConnected},
+				{status: events.ConnectionStatusDisconnected},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, published := newRecordingConnState()
+
+			for i, step := range tt.steps {
+				if err := step(s); err != nil {
+					t.Fatalf("step %d error = %v", i, err)
+				}
+			}
+
+			if len(*published) != len(tt.want) {
+				t.Fatalf("published %d events, want %d: %+v", len(*published), len(tt.want), *published)
+			}
+			for i, want := range tt.want {
+				if got := (*published)[i]; got != want {
+					t.Errorf("event %d = %+v, want %+v", i, got, want)
+				}
+			}
+
+			if got, want := s.Current(), tt.want[len(tt.want)-1].status; got != want {
+				t.Errorf("Current() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestConnStateInvalidTransitions(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup []func(s *connState) error
+		step  func(s *connState) error
+		from  events.ConnectionStatus
+	}{
+		{
+			name: "connected without connecting",
+			step: (*connState).Connected,
+			from: events.ConnectionStatusDisconnected,
+		},
+		{
+			name: "disconnected twice",
+			step: (*connState).Disconnected,
+			from: events.ConnectionStatusDisconnected,
+		},
+		{
+			name:  "connecting twice",
+			setup: []func(s *connState) error{(*connState).Connecting},
+			step:  (*connState).Connecting,
+			from:  events.ConnectionStatusConnecting,
+		},
+		{
+			name:  "connected twice",
+			setup: []func(s *connState) error{(*connState).Connecting, (*connState).Connected},
+			step:  (*connState).Connected,
+			from:  events.ConnectionStatusConnected,
+		},
+		{
+			name:  "failed while connected",
+			setup: []func(s *connState) error{(*connState).Connecting, (*connState).Connected},
+			step:  func(s *connState) error { return s.Failed(nil) },
+			from:  events.ConnectionStatusConnected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, published := newRecordingConnState()
+
+			for i, step := range tt.setup {
+				if err := step(s); err != nil {
+					t.Fatalf("setup step %d error = %v", i, err)
+				}
+			}
+			before := len(*published)
+
+			if err := tt.step(s); !errors.Is(err, errInvalidTransition) {
+				t.Fatalf("error = %v, want %v", err, errInvalidTransition)
+			}
+
+			if len(*published) != before {
+				t.Errorf("invalid transition published %d events, want none", len(*published)-before)
+			}
+			if got := s.Current(); got != tt.from {
+				t.Errorf("Current() = %v, want unchanged %v", got, tt.from)
+			}
+		})
+	}
+}
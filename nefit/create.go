@@ -0,0 +1,54 @@
+package nefit
+
+import (
+	"fmt"
+
+	nefitclient "github.com/kradalby/nefit-go/client"
+	"go.uber.org/zap"
+)
+
+// BackendFactory creates the nefit-go client from its configuration.
+type BackendFactory func(cfg nefitclient.Config) (Backend, error)
+
+// newNefitBackend is the default BackendFactory, creating a nefit-go client.
+func newNefitBackend(cfg nefitclient.Config) (Backend, error) {
+	return nefitclient.NewClient(cfg)
+}
+
+// WithBackendFactory replaces how the nefit-go client is created, for example
+// with one that fails in tests. It is ignored when WithBackend is also used.
+func WithBackendFactory(factory BackendFactory) Option {
+	return func(client *Client) {
+		client.newBackend = factory
+	}
+}
+
+// createBackend creates the nefit-go client, trying up to
+// NEFITHK_NEFIT_CREATE_ATTEMPTS times while it fails with transient errors so
+// a brief problem at boot does not abort startup.
+func (c *Client) createBackend(nefitCfg nefitclient.Config) (Backend, error) {
+	attempts := max(c.cfg.NefitCreateAttempts, 1)
+
+	for attempt := 1; ; attempt++ {
+		backend, err := c.newBackend(nefitCfg)
+		if err == nil {
+			return backend, nil
+		}
+		if attempt == attempts || !isTransient(err) {
+			return nil, fmt.Errorf("failed to create nefit client after %d attempts: %w", attempt, err)
+		}
+
+		c.logger.Warn("failed to create nefit client, retrying",
+			zap.Int("attempt", attempt),
+			zap.Int("max_attempts", attempts),
+			zap.Duration("delay", c.cfg.NefitCreateRetryDelay),
+			zap.Error(err),
+		)
+
+		select {
+		case <-c.clock.After(c.cfg.NefitCreateRetryDelay):
+		case <-c.ctx.Done():
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package nefit
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	nefitclient "github.com/kradalby/nefit-go/client"
+	"github.com/kradalby/nefit-homekit/clock"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+func TestCreateBackendRetry(t *testing.T) {
+	transient := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name      string
+		errs      []error // Returned by the first calls in order
+		attempts  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "fails once then succeeds", errs: []error{transient}, attempts: 3, wantCalls: 2},
+		{name: "gives up after the attempts", errs: []error{transient, transient, transient}, attempts: 2, wantCalls: 2, wantErr: true},
+		{name: "permanent error is not retried", errs: []error{errors.New("invalid config: serial number is required")}, attempts: 3, wantCalls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:           "TEST123",
+				NefitAccessKey:        "TESTKEY",
+				NefitPassword:         "TESTPASS",
+				NefitCreateAttempts:   tt.attempts,
+				NefitCreateRetryDelay: 2 * time.Second,
+			}
+
+			calls := 0
+			factory := func(nefitclient.Config) (Backend, error) {
+				calls++
+				if calls <= len(tt.errs) {
+					return nil, tt.errs[calls-1]
+				}
+				return &fakeBackend{}, nil
+			}
+
+			fake := clock.NewFake(time.Now())
+			type result struct {
+				client *Client
+				err    error
+			}
+			done := make(chan result, 1)
+			go func() {
+				client, err := New(cfg, logger, bus, WithBackendFactory(factory), WithClock(fake))
+				done <- result{client, err}
+			}()
+
+			// Each retry waits for the delay
+			for i := 1; i < tt.wantCalls; i++ {
+				fake.BlockUntil(1)
+				fake.Advance(cfg.NefitCreateRetryDelay)
+			}
+
+			var res result
+			select {
+			case res = <-done:
+			case <-time.After(1 * time.Second):
+				t.Fatal("New() did not return")
+			}
+
+			if (res.err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", res.err, tt.wantErr)
+			}
+			if res.client != nil {
+				_ = res.client.Close()
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("factory called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
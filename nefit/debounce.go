@@ -0,0 +1,103 @@
+package nefit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/clock"
+	"github.com/kradalby/nefit-homekit/events"
+)
+
+// modeDebouncer collapses rapid mode changes into a single backend write of
+// the final mode, and ignores repeats of a mode written within the window.
+type modeDebouncer struct {
+	ctx    context.Context
+	clock  clock.Clock
+	window time.Duration
+	write  func(mode events.Mode, source string) bool // Reports whether the write succeeded
+
+	mu            sync.Mutex
+	pending       events.Mode // Empty when no change is waiting
+	pendingSource string      // Source of the last submitted change
+	firstAt       time.Time   // When the pending burst of changes started
+	gen           uint64      // Incremented on every submit so superseded timers do nothing
+	last          events.Mode
+	lastAt        time.Time
+}
+
+// newModeDebouncer creates a debouncer that calls write with the final mode,
+// and the source that submitted it, once no further changes arrive within
+// window.
+func newModeDebouncer(ctx context.Context, c clock.Clock, window time.Duration, write func(mode events.Mode, source string) bool) *modeDebouncer {
+	return &modeDebouncer{
+		ctx:    ctx,
+		clock:  c,
+		window: window,
+		write:  write,
+	}
+}
+
+// Submit schedules a mode change from source. It returns false if the change
+// was ignored because the same mode was written within the window.
+func (d *modeDebouncer) Submit(mode events.Mode, source string) bool {
+	d.mu.Lock()
+	if d.pending == "" {
+		now := d.clock.Now()
+		if d.writtenWithinWindowLocked(mode, now) {
+			d.mu.Unlock()
+			return false
+		}
+		d.firstAt = now
+	}
+
+	d.pending = mode
+	d.pendingSource = source
+	d.gen++
+	gen := d.gen
+	d.mu.Unlock()
+
+	go d.flushAfterWindow(gen)
+	return true
+}
+
+// flushAfterWindow writes the pending mode once the window passes, unless a
+// later submit superseded it.
+func (d *modeDebouncer) flushAfterWindow(gen uint64) {
+	select {
+	case <-d.clock.After(d.window):
+	case <-d.ctx.Done():
+		return
+	}
+
+	d.mu.Lock()
+	if gen != d.gen {
+		d.mu.Unlock()
+		return
+	}
+
+	mode, source := d.pending, d.pendingSource
+	d.pending, d.pendingSource = "", ""
+
+	// Toggles that end where they started need no write
+	if d.writtenWithinWindowLocked(mode, d.firstAt) {
+		d.mu.Unlock()
+		return
+	}
+	d.mu.Unlock()
+
+	if !d.write(mode, source) {
+		return
+	}
+
+	d.mu.Lock()
+	d.last = mode
+	d.lastAt = d.clock.Now()
+	d.mu.Unlock()
+}
+
+// writtenWithinWindowLocked reports whether mode was the last mode written, within
+// the window before at. d.mu must be held.
+func (d *modeDebouncer) writtenWithinWindowLocked(mode events.Mode, at time.Time) bool {
+	return mode == d.last && at.Sub(d.lastAt) < d.window
+}
@@ -0,0 +1,163 @@
+package nefit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-go/types"
+	"github.com/kradalby/nefit-homekit/clock"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+	"tailscale.com/util/eventbus"
+)
+
+func TestModeChangeDebounce(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:        "TEST123",
+		NefitAccessKey:     "TESTKEY",
+		NefitPassword:      "TESTPASS",
+		ModeChangeDebounce: 2 * time.Second,
+	}
+
+	backend := &fakeBackend{attempts: make(chan int, 10), puts: make(chan fakePut, 10)}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	setMode := func(mode events.Mode) {
+		client.handleCommand(events.CommandEvent{
+			Source:      "web",
+			CommandType: events.CommandTypeSetMode,
+			Mode:        &mode,
+		})
+	}
+
+	expectPut := func(want string) {
+		t.Helper()
+		select {
+		case put := <-backend.puts:
+			if put.uri != types.URIUserMode || put.data != want {
+				t.Fatalf("put = %s %v, want %s %s", put.uri, put.data, types.URIUserMode, want)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timeout waiting for %s write", want)
+		}
+	}
+
+	expectNoPut := func() {
+		t.Helper()
+		select {
+		case put := <-backend.puts:
+			t.Fatalf("unexpected write %s %v", put.uri, put.data)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// Two off->heat->off toggles within the window collapse into one write
+	for _, mode := range []events.Mode{events.ModeOff, events.ModeHeat, events.ModeOff, events.ModeHeat, events.ModeOff} {
+		setMode(mode)
+	}
+	fake.BlockUntil(5)
+	expectNoPut()
+
+	fake.Advance(2 * time.Second)
+	expectPut(nefitOff)
+	expectNoPut()
+
+	// Repeating the mode just written is ignored
+	setMode(events.ModeOff)
+	if got := fake.Waiters(); got != 0 {
+		t.Errorf("repeated mode scheduled %d writes, want 0", got)
+	}
+
+	// Toggling away and back within the window ends where it started
+	setMode(events.ModeHeat)
+	setMode(events.ModeOff)
+	fake.BlockUntil(2)
+	fake.Advance(2 * time.Second)
+	expectNoPut()
+
+	// Once the window has passed, a new mode is written
+	setMode(events.ModeHeat)
+	fake.BlockUntil(1)
+	fake.Advance(2 * time.Second)
+	expectPut("manual")
+}
+
+func TestDebouncedModeChangeResultSource(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:        "TEST123",
+		NefitAccessKey:     "TESTKEY",
+		NefitPassword:      "TESTPASS",
+		ModeChangeDebounce: 2 * time.Second,
+	}
+
+	backend := &fakeBackend{attempts: make(chan int, 10), puts: make(chan fakePut, 10)}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientWeb)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	sub := eventbus.Subscribe[events.CommandResultEvent](subscriberClient)
+	defer sub.Close()
+
+	// The result of the collapsed write is attributed to the last source
+	for _, cmd := range []struct {
+		source string
+		mode   events.Mode
+	}{
+		{"homekit", events.ModeHeat},
+		{"web", events.ModeOff},
+	} {
+		client.handleCommand(events.CommandEvent{
+			Source:      cmd.source,
+			CommandType: events.CommandTypeSetMode,
+			Mode:        &cmd.mode,
+		})
+	}
+	fake.BlockUntil(2)
+	fake.Advance(2 * time.Second)
+
+	select {
+	case result := <-sub.Events():
+		if result.CommandSource != "web" || result.CommandType != events.CommandTypeSetMode || !result.Success {
+			t.Errorf("result = %s %s success %v, want web %s success true", result.CommandSource, result.CommandType, result.Success, events.CommandTypeSetMode)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for command result")
+	}
+}
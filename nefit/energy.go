@@ -0,0 +1,148 @@
+package nefit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kradalby/nefit-go/types"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+const (
+	// uriGasUsagePointer is the Nefit endpoint reporting how many daily gas
+	// usage recordings have been written, which locates the latest page.
+	uriGasUsagePointer = "/ecus/rrc/recordings/gasusagePointer"
+
+	// gasUsagePageSize is the number of daily recordings per gas usage page.
+	gasUsagePageSize = 32
+
+	// gasUsageDateLayout is the layout of recording dates, such as 16-10-2026.
+	gasUsageDateLayout = "02-01-2006"
+)
+
+// pollEnergy periodically reads the gas usage recordings and publishes them,
+// starting with a read right away so usage is known soon after connecting,
+// until ctx is done.
+func (c *Client) pollEnergy(ctx context.Context) {
+	ticker := c.clock.NewTicker(c.cfg.EnergyPollInterval)
+	defer ticker.Stop()
+
+	c.logger.Debug("starting energy polling",
+		zap.Duration("interval", c.cfg.EnergyPollInterval),
+	)
+
+	for {
+		if err := c.fetchAndPublishEnergy(); err != nil {
+			c.logger.Warn("failed to fetch gas usage", zap.Error(err))
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			c.logger.Debug("stopping energy polling")
+			return
+		}
+	}
+}
+
+// fetchAndPublishEnergy reads the most recent page of daily gas usage
+// recordings and publishes it to the eventbus.
+func (c *Client) fetchAndPublishEnergy() error {
+	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+	defer cancel()
+
+	data, err := c.get(ctx, uriGasUsagePointer)
+	if err != nil {
+		return fmt.Errorf("failed to get gas usage pointer: %w", err)
+	}
+	c.logRawPayload("get", uriGasUsagePointer, data)
+
+	pointer, err := parseGasUsagePointer(data)
+	if err != nil {
+		return err
+	}
+	if pointer == 0 {
+		c.logger.Debug("no gas usage recorded yet")
+		return nil
+	}
+
+	uri := fmt.Sprintf("%s?page=%d", types.URIGasUsage, gasUsagePage(pointer))
+	data, err = c.get(ctx, uri)
+	if err != nil {
+		return fmt.Errorf("failed to get gas usage: %w", err)
+	}
+	c.logRawPayload("get", uri, data)
+
+	days, err := parseGasUsage(data)
+	if err != nil {
+		return err
+	}
+
+	c.bus.PublishEnergy(c.client, events.EnergyEvent{
+		Source: sourceNefit,
+		Days:   days,
+	})
+	return nil
+}
+
+// gasUsagePage returns the 1-based page holding the last recording written,
+// given the number of recordings written so far.
+func gasUsagePage(pointer int) int {
+	return (pointer-1)/gasUsagePageSize + 1
+}
+
+// parseGasUsagePointer parses the gas usage pointer response.
+func parseGasUsagePointer(data interface{}) (int, error) {
+	response, ok := data.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("unexpected gas usage pointer response type %T", data)
+	}
+	pointer, ok := response["value"].(float64)
+	if !ok || pointer < 0 {
+		return 0, fmt.Errorf("gas usage pointer response has no valid value")
+	}
+
+	return int(pointer), nil
+}
+
+// parseGasUsage parses a page of daily gas usage recordings, oldest first.
+// Unused slots on the page carry an invalid date and are skipped.
+func parseGasUsage(data interface{}) ([]events.EnergyUsage, error) {
+	response, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected gas usage response type %T", data)
+	}
+	recordings, ok := response["value"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("gas usage response has no recordings")
+	}
+
+	days := make([]events.EnergyUsage, 0, len(recordings))
+	for _, r := range recordings {
+		recording, ok := r.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unexpected gas usage recording type %T", r)
+		}
+
+		d, _ := recording["d"].(string)
+		date, err := time.Parse(gasUsageDateLayout, d)
+		if err != nil {
+			continue
+		}
+
+		ch, _ := recording["ch"].(float64)
+		hw, _ := recording["hw"].(float64)
+		outdoor, _ := recording["T"].(float64)
+
+		days = append(days, events.EnergyUsage{
+			Date:               date,
+			CentralHeating:     ch,
+			HotWater:           hw,
+			OutdoorTemperature: outdoor,
+		})
+	}
+
+	return days, nil
+}
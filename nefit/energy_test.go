@@ -0,0 +1,150 @@
+package nefit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-go/types"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+	"tailscale.com/util/eventbus"
+)
+
+// sampleGasUsagePage is a gas usage page as returned by the Nefit backend,
+// with two recorded days followed by unused slots.
+func sampleGasUsagePage() map[string]interface{} {
+	return map[string]interface{}{
+		"id":   types.URIGasUsage,
+		"type": "recordings",
+		"value": []interface{}{
+			map[string]interface{}{"d": "14-10-2026", "hw": 1.5, "ch": 10.25, "T": 11.0},
+			map[string]interface{}{"d": "15-10-2026", "hw": 2.0, "ch": 12.5, "T": 9.0},
+			map[string]interface{}{"d": "255-256-65535", "hw": 0.0, "ch": 0.0, "T": 0.0},
+			map[string]interface{}{"d": "255-256-65535", "hw": 0.0, "ch": 0.0, "T": 0.0},
+		},
+	}
+}
+
+func TestParseGasUsage(t *testing.T) {
+	days, err := parseGasUsage(sampleGasUsagePage())
+	if err != nil {
+		t.Fatalf("parseGasUsage() error = %v", err)
+	}
+
+	want := []events.EnergyUsage{
+		{Date: time.Date(2026, time.October, 14, 0, 0, 0, 0, time.UTC), CentralHeating: 10.25, HotWater: 1.5, OutdoorTemperature: 11},
+		{Date: time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC), CentralHeating: 12.5, HotWater: 2.0, OutdoorTemperature: 9},
+	}
+	if len(days) != len(want) {
+		t.Fatalf("parseGasUsage() returned %d days, want %d", len(days), len(want))
+	}
+	for i := range want {
+		if !days[i].Date.Equal(want[i].Date) || days[i].CentralHeating != want[i].CentralHeating ||
+			days[i].HotWater != want[i].HotWater || days[i].OutdoorTemperature != want[i].OutdoorTemperature {
+			t.Errorf("day %d = %+v, want %+v", i, days[i], want[i])
+		}
+	}
+}
+
+func TestParseGasUsageMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data interface{}
+	}{
+		{name: "not an object", data: "unexpected"},
+		{name: "no recordings", data: map[string]interface{}{"value": 3.0}},
+		{name: "recording not an object", data: map[string]interface{}{"value": []interface{}{"14-10-2026"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseGasUsage(tt.data); err == nil {
+				t.Error("parseGasUsage() expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestGasUsagePage(t *testing.T) {
+	tests := []struct {
+		pointer int
+		want    int
+	}{
+		{pointer: 1, want: 1},
+		{pointer: 32, want: 1},
+		{pointer: 33, want: 2},
+		{pointer: 64, want: 2},
+		{pointer: 65, want: 3},
+	}
+
+	for _, tt := range tests {
+		if got := gasUsagePage(tt.pointer); got != tt.want {
+			t.Errorf("gasUsagePage(%d) = %d, want %d", tt.pointer, got, tt.want)
+		}
+	}
+}
+
+func TestFetchAndPublishEnergy(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+	}
+
+	backend := &fakeBackend{
+		gets: map[string]interface{}{
+			uriGasUsagePointer:            map[string]interface{}{"id": uriGasUsagePointer, "value": 34.0},
+			types.URIGasUsage + "?page=2": sampleGasUsagePage(),
+		},
+	}
+
+	client, err := New(cfg, logger, bus, WithBackend(backend))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientWeb)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.EnergyEvent](subscriberClient)
+	defer sub.Close()
+
+	if err := client.fetchAndPublishEnergy(); err != nil {
+		t.Fatalf("fetchAndPublishEnergy() error = %v", err)
+	}
+
+	select {
+	case event := <-sub.Events():
+		if event.Source != "nefit" {
+			t.Errorf("Source = %q, want nefit", event.Source)
+		}
+		if len(event.Days) != 2 {
+			t.Fatalf("Days = %d, want 2", len(event.Days))
+		}
+		if got := event.Total(); got != 26.25 {
+			t.Errorf("Total() = %v, want 26.25", got)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for energy event")
+	}
+
+	backend.gets[uriGasUsagePointer] = map[string]interface{}{"id": uriGasUsagePointer}
+	if err := client.fetchAndPublishEnergy(); err == nil {
+		t.Error("fetchAndPublishEnergy() expected error for malformed pointer, got nil")
+	}
+}
@@ -0,0 +1,62 @@
+package nefit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// uriIndoorHumidity is the Nefit endpoint holding the relative humidity
+// measured by the thermostat, in percent.
+const uriIndoorHumidity = "/system/sensors/humidity/indoor_h1"
+
+// errNoHumiditySensor is returned by parseHumidity when the thermostat
+// reports a value no sensor would measure.
+var errNoHumiditySensor = errors.New("thermostat has no indoor humidity sensor")
+
+// fetchHumidity reads the indoor humidity to include in state updates. On
+// failure the last known humidity is kept.
+func (c *Client) fetchHumidity(ctx context.Context) error {
+	data, err := c.get(ctx, uriIndoorHumidity)
+	if err != nil {
+		return fmt.Errorf("failed to get indoor humidity: %w", err)
+	}
+	c.logRawPayload("get", uriIndoorHumidity, data)
+
+	humidity, err := parseHumidity(data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errSensorUnavailable, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.humidity = humidity
+	return nil
+}
+
+// parseHumidity parses the indoor humidity response. Thermostats without a
+// humidity sensor report values outside 0-100.
+func parseHumidity(data interface{}) (float64, error) {
+	response, ok := data.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("unexpected indoor humidity response type %T", data)
+	}
+	humidity, ok := response["value"].(float64)
+	if !ok {
+		return 0, fmt.Errorf("indoor humidity response has no valid value")
+	}
+	if !finite(humidity) || humidity <= 0 || humidity > 100 {
+		return 0, fmt.Errorf("%w, got %g", errNoHumiditySensor, humidity)
+	}
+
+	return humidity, nil
+}
+
+// currentHumidity returns the last indoor humidity read, 0 if none was.
+func (c *Client) currentHumidity() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.humidity
+}
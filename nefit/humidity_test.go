@@ -0,0 +1,37 @@
+package nefit
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseHumidity(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       interface{}
+		want       float64
+		wantErr    bool
+		wantAbsent bool
+	}{
+		{name: "reading", data: map[string]interface{}{"id": uriIndoorHumidity, "value": 48.5, "unitOfMeasure": "%"}, want: 48.5},
+		{name: "no sensor", data: map[string]interface{}{"value": -1.0}, wantErr: true, wantAbsent: true},
+		{name: "above 100", data: map[string]interface{}{"value": 255.0}, wantErr: true, wantAbsent: true},
+		{name: "no value", data: map[string]interface{}{"id": uriIndoorHumidity}, wantErr: true},
+		{name: "not a map", data: "48.5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHumidity(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHumidity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errNoHumiditySensor) != tt.wantAbsent {
+				t.Errorf("parseHumidity() error = %v, want errNoHumiditySensor %v", err, tt.wantAbsent)
+			}
+			if got != tt.want {
+				t.Errorf("parseHumidity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
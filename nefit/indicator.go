@@ -0,0 +1,56 @@
+package nefit
+
+import (
+	"maps"
+
+	"github.com/kradalby/nefit-homekit/config"
+	"go.uber.org/zap"
+)
+
+// defaultBoilerIndicators maps the boiler indicator codes reported by Nefit
+// Easy thermostats to their meaning. NEFITHK_BOILER_INDICATORS adds to or
+// replaces these.
+var defaultBoilerIndicators = map[string]string{
+	"CH": config.BoilerIndicatorHeating,  // Central heating
+	"HW": config.BoilerIndicatorHotWater, // Heating hot water
+	"No": config.BoilerIndicatorIdle,
+}
+
+// boilerIndicators returns the built-in boiler indicator meanings with the
+// configured overrides applied.
+func boilerIndicators(cfg *config.Config) (map[string]string, error) {
+	overrides, err := cfg.BoilerIndicatorOverrides()
+	if err != nil {
+		return nil, err
+	}
+
+	indicators := maps.Clone(defaultBoilerIndicators)
+	maps.Copy(indicators, overrides)
+	return indicators, nil
+}
+
+// boilerState interprets a boiler indicator code. The boiler burns both for
+// heating and for hot water, so both report heating. Unknown codes are
+// reported as idle, the safe default, and logged so they can be added.
+func (c *Client) boilerState(indicator string) (heating, hotWater bool) {
+	if indicator == "" {
+		// Not reported, as in push notifications without an indicator
+		return false, false
+	}
+
+	meaning, ok := c.indicators[indicator]
+	if !ok {
+		c.logger.Warn("unknown nefit boiler indicator, reporting idle, map it with NEFITHK_BOILER_INDICATORS",
+			zap.String("boiler_indicator", indicator),
+		)
+		return false, false
+	}
+
+	switch meaning {
+	case config.BoilerIndicatorHeating:
+		return true, false
+	case config.BoilerIndicatorHotWater:
+		return true, true
+	}
+	return false, false
+}
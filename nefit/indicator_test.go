@@ -0,0 +1,91 @@
+package nefit
+
+import (
+	"testing"
+
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestBoilerState(t *testing.T) {
+	tests := []struct {
+		name         string
+		overrides    string
+		indicator    string
+		wantHeating  bool
+		wantHotWater bool
+		wantWarning  bool
+	}{
+		{name: "central heating", indicator: "CH", wantHeating: true},
+		{name: "hot water", indicator: "HW", wantHeating: true, wantHotWater: true},
+		{name: "idle", indicator: "No"},
+		{name: "not reported", indicator: ""},
+		{name: "unknown code", indicator: "XY", wantWarning: true},
+		{name: "configured code", overrides: "XY=heating", indicator: "XY", wantHeating: true},
+		{name: "overridden code", overrides: "HW=idle", indicator: "HW"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.WarnLevel)
+			logger := zap.New(core)
+
+			bus, err := events.New(zap.NewNop())
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:      "TEST123",
+				NefitAccessKey:   "TESTKEY",
+				NefitPassword:    "TESTPASS",
+				BoilerIndicators: tt.overrides,
+			}
+
+			client, err := New(cfg, logger, bus, WithBackend(&fakeBackend{}))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = client.Close()
+			}()
+
+			heating, hotWater := client.boilerState(tt.indicator)
+			if heating != tt.wantHeating || hotWater != tt.wantHotWater {
+				t.Errorf("boilerState(%q) = %v, %v, want %v, %v", tt.indicator, heating, hotWater, tt.wantHeating, tt.wantHotWater)
+			}
+
+			warnings := logs.FilterField(zap.String("boiler_indicator", tt.indicator)).Len()
+			if got := warnings > 0; got != tt.wantWarning {
+				t.Errorf("logged %d warnings for %q, want warning %v", warnings, tt.indicator, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestNewRejectsInvalidBoilerIndicators(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:      "TEST123",
+		NefitAccessKey:   "TESTKEY",
+		NefitPassword:    "TESTPASS",
+		BoilerIndicators: "XY=burning",
+	}
+
+	if _, err := New(cfg, logger, bus, WithBackend(&fakeBackend{})); err == nil {
+		t.Error("New() error = nil, want an error for an invalid boiler indicator")
+	}
+}
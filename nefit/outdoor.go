@@ -0,0 +1,58 @@
+package nefit
+
+import (
+	"context"
+	"fmt"
+)
+
+// uriOutdoorTemperature is the Nefit endpoint holding the outdoor sensor
+// temperature, in Celsius.
+const uriOutdoorTemperature = "/system/sensors/temperatures/outdoor_t1"
+
+// minOutdoorTemperature is the lowest plausible outdoor reading in Celsius.
+// Without a sensor Nefit reports a sentinel far below it, such as -3276.8.
+const minOutdoorTemperature = -50.0
+
+// fetchOutdoorTemperature reads the outdoor temperature to include in state
+// updates. On failure the last known temperature is kept.
+func (c *Client) fetchOutdoorTemperature(ctx context.Context) error {
+	data, err := c.get(ctx, uriOutdoorTemperature)
+	if err != nil {
+		return fmt.Errorf("failed to get outdoor temperature: %w", err)
+	}
+	c.logRawPayload("get", uriOutdoorTemperature, data)
+
+	temp, err := parseOutdoorTemperature(data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errSensorUnavailable, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.outdoorTemp = temp
+	return nil
+}
+
+// parseOutdoorTemperature parses the outdoor temperature response.
+func parseOutdoorTemperature(data interface{}) (float64, error) {
+	response, ok := data.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("unexpected outdoor temperature response type %T", data)
+	}
+	temp, ok := response["value"].(float64)
+	if !ok || !finite(temp) || temp < minOutdoorTemperature {
+		return 0, fmt.Errorf("outdoor temperature response has no valid value")
+	}
+
+	return temp, nil
+}
+
+// currentOutdoorTemperature returns the last outdoor temperature read, 0 if
+// none was.
+func (c *Client) currentOutdoorTemperature() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.outdoorTemp
+}
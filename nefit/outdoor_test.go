@@ -0,0 +1,107 @@
+package nefit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-go/types"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+	"tailscale.com/util/eventbus"
+)
+
+func TestFetchAndPublishStatusOutdoorTemperature(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+	}
+
+	var status interface{}
+	if err := json.Unmarshal([]byte(recordedStatus), &status); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	backend := &fakeBackend{
+		gets: map[string]interface{}{
+			types.URIStatus:       status,
+			uriOutdoorTemperature: map[string]interface{}{"id": uriOutdoorTemperature, "value": 4.5, "unitOfMeasure": "C"},
+		},
+	}
+
+	client, err := New(cfg, logger, bus, WithBackend(backend))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.StateUpdateEvent](subscriberClient)
+	defer sub.Close()
+
+	expectOutdoor := func(want float64) {
+		t.Helper()
+		select {
+		case event := <-sub.Events():
+			if event.OutdoorTemperature != want {
+				t.Errorf("OutdoorTemperature = %v, want %v", event.OutdoorTemperature, want)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout waiting for state update event")
+		}
+	}
+
+	if err := client.fetchAndPublishStatus(); err != nil {
+		t.Fatalf("fetchAndPublishStatus() error = %v", err)
+	}
+	expectOutdoor(4.5)
+
+	// A change of only the outdoor temperature still publishes
+	backend.gets[uriOutdoorTemperature] = map[string]interface{}{"id": uriOutdoorTemperature, "value": 3.0}
+	if err := client.fetchAndPublishStatus(); err != nil {
+		t.Fatalf("fetchAndPublishStatus() error = %v", err)
+	}
+	expectOutdoor(3.0)
+}
+
+func TestParseOutdoorTemperature(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    interface{}
+		want    float64
+		wantErr bool
+	}{
+		{name: "reading", data: map[string]interface{}{"value": -2.5}, want: -2.5},
+		{name: "no sensor", data: map[string]interface{}{"value": -3276.8}, wantErr: true},
+		{name: "no value", data: map[string]interface{}{"id": uriOutdoorTemperature}, wantErr: true},
+		{name: "not a map", data: "4.5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOutdoorTemperature(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOutdoorTemperature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseOutdoorTemperature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package nefit
+
+import (
+	"context"
+	"fmt"
+)
+
+// uriSystemPressure is the Nefit endpoint holding the heating system water
+// pressure, in bar.
+const uriSystemPressure = "/system/appliance/systemPressure"
+
+// fetchPressure reads the system pressure to include in state updates. On
+// failure the last known pressure is kept.
+func (c *Client) fetchPressure(ctx context.Context) error {
+	data, err := c.get(ctx, uriSystemPressure)
+	if err != nil {
+		return fmt.Errorf("failed to get system pressure: %w", err)
+	}
+	c.logRawPayload("get", uriSystemPressure, data)
+
+	pressure, err := parsePressure(data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errSensorUnavailable, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pressure = pressure
+	return nil
+}
+
+// parsePressure parses the system pressure response.
+func parsePressure(data interface{}) (float64, error) {
+	response, ok := data.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("unexpected system pressure response type %T", data)
+	}
+	pressure, ok := response["value"].(float64)
+	if !ok || !finite(pressure) || pressure < 0 {
+		return 0, fmt.Errorf("system pressure response has no valid value")
+	}
+
+	return pressure, nil
+}
+
+// currentPressure returns the last system pressure read, 0 if none was.
+func (c *Client) currentPressure() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.pressure
+}
@@ -0,0 +1,89 @@
+package nefit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-go/types"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+	"tailscale.com/util/eventbus"
+)
+
+func TestFetchAndPublishStatusPressure(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+	}
+
+	var status interface{}
+	if err := json.Unmarshal([]byte(recordedStatus), &status); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	backend := &fakeBackend{
+		gets: map[string]interface{}{
+			types.URIStatus:   status,
+			uriSystemPressure: map[string]interface{}{"id": uriSystemPressure, "value": 1.6, "unitOfMeasure": "bar"},
+		},
+	}
+
+	client, err := New(cfg, logger, bus, WithBackend(backend))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.StateUpdateEvent](subscriberClient)
+	defer sub.Close()
+
+	expectPressure := func(want float64) {
+		t.Helper()
+		select {
+		case event := <-sub.Events():
+			if event.Pressure != want {
+				t.Errorf("Pressure = %v, want %v", event.Pressure, want)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout waiting for state update event")
+		}
+	}
+
+	if err := client.fetchAndPublishStatus(); err != nil {
+		t.Fatalf("fetchAndPublishStatus() error = %v", err)
+	}
+	expectPressure(1.6)
+
+	// Push notifications carry the last known pressure
+	client.handleNefitEvent(types.URIStatus, map[string]interface{}{
+		"in_house_temp": 19.5,
+		"temp_setpoint": 21.5,
+		"user_mode":     nefitManual,
+	})
+	expectPressure(1.6)
+
+	// A failed pressure read keeps the last known pressure
+	backend.gets[uriSystemPressure] = map[string]interface{}{"id": uriSystemPressure}
+	if err := client.fetchAndPublishStatus(); err != nil {
+		t.Fatalf("fetchAndPublishStatus() error = %v", err)
+	}
+	expectPressure(1.6)
+}
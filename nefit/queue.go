@@ -0,0 +1,85 @@
+package nefit
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/clock"
+	"github.com/kradalby/nefit-homekit/events"
+)
+
+// queuedCommand is a command held while the backend is disconnected.
+type queuedCommand struct {
+	cmd      events.CommandEvent
+	queuedAt time.Time
+	seq      uint64 // Orders commands queued at the same instant
+}
+
+// commandQueue holds commands issued while disconnected so they can be
+// delivered after reconnecting. It keeps only the latest command per command
+// type, which bounds its size, and drops commands older than maxAge.
+type commandQueue struct {
+	mu      sync.Mutex
+	clock   clock.Clock
+	maxAge  time.Duration
+	seq     uint64
+	pending map[events.CommandType]queuedCommand
+}
+
+// newCommandQueue creates a command queue with the given staleness window.
+func newCommandQueue(c clock.Clock, maxAge time.Duration) *commandQueue {
+	return &commandQueue{
+		clock:   c,
+		maxAge:  maxAge,
+		pending: make(map[events.CommandType]queuedCommand),
+	}
+}
+
+// Enqueue stores cmd, replacing any queued command of the same type.
+func (q *commandQueue) Enqueue(cmd events.CommandEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	q.pending[cmd.CommandType] = queuedCommand{cmd: cmd, queuedAt: q.clock.Now(), seq: q.seq}
+}
+
+// Drain empties the queue and returns the commands that are still within the
+// staleness window, oldest first, along with the number of stale commands dropped.
+func (q *commandQueue) Drain() ([]events.CommandEvent, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.clock.Now()
+
+	fresh := make([]queuedCommand, 0, len(q.pending))
+	dropped := 0
+	for _, queued := range q.pending {
+		if now.Sub(queued.queuedAt) > q.maxAge {
+			dropped++
+			continue
+		}
+		fresh = append(fresh, queued)
+	}
+	q.pending = make(map[events.CommandType]queuedCommand)
+
+	sort.Slice(fresh, func(i, j int) bool {
+		return fresh[i].seq < fresh[j].seq
+	})
+
+	cmds := make([]events.CommandEvent, len(fresh))
+	for i, queued := range fresh {
+		cmds[i] = queued.cmd
+	}
+
+	return cmds, dropped
+}
+
+// Len returns the number of queued commands.
+func (q *commandQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending)
+}
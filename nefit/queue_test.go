@@ -0,0 +1,66 @@
+package nefit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/clock"
+	"github.com/kradalby/nefit-homekit/events"
+)
+
+func TestCommandQueueLatestWinsPerType(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := newCommandQueue(fake, time.Minute)
+
+	first, second := 20.0, 22.0
+	mode := events.ModeOff
+
+	q.Enqueue(events.CommandEvent{CommandType: events.CommandTypeSetTemperature, TargetTemperature: &first})
+	q.Enqueue(events.CommandEvent{CommandType: events.CommandTypeSetMode, Mode: &mode})
+	q.Enqueue(events.CommandEvent{CommandType: events.CommandTypeSetTemperature, TargetTemperature: &second})
+
+	if got := q.Len(); got != 2 {
+		t.Fatalf("Len() = %d, want 2", got)
+	}
+
+	cmds, dropped := q.Drain()
+	if dropped != 0 {
+		t.Errorf("Drain() dropped = %d, want 0", dropped)
+	}
+	if len(cmds) != 2 {
+		t.Fatalf("Drain() returned %d commands, want 2", len(cmds))
+	}
+
+	// Ordered by when each command was last queued
+	if cmds[0].CommandType != events.CommandTypeSetMode {
+		t.Errorf("cmds[0].CommandType = %s, want %s", cmds[0].CommandType, events.CommandTypeSetMode)
+	}
+	if cmds[1].CommandType != events.CommandTypeSetTemperature || *cmds[1].TargetTemperature != second {
+		t.Errorf("cmds[1] = %s %v, want %s %v", cmds[1].CommandType, *cmds[1].TargetTemperature, events.CommandTypeSetTemperature, second)
+	}
+
+	if got := q.Len(); got != 0 {
+		t.Errorf("Len() after Drain = %d, want 0", got)
+	}
+}
+
+func TestCommandQueueDropsStale(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := newCommandQueue(fake, time.Minute)
+
+	temp := 21.0
+	enabled := true
+
+	q.Enqueue(events.CommandEvent{CommandType: events.CommandTypeSetTemperature, TargetTemperature: &temp})
+	fake.Advance(45 * time.Second)
+	q.Enqueue(events.CommandEvent{CommandType: events.CommandTypeSetHotWater, HotWaterEnabled: &enabled})
+	fake.Advance(30 * time.Second)
+
+	cmds, dropped := q.Drain()
+	if dropped != 1 {
+		t.Errorf("Drain() dropped = %d, want 1", dropped)
+	}
+	if len(cmds) != 1 || cmds[0].CommandType != events.CommandTypeSetHotWater {
+		t.Errorf("Drain() = %+v, want only the hot water command", cmds)
+	}
+}
@@ -0,0 +1,171 @@
+package nefit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/clock"
+	"github.com/kradalby/nefit-homekit/temperature"
+	"go.uber.org/zap"
+)
+
+// setpointRamp writes large setpoint changes in steps, one per interval, so
+// the boiler is not asked to jump from setback to comfort at once. A newer
+// setpoint or mode change cancels a running ramp.
+type setpointRamp struct {
+	ctx      context.Context
+	clock    clock.Clock
+	logger   *zap.Logger
+	step     float64 // Celsius per write
+	round    float64 // Target temperature step intermediate setpoints are rounded to
+	interval time.Duration
+	write    func(setpoint float64) error
+
+	// cmdMu is the client's command lock, held around the steps written in
+	// the background so they do not interleave with a command's writes.
+	// Commands hold it while starting or cancelling a ramp, so it is always
+	// taken before mu.
+	cmdMu sync.Locker
+
+	// mu is held while writing, so a superseded ramp step never lands after
+	// the write of a newer command
+	mu      sync.Mutex
+	gen     uint64  // Incremented on every start and cancel so superseded ramps stop
+	current float64 // Last setpoint written by a running ramp, 0 when none runs
+
+	// running is set while a ramp has steps left to write. It is read
+	// without mu, so status polling does not wait for a ramp write.
+	running atomic.Bool
+}
+
+// newSetpointRamp creates a ramp that calls write with each setpoint, holding
+// cmdMu for the steps after the first.
+func newSetpointRamp(ctx context.Context, c clock.Clock, logger *zap.Logger, step, round float64, interval time.Duration, cmdMu sync.Locker, write func(setpoint float64) error) *setpointRamp {
+	return &setpointRamp{
+		ctx:      ctx,
+		clock:    c,
+		logger:   logger,
+		step:     step,
+		round:    round,
+		interval: interval,
+		cmdMu:    cmdMu,
+		write:    write,
+	}
+}
+
+// Start cancels any running ramp and writes the first setpoint from from
+// towards to, continuing in the background until to is written. Changes
+// within one step, or with no known starting setpoint, write to at once. A
+// running ramp starts over from the setpoint it last wrote. The error is the
+// one of the first write.
+func (r *setpointRamp) Start(from, to float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.gen++
+	if r.current != 0 {
+		from = r.current
+	}
+	r.current = 0
+
+	next := r.next(from, to)
+	r.running.Store(next != to)
+	if next != to {
+		r.logger.Info("ramping setpoint",
+			zap.Float64("from", from),
+			zap.Float64("to", to),
+			zap.Float64("step", r.step),
+			zap.Duration("interval", r.interval),
+		)
+	}
+
+	if err := r.write(next); err != nil {
+		r.running.Store(false)
+		return err
+	}
+
+	if next != to {
+		r.current = next
+		go r.continueRamp(r.gen, to)
+	}
+
+	return nil
+}
+
+// Cancel stops a running ramp, leaving the setpoint it last wrote.
+func (r *setpointRamp) Cancel() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.gen++
+	r.current = 0
+	r.running.Store(false)
+}
+
+// Running reports whether a ramp is writing intermediate setpoints.
+func (r *setpointRamp) Running() bool {
+	return r.running.Load()
+}
+
+// continueRamp writes the remaining steps towards to, one per interval, until
+// to is written, a write fails or a later start or cancel supersedes it.
+func (r *setpointRamp) continueRamp(gen uint64, to float64) {
+	for {
+		select {
+		case <-r.clock.After(r.interval):
+		case <-r.ctx.Done():
+			return
+		}
+
+		if !r.writeStep(gen, to) {
+			return
+		}
+	}
+}
+
+// writeStep writes the next setpoint towards to and reports whether steps
+// are left, unless a later start or cancel superseded the ramp.
+func (r *setpointRamp) writeStep(gen uint64, to float64) bool {
+	r.cmdMu.Lock()
+	defer r.cmdMu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if gen != r.gen {
+		return false
+	}
+
+	next := r.next(r.current, to)
+	if err := r.write(next); err != nil {
+		r.logger.Error("setpoint ramp step failed, stopping ramp",
+			zap.Float64("setpoint", next),
+			zap.Error(err),
+		)
+		r.current = 0
+		r.running.Store(false)
+		return false
+	}
+
+	if next == to {
+		r.current = 0
+		r.running.Store(false)
+		return false
+	}
+	r.current = next
+	return true
+}
+
+// next returns the setpoint to write after from on the way to to.
+func (r *setpointRamp) next(from, to float64) float64 {
+	if from <= 0 || math.Abs(to-from) <= r.step {
+		return to
+	}
+	if to > from {
+		return temperature.RoundToStep(from+r.step, r.round)
+	}
+	return temperature.RoundToStep(from-r.step, r.round)
+}
@@ -0,0 +1,206 @@
+package nefit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-go/types"
+	"github.com/kradalby/nefit-homekit/clock"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+func TestSetpointRamp(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:          "TEST123",
+		NefitAccessKey:       "TESTKEY",
+		NefitPassword:        "TESTPASS",
+		SetpointRampStep:     2,
+		SetpointRampInterval: time.Minute,
+	}
+
+	backend := &fakeBackend{attempts: make(chan int, 10), puts: make(chan fakePut, 10)}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	client.setComfortSetpoint(16, sourceNefit)
+
+	setTemperature := func(temp float64) {
+		client.handleCommand(events.CommandEvent{
+			Source:            "web",
+			CommandType:       events.CommandTypeSetTemperature,
+			TargetTemperature: &temp,
+		})
+	}
+
+	expectPut := func(want float64) {
+		t.Helper()
+		select {
+		case put := <-backend.puts:
+			if put.uri != types.URIManualSetpoint || put.data != want {
+				t.Fatalf("put = %s %v, want %s %v", put.uri, put.data, types.URIManualSetpoint, want)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timeout waiting for setpoint %v", want)
+		}
+	}
+
+	expectNoPut := func() {
+		t.Helper()
+		select {
+		case put := <-backend.puts:
+			t.Fatalf("unexpected write %s %v", put.uri, put.data)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// A large jump is written one step per interval
+	setTemperature(24)
+	expectPut(18)
+	for _, want := range []float64{20, 22, 24} {
+		fake.BlockUntil(1)
+		expectNoPut()
+		fake.Advance(time.Minute)
+		expectPut(want)
+	}
+	fake.Advance(time.Minute)
+	expectNoPut()
+
+	// Changes within one step are written at once
+	setTemperature(23)
+	expectPut(23)
+	expectNoPut()
+
+	// A newer command cancels the running ramp, and starts from the setpoint
+	// the ramp last wrote
+	setTemperature(15)
+	expectPut(21)
+	fake.BlockUntil(1)
+	setTemperature(20)
+	expectPut(20)
+	fake.Advance(time.Minute)
+	expectNoPut()
+	if got := client.currentComfortSetpoint(); got != 20 {
+		t.Errorf("comfort setpoint = %v, want 20", got)
+	}
+
+	// A mode change cancels the running ramp too
+	setTemperature(26)
+	expectPut(22)
+	fake.BlockUntil(1)
+	mode := events.ModeOff
+	client.handleCommand(events.CommandEvent{
+		Source:      "web",
+		CommandType: events.CommandTypeSetMode,
+		Mode:        &mode,
+	})
+	select {
+	case put := <-backend.puts:
+		if put.uri != types.URIUserMode || put.data != nefitOff {
+			t.Fatalf("put = %s %v, want %s %s", put.uri, put.data, types.URIUserMode, nefitOff)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for mode write")
+	}
+	fake.Advance(time.Minute)
+	expectNoPut()
+}
+
+func TestSetpointRampIsNotAnExternalChange(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:          "TEST123",
+		NefitAccessKey:       "TESTKEY",
+		NefitPassword:        "TESTPASS",
+		SetpointRampStep:     2,
+		SetpointRampInterval: time.Minute,
+	}
+
+	backend := &fakeBackend{attempts: make(chan int, 10), puts: make(chan fakePut, 10)}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	client.setComfortSetpoint(16, sourceNefit)
+
+	setTemperature := func(temp float64) {
+		client.handleCommand(events.CommandEvent{
+			Source:            "web",
+			CommandType:       events.CommandTypeSetTemperature,
+			TargetTemperature: &temp,
+		})
+	}
+
+	expectPut := func(want float64) {
+		t.Helper()
+		select {
+		case put := <-backend.puts:
+			if put.uri != types.URIManualSetpoint || put.data != want {
+				t.Fatalf("put = %s %v, want %s %v", put.uri, put.data, types.URIManualSetpoint, want)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timeout waiting for setpoint %v", want)
+		}
+	}
+
+	expectTracked := func(setpoint, wantComfort float64, wantSource string) {
+		t.Helper()
+		comfort, source := client.trackComfortSetpoint(events.ModeHeat, setpoint)
+		if comfort != wantComfort || source != wantSource {
+			t.Errorf("trackComfortSetpoint(%v) = %v, %q, want %v, %q", setpoint, comfort, source, wantComfort, wantSource)
+		}
+	}
+
+	// The thermostat reports each step of the ramp, which keeps the target
+	// and its source
+	setTemperature(22)
+	expectPut(18)
+	expectTracked(18, 22, "web")
+
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	expectPut(20)
+	expectTracked(20, 22, "web")
+
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	expectPut(22)
+	expectTracked(22, 22, "web")
+
+	// Without a running ramp, a different setpoint is a change on the
+	// thermostat again
+	setTemperature(23)
+	expectPut(23)
+	expectTracked(21, 21, sourceNefit)
+}
@@ -0,0 +1,122 @@
+package nefit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// opAttempts bounds how often a Get or Put is tried on transient errors.
+	opAttempts = 3
+
+	// opRetryBackoff is the wait before the first retry, doubled for each
+	// following one.
+	opRetryBackoff = 500 * time.Millisecond
+)
+
+// permanentErrorMarkers are substrings of nefit-go errors that retrying cannot
+// fix. nefit-go only returns formatted errors, so they are matched on text.
+var permanentErrorMarkers = []string{
+	"not connected",     // Handled by the reconnect loop instead
+	"decryption failed", // Wrong password
+	"HTTP error 4",      // Rejected request
+	"failed to marshal data",
+	"failed to encrypt data",
+	"invalid config",             // Missing serial, access key or password
+	"failed to create encryptor", // Unusable credentials
+}
+
+// authErrorMarkers are substrings of nefit-go connect errors for rejected
+// credentials. Any other connect error, timeouts included, is retried with
+// the usual backoff.
+var authErrorMarkers = []string{
+	"decryption failed", // Wrong password
+}
+
+// isAuthError reports whether a connect attempt failed on its credentials.
+func isAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	for _, marker := range authErrorMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransient reports whether a failed Get, Put or client creation is worth
+// retrying.
+// Context errors are permanent: either the operation timed out, after
+// nefit-go's own retries on response timeouts, or the client is shutting down.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	msg := err.Error()
+	for _, marker := range permanentErrorMarkers {
+		if strings.Contains(msg, marker) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// get reads uri from the backend, retrying transient failures.
+func (c *Client) get(ctx context.Context, uri string) (interface{}, error) {
+	var data interface{}
+	err := c.retry(ctx, "get", uri, func() error {
+		var err error
+		data, err = c.nefitClient.Get(ctx, uri)
+		return err
+	})
+
+	return data, err
+}
+
+// put writes data to uri on the backend, retrying transient failures.
+func (c *Client) put(ctx context.Context, uri string, data interface{}) error {
+	return c.retry(ctx, "put", uri, func() error {
+		return c.nefitClient.Put(ctx, uri, data)
+	})
+}
+
+// retry calls fn up to opAttempts times while it fails with transient errors.
+func (c *Client) retry(ctx context.Context, op, uri string, fn func() error) error {
+	backoff := opRetryBackoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt == opAttempts || !isTransient(err) {
+			return err
+		}
+
+		c.logger.Warn("transient nefit error, retrying",
+			zap.String("op", op),
+			zap.String("uri", uri),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+
+		select {
+		case <-c.clock.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
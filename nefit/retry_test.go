@@ -0,0 +1,231 @@
+package nefit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-go/types"
+	"github.com/kradalby/nefit-homekit/clock"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"send failure", errors.New("failed to send message: broken pipe"), true},
+		{"server error", errors.New("HTTP error 500: Internal Server Error"), true},
+		{"not connected", errors.New("not connected"), false},
+		{"wrapped not connected", fmt.Errorf("get status: %w", errors.New("not connected")), false},
+		{"wrong password", errors.New("decryption failed: bad padding"), false},
+		{"rejected request", errors.New("HTTP error 403: Forbidden"), false},
+		{"timed out", fmt.Errorf("GET request failed after 15 attempts: %w", context.DeadlineExceeded), false},
+		{"canceled", context.Canceled, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"wrong password", errors.New("decryption failed: bad padding"), true},
+		{"wrapped wrong password", fmt.Errorf("connect: %w", errors.New("decryption failed")), true},
+		{"dial timeout", &net.OpError{Op: "dial", Net: "tcp", Err: context.DeadlineExceeded}, false},
+		{"timed out", fmt.Errorf("connect: %w", context.DeadlineExceeded), false},
+		{"not connected", errors.New("not connected"), false},
+		{"refused", errors.New("connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAuthError(tt.err); got != tt.want {
+				t.Errorf("isAuthError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPutRetriesTransientFailures(t *testing.T) {
+	errTransient := errors.New("failed to send message: broken pipe")
+
+	tests := []struct {
+		name      string
+		putErrs   []error
+		wantPuts  int
+		wantSaved bool
+	}{
+		{
+			name:      "fails once then succeeds",
+			putErrs:   []error{errTransient},
+			wantPuts:  2,
+			wantSaved: true,
+		},
+		{
+			name:      "gives up after max attempts",
+			putErrs:   []error{errTransient, errTransient, errTransient},
+			wantPuts:  opAttempts,
+			wantSaved: false,
+		},
+		{
+			name:      "permanent error is not retried",
+			putErrs:   []error{errors.New("HTTP error 401: Unauthorized")},
+			wantPuts:  1,
+			wantSaved: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:    "TEST123",
+				NefitAccessKey: "TESTKEY",
+				NefitPassword:  "TESTPASS",
+			}
+
+			backend := &fakeBackend{
+				attempts: make(chan int, 10),
+				puts:     make(chan fakePut, 10),
+				putErrs:  tt.putErrs,
+			}
+			fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+			client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = client.Close()
+			}()
+
+			temp := 22.5
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				client.handleCommand(events.CommandEvent{
+					Source:            "web",
+					CommandType:       events.CommandTypeSetTemperature,
+					TargetTemperature: &temp,
+				})
+			}()
+
+			backoff := opRetryBackoff
+			for i := 1; i <= tt.wantPuts; i++ {
+				select {
+				case put := <-backend.puts:
+					if put.uri != types.URIManualSetpoint || put.data != temp {
+						t.Fatalf("put = %s %v, want %s %v", put.uri, put.data, types.URIManualSetpoint, temp)
+					}
+				case <-time.After(1 * time.Second):
+					t.Fatalf("timeout waiting for put %d", i)
+				}
+
+				if i < tt.wantPuts {
+					fake.BlockUntil(1)
+					fake.Advance(backoff)
+					backoff *= 2
+				}
+			}
+
+			select {
+			case <-done:
+			case <-time.After(1 * time.Second):
+				t.Fatal("handleCommand did not return")
+			}
+
+			select {
+			case put := <-backend.puts:
+				t.Errorf("unexpected extra put %s %v", put.uri, put.data)
+			default:
+			}
+
+			client.mu.Lock()
+			saved := client.comfortSetpoint == temp
+			client.mu.Unlock()
+			if saved != tt.wantSaved {
+				t.Errorf("setpoint saved = %v, want %v", saved, tt.wantSaved)
+			}
+		})
+	}
+}
+
+func TestGetRetriesTransientFailures(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+	}
+
+	backend := &fakeBackend{
+		attempts: make(chan int, 10),
+		gets:     map[string]interface{}{uriFirmwareVersion: map[string]interface{}{"value": "04.08.02"}},
+		getErrs:  []error{errors.New("failed to send message: connection reset")},
+	}
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client, err := New(cfg, logger, bus, WithBackend(backend), WithClock(fake))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- client.fetchFirmwareVersion()
+	}()
+
+	fake.BlockUntil(1)
+	fake.Advance(opRetryBackoff)
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("fetchFirmwareVersion() error = %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for firmware version")
+	}
+
+	if got := client.currentFirmwareVersion(); got != "04.08.02" {
+		t.Errorf("firmware version = %q, want 04.08.02", got)
+	}
+}
@@ -0,0 +1,59 @@
+package nefit
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kradalby/nefit-go/types"
+)
+
+// Keys of the status read from types.URIStatus, abbreviated by Nefit.
+const (
+	statusInHouseTemp     = "IHT" // Room temperature in Celsius
+	statusTempSetpoint    = "TSP" // Target temperature in Celsius
+	statusBoilerIndicator = "BAI" // "CH" heating, "HW" hot water, "No" idle
+	statusUserMode        = "UMD" // "manual", "clock" or "off"
+	statusHotWater        = "DHW" // "on" or "off"
+)
+
+// parseStatus parses the status response. Nefit reports most values as
+// strings, such as "20.50" for temperatures.
+func parseStatus(data interface{}) (types.Status, error) {
+	response, ok := data.(map[string]interface{})
+	if !ok {
+		return types.Status{}, fmt.Errorf("unexpected status response type %T", data)
+	}
+	value, ok := response["value"].(map[string]interface{})
+	if !ok {
+		return types.Status{}, fmt.Errorf("status response has no value")
+	}
+
+	var status types.Status
+	status.InHouseTemp, ok = parseStatusNumber(value[statusInHouseTemp])
+	if !ok {
+		return types.Status{}, fmt.Errorf("status response has no valid %s", statusInHouseTemp)
+	}
+	status.TempSetpoint, ok = parseStatusNumber(value[statusTempSetpoint])
+	if !ok {
+		return types.Status{}, fmt.Errorf("status response has no valid %s", statusTempSetpoint)
+	}
+
+	status.BoilerIndicator, _ = value[statusBoilerIndicator].(string)
+	status.UserMode, _ = value[statusUserMode].(string)
+	hotWater, _ := value[statusHotWater].(string)
+	status.HotWaterActive = hotWater == "on"
+
+	return status, nil
+}
+
+// parseStatusNumber parses a number reported as a string or a JSON number.
+func parseStatusNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
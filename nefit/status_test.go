@@ -0,0 +1,269 @@
+package nefit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-go/types"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"tailscale.com/util/eventbus"
+)
+
+// recordedStatus is a status read from a Nefit Easy, as decoded from JSON.
+const recordedStatus = `{
+	"id": "/ecus/rrc/uiStatus",
+	"type": "uiUpdate",
+	"recordable": 0,
+	"writeable": 0,
+	"value": {
+		"CTD": "2025-01-01T12:00:00+01:00 We",
+		"CTR": "room",
+		"UMD": "manual",
+		"MMT": "21.5",
+		"CPM": "auto",
+		"CSP": "31",
+		"TOR": "off",
+		"TOD": "0",
+		"TOT": "21.5",
+		"TSP": "21.5",
+		"IHT": "20.82",
+		"IHS": "ok",
+		"DAS": "off",
+		"TAS": "off",
+		"BAI": "CH",
+		"DHW": "on",
+		"HMD": "off",
+		"FPA": "off",
+		"ESI": "off"
+	}
+}`
+
+func TestParseStatus(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(recordedStatus), &data); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	status, err := parseStatus(data)
+	if err != nil {
+		t.Fatalf("parseStatus() error = %v", err)
+	}
+
+	if status.InHouseTemp != 20.82 || status.TempSetpoint != 21.5 {
+		t.Errorf("temperatures = %v, %v, want 20.82, 21.5", status.InHouseTemp, status.TempSetpoint)
+	}
+	if status.BoilerIndicator != "CH" || status.UserMode != "manual" || !status.HotWaterActive {
+		t.Errorf("parseStatus() = %+v, want CH, manual and hot water on", status)
+	}
+
+	for name, data := range map[string]interface{}{
+		"not a map":        "uiStatus",
+		"no value":         map[string]interface{}{"id": types.URIStatus},
+		"no temperature":   map[string]interface{}{"value": map[string]interface{}{"TSP": "21.5"}},
+		"invalid setpoint": map[string]interface{}{"value": map[string]interface{}{"IHT": "20.5", "TSP": "n/a"}},
+	} {
+		if _, err := parseStatus(data); err == nil {
+			t.Errorf("parseStatus(%s) error = nil, want an error", name)
+		}
+	}
+}
+
+func TestFetchAndPublishStatus(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+	}
+
+	var data interface{}
+	if err := json.Unmarshal([]byte(recordedStatus), &data); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	backend := &fakeBackend{
+		gets: map[string]interface{}{types.URIStatus: data},
+	}
+
+	client, err := New(cfg, logger, bus, WithBackend(backend))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.StateUpdateEvent](subscriberClient)
+	defer sub.Close()
+
+	if err := client.fetchAndPublishStatus(); err != nil {
+		t.Fatalf("fetchAndPublishStatus() error = %v", err)
+	}
+
+	select {
+	case event := <-sub.Events():
+		if event.CurrentTemperature != 20.82 {
+			t.Errorf("CurrentTemperature = %v, want 20.82", event.CurrentTemperature)
+		}
+		if event.TargetTemperature != 21.5 {
+			t.Errorf("TargetTemperature = %v, want 21.5", event.TargetTemperature)
+		}
+		if !event.HeatingActive {
+			t.Error("HeatingActive = false, want true")
+		}
+		if event.Mode != events.ModeHeat {
+			t.Errorf("Mode = %q, want %q", event.Mode, events.ModeHeat)
+		}
+		if !event.HotWaterActive {
+			t.Error("HotWaterActive = false, want true")
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for state update event")
+	}
+}
+
+func TestFetchAndPublishStatusSharesDeadline(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+	}
+
+	var data interface{}
+	if err := json.Unmarshal([]byte(recordedStatus), &data); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	backend := &fakeBackend{
+		gets:    map[string]interface{}{types.URIStatus: data},
+		getCtxs: make(chan context.Context, 10),
+	}
+
+	client, err := New(cfg, logger, bus, WithBackend(backend))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.fetchAndPublishStatus(); err != nil {
+		t.Fatalf("fetchAndPublishStatus() error = %v", err)
+	}
+
+	// The status and the three sensors are read under one deadline
+	close(backend.getCtxs)
+	var deadlines []time.Time
+	for ctx := range backend.getCtxs {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Fatal("Get() context has no deadline")
+		}
+		deadlines = append(deadlines, deadline)
+	}
+	if len(deadlines) != 4 {
+		t.Fatalf("got %d reads, want 4", len(deadlines))
+	}
+	for i, deadline := range deadlines[1:] {
+		if !deadline.Equal(deadlines[0]) {
+			t.Errorf("read %d deadline = %v, want the status deadline %v", i+1, deadline, deadlines[0])
+		}
+	}
+}
+
+func TestFetchAndPublishStatusSkipsUnavailableSensors(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core)
+
+	bus, err := events.New(zap.NewNop())
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		NefitAccessKey: "TESTKEY",
+		NefitPassword:  "TESTPASS",
+	}
+
+	var data interface{}
+	if err := json.Unmarshal([]byte(recordedStatus), &data); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	// The thermostat has no humidity sensor, and the outdoor temperature read
+	// fails as the endpoint is not answered
+	backend := &fakeBackend{
+		gets: map[string]interface{}{
+			types.URIStatus:   data,
+			uriSystemPressure: map[string]interface{}{"id": uriSystemPressure, "value": 1.6},
+			uriIndoorHumidity: map[string]interface{}{"id": uriIndoorHumidity, "value": -1.0},
+		},
+	}
+
+	client, err := New(cfg, logger, bus, WithBackend(backend))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	for i := 0; i < 3; i++ {
+		if err := client.fetchAndPublishStatus(); err != nil {
+			t.Fatalf("poll %d: fetchAndPublishStatus() error = %v", i, err)
+		}
+	}
+
+	// The missing sensor is logged once, failed requests on every poll
+	if got := logs.FilterMessage("sensor not available, no longer reading it").Len(); got != 1 {
+		t.Errorf("logged the missing sensor %d times, want 1", got)
+	}
+	if got := logs.FilterMessage("failed to fetch outdoor temperature").Len(); got != 3 {
+		t.Errorf("logged the failed outdoor read %d times, want 3", got)
+	}
+
+	// Only the unavailable sensor is no longer read
+	backend.gets[uriSystemPressure] = map[string]interface{}{"id": uriSystemPressure, "value": 1.4}
+	backend.gets[uriIndoorHumidity] = map[string]interface{}{"id": uriIndoorHumidity, "value": 48.5}
+	backend.gets[uriOutdoorTemperature] = map[string]interface{}{"id": uriOutdoorTemperature, "value": 4.5}
+	if err := client.fetchAndPublishStatus(); err != nil {
+		t.Fatalf("fetchAndPublishStatus() error = %v", err)
+	}
+	if got := client.currentPressure(); got != 1.4 {
+		t.Errorf("currentPressure() = %v, want 1.4", got)
+	}
+	if got := client.currentOutdoorTemperature(); got != 4.5 {
+		t.Errorf("currentOutdoorTemperature() = %v, want 4.5", got)
+	}
+	if got := client.currentHumidity(); got != 0 {
+		t.Errorf("currentHumidity() = %v, want 0", got)
+	}
+}
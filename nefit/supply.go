@@ -0,0 +1,91 @@
+package nefit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+// uriSupplySetpoint is the Nefit endpoint holding the boiler supply
+// temperature setpoint of the heating circuit, in Celsius.
+const uriSupplySetpoint = "/heatingCircuits/hc1/supplyTemperatureSetpoint"
+
+// Errors returned by setSupplySetpoint for setpoints that are not written.
+var (
+	errSupplySetpointDisabled = errors.New("setting the supply setpoint requires NEFITHK_ADVANCED_SUPPLY_SETPOINT_ENABLED")
+	errSupplySetpointRange    = errors.New("supply setpoint out of range")
+)
+
+// fetchSupplySetpoint reads the supply temperature setpoint to include in
+// state updates.
+func (c *Client) fetchSupplySetpoint() error {
+	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Second)
+	defer cancel()
+
+	data, err := c.get(ctx, uriSupplySetpoint)
+	if err != nil {
+		return fmt.Errorf("failed to get supply setpoint: %w", err)
+	}
+	c.logRawPayload("get", uriSupplySetpoint, data)
+
+	setpoint, err := parseSupplySetpoint(data)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.supplySetpoint = setpoint
+	return nil
+}
+
+// parseSupplySetpoint parses the supply setpoint response.
+func parseSupplySetpoint(data interface{}) (float64, error) {
+	response, ok := data.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("unexpected supply setpoint response type %T", data)
+	}
+	setpoint, ok := response["value"].(float64)
+	if !ok || setpoint <= 0 {
+		return 0, fmt.Errorf("supply setpoint response has no valid value")
+	}
+
+	return setpoint, nil
+}
+
+// currentSupplySetpoint returns the last supply setpoint read or written.
+func (c *Client) currentSupplySetpoint() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.supplySetpoint
+}
+
+// setSupplySetpoint writes a supply setpoint within the accepted bounds.
+func (c *Client) setSupplySetpoint(ctx context.Context, setpoint float64) error {
+	if !c.cfg.AdvancedSupplySetpointEnabled {
+		return errSupplySetpointDisabled
+	}
+	if setpoint < events.MinSupplySetpoint || setpoint > events.MaxSupplySetpoint {
+		return fmt.Errorf("%w: %.1f is outside %.0f-%.0f", errSupplySetpointRange, setpoint, events.MinSupplySetpoint, events.MaxSupplySetpoint)
+	}
+
+	c.logger.Info("setting supply setpoint",
+		zap.Float64("setpoint", setpoint),
+	)
+
+	if err := c.put(ctx, uriSupplySetpoint, setpoint); err != nil {
+		return fmt.Errorf("failed to set supply setpoint: %w", err)
+	}
+
+	c.mu.Lock()
+	c.supplySetpoint = setpoint
+	c.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package nefit
+
+import (
+	"testing"
+
+	"github.com/kradalby/nefit-go/types"
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+func TestFetchSupplySetpoint(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:                   "TEST123",
+		NefitAccessKey:                "TESTKEY",
+		NefitPassword:                 "TESTPASS",
+		AdvancedSupplySetpointEnabled: true,
+	}
+
+	backend := &fakeBackend{
+		gets: map[string]interface{}{
+			uriSupplySetpoint: map[string]interface{}{"id": uriSupplySetpoint, "value": 55.0},
+		},
+	}
+
+	client, err := New(cfg, logger, bus, WithBackend(backend))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := client.fetchSupplySetpoint(); err != nil {
+		t.Fatalf("fetchSupplySetpoint() error = %v", err)
+	}
+	if got := client.currentSupplySetpoint(); got != 55.0 {
+		t.Errorf("currentSupplySetpoint() = %v, want 55", got)
+	}
+
+	// The setpoint is reported with state updates
+	client.publishStateUpdate(types.Status{InHouseTemp: 20.5, TempSetpoint: 21.0, UserMode: nefitManual})
+	var published bool
+	for _, recorded := range bus.RecentEvents() {
+		if state, ok := recorded.Event.(events.StateUpdateEvent); ok {
+			published = true
+			if state.SupplySetpoint != 55.0 {
+				t.Errorf("SupplySetpoint = %v, want 55", state.SupplySetpoint)
+			}
+		}
+	}
+	if !published {
+		t.Fatal("no state update published")
+	}
+
+	backend.gets[uriSupplySetpoint] = map[string]interface{}{"id": uriSupplySetpoint}
+	if err := client.fetchSupplySetpoint(); err == nil {
+		t.Error("fetchSupplySetpoint() expected error for a response without value, got nil")
+	}
+}
+
+func TestSetSupplySetpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		setpoint float64
+		wantPut  bool
+	}{
+		{name: "within bounds", enabled: true, setpoint: 55, wantPut: true},
+		{name: "minimum", enabled: true, setpoint: events.MinSupplySetpoint, wantPut: true},
+		{name: "maximum", enabled: true, setpoint: events.MaxSupplySetpoint, wantPut: true},
+		{name: "below minimum", enabled: true, setpoint: events.MinSupplySetpoint - 1},
+		{name: "above maximum", enabled: true, setpoint: events.MaxSupplySetpoint + 1},
+		{name: "advanced features disabled", setpoint: 55},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:                   "TEST123",
+				NefitAccessKey:                "TESTKEY",
+				NefitPassword:                 "TESTPASS",
+				AdvancedSupplySetpointEnabled: tt.enabled,
+			}
+
+			backend := &fakeBackend{puts: make(chan fakePut, 10)}
+
+			client, err := New(cfg, logger, bus, WithBackend(backend))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = client.Close()
+			}()
+
+			setpoint := tt.setpoint
+			client.handleCommand(events.CommandEvent{
+				Source:         "web",
+				CommandType:    events.CommandTypeSetSupplySetpoint,
+				SupplySetpoint: &setpoint,
+			})
+
+			select {
+			case put := <-backend.puts:
+				if !tt.wantPut {
+					t.Fatalf("unexpected write %s %v", put.uri, put.data)
+				}
+				if put.uri != uriSupplySetpoint || put.data != tt.setpoint {
+					t.Errorf("put = %s %v, want %s %v", put.uri, put.data, uriSupplySetpoint, tt.setpoint)
+				}
+				if got := client.currentSupplySetpoint(); got != tt.setpoint {
+					t.Errorf("currentSupplySetpoint() = %v, want %v", got, tt.setpoint)
+				}
+			default:
+				if tt.wantPut {
+					t.Fatal("no supply setpoint written")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+// Package netutil provides listeners that can be rebound right after a restart.
+package netutil
+
+import (
+	"context"
+	"net"
+)
+
+// Listen announces on the local network address like net.Listen, with
+// SO_REUSEADDR set where supported. This lets a restarted process bind a port
+// whose previous connections are still in TIME_WAIT.
+func Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: reuseAddr}
+	return lc.Listen(ctx, network, address)
+}
@@ -0,0 +1,72 @@
+package netutil
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenRebindAfterClose(t *testing.T) {
+	ctx := context.Background()
+
+	ln, err := Listen(ctx, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	addr := ln.Addr().String()
+
+	// Close an accepted connection from the server side, leaving it in
+	// TIME_WAIT on the listening port, as after serving requests
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			err = conn.Close()
+		}
+		accepted <- err
+	}()
+
+	client, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if err := <-accepted; err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read() error = %v, want EOF", err)
+	}
+	_ = client.Close()
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Rebinding immediately must succeed
+	ln, err = Listen(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatalf("rebind Listen(%s) error = %v", addr, err)
+	}
+	_ = ln.Close()
+}
+
+func TestListenPortInUse(t *testing.T) {
+	ctx := context.Background()
+
+	ln, err := Listen(ctx, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	// Address reuse must not allow two listeners on the same port
+	if second, err := Listen(ctx, "tcp", ln.Addr().String()); err == nil {
+		_ = second.Close()
+		t.Fatal("Listen() on a port in use succeeded, want error")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package netutil
+
+import (
+	"syscall"
+)
+
+// reuseAddr is a no-op where SO_REUSEADDR is not available, or, as on Windows,
+// lets another process take over a port that is in use.
+func reuseAddr(_, _ string, _ syscall.RawConn) error {
+	return nil
+}
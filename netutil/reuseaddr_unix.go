@@ -0,0 +1,21 @@
+//go:build unix
+
+package netutil
+
+import (
+	"syscall"
+)
+
+// reuseAddr sets SO_REUSEADDR on the socket before it is bound. Go already
+// does this for listeners on Unix, setting it here keeps it explicit.
+func reuseAddr(_, _ string, conn syscall.RawConn) error {
+	var sockErr error
+	err := conn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
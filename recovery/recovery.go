@@ -0,0 +1,87 @@
+// Package recovery turns panics into errors so the bridge can log them and
+// shut down gracefully instead of crashing with a raw stack trace.
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// PanicError is a recovered panic.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+// Error implements the error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+var (
+	panicOnce sync.Once
+	panicked  = make(chan struct{})
+)
+
+// Panicked returns a channel that is closed once a goroutine started with Go
+// has panicked, so main can shut down.
+func Panicked() <-chan struct{} {
+	return panicked
+}
+
+// Run calls fn and returns a *PanicError if it panics.
+func Run(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	return fn()
+}
+
+// Go runs fn in a new goroutine. A panic is logged and reported through
+// Panicked instead of crashing the process.
+func Go(logger *zap.Logger, name string, fn func()) {
+	go func() {
+		err := Run(func() error {
+			fn()
+			return nil
+		})
+
+		var panicErr *PanicError
+		if errors.As(err, &panicErr) {
+			Log(logger, name, panicErr)
+
+			panicOnce.Do(func() {
+				close(panicked)
+			})
+		}
+	}()
+}
+
+// Recover must be deferred directly. It recovers a panic, logs it, and stores
+// it in *errp as a *PanicError.
+func Recover(logger *zap.Logger, name string, errp *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	panicErr := &PanicError{Value: r, Stack: debug.Stack()}
+	Log(logger, name, panicErr)
+	*errp = panicErr
+}
+
+// Log logs a recovered panic with its stack trace.
+func Log(logger *zap.Logger, name string, err *PanicError) {
+	logger.Error("recovered from panic",
+		zap.String("goroutine", name),
+		zap.Any("panic", err.Value),
+		zap.ByteString("stack", err.Stack),
+	)
+}
@@ -0,0 +1,106 @@
+package recovery
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRun(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name      string
+		fn        func() error
+		wantErr   error
+		wantPanic any
+	}{
+		{
+			name: "success",
+			fn:   func() error { return nil },
+		},
+		{
+			name:    "error",
+			fn:      func() error { return errFailed },
+			wantErr: errFailed,
+		},
+		{
+			name:      "panic",
+			fn:        func() error { panic("boom") },
+			wantPanic: "boom",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Run(tt.fn)
+
+			var panicErr *PanicError
+			if tt.wantPanic != nil {
+				if !errors.As(err, &panicErr) {
+					t.Fatalf("Run() error = %v, want *PanicError", err)
+				}
+				if panicErr.Value != tt.wantPanic {
+					t.Errorf("PanicError.Value = %v, want %v", panicErr.Value, tt.wantPanic)
+				}
+				if len(panicErr.Stack) == 0 {
+					t.Error("PanicError.Stack is empty")
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGoRecoversPanic(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	logger := zap.New(core)
+
+	Go(logger, "test", func() {
+		panic("boom")
+	})
+
+	select {
+	case <-Panicked():
+	case <-time.After(1 * time.Second):
+		t.Fatal("Panicked() was not closed after goroutine panic")
+	}
+
+	entries := logs.FilterMessage("recovered from panic").All()
+	if len(entries) != 1 {
+		t.Fatalf("logged %d panic entries, want 1", len(entries))
+	}
+	if got := entries[0].ContextMap()["goroutine"]; got != "test" {
+		t.Errorf("goroutine = %v, want test", got)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	logger := zap.New(core)
+
+	run := func() (err error) {
+		defer Recover(logger, "main", &err)
+		panic("boom")
+	}
+
+	err := run()
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("run() error = %v, want *PanicError", err)
+	}
+	if panicErr.Value != "boom" {
+		t.Errorf("PanicError.Value = %v, want boom", panicErr.Value)
+	}
+	if got := logs.FilterMessage("recovered from panic").Len(); got != 1 {
+		t.Errorf("logged %d panic entries, want 1", got)
+	}
+}
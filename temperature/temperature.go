@@ -0,0 +1,37 @@
+// Package temperature converts temperatures between Celsius and Fahrenheit
+// and rounds them for display, so every package converts the same way.
+// The bridge works in Celsius internally; Fahrenheit is only for display.
+package temperature
+
+import "math"
+
+// CtoF converts a temperature from Celsius to Fahrenheit.
+func CtoF(celsius float64) float64 {
+	return celsius*9/5 + 32
+}
+
+// FtoC converts a temperature from Fahrenheit to Celsius.
+func FtoC(fahrenheit float64) float64 {
+	return (fahrenheit - 32) * 5 / 9
+}
+
+// stepEpsilon absorbs float noise when dividing by a step, so 20.95/0.1,
+// which is 209.49999999999997, still counts as a midpoint.
+const stepEpsilon = 1e-9
+
+// RoundToStep rounds a temperature to the nearest multiple of step, rounding
+// halves up like Round. Steps are multiples of 0.1, so the result is rounded
+// to 0.1 as well to drop float noise such as 21.000000000000004. A step of 0
+// or less rounds to 0.1.
+func RoundToStep(v, step float64) float64 {
+	if step <= 0 {
+		return Round(v)
+	}
+	return Round(math.Floor(v/step+0.5+stepEpsilon) * step)
+}
+
+// Round rounds a temperature to the nearest 0.1 degree, rounding halves up,
+// also for negative values, where -1.25 rounds to -1.2.
+func Round(v float64) float64 {
+	return math.Floor(v*10+0.5) / 10
+}
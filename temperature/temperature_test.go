@@ -0,0 +1,120 @@
+package temperature
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCtoF(t *testing.T) {
+	tests := []struct {
+		name    string
+		celsius float64
+		want    float64
+	}{
+		{name: "scales cross", celsius: -40, want: -40},
+		{name: "freezing", celsius: 0, want: 32},
+		{name: "boiling", celsius: 100, want: 212},
+		{name: "minimum setpoint", celsius: 10, want: 50},
+		{name: "maximum setpoint", celsius: 30, want: 86},
+		{name: "typical room", celsius: 21.5, want: 70.7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CtoF(tt.celsius); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("CtoF(%v) = %v, want %v", tt.celsius, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFtoC(t *testing.T) {
+	tests := []struct {
+		name       string
+		fahrenheit float64
+		want       float64
+	}{
+		{name: "scales cross", fahrenheit: -40, want: -40},
+		{name: "freezing", fahrenheit: 32, want: 0},
+		{name: "boiling", fahrenheit: 212, want: 100},
+		{name: "minimum setpoint", fahrenheit: 50, want: 10},
+		{name: "maximum setpoint", fahrenheit: 86, want: 30},
+		{name: "typical room", fahrenheit: 70.7, want: 21.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FtoC(tt.fahrenheit); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("FtoC(%v) = %v, want %v", tt.fahrenheit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		name string
+		in   float64
+		want float64
+	}{
+		{name: "already rounded", in: 21.5, want: 21.5},
+		{name: "rounds down", in: 21.44, want: 21.4},
+		{name: "rounds up", in: 21.46, want: 21.5},
+		{name: "half rounds up", in: 21.25, want: 21.3},
+		{name: "just below half step", in: 21.449999, want: 21.4},
+		{name: "float noise below tenth", in: 21.499999, want: 21.5},
+		{name: "zero", in: 0, want: 0},
+		{name: "negative half rounds up", in: -1.25, want: -1.2},
+		{name: "negative rounds down", in: -1.26, want: -1.3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Round(tt.in); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Round(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTripStable(t *testing.T) {
+	for i := -500; i <= 500; i++ {
+		c := float64(i) / 10
+
+		if got, want := Round(FtoC(CtoF(c))), Round(c); got != want {
+			t.Errorf("Round(FtoC(CtoF(%v))) = %v, want %v", c, got, want)
+		}
+
+		f := Round(CtoF(c))
+		if got := Round(CtoF(FtoC(f))); got != f {
+			t.Errorf("Round(CtoF(FtoC(%v))) = %v, want %v", f, got, f)
+		}
+	}
+}
+
+func TestRoundToStep(t *testing.T) {
+	tests := []struct {
+		name string
+		in   float64
+		step float64
+		want float64
+	}{
+		{name: "half step rounds down", in: 21.2, step: 0.5, want: 21.0},
+		{name: "half step rounds up", in: 21.3, step: 0.5, want: 21.5},
+		{name: "half step exact", in: 21.5, step: 0.5, want: 21.5},
+		{name: "half step midpoint rounds up", in: 21.25, step: 0.5, want: 21.5},
+		{name: "whole degrees", in: 21.4, step: 1, want: 21},
+		{name: "whole degrees midpoint rounds up", in: 21.5, step: 1, want: 22},
+		{name: "tenths", in: 21.34, step: 0.1, want: 21.3},
+		{name: "no float noise", in: 20.95, step: 0.1, want: 21.0},
+		{name: "zero step rounds to tenths", in: 21.34, step: 0, want: 21.3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RoundToStep(tt.in, tt.step); got != tt.want {
+				t.Errorf("RoundToStep(%v, %v) = %v, want %v", tt.in, tt.step, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package timeseries
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/recovery"
+	"go.uber.org/zap"
+	"tailscale.com/util/eventbus"
+)
+
+// clientName is the eventbus client the exporter subscribes with.
+const clientName events.ClientName = "timeseries"
+
+// writeTimeout bounds a single sink write, so a slow database does not hold
+// up later samples indefinitely.
+const writeTimeout = 10 * time.Second
+
+// Exporter writes a sample to its sink for every state update.
+type Exporter struct {
+	logger *zap.Logger
+	bus    *events.Bus
+	client *eventbus.Client
+	sink   TimeSeriesSink
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started bool
+	done    chan struct{}
+}
+
+// New creates an exporter writing to sink.
+func New(logger *zap.Logger, bus *events.Bus, sink TimeSeriesSink) (*Exporter, error) {
+	client, err := bus.RegisterClient(clientName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register eventbus client: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Exporter{
+		logger: logger,
+		bus:    bus,
+		client: client,
+		sink:   sink,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Start subscribes to state updates and writes them in the background.
+func (e *Exporter) Start() error {
+	e.logger.Info("starting time-series exporter")
+
+	// Replay the last state so a restarted exporter does not leave a gap
+	// until the next change
+	sub := e.bus.SubscribeStateUpdates(e.client, true)
+	e.started = true
+
+	recovery.Go(e.logger, "time-series exporter", func() {
+		defer close(e.done)
+		defer sub.Close()
+
+		for {
+			select {
+			case event := <-sub.Events():
+				e.write(event)
+			case <-e.ctx.Done():
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// write writes the sample for a state update. Failed writes are logged and
+// dropped, the next state update is written as usual.
+func (e *Exporter) write(event events.StateUpdateEvent) {
+	ctx, cancel := context.WithTimeout(e.ctx, writeTimeout)
+	defer cancel()
+
+	if err := e.sink.Write(ctx, sampleFromState(event)); err != nil {
+		e.logger.Warn("failed to write time-series sample", zap.Error(err))
+	}
+}
+
+// sampleFromState returns the sample for a state update. Booleans are
+// written as 1 and 0. Values that are 0 until known, such as the outdoor
+// temperature, are only written once they are.
+func sampleFromState(event events.StateUpdateEvent) Sample {
+	fields := map[string]float64{
+		"current_temperature": event.CurrentTemperature,
+		"target_temperature":  event.TargetTemperature,
+		"heating_active":      boolValue(event.HeatingActive),
+		"hotwater_active":     boolValue(event.HotWaterActive),
+		"pressure":            event.Pressure,
+		"comfort_temperature": event.ComfortTemperature,
+	}
+	for name, value := range map[string]float64{
+		"hotwater_temperature": event.HotWaterTemperature,
+		"supply_setpoint":      event.SupplySetpoint,
+		"outdoor_temperature":  event.OutdoorTemperature,
+		"humidity":             event.Humidity,
+	} {
+		if value != 0 {
+			fields[name] = value
+		}
+	}
+
+	return Sample{
+		Timestamp: event.Timestamp,
+		Fields:    fields,
+	}
+}
+
+// Close stops the exporter, cancelling a running write.
+func (e *Exporter) Close() error {
+	e.logger.Info("shutting down time-series exporter")
+
+	e.cancel()
+	if e.started {
+		<-e.done
+	}
+	return nil
+}
+
+// boolValue returns 1 for true and 0 for false.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
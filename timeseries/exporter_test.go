@@ -0,0 +1,202 @@
+package timeseries
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/leaktest"
+	"go.uber.org/zap"
+)
+
+// fakeSink records the samples written to it.
+type fakeSink struct {
+	samples chan Sample
+	err     error
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{samples: make(chan Sample, 10)}
+}
+
+func (s *fakeSink) Write(_ context.Context, sample Sample) error {
+	s.samples <- sample
+	return s.err
+}
+
+// next returns the next sample written, failing the test after a second.
+func (s *fakeSink) next(t *testing.T) Sample {
+	t.Helper()
+
+	select {
+	case sample := <-s.samples:
+		return sample
+	case <-time.After(time.Second):
+		t.Fatal("no sample written")
+		return Sample{}
+	}
+}
+
+func newTestExporter(t *testing.T, sink TimeSeriesSink) (*events.Bus, *Exporter) {
+	t.Helper()
+
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	t.Cleanup(func() { _ = bus.Close() })
+
+	exporter, err := New(logger, bus, sink)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { _ = exporter.Close() })
+
+	if err := exporter.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	return bus, exporter
+}
+
+func TestExporterWritesStateUpdates(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	sink := newFakeSink()
+	bus, _ := newTestExporter(t, sink)
+
+	client, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	updates := []struct {
+		event events.StateUpdateEvent
+		want  map[string]float64
+	}{
+		{
+			event: events.StateUpdateEvent{
+				Timestamp:          at,
+				Source:             "nefit",
+				CurrentTemperature: 20.5,
+				TargetTemperature:  21,
+				HeatingActive:      true,
+				Mode:               events.ModeHeat,
+				Pressure:           1.6,
+			},
+			want: map[string]float64{
+				"current_temperature": 20.5,
+				"target_temperature":  21,
+				"heating_active":      1,
+				"hotwater_active":     0,
+				"pressure":            1.6,
+				"comfort_temperature": 0,
+			},
+		},
+		{
+			event: events.StateUpdateEvent{
+				Timestamp:          at.Add(time.Minute),
+				Source:             "nefit",
+				CurrentTemperature: 21,
+				TargetTemperature:  21,
+				HotWaterActive:     true,
+				Mode:               events.ModeHeat,
+				Pressure:           1.5,
+				OutdoorTemperature: -3.5,
+				Humidity:           45,
+			},
+			want: map[string]float64{
+				"current_temperature": 21,
+				"target_temperature":  21,
+				"heating_active":      0,
+				"hotwater_active":     1,
+				"pressure":            1.5,
+				"comfort_temperature": 0,
+				"outdoor_temperature": -3.5,
+				"humidity":            45,
+			},
+		},
+	}
+
+	for i, update := range updates {
+		bus.PublishStateUpdate(client, update.event)
+
+		sample := sink.next(t)
+		if !sample.Timestamp.Equal(update.event.Timestamp) {
+			t.Errorf("update %d: Timestamp = %v, want %v", i, sample.Timestamp, update.event.Timestamp)
+		}
+		if !maps.Equal(sample.Fields, update.want) {
+			t.Errorf("update %d: Fields = %v, want %v", i, sample.Fields, update.want)
+		}
+	}
+}
+
+func TestExporterContinuesAfterWriteError(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	sink := newFakeSink()
+	sink.err = errors.New("database unavailable")
+	bus, _ := newTestExporter(t, sink)
+
+	client, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	bus.PublishStateUpdate(client, events.StateUpdateEvent{Source: "nefit", CurrentTemperature: 20})
+	sink.next(t)
+
+	bus.PublishStateUpdate(client, events.StateUpdateEvent{Source: "nefit", CurrentTemperature: 21})
+	if got := sink.next(t).Fields["current_temperature"]; got != 21 {
+		t.Errorf("current_temperature = %v after a failed write, want 21", got)
+	}
+}
+
+func TestSampleFromStateWritesEveryNumericField(t *testing.T) {
+	// Sample field for each numeric StateUpdateEvent field. A new field
+	// fails the test until it is written by sampleFromState and added here.
+	sampleFields := map[string]string{
+		"CurrentTemperature":  "current_temperature",
+		"TargetTemperature":   "target_temperature",
+		"Pressure":            "pressure",
+		"HotWaterTemperature": "hotwater_temperature",
+		"ComfortTemperature":  "comfort_temperature",
+		"SupplySetpoint":      "supply_setpoint",
+		"OutdoorTemperature":  "outdoor_temperature",
+		"Humidity":            "humidity",
+	}
+
+	var event events.StateUpdateEvent
+	v := reflect.ValueOf(&event).Elem()
+	want := make(map[string]float64)
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if field.Type.Kind() != reflect.Float64 {
+			continue
+		}
+
+		name, ok := sampleFields[field.Name]
+		if !ok {
+			t.Errorf("StateUpdateEvent.%s has no sample field", field.Name)
+			continue
+		}
+
+		// Distinct non-zero values, so values that are 0 until known are written too
+		value := float64(i) + 0.5
+		v.Field(i).SetFloat(value)
+		want[name] = value
+	}
+
+	fields := sampleFromState(event).Fields
+	for name, value := range want {
+		if got, ok := fields[name]; !ok || got != value {
+			t.Errorf("Fields[%q] = %v (present %v), want %v", name, got, ok, value)
+		}
+	}
+}
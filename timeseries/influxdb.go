@@ -0,0 +1,73 @@
+package timeseries
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/config"
+)
+
+// maxInfluxDBErrorBody limits how much of an error response is kept.
+const maxInfluxDBErrorBody = 512
+
+// influxDBSink writes samples to the InfluxDB 2.x write API.
+type influxDBSink struct {
+	client   *http.Client
+	writeURL string
+	token    string
+}
+
+// newInfluxDBSink creates a sink writing to the configured InfluxDB bucket.
+func newInfluxDBSink(cfg *config.Config) (*influxDBSink, error) {
+	u, err := url.Parse(cfg.InfluxDBURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid NEFITHK_INFLUXDB_URL %q", cfg.InfluxDBURL)
+	}
+
+	u = u.JoinPath("api", "v2", "write")
+	query := url.Values{}
+	query.Set("bucket", cfg.InfluxDBBucket)
+	if cfg.InfluxDBOrg != "" {
+		query.Set("org", cfg.InfluxDBOrg)
+	}
+	query.Set("precision", "ns")
+	u.RawQuery = query.Encode()
+
+	return &influxDBSink{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		writeURL: u.String(),
+		token:    cfg.InfluxDBToken,
+	}, nil
+}
+
+// Write posts the sample to InfluxDB.
+func (s *influxDBSink) Write(ctx context.Context, sample Sample) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.writeURL, strings.NewReader(lineProtocol(sample)))
+	if err != nil {
+		return fmt.Errorf("failed to create influxdb request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to write to influxdb: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxInfluxDBErrorBody))
+		return fmt.Errorf("influxdb write failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
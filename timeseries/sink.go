@@ -0,0 +1,86 @@
+// Package timeseries writes the thermostat state to a time-series database on
+// every state update, through a TimeSeriesSink selected by
+// NEFITHK_TIMESERIES_SINK.
+package timeseries
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/config"
+)
+
+// measurement is the name samples are stored under.
+const measurement = "nefit"
+
+// Sample is the thermostat state at a point in time, as named values such as
+// "current_temperature".
+type Sample struct {
+	Timestamp time.Time
+	Fields    map[string]float64
+}
+
+// TimeSeriesSink stores samples. Implementations for other databases only
+// need Write, which the exporter calls from a single goroutine.
+type TimeSeriesSink interface {
+	Write(ctx context.Context, sample Sample) error
+}
+
+// NewSink returns the sink selected by cfg.TimeSeriesSink.
+func NewSink(cfg *config.Config) (TimeSeriesSink, error) {
+	switch cfg.TimeSeriesSink {
+	case "", config.TimeSeriesSinkNone:
+		return noopSink{}, nil
+	case config.TimeSeriesSinkStdout:
+		return &writerSink{w: os.Stdout}, nil
+	case config.TimeSeriesSinkInfluxDB:
+		return newInfluxDBSink(cfg)
+	}
+	return nil, fmt.Errorf("unknown time-series sink %q", cfg.TimeSeriesSink)
+}
+
+// noopSink drops samples, for when no time-series database is configured.
+type noopSink struct{}
+
+// Write drops the sample.
+func (noopSink) Write(context.Context, Sample) error {
+	return nil
+}
+
+// writerSink writes samples to w in the InfluxDB line protocol, one per line.
+type writerSink struct {
+	w io.Writer
+}
+
+// Write writes the sample as a line.
+func (s *writerSink) Write(_ context.Context, sample Sample) error {
+	_, err := io.WriteString(s.w, lineProtocol(sample)+"\n")
+	return err
+}
+
+// lineProtocol formats a sample in the InfluxDB line protocol, with the
+// fields sorted by name and a nanosecond timestamp.
+func lineProtocol(sample Sample) string {
+	var b strings.Builder
+	b.WriteString(measurement)
+	for i, name := range slices.Sorted(maps.Keys(sample.Fields)) {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.FormatFloat(sample.Fields[name], 'f', -1, 64))
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(sample.Timestamp.UnixNano(), 10))
+	return b.String()
+}
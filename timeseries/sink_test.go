@@ -0,0 +1,138 @@
+package timeseries
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/config"
+)
+
+var testSample = Sample{
+	Timestamp: time.Unix(1735732800, 500),
+	Fields: map[string]float64{
+		"target_temperature":  21,
+		"current_temperature": 20.5,
+		"heating_active":      1,
+	},
+}
+
+const testSampleLine = "nefit current_temperature=20.5,heating_active=1,target_temperature=21 1735732800000000500"
+
+func TestNewSink(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.Config
+		wantType string
+		wantErr  bool
+	}{
+		{name: "unset", cfg: config.Config{}, wantType: "timeseries.noopSink"},
+		{name: "none", cfg: config.Config{TimeSeriesSink: config.TimeSeriesSinkNone}, wantType: "timeseries.noopSink"},
+		{name: "stdout", cfg: config.Config{TimeSeriesSink: config.TimeSeriesSinkStdout}, wantType: "*timeseries.writerSink"},
+		{
+			name: "influxdb",
+			cfg: config.Config{
+				TimeSeriesSink: config.TimeSeriesSinkInfluxDB,
+				InfluxDBURL:    "http://influxdb:8086",
+				InfluxDBBucket: "nefit",
+			},
+			wantType: "*timeseries.influxDBSink",
+		},
+		{
+			name: "influxdb without scheme",
+			cfg: config.Config{
+				TimeSeriesSink: config.TimeSeriesSinkInfluxDB,
+				InfluxDBURL:    "influxdb:8086",
+				InfluxDBBucket: "nefit",
+			},
+			wantErr: true,
+		},
+		{name: "unknown", cfg: config.Config{TimeSeriesSink: "graphite"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink, err := NewSink(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewSink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := fmt.Sprintf("%T", sink); !tt.wantErr && got != tt.wantType {
+				t.Errorf("NewSink() = %s, want %s", got, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := &writerSink{w: &buf}
+
+	if err := sink.Write(context.Background(), testSample); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if got, want := buf.String(), testSampleLine+"\n"; got != want {
+		t.Errorf("Write() wrote %q, want %q", got, want)
+	}
+}
+
+// influxDBRequest is a write request received by the fake InfluxDB server.
+type influxDBRequest struct {
+	path, query, auth, body string
+}
+
+func TestInfluxDBSink(t *testing.T) {
+	requests := make(chan influxDBRequest, 2)
+	var status atomic.Int32
+	status.Store(http.StatusNoContent)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- influxDBRequest{
+			path:  r.URL.Path,
+			query: r.URL.RawQuery,
+			auth:  r.Header.Get("Authorization"),
+			body:  string(body),
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	sink, err := newInfluxDBSink(&config.Config{
+		InfluxDBURL:    srv.URL,
+		InfluxDBToken:  "secret-token",
+		InfluxDBOrg:    "home",
+		InfluxDBBucket: "nefit",
+	})
+	if err != nil {
+		t.Fatalf("newInfluxDBSink() error = %v", err)
+	}
+
+	if err := sink.Write(context.Background(), testSample); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	got := <-requests
+	if got.path != "/api/v2/write" {
+		t.Errorf("path = %q, want /api/v2/write", got.path)
+	}
+	if want := "bucket=nefit&org=home&precision=ns"; got.query != want {
+		t.Errorf("query = %q, want %q", got.query, want)
+	}
+	if got.auth != "Token secret-token" {
+		t.Errorf("Authorization = %q, want %q", got.auth, "Token secret-token")
+	}
+	if got.body != testSampleLine {
+		t.Errorf("body = %q, want %q", got.body, testSampleLine)
+	}
+
+	status.Store(http.StatusUnauthorized)
+	if err := sink.Write(context.Background(), testSample); err == nil {
+		t.Error("Write() error = nil for a 401 response, want an error")
+	}
+}
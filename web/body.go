@@ -0,0 +1,39 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+)
+
+// limitBody wraps a handler so reading more than NEFITHK_WEB_MAX_BODY_BYTES
+// of the request body fails, see parseForm. A limit of 0 disables it.
+func (s *Server) limitBody(handler http.HandlerFunc) http.HandlerFunc {
+	limit := s.cfg.WebMaxBodyBytes
+	if limit <= 0 {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(limit))
+		handler(w, r)
+	}
+}
+
+// parseForm parses the request form, answering 413 for a body over the limit
+// set by limitBody and 400 for other errors. It reports whether the form was
+// parsed.
+func parseForm(w http.ResponseWriter, r *http.Request) bool {
+	err := r.ParseForm()
+	if err == nil {
+		return true
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+
+	http.Error(w, "Invalid form data", http.StatusBadRequest)
+	return false
+}
@@ -0,0 +1,72 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+func TestRequestBodyLimit(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:                       0,
+		WebMaxBodyBytes:               256,
+		AdvancedSupplySetpointEnabled: true,
+		PresenceComfortTemperature:    21,
+		PresenceSetbackTemperature:    17,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	tests := []struct {
+		path string
+		form url.Values
+	}{
+		{path: "/api/temperature", form: url.Values{"temperature": {"22"}}},
+		{path: "/api/mode", form: url.Values{"mode": {"off"}}},
+		{path: "/api/presence", form: url.Values{"presence": {"away"}}},
+		{path: "/api/supply-setpoint", form: url.Values{"supply_setpoint": {"55"}}},
+	}
+
+	post := func(path, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := post(tt.path, tt.form.Encode()); got != http.StatusOK {
+				t.Errorf("status for a normal body = %d, want %d", got, http.StatusOK)
+			}
+
+			oversized := tt.form.Encode() + "&padding=" + strings.Repeat("x", 1024)
+			if got := post(tt.path, oversized); got != http.StatusRequestEntityTooLarge {
+				t.Errorf("status for an oversized body = %d, want %d", got, http.StatusRequestEntityTooLarge)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package web
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+// lastCommand is the last command published for a command type.
+type lastCommand struct {
+	event events.CommandEvent
+	at    time.Time
+}
+
+// commandDedup drops a command identical to the last one of its type within
+// a short window, such as the second request of a double-fired HTMX button.
+type commandDedup struct {
+	window time.Duration // 0 disables
+
+	mu   sync.Mutex
+	last map[events.CommandType]lastCommand
+}
+
+// newCommandDedup creates a dedup dropping repeats within window.
+func newCommandDedup(window time.Duration) *commandDedup {
+	return &commandDedup{
+		window: window,
+		last:   make(map[events.CommandType]lastCommand),
+	}
+}
+
+// allow reports whether event should be published at now, recording it if so.
+// A repeat does not extend the window, so a button held down still publishes
+// once per window.
+func (d *commandDedup) allow(event events.CommandEvent, now time.Time) bool {
+	if d.window <= 0 {
+		return true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if last, ok := d.last[event.CommandType]; ok && sameCommand(last.event, event) && now.Sub(last.at) < d.window {
+		return false
+	}
+
+	d.last[event.CommandType] = lastCommand{event: event, at: now}
+	return true
+}
+
+// sameCommand reports whether a and b come from the same source and carry the
+// same values, ignoring their timestamps.
+func sameCommand(a, b events.CommandEvent) bool {
+	return a.Source == b.Source &&
+		a.CommandType == b.CommandType &&
+		equalPtr(a.TargetTemperature, b.TargetTemperature) &&
+		equalPtr(a.Mode, b.Mode) &&
+		equalPtr(a.HotWaterEnabled, b.HotWaterEnabled) &&
+		equalPtr(a.SupplySetpoint, b.SupplySetpoint)
+}
+
+// equalPtr reports whether a and b are both nil or point to equal values.
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// publishCommand publishes event unless it repeats the previous command of
+// its type within NEFITHK_WEB_COMMAND_MIN_INTERVAL. It reports whether the
+// command was published.
+func (s *Server) publishCommand(event events.CommandEvent) bool {
+	if !s.commands.allow(event, time.Now()) {
+		s.logger.Debug("dropped repeated web command",
+			zap.String("type", string(event.CommandType)),
+			zap.String("source", event.Source),
+		)
+		return false
+	}
+
+	s.bus.PublishCommand(s.client, event)
+	return true
+}
+
+// commandResultMessage is the payload of the "command_result" SSE event.
+type commandResultMessage struct {
+	CommandType events.CommandType `json:"command_type"`
+	Success     bool               `json:"success"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// handleCommandResults broadcasts the results of executed commands to SSE
+// clients, so a change the thermostat rejected does not go unnoticed.
+func (s *Server) handleCommandResults() {
+	s.logger.Info("subscribed to command result events")
+
+	for {
+		select {
+		case event := <-s.resultSub.Events():
+			s.broadcastCommandResult(event)
+		case <-s.ctx.Done():
+			s.logger.Info("stopping command result handler")
+			return
+		}
+	}
+}
+
+// broadcastCommandResult sends a command result to all SSE clients.
+func (s *Server) broadcastCommandResult(event events.CommandResultEvent) {
+	s.mu.Lock()
+	s.broadcast(sseMessage{
+		event: "command_result",
+		data: commandResultMessage{
+			CommandType: event.CommandType,
+			Success:     event.Success,
+			Error:       event.Error,
+		},
+	})
+	s.mu.Unlock()
+
+	if !event.Success {
+		s.logger.Debug("command failed",
+			zap.String("type", string(event.CommandType)),
+			zap.String("error", event.Error),
+		)
+	}
+}
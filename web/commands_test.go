@@ -0,0 +1,179 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+	"tailscale.com/util/eventbus"
+)
+
+func TestCommandDedup(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	heat, off := events.ModeHeat, events.ModeOff
+	temp := 21.0
+
+	setMode := func(mode *events.Mode) events.CommandEvent {
+		return events.CommandEvent{Source: "web", CommandType: events.CommandTypeSetMode, Mode: mode}
+	}
+
+	d := newCommandDedup(time.Second)
+
+	steps := []struct {
+		name  string
+		event events.CommandEvent
+		at    time.Duration
+		want  bool
+	}{
+		{name: "first", event: setMode(&heat), at: 0, want: true},
+		{name: "repeat", event: setMode(&heat), at: 100 * time.Millisecond, want: false},
+		{name: "other type", event: events.CommandEvent{Source: "web", CommandType: events.CommandTypeSetTemperature, TargetTemperature: &temp}, at: 200 * time.Millisecond, want: true},
+		{name: "repeat after other type", event: setMode(&heat), at: 300 * time.Millisecond, want: false},
+		{name: "repeat after window", event: setMode(&heat), at: time.Second, want: true},
+		{name: "different mode", event: setMode(&off), at: 1100 * time.Millisecond, want: true},
+		{name: "back to first mode", event: setMode(&heat), at: 1200 * time.Millisecond, want: true},
+	}
+
+	for _, step := range steps {
+		if got := d.allow(step.event, start.Add(step.at)); got != step.want {
+			t.Errorf("%s: allow() = %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	disabled := newCommandDedup(0)
+	for i := 0; i < 2; i++ {
+		if !disabled.allow(setMode(&heat), start) {
+			t.Errorf("allow() = false with the dedup disabled, call %d", i)
+		}
+	}
+}
+
+func TestRepeatedModeCommandPublishedOnce(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:               0,
+		WebCommandMinInterval: time.Minute,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.CommandEvent](subscriberClient)
+	defer sub.Close()
+
+	// A double-fired button sends the same mode twice, both requests succeed
+	for i := 0; i < 2; i++ {
+		form := url.Values{"mode": {"heat"}}
+		req := httptest.NewRequest(http.MethodPost, "/api/mode", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+
+		server.handleSetMode(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+
+	select {
+	case event := <-sub.Events():
+		if event.Mode == nil || *event.Mode != events.ModeHeat {
+			t.Errorf("event.Mode = %v, want heat", event.Mode)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for command event")
+	}
+
+	select {
+	case event := <-sub.Events():
+		t.Errorf("repeated command published: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCommandResultBroadcast(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	server, err := New(&config.Config{WebPort: 0}, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	nefitClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		server.handleSSE(w, req)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	bus.PublishCommandResult(nefitClient, events.CommandResultEvent{
+		Source:        "nefit",
+		CommandSource: "web",
+		CommandType:   events.CommandTypeSetTemperature,
+		Error:         "failed to set temperature: timeout",
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("SSE handler did not finish in time")
+	}
+
+	want := "event: command_result\ndata: {\"command_type\":\"set_temperature\",\"success\":false,\"error\":\"failed to set temperature: timeout\"}"
+	if stream := w.Body.String(); !strings.Contains(stream, want) {
+		t.Errorf("SSE stream missing failed command result:\n%s", stream)
+	}
+}
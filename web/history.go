@@ -0,0 +1,127 @@
+package web
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// historySample is one point of the temperature history, in Celsius.
+type historySample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Current   float64   `json:"current"`
+	Target    float64   `json:"target"`
+}
+
+// history is a fixed-size ring of temperature samples. State updates are
+// downsampled to one sample per interval, keeping the latest reading of each.
+type history struct {
+	interval time.Duration
+	samples  []historySample
+	start    int // Index of the oldest sample
+	n        int
+}
+
+// newHistory creates a history holding up to size samples, or nil when size is
+// not positive, which disables it. An interval of 0 keeps every update.
+func newHistory(size int, interval time.Duration) *history {
+	if size <= 0 {
+		return nil
+	}
+
+	return &history{
+		interval: interval,
+		samples:  make([]historySample, size),
+	}
+}
+
+// add records a reading, replacing the newest sample if it falls in the same
+// interval and evicting the oldest sample once the ring is full.
+func (h *history) add(at time.Time, current, target float64) {
+	sample := historySample{Timestamp: at, Current: current, Target: target}
+
+	if h.n > 0 && h.interval > 0 {
+		newest := (h.start + h.n - 1) % len(h.samples)
+		if at.Truncate(h.interval).Equal(h.samples[newest].Timestamp.Truncate(h.interval)) {
+			h.samples[newest] = sample
+			return
+		}
+	}
+
+	if h.n < len(h.samples) {
+		h.samples[(h.start+h.n)%len(h.samples)] = sample
+		h.n++
+		return
+	}
+
+	h.samples[h.start] = sample
+	h.start = (h.start + 1) % len(h.samples)
+}
+
+// snapshot returns a copy of the samples, oldest first.
+func (h *history) snapshot() []historySample {
+	if h == nil {
+		return []historySample{}
+	}
+
+	out := make([]historySample, h.n)
+	for i := range out {
+		out[i] = h.samples[(h.start+i)%len(h.samples)]
+	}
+
+	return out
+}
+
+// Sparkline dimensions in SVG user units. The SVG scales to its container.
+const (
+	sparklineWidth  = 300
+	sparklineHeight = 60
+)
+
+// renderSparkline returns an inline SVG plotting current and target
+// temperature over time, or an empty string with fewer than two samples.
+// Only numbers are interpolated into the markup.
+func renderSparkline(samples []historySample) string {
+	if len(samples) < 2 {
+		return ""
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, sample := range samples {
+		lo = math.Min(lo, math.Min(sample.Current, sample.Target))
+		hi = math.Max(hi, math.Max(sample.Current, sample.Target))
+	}
+	// Pad the range so flat lines sit inside the chart
+	lo -= 0.5
+	hi += 0.5
+
+	first := samples[0].Timestamp
+	span := samples[len(samples)-1].Timestamp.Sub(first)
+
+	points := func(value func(historySample) float64) string {
+		var b strings.Builder
+		for i, sample := range samples {
+			x := float64(i) / float64(len(samples)-1)
+			if span > 0 {
+				x = float64(sample.Timestamp.Sub(first)) / float64(span)
+			}
+			y := (hi - value(sample)) / (hi - lo)
+
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprintf(&b, "%.1f,%.1f", x*sparklineWidth, y*sparklineHeight)
+		}
+		return b.String()
+	}
+
+	return fmt.Sprintf(`<svg class="sparkline" viewBox="0 0 %d %d" preserveAspectRatio="none" role="img" aria-label="Temperature history">`+
+		`<polyline class="spark-target" points="%s"/>`+
+		`<polyline class="spark-current" points="%s"/>`+
+		`</svg>`,
+		sparklineWidth, sparklineHeight,
+		points(func(s historySample) float64 { return s.Target }),
+		points(func(s historySample) float64 { return s.Current }),
+	)
+}
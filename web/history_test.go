@@ -0,0 +1,109 @@
+package web
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryRing(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		size        int
+		interval    time.Duration
+		offsets     []time.Duration // Reading times relative to start, current = index
+		wantCurrent []float64
+	}{
+		{
+			name:        "partially filled",
+			size:        3,
+			interval:    time.Minute,
+			offsets:     []time.Duration{0, time.Minute},
+			wantCurrent: []float64{0, 1},
+		},
+		{
+			name:        "evicts oldest when full",
+			size:        3,
+			interval:    time.Minute,
+			offsets:     []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute, 4 * time.Minute},
+			wantCurrent: []float64{2, 3, 4},
+		},
+		{
+			name:        "keeps latest reading per interval",
+			size:        3,
+			interval:    time.Minute,
+			offsets:     []time.Duration{0, 10 * time.Second, 50 * time.Second, 70 * time.Second},
+			wantCurrent: []float64{2, 3},
+		},
+		{
+			name:        "zero interval keeps every reading",
+			size:        3,
+			interval:    0,
+			offsets:     []time.Duration{0, time.Second, 2 * time.Second},
+			wantCurrent: []float64{0, 1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHistory(tt.size, tt.interval)
+			for i, offset := range tt.offsets {
+				h.add(start.Add(offset), float64(i), 20)
+			}
+
+			samples := h.snapshot()
+			if len(samples) != len(tt.wantCurrent) {
+				t.Fatalf("got %d samples, want %d", len(samples), len(tt.wantCurrent))
+			}
+			for i, want := range tt.wantCurrent {
+				if samples[i].Current != want {
+					t.Errorf("sample %d current = %v, want %v", i, samples[i].Current, want)
+				}
+			}
+			for i := 1; i < len(samples); i++ {
+				if !samples[i].Timestamp.After(samples[i-1].Timestamp) {
+					t.Errorf("samples not in time order at %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryDisabled(t *testing.T) {
+	h := newHistory(0, time.Minute)
+	if h != nil {
+		t.Fatal("newHistory(0) should return nil")
+	}
+
+	if samples := h.snapshot(); len(samples) != 0 {
+		t.Errorf("snapshot of disabled history = %v, want empty", samples)
+	}
+}
+
+func TestRenderSparkline(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if got := renderSparkline([]historySample{{Timestamp: start, Current: 20, Target: 21}}); got != "" {
+		t.Errorf("renderSparkline() with one sample = %q, want empty", got)
+	}
+
+	samples := []historySample{
+		{Timestamp: start, Current: 19, Target: 21},
+		{Timestamp: start.Add(time.Minute), Current: 20, Target: 21},
+		{Timestamp: start.Add(3 * time.Minute), Current: 21, Target: 21},
+	}
+	svg := renderSparkline(samples)
+
+	for _, want := range []string{`<svg class="sparkline"`, `class="spark-current"`, `class="spark-target"`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("renderSparkline() missing %q", want)
+		}
+	}
+
+	// Points are spaced by time and span the full width, highest at the top
+	if !strings.Contains(svg, `points="0.0,50.0 100.0,30.0 300.0,10.0"`) {
+		t.Errorf("renderSparkline() current points not as expected: %s", svg)
+	}
+}
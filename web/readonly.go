@@ -0,0 +1,33 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/chasefleming/elem-go/attrs"
+)
+
+// control wraps a handler that changes the thermostat. In read-only mode,
+// for a kiosk showing the UI on a shared screen, it is rejected with 403.
+// Otherwise the request body is limited to NEFITHK_WEB_MAX_BODY_BYTES.
+func (s *Server) control(handler http.HandlerFunc) http.HandlerFunc {
+	if !s.cfg.WebReadOnly {
+		return s.limitBody(handler)
+	}
+
+	return func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "Web interface is read-only", http.StatusForbidden)
+	}
+}
+
+// controlForm returns the attributes of a form posting to path. In read-only
+// mode the form does not post, so the page has no way to change anything.
+func (s *Server) controlForm(path, target string) attrs.Props {
+	if s.cfg.WebReadOnly {
+		return attrs.Props{}
+	}
+
+	return attrs.Props{
+		"hx-post":   s.path(path),
+		"hx-target": target,
+	}
+}
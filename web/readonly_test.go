@@ -0,0 +1,121 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+	"tailscale.com/util/eventbus"
+)
+
+func TestReadOnlyRejectsCommands(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:                       0,
+		WebReadOnly:                   true,
+		AdvancedSupplySetpointEnabled: true,
+		PresenceComfortTemperature:    21,
+		PresenceSetbackTemperature:    17,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.CommandEvent](subscriberClient)
+	defer sub.Close()
+
+	tests := []struct {
+		path string
+		form url.Values
+	}{
+		{path: "/api/temperature", form: url.Values{"temperature": {"22"}}},
+		{path: "/api/mode", form: url.Values{"mode": {"off"}}},
+		{path: "/api/presence", form: url.Values{"presence": {"away"}}},
+		{path: "/api/supply-setpoint", form: url.Values{"supply_setpoint": {"55"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			server.server.Handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+
+	select {
+	case event := <-sub.Events():
+		t.Errorf("unexpected command published: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The state is still readable
+	req := httptest.NewRequest(http.MethodGet, "/api/state", nil)
+	w := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(w, req)
+	if w.Code == http.StatusForbidden {
+		t.Errorf("GET /api/state status = %d in read-only mode", w.Code)
+	}
+}
+
+func TestReadOnlyIndex(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	state := &events.StateUpdateEvent{CurrentTemperature: 20.5, TargetTemperature: 21, Mode: events.ModeHeat}
+
+	for _, readOnly := range []bool{false, true} {
+		server, err := New(&config.Config{WebReadOnly: readOnly, AdvancedSupplySetpointEnabled: true}, logger, bus)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		html := server.renderThermostatUI(state, "", nil)
+		_ = server.Close()
+
+		if got := strings.Contains(html, "hx-post"); got == readOnly {
+			t.Errorf("readOnly=%v: controls post = %v", readOnly, got)
+		}
+		if got := strings.Contains(html, `<input disabled`); got != readOnly {
+			t.Errorf("readOnly=%v: controls disabled = %v", readOnly, got)
+		}
+		if !strings.Contains(html, `id="current-temp"`) {
+			t.Errorf("readOnly=%v: current temperature not shown", readOnly)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package web
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+// defaultReconnectMinInterval is used when NEFITHK_WEB_RECONNECT_MIN_INTERVAL
+// is unset or 0.
+const defaultReconnectMinInterval = time.Minute
+
+// reconnectLimiter accepts one reconnect request per interval.
+type reconnectLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last time.Time // When the last request was accepted, zero if none was
+}
+
+// newReconnectLimiter creates a limiter accepting one request per interval,
+// or per defaultReconnectMinInterval when interval is 0.
+func newReconnectLimiter(interval time.Duration) *reconnectLimiter {
+	if interval <= 0 {
+		interval = defaultReconnectMinInterval
+	}
+	return &reconnectLimiter{interval: interval}
+}
+
+// allow reports whether a request at now is accepted, recording it if so,
+// and otherwise how long until the next one is.
+func (l *reconnectLimiter) allow(now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() {
+		if wait := l.interval - now.Sub(l.last); wait > 0 {
+			return false, wait
+		}
+	}
+
+	l.last = now
+	return true, 0
+}
+
+// handleReconnect asks the Nefit client to drop its backend connection and
+// connect again, for a connection that is up but no longer delivers updates,
+// or to try again after a failed connection. Requests within NEFITHK_WEB_RECONNECT_MIN_INTERVAL of the last accepted one
+// answer 429.
+func (s *Server) handleReconnect(w http.ResponseWriter, r *http.Request) {
+	if ok, wait := s.reconnects.allow(time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "Reconnect was requested recently, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	s.logger.Info("reconnect requested from web interface")
+
+	s.bus.PublishReconnect(s.client, events.ReconnectEvent{
+		Source: "web",
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte("Reconnect requested"))
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+	"tailscale.com/util/eventbus"
+)
+
+func TestHandleReconnect(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:                 0,
+		WebDisplayUnit:          "celsius",
+		WebReconnectMinInterval: time.Minute,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	nefitClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	sub := eventbus.Subscribe[events.ReconnectEvent](nefitClient)
+	defer sub.Close()
+
+	reconnect := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/reconnect", nil)
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := reconnect(); w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+	select {
+	case event := <-sub.Events():
+		if event.Source != "web" {
+			t.Errorf("Source = %q, want web", event.Source)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for reconnect event")
+	}
+
+	// A second request within the interval is refused and not published
+	w := reconnect()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	select {
+	case event := <-sub.Events():
+		t.Errorf("unexpected reconnect event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReconnectLimiter(t *testing.T) {
+	limiter := newReconnectLimiter(0)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if ok, _ := limiter.allow(now); !ok {
+		t.Fatal("first request refused")
+	}
+	if ok, wait := limiter.allow(now.Add(20 * time.Second)); ok || wait != 40*time.Second {
+		t.Errorf("allow() = %v, %s, want false, 40s", ok, wait)
+	}
+	if ok, _ := limiter.allow(now.Add(defaultReconnectMinInterval)); !ok {
+		t.Error("request after the interval refused")
+	}
+}
@@ -3,10 +3,19 @@ package web
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"math"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,16 +23,58 @@ import (
 	"github.com/chasefleming/elem-go/attrs"
 	"github.com/kradalby/nefit-homekit/config"
 	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/netutil"
+	"github.com/kradalby/nefit-homekit/recovery"
+	"github.com/kradalby/nefit-homekit/temperature"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"tailscale.com/util/eventbus"
 )
 
 const (
-	modeOff  = "off"
-	modeHeat = "heat"
+	// defaultTitle is the page title when NEFITHK_WEB_TITLE is blank.
+	defaultTitle = "Nefit Easy Thermostat"
+
+	// disconnectedNotice is shown while the Nefit backend is unreachable.
+	disconnectedNotice = "Thermostat is not connected, controls are read-only until it reconnects"
+
+	// setupHelpNotice is shown when Nefit never connected within the startup
+	// grace period, which usually means the credentials are wrong.
+	setupHelpNotice = "Could not connect to the thermostat since startup, check NEFITHK_NEFIT_SERIAL, NEFITHK_NEFIT_ACCESS_KEY and NEFITHK_NEFIT_PASSWORD"
+
+	// shutdownNotice is sent to SSE clients when the web server shuts down.
+	shutdownNotice = "Server is shutting down, the page reconnects once it is back"
+
+	// sseKeepaliveInterval is how often a comment is sent on idle SSE streams so
+	// proxies and browsers do not close them.
+	sseKeepaliveInterval = 15 * time.Second
+
+	// sseClientBuffer is how many messages are queued for an SSE client that
+	// has not caught up yet, before its oldest ones are dropped.
+	sseClientBuffer = 10
+
+	// minTemperature and maxTemperature bound the settable target in Celsius.
+	minTemperature = 10.0
+	maxTemperature = 30.0
+
+	// displayUnitFahrenheit is the NEFITHK_WEB_DISPLAY_UNIT value for Fahrenheit.
+	displayUnitFahrenheit = "fahrenheit"
 )
 
+// sseMessage is a message sent to SSE clients. An empty event name uses the
+// default message event.
+type sseMessage struct {
+	event string
+	data  any
+}
+
+// connectionMessage is the payload of the "connection" SSE event.
+type connectionMessage struct {
+	Connected bool                    `json:"connected"`
+	Status    events.ConnectionStatus `json:"status"`
+	Notice    string                  `json:"notice,omitempty"` // Shown while not connected
+}
+
 // Server manages the web interface.
 type Server struct {
 	cfg    *config.Config
@@ -35,10 +86,24 @@ type Server struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	// Subscribed in New so connection changes and gas usage published before
+	// Start are not missed
+	connSub   *eventbus.Subscriber[events.ConnectionStatusEvent]
+	energySub *eventbus.Subscriber[events.EnergyEvent]
+	resultSub *eventbus.Subscriber[events.CommandResultEvent]
+
 	// Current state for SSE clients
 	mu           sync.RWMutex
 	currentState *events.StateUpdateEvent
-	sseClients   map[chan events.StateUpdateEvent]struct{}
+	energy       *events.EnergyEvent                     // Latest gas usage, nil until read
+	statuses     map[string]events.ConnectionStatusEvent // Latest status per component
+	history      *history                                // Nil when disabled
+	sseClients   map[chan sseMessage]struct{}
+
+	commands   *commandDedup     // Drops repeated commands, such as double-fired buttons
+	reconnects *reconnectLimiter // Limits POST /admin/reconnect
+
+	tailscale tailscaleNode // Serves the web interface in the tailnet, nil when disabled
 }
 
 // New creates a new web server.
@@ -72,16 +137,29 @@ func New(cfg *config.Config, logger *zap.Logger, bus *events.Bus) (*Server, erro
 		mux:        mux,
 		ctx:        ctx,
 		cancel:     cancel,
-		sseClients: make(map[chan events.StateUpdateEvent]struct{}),
+		connSub:    eventbus.Subscribe[events.ConnectionStatusEvent](client),
+		energySub:  eventbus.Subscribe[events.EnergyEvent](client),
+		resultSub:  eventbus.Subscribe[events.CommandResultEvent](client),
+		statuses:   make(map[string]events.ConnectionStatusEvent),
+		history:    newHistory(cfg.WebHistorySize, cfg.WebHistoryInterval),
+		sseClients: make(map[chan sseMessage]struct{}),
+		commands:   newCommandDedup(cfg.WebCommandMinInterval),
+		reconnects: newReconnectLimiter(cfg.WebReconnectMinInterval),
 	}
 
-	// Create HTTP server
+	// Create HTTP server. WriteTimeout bounds regular requests; the SSE handler
+	// and the pprof profile and trace clear their write deadline so they are
+	// not cut off.
 	s.server = &http.Server{
 		Addr:              fmt.Sprintf(":%d", cfg.WebPort),
 		Handler:           mux,
 		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       120 * time.Second,
 	}
 
+	s.tailscale = s.newTailscaleServer()
+
 	// Setup routes
 	s.setupRoutes()
 
@@ -94,39 +172,108 @@ func New(cfg *config.Config, logger *zap.Logger, bus *events.Bus) (*Server, erro
 
 // setupRoutes configures all HTTP routes.
 func (s *Server) setupRoutes() {
-	// Main thermostat UI
-	s.mux.HandleFunc("/", s.handleIndex)
+	// Patterns are method-qualified, so the mux answers other methods with
+	// 405 and an Allow header. GET patterns also match HEAD. All routes live
+	// under the base path.
+
+	// Main thermostat UI, only at the root so "GET /" does not swallow GETs
+	// to POST-only endpoints
+	s.mux.HandleFunc("GET "+s.path("/{$}"), s.handleIndex)
 
 	// SSE for real-time updates
-	s.mux.HandleFunc("/events", s.handleSSE)
+	s.mux.HandleFunc("GET "+s.path("/events"), s.handleSSE)
+
+	// HTMX API endpoints, the POSTs answer 403 in read-only mode
+	s.mux.HandleFunc("POST "+s.path("/api/temperature"), s.control(s.handleSetTemperature))
+	s.mux.HandleFunc("POST "+s.path("/api/mode"), s.control(s.handleSetMode))
+	s.mux.HandleFunc("POST "+s.path("/api/presence"), s.control(s.handlePresence))
+	if s.cfg.AdvancedSupplySetpointEnabled {
+		s.mux.HandleFunc("POST "+s.path("/api/supply-setpoint"), s.control(s.handleSetSupplySetpoint))
+	}
+	s.mux.HandleFunc("GET "+s.path("/api/state"), s.handleState)
+	s.mux.HandleFunc("GET "+s.path("/api/history"), s.handleHistory)
+	s.mux.HandleFunc("GET "+s.path("/api/energy"), s.handleEnergy)
+	s.mux.HandleFunc("GET "+s.path("/api/connection"), s.handleConnection)
+	if s.cfg.WebConfigEndpointEnabled {
+		s.mux.HandleFunc("GET "+s.path("/api/config"), s.handleConfig)
+	}
 
-	// HTMX API endpoints
-	s.mux.HandleFunc("/api/temperature", s.handleSetTemperature)
-	s.mux.HandleFunc("/api/mode", s.handleSetMode)
+	// Force a Nefit reconnect, rate limited to spare the backend
+	s.mux.HandleFunc("POST "+s.path("/admin/reconnect"), s.control(s.handleReconnect))
 
 	// EventBus debugger
-	s.mux.HandleFunc("/debug/eventbus", s.handleEventBusDebug)
+	s.mux.HandleFunc("GET "+s.path("/debug/eventbus"), s.handleEventBusDebug)
+	s.mux.HandleFunc("GET "+s.path("/debug/events.json"), s.handleEventsJSON)
+	s.mux.HandleFunc("GET "+s.path("/debug/events.csv"), s.handleEventsCSV)
+
+	// Profiling, only registered when enabled. CPU profiles and traces run
+	// for as long as asked, so they are exempt from the server WriteTimeout.
+	// The index looks up profiles by path, so it sees the path without the
+	// base path.
+	if s.cfg.PprofEnabled {
+		s.mux.Handle("GET "+s.path("/debug/pprof/"), http.StripPrefix(s.path(""), http.HandlerFunc(pprof.Index)))
+		s.mux.HandleFunc("GET "+s.path("/debug/pprof/cmdline"), pprof.Cmdline)
+		s.mux.HandleFunc("GET "+s.path("/debug/pprof/profile"), s.withoutWriteTimeout(pprof.Profile))
+		s.mux.HandleFunc("GET "+s.path("/debug/pprof/symbol"), pprof.Symbol)
+		s.mux.HandleFunc("POST "+s.path("/debug/pprof/symbol"), pprof.Symbol)
+		s.mux.HandleFunc("GET "+s.path("/debug/pprof/trace"), s.withoutWriteTimeout(pprof.Trace))
+	}
 
 	// Prometheus metrics
-	s.mux.Handle("/metrics", promhttp.Handler())
+	s.mux.Handle("GET "+s.path("/metrics"), promhttp.Handler())
 
 	// Health check
-	s.mux.HandleFunc("/health", s.handleHealth)
+	s.mux.HandleFunc("GET "+s.path("/health"), s.handleHealth)
 }
 
 // Start starts the web server and begins handling events.
 func (s *Server) Start() error {
 	s.logger.Info("starting web server")
 
+	// Bind before reporting connected so a busy port fails Start instead of
+	// only being logged from the serving goroutine
+	ln, err := netutil.Listen(s.ctx, "tcp", s.server.Addr)
+	if err != nil {
+		s.publishConnectionStatus(events.ConnectionStatusFailed, err.Error())
+		return fmt.Errorf("failed to listen on %s (check NEFITHK_WEB_PORT): %w", s.server.Addr, err)
+	}
+
+	// Also serve in the tailnet, with the same handler and timeouts
+	var tsLn net.Listener
+	if s.tailscale != nil {
+		tsLn, err = s.listenTailscale()
+		if err != nil {
+			_ = ln.Close()
+			s.publishConnectionStatus(events.ConnectionStatusFailed, err.Error())
+			return err
+		}
+	}
+
 	// Subscribe to state update events
-	go s.handleStateUpdates()
+	recovery.Go(s.logger, "web state updates", s.handleStateUpdates)
+
+	// Track the Nefit connection to switch the UI to read-only while disconnected
+	recovery.Go(s.logger, "web connection status", s.handleConnectionStatus)
+
+	// Keep the latest gas usage for the energy API
+	recovery.Go(s.logger, "web energy", s.handleEnergyUpdates)
+
+	// Tell SSE clients about commands the thermostat rejected
+	recovery.Go(s.logger, "web command results", s.handleCommandResults)
 
 	// Start HTTP server in background
-	go func() {
-		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	recovery.Go(s.logger, "web server", func() {
+		if err := s.server.Serve(ln); err != nil && err != http.ErrServerClosed {
 			s.logger.Error("web server error", zap.Error(err))
 		}
-	}()
+	})
+	if tsLn != nil {
+		recovery.Go(s.logger, "web tailscale server", func() {
+			if err := s.server.Serve(tsLn); err != nil && err != http.ErrServerClosed {
+				s.logger.Error("web tailscale server error", zap.Error(err))
+			}
+		})
+	}
 
 	// Publish connection status
 	s.publishConnectionStatus(events.ConnectionStatusConnected, "")
@@ -137,7 +284,9 @@ func (s *Server) Start() error {
 
 // handleStateUpdates subscribes to state update events and broadcasts to SSE clients.
 func (s *Server) handleStateUpdates() {
-	sub := eventbus.Subscribe[events.StateUpdateEvent](s.client)
+	// Replay the last state so a restarted server does not show defaults
+	// until the next poll
+	sub := s.bus.SubscribeStateUpdates(s.client, true)
 	defer sub.Close()
 
 	s.logger.Info("subscribed to state update events")
@@ -158,14 +307,15 @@ func (s *Server) updateState(event events.StateUpdateEvent) {
 	s.mu.Lock()
 	s.currentState = &event
 
-	// Broadcast to all SSE clients
-	for client := range s.sseClients {
-		select {
-		case client <- event:
-		default:
-			// Client is slow or disconnected, skip
+	if s.history != nil {
+		at := event.Timestamp
+		if at.IsZero() {
+			at = time.Now()
 		}
+		s.history.add(at, event.CurrentTemperature, event.DisplayTargetTemperature())
 	}
+
+	s.broadcast(sseMessage{data: event})
 	s.mu.Unlock()
 
 	s.logger.Debug("state updated",
@@ -175,107 +325,402 @@ func (s *Server) updateState(event events.StateUpdateEvent) {
 	)
 }
 
-// handleIndex serves the main thermostat UI.
-func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleConnectionStatus tracks the Nefit connection status and broadcasts it to SSE clients.
+func (s *Server) handleConnectionStatus() {
+	s.logger.Info("subscribed to connection status events")
+
+	for {
+		select {
+		case event := <-s.connSub.Events():
+			s.updateConnectionStatus(event)
+		case <-s.ctx.Done():
+			s.logger.Info("stopping connection status handler")
+			return
+		}
+	}
+}
+
+// handleEnergyUpdates keeps the latest gas usage read from the thermostat.
+func (s *Server) handleEnergyUpdates() {
+	s.logger.Info("subscribed to energy events")
+
+	for {
+		select {
+		case event := <-s.energySub.Events():
+			s.updateEnergy(event)
+		case <-s.ctx.Done():
+			s.logger.Info("stopping energy handler")
+			return
+		}
+	}
+}
+
+// updateEnergy records the latest gas usage.
+func (s *Server) updateEnergy(event events.EnergyEvent) {
+	s.mu.Lock()
+	s.energy = &event
+	s.mu.Unlock()
+
+	s.logger.Debug("energy updated",
+		zap.Int("days", len(event.Days)),
+		zap.Float64("total_kwh", event.Total()),
+	)
+}
+
+// updateConnectionStatus records the latest status of a component. Nefit
+// status changes are broadcast to all SSE clients.
+func (s *Server) updateConnectionStatus(event events.ConnectionStatusEvent) {
+	s.mu.Lock()
+	s.statuses[event.Component] = event
+	if event.Component == "nefit" {
+		s.broadcast(s.connectionMessageLocked())
+	}
+	s.mu.Unlock()
+
+	s.logger.Debug("connection status updated",
+		zap.String("component", event.Component),
+		zap.String("status", string(event.Status)),
+	)
+}
+
+// Handler returns the handler serving the web UI and API, which Start
+// serves on the configured address.
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
+// ComponentStatus returns the latest connection status published by a component.
+func (s *Server) ComponentStatus(component string) (events.ConnectionStatusEvent, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	event, ok := s.statuses[component]
+	return event, ok
+}
+
+// ComponentStatuses returns the latest connection status of every component seen so far.
+func (s *Server) ComponentStatuses() map[string]events.ConnectionStatusEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make(map[string]events.ConnectionStatusEvent, len(s.statuses))
+	for component, event := range s.statuses {
+		out[component] = event
+	}
+	return out
+}
+
+// nefitStatusLocked returns the latest Nefit connection status. s.mu must be held.
+func (s *Server) nefitStatusLocked() events.ConnectionStatus {
+	return s.statuses["nefit"].Status
+}
+
+// connectionNoticeLocked returns the notice to show for the Nefit connection, or
+// an empty string while connected. s.mu must be held.
+func (s *Server) connectionNoticeLocked() string {
+	nefit := s.statuses["nefit"]
+	switch {
+	case nefit.Status == events.ConnectionStatusConnected:
+		return ""
+	case nefit.NeverConnected:
+		return setupHelpNotice
+	default:
+		return disconnectedNotice
+	}
+}
+
+// connectionMessageLocked returns the current connection SSE message. s.mu must be held.
+func (s *Server) connectionMessageLocked() sseMessage {
+	status := s.nefitStatusLocked()
+	return sseMessage{
+		event: "connection",
+		data: connectionMessage{
+			Connected: status == events.ConnectionStatusConnected,
+			Status:    status,
+			Notice:    s.connectionNoticeLocked(),
+		},
+	}
+}
+
+// broadcast sends a message to all SSE clients. s.mu must be held.
+//
+// Messages are only queued while s.mu is held, and each client's queue is
+// drained in order by its own handleSSE, so every client sees messages in the
+// order they were broadcast. Broadcasting never blocks: when a slow client's
+// queue is full its oldest message is dropped, so the newest state always
+// gets through.
+func (s *Server) broadcast(msg sseMessage) {
+	for client := range s.sseClients {
+		enqueueSSE(client, msg)
+	}
+}
+
+// enqueueSSE queues msg for an SSE client, dropping the oldest queued message
+// if the queue is full. Only senders holding s.mu call it, so the freed slot
+// cannot be taken by another message; handleSSE may drain the queue at the
+// same time, in which case nothing needs to be dropped.
+func enqueueSSE(client chan sseMessage, msg sseMessage) {
+	select {
+	case client <- msg:
 		return
+	default:
+	}
+
+	select {
+	case <-client:
+	default:
+	}
+
+	select {
+	case client <- msg:
+	default:
 	}
+}
 
+// handleIndex serves the main thermostat UI.
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	state := s.currentState
+	notice := s.connectionNoticeLocked()
+	samples := s.history.snapshot()
 	s.mu.RUnlock()
 
-	html := s.renderThermostatUI(state)
+	html := s.renderThermostatUI(state, notice, samples)
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	_, _ = w.Write([]byte(html))
 }
 
 // handleSSE handles Server-Sent Events for real-time updates.
+//
+// The stream is flushed through http.ResponseController, which works for both
+// HTTP/1.1 and HTTP/2 and through wrapping ResponseWriters. Under HTTP/2 the
+// stream is multiplexed with HTMX requests on one connection, so it does not use
+// up a browser connection slot. The server write deadline is cleared for the
+// stream, and idle streams get a keepalive comment so intermediaries keep them open.
 func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	rc := http.NewResponseController(w)
+
+	// Long-lived stream, so the server WriteTimeout must not apply. Each write
+	// sets its own deadline instead, see writeSSE.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.logger.Warn("failed to clear SSE write deadline", zap.Error(err))
 	}
 
-	// Set SSE headers
+	// Set SSE headers. Connection is a hop-by-hop header that is only valid for
+	// HTTP/1.x; the HTTP/2 server drops it.
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering
 
 	// Create client channel
-	clientChan := make(chan events.StateUpdateEvent, 10)
+	clientChan := make(chan sseMessage, sseClientBuffer)
 
-	// Register client
+	// Register the client and queue the current state and connection status
+	// together, so no broadcast can slip in between and arrive out of order
 	s.mu.Lock()
 	s.sseClients[clientChan] = struct{}{}
-	s.mu.Unlock()
-
-	// Send current state immediately
-	s.mu.RLock()
 	if s.currentState != nil {
-		clientChan <- *s.currentState
+		enqueueSSE(clientChan, sseMessage{data: *s.currentState})
 	}
-	s.mu.RUnlock()
+	enqueueSSE(clientChan, s.connectionMessageLocked())
+	s.mu.Unlock()
 
-	// Cleanup on disconnect
+	// Cleanup on disconnect. Close may already have closed the channel and
+	// dropped the registration while shutting down.
 	defer func() {
 		s.mu.Lock()
-		delete(s.sseClients, clientChan)
+		if _, ok := s.sseClients[clientChan]; ok {
+			delete(s.sseClients, clientChan)
+			close(clientChan)
+		}
 		s.mu.Unlock()
-		close(clientChan)
 	}()
 
 	// Stream events
-	flusher, ok := w.(http.Flusher)
-	if !ok {
+	if err := rc.Flush(); err != nil {
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
 		return
 	}
 
+	keepalive := time.NewTicker(sseKeepaliveInterval)
+	defer keepalive.Stop()
+
+	// Resend the cached state on our own cadence. The bus drops state updates
+	// equal to the last one for all subscribers, so without this a client
+	// that missed an update, for example one dropped while it was slow, would
+	// not see that state again until it changes.
+	var refresh <-chan time.Time
+	if s.cfg.WebSSERefreshInterval > 0 {
+		ticker := time.NewTicker(s.cfg.WebSSERefreshInterval)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+
+	// Bound the stream lifetime; the browser's EventSource reconnects on its own
+	var lifetime <-chan time.Time
+	if s.cfg.WebSSEMaxLifetime > 0 {
+		timer := time.NewTimer(s.cfg.WebSSEMaxLifetime)
+		defer timer.Stop()
+		lifetime = timer.C
+	}
+
 	for {
 		select {
-		case event := <-clientChan:
-			data, err := json.Marshal(event)
-			if err != nil {
-				s.logger.Error("failed to marshal event", zap.Error(err))
-				continue
+		case msg, ok := <-clientChan:
+			if !ok {
+				return
+			}
+			if err := s.writeSSEMessage(w, rc, msg); err != nil {
+				s.logger.Debug("closing SSE stream after failed write", zap.Error(err))
+				return
+			}
+
+		case <-keepalive.C:
+			if err := s.writeSSE(w, rc, ": keepalive\n\n"); err != nil {
+				s.logger.Debug("closing SSE stream after failed write", zap.Error(err))
+				return
 			}
 
-			_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
-			flusher.Flush()
+		case <-refresh:
+			s.refreshSSE(clientChan)
+
+		case <-lifetime:
+			s.logger.Debug("closing SSE stream after max lifetime",
+				zap.Duration("max_lifetime", s.cfg.WebSSEMaxLifetime),
+			)
+			return
 
 		case <-r.Context().Done():
 			return
 		case <-s.ctx.Done():
+			// Close queued the final connection event before cancelling,
+			// deliver it rather than racing it against the cancellation
+			s.drainSSE(w, rc, clientChan)
 			return
 		}
 	}
 }
 
-// handleSetTemperature handles temperature change requests via HTMX.
-func (s *Server) handleSetTemperature(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// writeSSEMessage writes one queued message as an SSE frame.
+func (s *Server) writeSSEMessage(w http.ResponseWriter, rc *http.ResponseController, msg sseMessage) error {
+	data, err := json.Marshal(msg.data)
+	if err != nil {
+		s.logger.Error("failed to marshal event", zap.Error(err))
+		return nil
+	}
+
+	frame := fmt.Sprintf("data: %s\n\n", data)
+	if msg.event != "" {
+		frame = fmt.Sprintf("event: %s\n", msg.event) + frame
+	}
+	return s.writeSSE(w, rc, frame)
+}
+
+// drainSSE writes the messages still queued for an SSE client, without
+// waiting for more.
+func (s *Server) drainSSE(w http.ResponseWriter, rc *http.ResponseController, client chan sseMessage) {
+	for {
+		select {
+		case msg, ok := <-client:
+			if !ok {
+				return
+			}
+			if err := s.writeSSEMessage(w, rc, msg); err != nil {
+				s.logger.Debug("failed to write SSE message while shutting down", zap.Error(err))
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// refreshSSE queues the current state for an SSE client, unless Close has
+// already closed its channel.
+func (s *Server) refreshSSE(client chan sseMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sseClients[client]; ok && s.currentState != nil {
+		enqueueSSE(client, sseMessage{data: *s.currentState})
+	}
+}
+
+// writeSSE writes and flushes one SSE frame. The write deadline makes a client
+// that stopped reading, but whose TCP connection is still open, fail the write
+// instead of blocking the stream forever. The deadline is cleared again after
+// the write, as HTTP/2 resets a stream whose deadline passes even while idle.
+func (s *Server) writeSSE(w http.ResponseWriter, rc *http.ResponseController, frame string) error {
+	if s.cfg.WebSSEWriteTimeout > 0 {
+		if err := setWriteDeadline(rc, time.Now().Add(s.cfg.WebSSEWriteTimeout)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(w, frame); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+	if err := rc.Flush(); err != nil {
+		return fmt.Errorf("failed to flush: %w", err)
+	}
+
+	if s.cfg.WebSSEWriteTimeout > 0 {
+		return setWriteDeadline(rc, time.Time{})
+	}
+	return nil
+}
+
+// setWriteDeadline sets the response write deadline, ignoring writers that do not support it.
+func setWriteDeadline(rc *http.ResponseController, deadline time.Time) error {
+	if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return fmt.Errorf("failed to set write deadline: %w", err)
 	}
+	return nil
+}
+
+// withoutWriteTimeout clears the server write deadline for next, which runs
+// for as long as the request asks. The pprof handlers would set their own
+// deadline from the WriteTimeout of the server in the request context, so
+// next does not see the server.
+func (s *Server) withoutWriteTimeout(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := setWriteDeadline(http.NewResponseController(w), time.Time{}); err != nil {
+			s.logger.Warn("failed to clear write deadline",
+				zap.String("path", r.URL.Path),
+				zap.Error(err),
+			)
+		}
 
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		ctx := context.WithValue(r.Context(), http.ServerContextKey, nil)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// handleSetTemperature handles temperature change requests via HTMX.
+func (s *Server) handleSetTemperature(w http.ResponseWriter, r *http.Request) {
+	if !parseForm(w, r) {
 		return
 	}
 
 	tempStr := r.FormValue("temperature")
-	temp, err := strconv.ParseFloat(tempStr, 64)
+	value, err := strconv.ParseFloat(tempStr, 64)
 	if err != nil {
 		http.Error(w, "Invalid temperature value", http.StatusBadRequest)
 		return
 	}
 
+	// Input is in the display unit, commands are always in Celsius
+	temp := s.fromDisplayUnit(value)
+
 	// Validate temperature range
-	if temp < 10.0 || temp > 30.0 {
-		http.Error(w, "Temperature out of range (10-30°C)", http.StatusBadRequest)
+	if temp < minTemperature || temp > maxTemperature {
+		http.Error(w, fmt.Sprintf("Temperature out of range (%s-%s%s)",
+			strconv.FormatFloat(s.toDisplayUnit(minTemperature), 'f', -1, 64),
+			strconv.FormatFloat(s.toDisplayUnit(maxTemperature), 'f', -1, 64),
+			s.unitSymbol(),
+		), http.StatusBadRequest)
 		return
 	}
 
@@ -285,11 +730,11 @@ func (s *Server) handleSetTemperature(w http.ResponseWriter, r *http.Request) {
 		CommandType:       events.CommandTypeSetTemperature,
 		TargetTemperature: &temp,
 	}
-	s.bus.PublishCommand(s.client, event)
-
-	s.logger.Info("temperature changed via web",
-		zap.Float64("temperature", temp),
-	)
+	if s.publishCommand(event) {
+		s.logger.Info("temperature changed via web",
+			zap.Float64("temperature", temp),
+		)
+	}
 
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("OK"))
@@ -297,19 +742,13 @@ func (s *Server) handleSetTemperature(w http.ResponseWriter, r *http.Request) {
 
 // handleSetMode handles mode change requests via HTMX.
 func (s *Server) handleSetMode(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form data", http.StatusBadRequest)
+	if !parseForm(w, r) {
 		return
 	}
 
-	mode := r.FormValue("mode")
-	if mode != modeOff && mode != modeHeat {
-		http.Error(w, "Invalid mode (must be 'off' or 'heat')", http.StatusBadRequest)
+	mode, err := events.ParseMode(r.FormValue("mode"))
+	if err != nil {
+		http.Error(w, "Invalid mode (must be 'off', 'heat' or 'auto')", http.StatusBadRequest)
 		return
 	}
 
@@ -319,36 +758,353 @@ func (s *Server) handleSetMode(w http.ResponseWriter, r *http.Request) {
 		CommandType: events.CommandTypeSetMode,
 		Mode:        &mode,
 	}
-	s.bus.PublishCommand(s.client, event)
+	if s.publishCommand(event) {
+		s.logger.Info("mode changed via web",
+			zap.String("mode", string(mode)),
+		)
+	}
 
-	s.logger.Info("mode changed via web",
-		zap.String("mode", mode),
-	)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("OK"))
+}
+
+// historyResponse is the JSON body of /api/history. Temperatures are in Celsius.
+type historyResponse struct {
+	IntervalSeconds float64         `json:"interval_seconds"`
+	Samples         []historySample `json:"samples"`
+}
+
+// handlePresence applies the comfort setpoint when presence is "home" and the
+// setback setpoint when it is "away", for automations that track whether
+// anyone is home.
+func (s *Server) handlePresence(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.PresenceEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !parseForm(w, r) {
+		return
+	}
+
+	var temp float64
+	presence := r.FormValue("presence")
+	switch presence {
+	case "home":
+		temp = s.cfg.PresenceComfortTemperature
+	case "away":
+		temp = s.cfg.PresenceSetbackTemperature
+	default:
+		http.Error(w, "Invalid presence (must be 'home' or 'away')", http.StatusBadRequest)
+		return
+	}
+
+	// Publish command event
+	event := events.CommandEvent{
+		Source:            "presence",
+		CommandType:       events.CommandTypeSetTemperature,
+		TargetTemperature: &temp,
+	}
+	if s.publishCommand(event) {
+		s.logger.Info("presence changed via web",
+			zap.String("presence", presence),
+			zap.Float64("temperature", temp),
+		)
+	}
 
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("OK"))
 }
 
-// handleEventBusDebug shows EventBus statistics and recent events.
-func (s *Server) handleEventBusDebug(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleHistory returns the recent temperature history, oldest sample first.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	samples := s.history.snapshot()
+	s.mu.RUnlock()
+
+	data, err := json.Marshal(historyResponse{
+		IntervalSeconds: s.cfg.WebHistoryInterval.Seconds(),
+		Samples:         samples,
+	})
+	if err != nil {
+		s.logger.Error("failed to marshal history", zap.Error(err))
+		http.Error(w, "Failed to encode history", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// handleState returns the current thermostat state as JSON. Responses carry an
+// ETag so polling clients can send If-None-Match and get a 304 while the state
+// is unchanged.
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	var state events.StateUpdateEvent
+	hasState := s.currentState != nil
+	if hasState {
+		state = *s.currentState
+	}
+	s.mu.RUnlock()
+
+	if !hasState {
+		s.setRetryAfter(w)
+		http.Error(w, "No state received from the thermostat yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	etag := stateETag(state)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		s.logger.Error("failed to marshal state", zap.Error(err))
+		http.Error(w, "Failed to encode state", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// handleConnection returns the latest connection status of every component
+// seen so far as JSON, keyed by component, for status dashboards that do not
+// need the full state.
+func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(s.ComponentStatuses())
+	if err != nil {
+		s.logger.Error("failed to marshal connection statuses", zap.Error(err))
+		http.Error(w, "Failed to encode connection statuses", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(data)
+}
+
+// handleConfig returns the effective configuration as JSON, with secrets
+// masked, for troubleshooting a running instance.
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(s.cfg.Redacted())
+	if err != nil {
+		s.logger.Error("failed to marshal config", zap.Error(err))
+		http.Error(w, "Failed to encode config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(data)
+}
+
+// handleEnergy returns the latest gas usage read from the thermostat as JSON,
+// one entry per day in kWh, oldest first.
+func (s *Server) handleEnergy(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	energy := s.energy
+	s.mu.RUnlock()
+
+	if energy == nil {
+		s.setRetryAfter(w)
+		http.Error(w, "No gas usage received from the thermostat yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	data, err := json.Marshal(energy)
+	if err != nil {
+		s.logger.Error("failed to marshal energy", zap.Error(err))
+		http.Error(w, "Failed to encode energy", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// setRetryAfter sets the Retry-After header of a 503 answered before the
+// first read from the thermostat, to the status poll interval by which one
+// is expected. Status and gas usage are both read once Nefit connects.
+func (s *Server) setRetryAfter(w http.ResponseWriter) {
+	seconds := int(math.Ceil(s.cfg.XMPPKeepaliveInterval.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// stateETag returns a strong ETag for the fields compared by
+// StateUpdateEvent.Equals. Timestamp and Source are left out, and temperatures
+// are rounded to the Equals tolerance, so a state that Equals the previous one
+// keeps its ETag.
+func stateETag(state events.StateUpdateEvent) string {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%.2f|%.2f|%t|%s|%.2f|%t|%.2f|%.2f|%s|%s|%.2f|%.2f|%.2f",
+		state.CurrentTemperature,
+		state.TargetTemperature,
+		state.HeatingActive,
+		state.Mode,
+		state.Pressure,
+		state.HotWaterActive,
+		state.HotWaterTemperature,
+		state.ComfortTemperature,
+		state.SetpointSource,
+		state.FirmwareVersion,
+		state.SupplySetpoint,
+		state.OutdoorTemperature,
+		state.Humidity,
+	)
+
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// etagMatches reports whether an If-None-Match header matches etag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleEventBusDebug shows EventBus statistics and recent events.
+func (s *Server) handleEventBusDebug(w http.ResponseWriter, r *http.Request) {
 	html := s.renderEventBusDebug()
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	_, _ = w.Write([]byte(html))
 }
 
-// handleHealth returns server health status.
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleEventsJSON dumps the recent event history as JSON.
+func (s *Server) handleEventsJSON(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.EventBusDebugEnabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := json.Marshal(s.bus.RecentEvents())
+	if err != nil {
+		s.logger.Error("failed to marshal event history", zap.Error(err))
+		http.Error(w, "Failed to encode events", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="events.json"`)
+	_, _ = w.Write(data)
+}
+
+// eventsCSVHeader lists the columns of the CSV event export.
+// StateUpdateEvent fields are flattened; other event types fill the columns they have.
+var eventsCSVHeader = []string{
+	"recorded_at",
+	"type",
+	"source",
+	"current_temperature",
+	"target_temperature",
+	"comfort_temperature",
+	"heating_active",
+	"mode",
+	"pressure",
+	"hot_water_active",
+	"hot_water_temperature",
+	"setpoint_source",
+	"supply_setpoint",
+	"outdoor_temperature",
+	"humidity",
+	"firmware_version",
+	"command_type",
+	"status",
+	"error",
+}
+
+// handleEventsCSV dumps the recent event history as CSV.
+func (s *Server) handleEventsCSV(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.EventBusDebugEnabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="events.csv"`)
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write(eventsCSVHeader)
+	for _, recorded := range s.bus.RecentEvents() {
+		_ = cw.Write(eventCSVRecord(recorded))
+	}
+	cw.Flush()
+
+	if err := cw.Error(); err != nil {
+		s.logger.Warn("failed to write event history", zap.Error(err))
+	}
+}
+
+// eventCSVRecord flattens a recorded event into a CSV row matching eventsCSVHeader.
+func eventCSVRecord(recorded events.RecordedEvent) []string {
+	row := make([]string, len(eventsCSVHeader))
+	row[0] = recorded.RecordedAt.Format(time.RFC3339Nano)
+	row[1] = string(recorded.Type)
+
+	formatFloat := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	switch event := recorded.Event.(type) {
+	case events.StateUpdateEvent:
+		row[2] = event.Source
+		row[3] = formatFloat(event.CurrentTemperature)
+		row[4] = formatFloat(event.TargetTemperature)
+		row[5] = formatFloat(event.ComfortTemperature)
+		row[6] = strconv.FormatBool(event.HeatingActive)
+		row[7] = string(event.Mode)
+		row[8] = formatFloat(event.Pressure)
+		row[9] = strconv.FormatBool(event.HotWaterActive)
+		row[10] = formatFloat(event.HotWaterTemperature)
+		row[11] = event.SetpointSource
+		row[12] = formatFloat(event.SupplySetpoint)
+		row[13] = formatFloat(event.OutdoorTemperature)
+		row[14] = formatFloat(event.Humidity)
+		row[15] = event.FirmwareVersion
+	case events.CommandEvent:
+		row[2] = event.Source
+		if event.TargetTemperature != nil {
+			row[4] = formatFloat(*event.TargetTemperature)
+		}
+		if event.Mode != nil {
+			row[7] = string(*event.Mode)
+		}
+		if event.HotWaterEnabled != nil {
+			row[9] = strconv.FormatBool(*event.HotWaterEnabled)
+		}
+		row[16] = string(event.CommandType)
+	case events.ConnectionStatusEvent:
+		row[2] = event.Component
+		row[17] = string(event.Status)
+		row[18] = event.Error
+	case events.CommandResultEvent:
+		row[2] = event.Source
+		row[16] = string(event.CommandType)
+		row[18] = event.Error
+	case events.ReconnectEvent:
+		row[2] = event.Source
+	}
+
+	return row
+}
+
+// handleHealth returns server health status.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte(`{"status":"ok"}`))
@@ -370,16 +1126,30 @@ func (s *Server) Close() error {
 
 	s.publishConnectionStatus(events.ConnectionStatusDisconnected, "")
 
-	// Close all SSE clients
+	// Tell SSE clients the server is going away, then close their streams.
+	// The final event is queued behind anything not yet sent and before the
+	// channel is closed, so it is always the last message a client gets, and
+	// browsers show the disconnect right away instead of when they notice the
+	// TCP connection closing.
 	s.mu.Lock()
+	s.broadcast(sseMessage{
+		event: "connection",
+		data: connectionMessage{
+			Status: events.ConnectionStatusDisconnected,
+			Notice: shutdownNotice,
+		},
+	})
 	for client := range s.sseClients {
 		close(client)
 	}
-	s.sseClients = make(map[chan events.StateUpdateEvent]struct{})
+	s.sseClients = make(map[chan sseMessage]struct{})
 	s.mu.Unlock()
 
 	// Cancel context to stop background goroutines
 	s.cancel()
+	s.connSub.Close()
+	s.energySub.Close()
+	s.resultSub.Close()
 
 	// Gracefully shutdown HTTP server
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -389,24 +1159,145 @@ func (s *Server) Close() error {
 		s.logger.Warn("server shutdown error", zap.Error(err))
 	}
 
+	// Leave the tailnet after the requests on it are done
+	if s.tailscale != nil {
+		if err := s.tailscale.Close(); err != nil {
+			s.logger.Warn("tailscale shutdown error", zap.Error(err))
+		}
+	}
+
 	s.logger.Info("web server shut down complete")
 	return nil
 }
 
+// formatTemperature formats a temperature with one decimal after rounding it
+// with temperature.Round. The UI script mirrors this in formatTemperature so
+// the server-rendered and SSE-updated values never disagree for the same reading.
+func formatTemperature(v float64) string {
+	return strconv.FormatFloat(temperature.Round(v), 'f', 1, 64)
+}
+
+// fahrenheit reports whether the UI shows and accepts temperatures in Fahrenheit.
+func (s *Server) fahrenheit() bool {
+	return s.cfg.WebDisplayUnit == displayUnitFahrenheit
+}
+
+// toDisplayUnit converts a Celsius temperature to the configured display unit.
+func (s *Server) toDisplayUnit(celsius float64) float64 {
+	if s.fahrenheit() {
+		return temperature.CtoF(celsius)
+	}
+	return celsius
+}
+
+// fromDisplayUnit converts a temperature in the configured display unit to Celsius.
+func (s *Server) fromDisplayUnit(v float64) float64 {
+	if s.fahrenheit() {
+		return temperature.FtoC(v)
+	}
+	return v
+}
+
+// unitSymbol returns the symbol of the configured display unit.
+func (s *Server) unitSymbol() string {
+	if s.fahrenheit() {
+		return "°F"
+	}
+	return "°C"
+}
+
+// outdoorText describes the outdoor temperature in the display unit, or
+// returns an empty string if it is unknown.
+func (s *Server) outdoorText(celsius float64) string {
+	if celsius == 0 {
+		return ""
+	}
+	return "Outdoor " + formatTemperature(s.toDisplayUnit(celsius)) + s.unitSymbol()
+}
+
+// setpointSourceLabels maps setpoint sources to the names shown in the UI.
+var setpointSourceLabels = map[string]string{
+	"homekit":  "HomeKit",
+	"web":      "the web UI",
+	"presence": "presence",
+	"startup":  "the startup setpoint",
+	"nefit":    "the thermostat",
+}
+
+// setpointSourceText describes who last changed the setpoint, or returns an
+// empty string if that is unknown.
+func setpointSourceText(source string) string {
+	label, ok := setpointSourceLabels[source]
+	if !ok {
+		return ""
+	}
+	return "Last changed by " + label
+}
+
+// renderHistory renders the temperature history card, or nothing when the
+// history is disabled.
+func (s *Server) renderHistory(samples []historySample) elem.Node {
+	if s.history == nil {
+		return elem.None()
+	}
+
+	caption := "Collecting temperature history"
+	if len(samples) >= 2 {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, sample := range samples {
+			lo = math.Min(lo, sample.Current)
+			hi = math.Max(hi, sample.Current)
+		}
+		caption = fmt.Sprintf("Current %s-%s%s since %s",
+			formatTemperature(s.toDisplayUnit(lo)),
+			formatTemperature(s.toDisplayUnit(hi)),
+			s.unitSymbol(),
+			samples[0].Timestamp.Format("Jan 2 15:04"),
+		)
+	}
+
+	return elem.Div(attrs.Props{attrs.Class: "control-card history-card"},
+		elem.H2(nil, elem.Text("History")),
+		elem.Div(attrs.Props{attrs.ID: "history-chart"}, elem.Raw(renderSparkline(samples))),
+		elem.Div(attrs.Props{attrs.Class: "history-range", attrs.ID: "history-range"}, elem.Text(caption)),
+	)
+}
+
 // renderThermostatUI renders the main thermostat UI using elem-go.
-func (s *Server) renderThermostatUI(state *events.StateUpdateEvent) string {
+// While Nefit is not connected, connectionNotice explains why and the
+// controls are rendered disabled, as they always are in read-only mode. samples are drawn as a history sparkline.
+func (s *Server) renderThermostatUI(state *events.StateUpdateEvent, connectionNotice string, samples []historySample) string {
 	currentTemp := "N/A"
-	targetTemp := "20.0"
+	outdoorTemp := ""
+	targetTemp := formatTemperature(s.toDisplayUnit(20.0))
 	heating := false
-	mode := modeHeat
+	mode := events.ModeHeat
+	setpointSource := ""
 
 	if state != nil {
-		currentTemp = fmt.Sprintf("%.1f°C", state.CurrentTemperature)
-		targetTemp = fmt.Sprintf("%.1f", state.TargetTemperature)
+		currentTemp = formatTemperature(s.toDisplayUnit(state.CurrentTemperature)) + s.unitSymbol()
+		outdoorTemp = s.outdoorText(state.OutdoorTemperature)
+		targetTemp = formatTemperature(s.toDisplayUnit(state.DisplayTargetTemperature()))
 		heating = state.HeatingActive
 		mode = state.Mode
+		setpointSource = setpointSourceText(state.SetpointSource)
+	}
+
+	offNotice := ""
+	if mode == events.ModeOff {
+		offNotice = "Thermostat is off, target applies when heating resumes"
 	}
 
+	// The slider works in the display unit, in whole degrees for Fahrenheit
+	// as the Celsius step rarely maps to a round Fahrenheit one. Nefit rounds
+	// the converted value to the Celsius step.
+	sliderStep := strconv.FormatFloat(s.cfg.TemperatureStep(), 'f', -1, 64)
+	if s.fahrenheit() {
+		sliderStep = "1"
+	}
+
+	disabled := strconv.FormatBool(connectionNotice != "" || s.cfg.WebReadOnly)
+
 	heatingStatus := "Off"
 	heatingClass := "status-off"
 	if heating {
@@ -416,7 +1307,7 @@ func (s *Server) renderThermostatUI(state *events.StateUpdateEvent) string {
 
 	return elem.Html(nil,
 		elem.Head(nil,
-			elem.Title(nil, elem.Text("Nefit Easy Thermostat")),
+			elem.Title(nil, elem.Text(s.title())),
 			elem.Meta(attrs.Props{attrs.Charset: "utf-8"}),
 			elem.Meta(attrs.Props{attrs.Name: "viewport", attrs.Content: "width=device-width, initial-scale=1"}),
 			elem.Script(attrs.Props{attrs.Src: "https://unpkg.com/htmx.org@1.9.10"}),
@@ -424,60 +1315,73 @@ func (s *Server) renderThermostatUI(state *events.StateUpdateEvent) string {
 		),
 		elem.Body(nil,
 			elem.Div(attrs.Props{attrs.Class: "container"},
-				elem.H1(nil, elem.Text("Nefit Easy Thermostat")),
+				elem.H1(nil, elem.Text(s.title())),
 
 				elem.Div(attrs.Props{attrs.Class: "status-card"},
 					elem.Div(attrs.Props{attrs.Class: "temp-display"},
 						elem.Div(attrs.Props{attrs.Class: "current-temp"},
 							elem.Span(attrs.Props{attrs.Class: "label"}, elem.Text("Current")),
 							elem.Span(attrs.Props{attrs.Class: "value", attrs.ID: "current-temp"}, elem.Text(currentTemp)),
+							elem.Span(attrs.Props{attrs.Class: "outdoor", attrs.ID: "outdoor-temp"}, elem.Text(outdoorTemp)),
 						),
 						elem.Div(attrs.Props{attrs.Class: heatingClass, attrs.ID: "heating-status"}, elem.Text(heatingStatus)),
 					),
 				),
 
 				elem.Div(attrs.Props{attrs.Class: "control-card"},
+					elem.Div(attrs.Props{attrs.Class: "connection-notice", attrs.ID: "connection-notice"}, elem.Text(connectionNotice)),
 					elem.H2(nil, elem.Text("Target Temperature")),
-					elem.Form(attrs.Props{
-						"hx-post":   "/api/temperature",
-						"hx-target": "#response",
-					},
+					elem.Form(s.controlForm("/api/temperature", "#response"),
 						elem.Input(attrs.Props{
-							attrs.Type:  "range",
-							attrs.Name:  "temperature",
-							attrs.Min:   "10",
-							attrs.Max:   "30",
-							attrs.Step:  "0.5",
-							attrs.Value: targetTemp,
-							attrs.ID:    "temp-slider",
-							"hx-trigger": "change",
+							attrs.Type:     "range",
+							attrs.Name:     "temperature",
+							attrs.Min:      strconv.FormatFloat(s.toDisplayUnit(minTemperature), 'f', -1, 64),
+							attrs.Max:      strconv.FormatFloat(s.toDisplayUnit(maxTemperature), 'f', -1, 64),
+							attrs.Step:     sliderStep,
+							attrs.Value:    attrValue(targetTemp),
+							attrs.ID:       "temp-slider",
+							attrs.Disabled: disabled,
+							"hx-trigger":   "change",
 						}),
-						elem.Div(attrs.Props{attrs.Class: "temp-value", attrs.ID: "target-temp"}, elem.Text(targetTemp+"°C")),
+						elem.Div(attrs.Props{attrs.Class: "temp-value", attrs.ID: "target-temp"}, elem.Text(targetTemp+s.unitSymbol())),
+						elem.Div(attrs.Props{attrs.Class: "setpoint-source", attrs.ID: "setpoint-source"}, elem.Text(setpointSource)),
+						elem.Div(attrs.Props{attrs.Class: "off-notice", attrs.ID: "off-notice"}, elem.Text(offNotice)),
 					),
 
 					elem.H2(nil, elem.Text("Mode")),
-					elem.Form(attrs.Props{
-						"hx-post":   "/api/mode",
-						"hx-target": "#response",
-					},
+					elem.Form(s.controlForm("/api/mode", "#response"),
 						elem.Div(attrs.Props{attrs.Class: "mode-buttons"},
 							elem.Button(attrs.Props{
-								attrs.Type:  "submit",
-								attrs.Name:  "mode",
-								attrs.Value: modeHeat,
+								attrs.Type:     "submit",
+								attrs.Name:     "mode",
+								attrs.Value:    string(events.ModeHeat),
+								attrs.Disabled: disabled,
 								attrs.Class: func() string {
-									if mode == modeHeat {
+									if mode == events.ModeHeat {
 										return "mode-btn active"
 									}
 									return "mode-btn"
 								}(),
 							}, elem.Text("Heat")),
 							elem.Button(attrs.Props{
-								attrs.Type:  "submit",
-								attrs.Name:  "mode",
-								attrs.Value: modeOff,
+								attrs.Type:     "submit",
+								attrs.Name:     "mode",
+								attrs.Value:    string(events.ModeAuto),
+								attrs.Disabled: disabled,
 								attrs.Class: func() string {
-									if mode == modeOff {
+									if mode == events.ModeAuto {
+										return "mode-btn active"
+									}
+									return "mode-btn"
+								}(),
+							}, elem.Text("Schedule")),
+							elem.Button(attrs.Props{
+								attrs.Type:     "submit",
+								attrs.Name:     "mode",
+								attrs.Value:    string(events.ModeOff),
+								attrs.Disabled: disabled,
+								attrs.Class: func() string {
+									if mode == events.ModeOff {
 										return "mode-btn active"
 									}
 									return "mode-btn"
@@ -489,22 +1393,101 @@ func (s *Server) renderThermostatUI(state *events.StateUpdateEvent) string {
 					elem.Div(attrs.Props{attrs.ID: "response"}),
 				),
 
+				s.renderSupplySetpoint(state, disabled),
+
+				s.renderHistory(samples),
+
 				elem.Div(attrs.Props{attrs.Class: "links"},
-					elem.A(attrs.Props{attrs.Href: "/debug/eventbus"}, elem.Text("EventBus Debug")),
+					elem.A(attrs.Props{attrs.Href: s.path("/debug/eventbus")}, elem.Text("EventBus Debug")),
 					elem.Text(" | "),
-					elem.A(attrs.Props{attrs.Href: "/metrics"}, elem.Text("Metrics")),
+					elem.A(attrs.Props{attrs.Href: s.path("/metrics")}, elem.Text("Metrics")),
 				),
 			),
 
-			// SSE handler script
-			elem.Script(nil, elem.Text(`
-				const eventSource = new EventSource('/events');
+			// SSE handler script. Raw, as text nodes are HTML-escaped, which breaks
+			// operators like && inside a script; only constants are interpolated.
+			elem.Script(nil, elem.Raw(`
+				const eventSource = new EventSource(`+jsString(s.path("/events"))+`);
+				const fahrenheit = `+strconv.FormatBool(s.fahrenheit())+`;
+				const unitSymbol = `+jsString(s.unitSymbol())+`;
+				const readOnly = `+strconv.FormatBool(s.cfg.WebReadOnly)+`;
+				function toDisplayUnit(c) {
+					return fahrenheit ? c * 9 / 5 + 32 : c;
+				}
+				// Mirrors formatTemperature in server.go: nearest 0.1, halves rounded up.
+				function formatTemperature(v) {
+					return (Math.floor(v * 10 + 0.5) / 10).toFixed(1);
+				}
+				const setpointSourceLabels = `+jsValue(setpointSourceLabels)+`;
 				const tempSlider = document.getElementById('temp-slider');
 				const targetTempDisplay = document.getElementById('target-temp');
+				const historyChart = document.getElementById('history-chart');
+
+				// Mirrors renderSparkline in history.go.
+				function renderSparkline(samples) {
+					if (samples.length < 2) {
+						return '';
+					}
+					let lo = Infinity, hi = -Infinity;
+					samples.forEach(function(s) {
+						lo = Math.min(lo, s.current, s.target);
+						hi = Math.max(hi, s.current, s.target);
+					});
+					lo -= 0.5;
+					hi += 0.5;
+					const first = Date.parse(samples[0].timestamp);
+					const span = Date.parse(samples[samples.length - 1].timestamp) - first;
+					function points(key) {
+						return samples.map(function(s, i) {
+							const x = span > 0 ? (Date.parse(s.timestamp) - first) / span : i / (samples.length - 1);
+							const y = (hi - s[key]) / (hi - lo);
+							return (x * `+strconv.Itoa(sparklineWidth)+`).toFixed(1) + ',' + (y * `+strconv.Itoa(sparklineHeight)+`).toFixed(1);
+						}).join(' ');
+					}
+					return '<svg class="sparkline" viewBox="0 0 `+strconv.Itoa(sparklineWidth)+` `+strconv.Itoa(sparklineHeight)+`" preserveAspectRatio="none" role="img" aria-label="Temperature history">' +
+						'<polyline class="spark-target" points="' + points('target') + '"/>' +
+						'<polyline class="spark-current" points="' + points('current') + '"/>' +
+						'</svg>';
+				}
+
+				// Redraws the chart from /api/history, which only changes on state updates.
+				function refreshHistory() {
+					if (!historyChart) {
+						return;
+					}
+					fetch(`+jsString(s.path("/api/history"))+`)
+						.then(function(r) { return r.json(); })
+						.then(function(history) {
+							const samples = history.samples;
+							historyChart.innerHTML = renderSparkline(samples);
+							let caption = 'Collecting temperature history';
+							if (samples.length >= 2) {
+								const currents = samples.map(function(s) { return s.current; });
+								const since = new Date(samples[0].timestamp).toLocaleString([], {month: 'short', day: 'numeric', hour: '2-digit', minute: '2-digit'});
+								caption = 'Current ' + formatTemperature(toDisplayUnit(Math.min.apply(null, currents))) + '-' +
+									formatTemperature(toDisplayUnit(Math.max.apply(null, currents))) + unitSymbol + ' since ' + since;
+							}
+							document.getElementById('history-range').textContent = caption;
+						})
+						.catch(function() {});
+				}
 
 				eventSource.onmessage = function(e) {
 					const data = JSON.parse(e.data);
-					document.getElementById('current-temp').textContent = data.CurrentTemperature.toFixed(1) + '°C';
+					document.getElementById('current-temp').textContent = formatTemperature(toDisplayUnit(data.CurrentTemperature)) + unitSymbol;
+					document.getElementById('outdoor-temp').textContent = data.OutdoorTemperature !== 0 ? 'Outdoor ' + formatTemperature(toDisplayUnit(data.OutdoorTemperature)) + unitSymbol : '';
+
+					const target = data.Mode === 'off' && data.ComfortTemperature > 0 ? data.ComfortTemperature : data.TargetTemperature;
+					tempSlider.value = formatTemperature(toDisplayUnit(target));
+					targetTempDisplay.textContent = formatTemperature(toDisplayUnit(target)) + unitSymbol;
+					document.getElementById('off-notice').textContent = data.Mode === 'off' ? 'Thermostat is off, target applies when heating resumes' : '';
+					const sourceLabel = setpointSourceLabels[data.SetpointSource];
+					document.getElementById('setpoint-source').textContent = sourceLabel ? 'Last changed by ' + sourceLabel : '';
+
+					const supplySetpoint = document.getElementById('supply-setpoint');
+					if (supplySetpoint) {
+						supplySetpoint.textContent = data.SupplySetpoint > 0 ? formatTemperature(data.SupplySetpoint) + '°C' : 'Unknown';
+					}
 
 					const heatingStatus = document.getElementById('heating-status');
 					if (data.HeatingActive) {
@@ -514,16 +1497,77 @@ func (s *Server) renderThermostatUI(state *events.StateUpdateEvent) string {
 						heatingStatus.textContent = 'Off';
 						heatingStatus.className = 'status-off';
 					}
+
+					refreshHistory();
 				};
 
+				eventSource.addEventListener('command_result', function(e) {
+					const data = JSON.parse(e.data);
+					if (!data.success) {
+						document.getElementById('response').textContent = 'Thermostat rejected the change: ' + data.error;
+					}
+				});
+
+				eventSource.addEventListener('connection', function(e) {
+					const data = JSON.parse(e.data);
+					const disabled = readOnly || !data.connected;
+					tempSlider.disabled = disabled;
+					document.querySelectorAll('.mode-btn, .supply-input').forEach(function(btn) {
+						btn.disabled = disabled;
+					});
+					document.getElementById('connection-notice').textContent = data.notice || '';
+				});
+
 				tempSlider.addEventListener('input', function(e) {
-					targetTempDisplay.textContent = e.target.value + '°C';
+					targetTempDisplay.textContent = formatTemperature(parseFloat(e.target.value)) + unitSymbol;
 				});
 			`)),
 		),
 	).Render()
 }
 
+// attrValue escapes a dynamic attribute value. elem-go escapes text nodes but
+// writes attribute values verbatim, so any value that is not a constant must
+// go through here.
+func attrValue(v string) string {
+	return html.EscapeString(v)
+}
+
+// jsString returns v as a JavaScript string literal for inline scripts. The
+// JSON encoder escapes <, > and &, so the value cannot close the script tag.
+func jsString(v string) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return `""`
+	}
+	return string(data)
+}
+
+// jsValue returns v as a JavaScript literal, escaped like jsString, or null if
+// it cannot be encoded.
+func jsValue(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "null"
+	}
+	return string(data)
+}
+
+// path returns p under the configured base path, for routes and the URLs
+// rendered into pages.
+func (s *Server) path(p string) string {
+	return strings.TrimRight(s.cfg.WebBasePath, "/") + p
+}
+
+// title returns the configured page title, so instances can be told apart.
+// It is rendered as text, which elem-go escapes.
+func (s *Server) title() string {
+	if strings.TrimSpace(s.cfg.WebTitle) == "" {
+		return defaultTitle
+	}
+	return s.cfg.WebTitle
+}
+
 // renderEventBusDebug renders the EventBus debugger interface.
 func (s *Server) renderEventBusDebug() string {
 	s.mu.RLock()
@@ -541,14 +1585,14 @@ func (s *Server) renderEventBusDebug() string {
 
 	return elem.Html(nil,
 		elem.Head(nil,
-			elem.Title(nil, elem.Text("EventBus Debug")),
+			elem.Title(nil, elem.Text("EventBus Debug - "+s.title())),
 			elem.Meta(attrs.Props{attrs.Charset: "utf-8"}),
 			elem.Meta(attrs.Props{attrs.Name: "viewport", attrs.Content: "width=device-width, initial-scale=1"}),
 			elem.Style(nil, elem.Text(s.getCSS())),
 		),
 		elem.Body(nil,
 			elem.Div(attrs.Props{attrs.Class: "container"},
-				elem.H1(nil, elem.Text("EventBus Debugger")),
+				elem.H1(nil, elem.Text(s.title()+" EventBus Debugger")),
 
 				elem.Div(attrs.Props{attrs.Class: "debug-card"},
 					elem.H2(nil, elem.Text("Statistics")),
@@ -564,7 +1608,11 @@ func (s *Server) renderEventBusDebug() string {
 				),
 
 				elem.Div(attrs.Props{attrs.Class: "links"},
-					elem.A(attrs.Props{attrs.Href: "/"}, elem.Text("Back to Thermostat")),
+					elem.A(attrs.Props{attrs.Href: s.path("/")}, elem.Text("Back to Thermostat")),
+					elem.Text(" | "),
+					elem.A(attrs.Props{attrs.Href: s.path("/debug/events.json")}, elem.Text("Events (JSON)")),
+					elem.Text(" | "),
+					elem.A(attrs.Props{attrs.Href: s.path("/debug/events.csv")}, elem.Text("Events (CSV)")),
 				),
 			),
 		),
@@ -622,6 +1670,11 @@ func (s *Server) getCSS() string {
 			font-weight: bold;
 			color: #333;
 		}
+		.current-temp .outdoor {
+			color: #666;
+			font-size: 0.9em;
+			margin-top: 5px;
+		}
 		.status-off {
 			background: #e0e0e0;
 			color: #666;
@@ -659,6 +1712,40 @@ func (s *Server) getCSS() string {
 			font-weight: bold;
 			color: #667eea;
 		}
+		.history-card svg {
+			width: 100%;
+			height: 60px;
+		}
+		.spark-current, .spark-target {
+			fill: none;
+			stroke-width: 2;
+			vector-effect: non-scaling-stroke;
+		}
+		.spark-current {
+			stroke: #667eea;
+		}
+		.spark-target {
+			stroke: #e0a040;
+			stroke-dasharray: 4 3;
+		}
+		.setpoint-source, .off-notice, .history-range {
+			text-align: center;
+			color: #666;
+			font-size: 0.9em;
+			margin-top: 5px;
+		}
+		.connection-notice:not(:empty) {
+			background: #fff3cd;
+			color: #856404;
+			border-radius: 10px;
+			padding: 10px 15px;
+			margin-bottom: 15px;
+			font-size: 0.9em;
+		}
+		input[type="range"]:disabled, .mode-btn:disabled, .supply-input:disabled {
+			opacity: 0.5;
+			cursor: not-allowed;
+		}
 		.mode-buttons {
 			display: flex;
 			gap: 10px;
@@ -678,6 +1765,13 @@ func (s *Server) getCSS() string {
 		.mode-btn:hover {
 			border-color: #667eea;
 		}
+		.supply-input {
+			flex: 2;
+			padding: 15px;
+			border: 2px solid #e0e0e0;
+			border-radius: 10px;
+			font-size: 1em;
+		}
 		.mode-btn.active {
 			background: #667eea;
 			color: white;
@@ -4,15 +4,22 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/kradalby/nefit-homekit/config"
 	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/leaktest"
+	"github.com/kradalby/nefit-homekit/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"tailscale.com/util/eventbus"
 )
@@ -151,11 +158,11 @@ func TestHandleIndex(t *testing.T) {
 		t.Errorf("handleIndex() Content-Type = %s, want text/html", contentType)
 	}
 
-	// Test POST request (should fail)
+	// Test POST request (should fail, rejected by the router)
 	req = httptest.NewRequest(http.MethodPost, "/", nil)
 	w = httptest.NewRecorder()
 
-	server.handleIndex(w, req)
+	server.mux.ServeHTTP(w, req)
 
 	resp = w.Result()
 	defer func() { _ = resp.Body.Close() }()
@@ -362,6 +369,11 @@ func TestHandleSetMode(t *testing.T) {
 			mode:       "off",
 			wantStatus: http.StatusOK,
 		},
+		{
+			name:       "auto mode",
+			mode:       "auto",
+			wantStatus: http.StatusOK,
+		},
 		{
 			name:       "invalid mode",
 			mode:       "cool",
@@ -397,7 +409,7 @@ func TestHandleSetMode(t *testing.T) {
 					if event.CommandType != events.CommandTypeSetMode {
 						t.Errorf("event.CommandType = %v, want %v", event.CommandType, events.CommandTypeSetMode)
 					}
-					if event.Mode == nil || *event.Mode != tt.mode {
+					if event.Mode == nil || string(*event.Mode) != tt.mode {
 						t.Errorf("event.Mode = %v, want %v", event.Mode, tt.mode)
 					}
 				case <-time.After(1 * time.Second):
@@ -689,6 +701,121 @@ func TestHandleEventBusDebug(t *testing.T) {
 	}
 }
 
+func TestHandleEventsExport(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:          "TEST123",
+		HAPPin:               "12345678",
+		HAPStoragePath:       t.TempDir(),
+		HAPPort:              0,
+		WebPort:              0,
+		EventBusDebugEnabled: true,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	publisherClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	bus.PublishStateUpdate(publisherClient, events.StateUpdateEvent{
+		Source:             "nefit",
+		CurrentTemperature: 21.5,
+		TargetTemperature:  22.0,
+		HeatingActive:      true,
+		Mode:               "heat",
+		Pressure:           1.6,
+		ComfortTemperature: 22.0,
+		SetpointSource:     "web",
+		FirmwareVersion:    "04.08.02",
+		SupplySetpoint:     55,
+		OutdoorTemperature: 4.5,
+		Humidity:           45,
+	})
+
+	tests := []struct {
+		name            string
+		path            string
+		handler         http.HandlerFunc
+		wantContentType string
+		wantBody        []string
+	}{
+		{
+			name:            "json",
+			path:            "/debug/events.json",
+			handler:         server.handleEventsJSON,
+			wantContentType: "application/json",
+			wantBody:        []string{`"type":"state_update"`, `"CurrentTemperature":21.5`, `"Humidity":45`},
+		},
+		{
+			name:            "csv",
+			path:            "/debug/events.csv",
+			handler:         server.handleEventsCSV,
+			wantContentType: "text/csv",
+			wantBody: []string{
+				"recorded_at,type,source,current_temperature,target_temperature,comfort_temperature,heating_active,mode,pressure," +
+					"hot_water_active,hot_water_temperature,setpoint_source,supply_setpoint,outdoor_temperature,humidity,firmware_version," +
+					"command_type,status,error\n",
+				",state_update,nefit,21.5,22,22,true,heat,1.6,false,0,web,55,4.5,45,04.08.02,,,\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+
+			tt.handler(w, req)
+
+			resp := w.Result()
+			defer func() { _ = resp.Body.Close() }()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+
+			if contentType := resp.Header.Get("Content-Type"); !strings.Contains(contentType, tt.wantContentType) {
+				t.Errorf("Content-Type = %s, want %s", contentType, tt.wantContentType)
+			}
+
+			body := w.Body.String()
+			for _, want := range tt.wantBody {
+				if !strings.Contains(body, want) {
+					t.Errorf("body does not contain %q:\n%s", want, body)
+				}
+			}
+		})
+	}
+
+	// Export is unavailable when the eventbus debugger is disabled
+	server.cfg.EventBusDebugEnabled = false
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/events.json", nil)
+	w := httptest.NewRecorder()
+
+	server.handleEventsJSON(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("disabled status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
 func TestClose(t *testing.T) {
 	logger := zap.NewNop()
 	bus, err := events.New(logger)
@@ -734,3 +861,1962 @@ func TestClose(t *testing.T) {
 		t.Errorf("After Close(), SSE client count = %d, want 0", clientCount)
 	}
 }
+
+// disabledControlPattern matches a slider or button rendered with the disabled attribute.
+var disabledControlPattern = regexp.MustCompile(`<(input|button)[^>]*\sdisabled[\s/>]`)
+
+func TestReadOnlyWhileNefitDisconnected(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+		WebPort:        0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	nefitClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	renderIndex := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		server.handleIndex(w, req)
+		return w.Body.String()
+	}
+
+	bus.PublishConnectionStatus(nefitClient, events.ConnectionStatusEvent{
+		Component: "nefit",
+		Status:    events.ConnectionStatusReconnecting,
+		Error:     "connection refused",
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	body := renderIndex()
+	if got := len(disabledControlPattern.FindAllString(body, -1)); got != 4 {
+		t.Errorf("disconnected UI has %d disabled controls, want 4", got)
+	}
+	if !strings.Contains(body, disconnectedNotice) {
+		t.Error("disconnected UI does not show the read-only notice")
+	}
+
+	// Stream while reconnecting and verify the controls are re-enabled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		server.handleSSE(w, req)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	bus.PublishConnectionStatus(nefitClient, events.ConnectionStatusEvent{
+		Component: "nefit",
+		Status:    events.ConnectionStatusConnected,
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("SSE handler did not finish in time")
+	}
+
+	stream := w.Body.String()
+	disconnectedAt := strings.Index(stream, "event: connection\ndata: {\"connected\":false,\"status\":\"reconnecting\",\"notice\":\""+disconnectedNotice+"\"}")
+	connectedAt := strings.Index(stream, "event: connection\ndata: {\"connected\":true,\"status\":\"connected\"}")
+	if disconnectedAt < 0 || connectedAt < 0 || connectedAt < disconnectedAt {
+		t.Errorf("SSE stream missing disconnected then connected events:\n%s", stream)
+	}
+
+	body = renderIndex()
+	if disabledControlPattern.MatchString(body) {
+		t.Error("connected UI still renders disabled controls")
+	}
+	if strings.Contains(body, ">"+disconnectedNotice+"<") {
+		t.Error("connected UI still shows the read-only notice")
+	}
+}
+
+func TestConnectionStatusIgnoresOtherComponents(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+		WebPort:        0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	// Start publishes the web component as connected, which must not enable the controls
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	server.mu.RLock()
+	status := server.nefitStatusLocked()
+	server.mu.RUnlock()
+
+	if status != "" {
+		t.Errorf("nefit status = %q, want unset", status)
+	}
+}
+
+func TestSSEStreamOverHTTP(t *testing.T) {
+	tests := []struct {
+		name      string
+		http2     bool
+		wantProto string
+	}{
+		{name: "HTTP/1.1", http2: false, wantProto: "HTTP/1.1"},
+		{name: "HTTP/2", http2: true, wantProto: "HTTP/2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			// The SSE write timeout is shorter than the gaps between updates, so
+			// a deadline left set after a write would also cut the stream
+			cfg := &config.Config{
+				NefitSerial:        "TEST123",
+				HAPPin:             "12345678",
+				HAPStoragePath:     t.TempDir(),
+				HAPPort:            0,
+				WebPort:            0,
+				WebSSEWriteTimeout: 50 * time.Millisecond,
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+
+			// A short write timeout would cut the stream if it applied to /events
+			ts := httptest.NewUnstartedServer(server.server.Handler)
+			ts.Config.WriteTimeout = 100 * time.Millisecond
+			if tt.http2 {
+				ts.EnableHTTP2 = true
+				ts.StartTLS()
+			} else {
+				ts.Start()
+			}
+			defer ts.Close()
+
+			stream := dialSSE(t, ts.Client(), ts.URL+"/events")
+			defer stream.close()
+
+			if stream.resp.Proto != tt.wantProto {
+				t.Errorf("Proto = %s, want %s", stream.resp.Proto, tt.wantProto)
+			}
+			if got := stream.resp.Header.Get("Content-Type"); got != "text/event-stream" {
+				t.Errorf("Content-Type = %s, want text/event-stream", got)
+			}
+
+			// Send updates spread over more than the write timeout
+			for i, temp := range []float64{20.0, 20.5, 21.0} {
+				time.Sleep(75 * time.Millisecond)
+				server.updateState(events.StateUpdateEvent{
+					Source:             "nefit",
+					CurrentTemperature: temp,
+					Mode:               events.ModeHeat,
+				})
+
+				state := stream.nextState(1 * time.Second)
+				if state.CurrentTemperature != temp {
+					t.Errorf("update %d CurrentTemperature = %v, want %v", i, state.CurrentTemperature, temp)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderOutdoorTemperature(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:        0,
+		WebDisplayUnit: "celsius",
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	state := &events.StateUpdateEvent{
+		CurrentTemperature: 20.0,
+		TargetTemperature:  21.0,
+		Mode:               events.ModeHeat,
+		OutdoorTemperature: 4.5,
+	}
+	html := server.renderThermostatUI(state, "", nil)
+	if !strings.Contains(html, `id="outdoor-temp">Outdoor 4.5°C<`) {
+		t.Errorf("renderThermostatUI() missing the outdoor temperature:\n%s", html)
+	}
+	if !strings.Contains(html, "getElementById('outdoor-temp')") {
+		t.Error("renderThermostatUI() does not update the outdoor temperature from SSE")
+	}
+
+	// Unknown outdoor temperatures leave the span empty
+	state.OutdoorTemperature = 0
+	html = server.renderThermostatUI(state, "", nil)
+	if !strings.Contains(html, `id="outdoor-temp"></span>`) {
+		t.Error("renderThermostatUI() shows an unknown outdoor temperature")
+	}
+}
+
+func TestRenderSetpointSource(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+		WebPort:        0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"homekit", "homekit", "Last changed by HomeKit"},
+		{"web", "web", "Last changed by the web UI"},
+		{"nefit", "nefit", "Last changed by the thermostat"},
+		{"unknown", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := setpointSourceText(tt.source); got != tt.want {
+				t.Errorf("setpointSourceText(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+
+			state := &events.StateUpdateEvent{
+				CurrentTemperature: 20.0,
+				TargetTemperature:  21.0,
+				Mode:               events.ModeHeat,
+				SetpointSource:     tt.source,
+			}
+			html := server.renderThermostatUI(state, "", nil)
+			if tt.want != "" && !strings.Contains(html, tt.want) {
+				t.Errorf("renderThermostatUI() missing %q", tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatTemperature(t *testing.T) {
+	tests := []struct {
+		name  string
+		input float64
+		want  string
+	}{
+		{"exact", 21.5, "21.5"},
+		{"whole number", 21, "21.0"},
+		{"just below half step", 21.449999, "21.4"},
+		{"float noise below tenth", 21.499999, "21.5"},
+		{"float noise above tenth", 21.500001, "21.5"},
+		{"half rounds up", 21.45, "21.5"},
+		{"half rounds up again", 21.25, "21.3"},
+		{"below half rounds down", 21.44, "21.4"},
+		{"zero", 0, "0.0"},
+		{"small negative rounds to zero", -0.04, "0.0"},
+		{"negative half rounds up", -1.25, "-1.2"},
+		{"negative", -1.26, "-1.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatTemperature(tt.input); got != tt.want {
+				t.Errorf("formatTemperature(%v) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartFailsOnBusyPort(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+		WebPort:        ln.Addr().(*net.TCPAddr).Port,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	sub := eventbus.Subscribe[events.ConnectionStatusEvent](subscriberClient)
+	defer sub.Close()
+
+	if err := server.Start(); err == nil {
+		t.Fatal("Start() expected error for busy port, got nil")
+	}
+
+	select {
+	case event := <-sub.Events():
+		if event.Component != "web" {
+			t.Errorf("Component = %q, want web", event.Component)
+		}
+		if event.Status != events.ConnectionStatusFailed {
+			t.Errorf("Status = %q, want %q", event.Status, events.ConnectionStatusFailed)
+		}
+		if event.Error == "" {
+			t.Error("Error is empty, want bind error")
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for connection status event")
+	}
+}
+
+func TestHandleSetTemperatureFahrenheit(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+		WebPort:        0,
+		WebDisplayUnit: "fahrenheit",
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.CommandEvent](subscriberClient)
+	defer sub.Close()
+
+	tests := []struct {
+		name        string
+		temp        string
+		wantStatus  int
+		wantCelsius float64
+	}{
+		{
+			name:        "room temperature",
+			temp:        "72",
+			wantStatus:  http.StatusOK,
+			wantCelsius: 22.22,
+		},
+		{
+			name:        "min temperature",
+			temp:        "50",
+			wantStatus:  http.StatusOK,
+			wantCelsius: 10.0,
+		},
+		{
+			name:        "max temperature",
+			temp:        "86",
+			wantStatus:  http.StatusOK,
+			wantCelsius: 30.0,
+		},
+		{
+			name:       "too low",
+			temp:       "49",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "too high",
+			temp:       "87",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("temperature", tt.temp)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/temperature", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			server.handleSetTemperature(w, req)
+
+			resp := w.Result()
+			defer func() { _ = resp.Body.Close() }()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("handleSetTemperature() status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if body := w.Body.String(); !strings.Contains(body, "50-86°F") {
+					t.Errorf("handleSetTemperature() body = %q, want Fahrenheit range", body)
+				}
+				return
+			}
+
+			select {
+			case event := <-sub.Events():
+				if event.TargetTemperature == nil {
+					t.Fatal("event.TargetTemperature is nil")
+				}
+				if diff := *event.TargetTemperature - tt.wantCelsius; diff > 0.01 || diff < -0.01 {
+					t.Errorf("event.TargetTemperature = %v, want ~%v", *event.TargetTemperature, tt.wantCelsius)
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatal("timeout waiting for command event")
+			}
+		})
+	}
+}
+
+// brokenSSEWriter is a ResponseWriter whose client has gone away: flushing
+// succeeds but every write fails.
+type brokenSSEWriter struct {
+	header http.Header
+}
+
+func (w *brokenSSEWriter) Header() http.Header        { return w.header }
+func (w *brokenSSEWriter) WriteHeader(statusCode int) {}
+func (w *brokenSSEWriter) Flush()                     {}
+
+func (w *brokenSSEWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestHandleSSEClosesStream(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxLifetime time.Duration
+		writer      func() http.ResponseWriter
+	}{
+		{
+			name:   "write failure",
+			writer: func() http.ResponseWriter { return &brokenSSEWriter{header: make(http.Header)} },
+		},
+		{
+			name:        "max lifetime",
+			maxLifetime: 50 * time.Millisecond,
+			writer:      func() http.ResponseWriter { return httptest.NewRecorder() },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:        "TEST123",
+				HAPPin:             "12345678",
+				HAPStoragePath:     t.TempDir(),
+				HAPPort:            0,
+				WebPort:            0,
+				WebSSEWriteTimeout: time.Second,
+				WebSSEMaxLifetime:  tt.maxLifetime,
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+
+			req := httptest.NewRequest(http.MethodGet, "/events", nil)
+
+			done := make(chan struct{})
+			go func() {
+				server.handleSSE(tt.writer(), req)
+				close(done)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(1 * time.Second):
+				t.Fatal("handleSSE() did not return")
+			}
+
+			server.mu.RLock()
+			clients := len(server.sseClients)
+			server.mu.RUnlock()
+
+			if clients != 0 {
+				t.Errorf("sseClients = %d after stream closed, want 0", clients)
+			}
+		})
+	}
+}
+
+func TestComponentStatuses(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+		WebPort:        0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	go server.handleConnectionStatus()
+
+	nefitClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	homekitClient, err := bus.Client(events.ClientHomeKit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	bus.PublishConnectionStatus(nefitClient, events.ConnectionStatusEvent{
+		Component:  "nefit",
+		Status:     events.ConnectionStatusReconnecting,
+		Error:      "timeout",
+		Reconnects: 2,
+	})
+	bus.PublishConnectionStatus(homekitClient, events.ConnectionStatusEvent{
+		Component: "homekit",
+		Status:    events.ConnectionStatusConnected,
+	})
+
+	deadline := time.Now().Add(1 * time.Second)
+	for len(server.ComponentStatuses()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("ComponentStatuses() = %v, want nefit and homekit", server.ComponentStatuses())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		component  string
+		wantStatus events.ConnectionStatus
+		wantError  string
+	}{
+		{"nefit", events.ConnectionStatusReconnecting, "timeout"},
+		{"homekit", events.ConnectionStatusConnected, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.component, func(t *testing.T) {
+			event, ok := server.ComponentStatus(tt.component)
+			if !ok {
+				t.Fatalf("ComponentStatus(%q) not found", tt.component)
+			}
+			if event.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", event.Status, tt.wantStatus)
+			}
+			if event.Error != tt.wantError {
+				t.Errorf("Error = %q, want %q", event.Error, tt.wantError)
+			}
+		})
+	}
+
+	if _, ok := server.ComponentStatus("web"); ok {
+		t.Error("ComponentStatus(web) found before the web server published a status")
+	}
+}
+
+func TestConnectionNotice(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []events.ConnectionStatusEvent
+		wantNotice string
+	}{
+		{
+			name: "never connected after grace period",
+			statuses: []events.ConnectionStatusEvent{
+				{Component: "nefit", Status: events.ConnectionStatusReconnecting, NeverConnected: true},
+			},
+			wantNotice: setupHelpNotice,
+		},
+		{
+			name: "reconnecting after a successful connection",
+			statuses: []events.ConnectionStatusEvent{
+				{Component: "nefit", Status: events.ConnectionStatusConnected},
+				{Component: "nefit", Status: events.ConnectionStatusReconnecting},
+			},
+			wantNotice: disconnectedNotice,
+		},
+		{
+			name: "connected",
+			statuses: []events.ConnectionStatusEvent{
+				{Component: "nefit", Status: events.ConnectionStatusConnected},
+			},
+			wantNotice: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:    "TEST123",
+				HAPPin:         "12345678",
+				HAPStoragePath: t.TempDir(),
+				HAPPort:        0,
+				WebPort:        0,
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+
+			for _, status := range tt.statuses {
+				server.updateConnectionStatus(status)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			w := httptest.NewRecorder()
+			server.handleIndex(w, req)
+			body := w.Body.String()
+
+			for _, notice := range []string{setupHelpNotice, disconnectedNotice} {
+				shown := strings.Contains(body, ">"+notice+"<")
+				if want := notice == tt.wantNotice; shown != want {
+					t.Errorf("notice %q shown = %v, want %v", notice, shown, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleStateETag(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		HAPPort:        0,
+		WebPort:        0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/state", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		server.handleState(w, req)
+		return w
+	}
+
+	if w := get(""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status before any state = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	} else if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After before any state = %q, want 1", got)
+	}
+
+	state := events.StateUpdateEvent{
+		Timestamp:          time.Now(),
+		Source:             "nefit",
+		CurrentTemperature: 20.5,
+		TargetTemperature:  21.0,
+		Mode:               events.ModeHeat,
+	}
+	server.updateState(state)
+
+	w := get("")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("ETag header is empty")
+	}
+	var got events.StateUpdateEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode state: %v", err)
+	}
+	if !got.Equals(state) {
+		t.Errorf("state = %+v, want %+v", got, state)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{"matching etag", etag, http.StatusNotModified},
+		{"weak matching etag", "W/" + etag, http.StatusNotModified},
+		{"etag in list", `"other", ` + etag, http.StatusNotModified},
+		{"wildcard", "*", http.StatusNotModified},
+		{"stale etag", `"0000000000000000"`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(tt.ifNoneMatch)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 response has body %q", w.Body.String())
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+		})
+	}
+
+	// Timestamp and source do not change the ETag, like Equals
+	state.Timestamp = state.Timestamp.Add(time.Minute)
+	state.Source = "web"
+	server.updateState(state)
+	if w := get(etag); w.Code != http.StatusNotModified {
+		t.Errorf("status after timestamp-only change = %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	state.TargetTemperature = 22.0
+	server.updateState(state)
+	w = get(etag)
+	if w.Code != http.StatusOK {
+		t.Errorf("status after target change = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("ETag"); got == etag {
+		t.Error("ETag did not change after target temperature changed")
+	}
+}
+
+func TestHandleHistory(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		updates     int
+		wantSamples int
+		wantChart   bool
+	}{
+		{"disabled", 0, 3, 0, false},
+		{"partially filled", 5, 3, 3, true},
+		{"full ring", 2, 3, 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:        "TEST123",
+				HAPPin:             "12345678",
+				HAPStoragePath:     t.TempDir(),
+				WebHistorySize:     tt.size,
+				WebHistoryInterval: time.Minute,
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+
+			start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+			for i := 0; i < tt.updates; i++ {
+				server.updateState(events.StateUpdateEvent{
+					Timestamp:          start.Add(time.Duration(i) * time.Minute),
+					Source:             "nefit",
+					CurrentTemperature: 20 + float64(i)*0.5,
+					TargetTemperature:  21,
+					Mode:               events.ModeHeat,
+				})
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/history", nil)
+			w := httptest.NewRecorder()
+			server.handleHistory(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			var resp historyResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode history: %v", err)
+			}
+			if len(resp.Samples) != tt.wantSamples {
+				t.Errorf("got %d samples, want %d", len(resp.Samples), tt.wantSamples)
+			}
+			if resp.IntervalSeconds != 60 {
+				t.Errorf("interval_seconds = %v, want 60", resp.IntervalSeconds)
+			}
+			if n := len(resp.Samples); n > 0 {
+				newest := resp.Samples[n-1]
+				if want := 20 + float64(tt.updates-1)*0.5; newest.Current != want {
+					t.Errorf("newest sample current = %v, want %v", newest.Current, want)
+				}
+			}
+
+			w = httptest.NewRecorder()
+			server.handleIndex(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if got := strings.Contains(w.Body.String(), `id="history-chart"><svg`); got != tt.wantChart {
+				t.Errorf("index has chart = %v, want %v", got, tt.wantChart)
+			}
+		})
+	}
+}
+
+func TestIndexHistoryChartScript(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:        "TEST123",
+		HAPPin:             "12345678",
+		HAPStoragePath:     t.TempDir(),
+		WebHistorySize:     10,
+		WebHistoryInterval: time.Minute,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	w := httptest.NewRecorder()
+	server.handleIndex(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := w.Body.String()
+
+	for _, want := range []string{
+		`id="history-chart"`,
+		`id="history-range"`,
+		`fetch("/api/history")`,
+		`function renderSparkline(samples)`,
+		// Script operators must not be HTML-escaped
+		`data.Mode === 'off' && data.ComfortTemperature > 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("index page missing %q", want)
+		}
+	}
+	if strings.Contains(body, "&amp;&amp;") {
+		t.Error("index page script contains HTML-escaped operators")
+	}
+}
+
+func TestWebTitle(t *testing.T) {
+	tests := []struct {
+		name      string
+		title     string
+		wantIndex string
+		wantDebug string
+	}{
+		{
+			name:      "default",
+			title:     "",
+			wantIndex: "<title>Nefit Easy Thermostat</title>",
+			wantDebug: "<title>EventBus Debug - Nefit Easy Thermostat</title>",
+		},
+		{
+			name:      "custom",
+			title:     "Upstairs",
+			wantIndex: "<h1>Upstairs</h1>",
+			wantDebug: "<h1>Upstairs EventBus Debugger</h1>",
+		},
+		{
+			name:      "special characters",
+			title:     `Up & Down <script>alert("x")</script>`,
+			wantIndex: `<h1>Up &amp; Down &lt;script&gt;alert("x")&lt;/script&gt;</h1>`,
+			wantDebug: `<h1>Up &amp; Down &lt;script&gt;alert("x")&lt;/script&gt; EventBus Debugger</h1>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				NefitSerial:    "TEST123",
+				HAPPin:         "12345678",
+				HAPStoragePath: t.TempDir(),
+				WebTitle:       tt.title,
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+
+			index := server.renderThermostatUI(nil, "", nil)
+			if !strings.Contains(index, tt.wantIndex) {
+				t.Errorf("index page missing %q", tt.wantIndex)
+			}
+
+			debug := server.renderEventBusDebug()
+			if !strings.Contains(debug, tt.wantDebug) {
+				t.Errorf("debug page missing %q", tt.wantDebug)
+			}
+
+			for page, html := range map[string]string{"index": index, "debug": debug} {
+				if strings.Contains(html, `<script>alert(`) {
+					t.Errorf("%s page contains the title unescaped", page)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderEscapesDynamicContent(t *testing.T) {
+	const (
+		scriptPayload = `<script>alert(1)</script>`
+		attrPayload   = `"><img src=x onerror=alert(1)>`
+	)
+
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		HAPPin:         "12345678",
+		HAPStoragePath: t.TempDir(),
+		WebTitle:       attrPayload,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	server.updateState(events.StateUpdateEvent{
+		Source:             scriptPayload,
+		CurrentTemperature: 20.5,
+		TargetTemperature:  21.0,
+		Mode:               events.Mode(attrPayload),
+		SetpointSource:     scriptPayload,
+		FirmwareVersion:    scriptPayload,
+	})
+	server.updateConnectionStatus(events.ConnectionStatusEvent{
+		Component: "nefit",
+		Status:    events.ConnectionStatusReconnecting,
+		Error:     scriptPayload,
+	})
+
+	w := httptest.NewRecorder()
+	server.handleIndex(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	pages := map[string]string{
+		"index": w.Body.String(),
+		"debug": server.renderEventBusDebug(),
+	}
+
+	for page, body := range pages {
+		for _, payload := range []string{scriptPayload, attrPayload, "<img"} {
+			if strings.Contains(body, payload) {
+				t.Errorf("%s page contains unescaped %q", page, payload)
+			}
+		}
+	}
+
+	// The state JSON on the debug page still shows the injected fields, escaped
+	if want := `\u003cscript\u003ealert(1)`; !strings.Contains(pages["debug"], want) {
+		t.Errorf("debug page missing escaped payload %q", want)
+	}
+}
+
+func TestAttrValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"21.5", "21.5"},
+		{`"><script>`, "&#34;&gt;&lt;script&gt;"},
+		{"'quoted'", "&#39;quoted&#39;"},
+		{"a&b", "a&amp;b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := attrValue(tt.in); got != tt.want {
+				t.Errorf("attrValue(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"°C", `"°C"`},
+		{`it's "x"`, `"it's \"x\""`},
+		{"</script><script>alert(1)", `"\u003c/script\u003e\u003cscript\u003ealert(1)"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := jsString(tt.in); got != tt.want {
+				t.Errorf("jsString(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMethodRouting(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:        0,
+		WebDisplayUnit: "celsius",
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+		wantAllow  string
+	}{
+		{http.MethodGet, "/api/temperature", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodGet, "/api/mode", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodPost, "/api/state", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodDelete, "/health", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodGet, "/health", http.StatusOK, ""},
+		{http.MethodGet, "/", http.StatusOK, ""},
+		{http.MethodGet, "/unknown", http.StatusNotFound, ""},
+		{http.MethodHead, "/health", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+
+			server.mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestHandlePresence(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:                    0,
+		WebDisplayUnit:             "celsius",
+		PresenceComfortTemperature: 21.5,
+		PresenceSetbackTemperature: 16.0,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	subscriberClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	sub := eventbus.Subscribe[events.CommandEvent](subscriberClient)
+	defer sub.Close()
+
+	tests := []struct {
+		name       string
+		presence   string
+		wantStatus int
+		wantTemp   float64
+	}{
+		{
+			name:       "away applies setback",
+			presence:   "away",
+			wantStatus: http.StatusOK,
+			wantTemp:   16.0,
+		},
+		{
+			name:       "home applies comfort",
+			presence:   "home",
+			wantStatus: http.StatusOK,
+			wantTemp:   21.5,
+		},
+		{
+			name:       "invalid presence",
+			presence:   "vacation",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("presence", tt.presence)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/presence", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			server.mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("handlePresence() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			select {
+			case event := <-sub.Events():
+				if event.Source != "presence" {
+					t.Errorf("event.Source = %v, want presence", event.Source)
+				}
+				if event.CommandType != events.CommandTypeSetTemperature {
+					t.Errorf("event.CommandType = %v, want %v", event.CommandType, events.CommandTypeSetTemperature)
+				}
+				if event.TargetTemperature == nil || *event.TargetTemperature != tt.wantTemp {
+					t.Errorf("event.TargetTemperature = %v, want %v", event.TargetTemperature, tt.wantTemp)
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatal("timeout waiting for command event")
+			}
+		})
+	}
+
+	// Without configured setpoints the endpoint does not exist
+	server.cfg.PresenceComfortTemperature = 0
+	server.cfg.PresenceSetbackTemperature = 0
+
+	req := httptest.NewRequest(http.MethodPost, "/api/presence", strings.NewReader("presence=away"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	server.mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("disabled status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestPprofEndpoints(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	tests := []struct {
+		name       string
+		enabled    bool
+		wantStatus int
+	}{
+		{name: "enabled", enabled: true, wantStatus: http.StatusOK},
+		{name: "disabled", enabled: false, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				WebPort:        0,
+				WebDisplayUnit: "celsius",
+				PprofEnabled:   tt.enabled,
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+
+			for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1"} {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				w := httptest.NewRecorder()
+
+				server.mux.ServeHTTP(w, req)
+
+				if w.Code != tt.wantStatus {
+					t.Errorf("GET %s status = %d, want %d", path, w.Code, tt.wantStatus)
+				}
+			}
+		})
+	}
+}
+
+func TestPprofTraceOutlastsWriteTimeout(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:        0,
+		WebDisplayUnit: "celsius",
+		PprofEnabled:   true,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	ts := httptest.NewUnstartedServer(server.server.Handler)
+	ts.Config.WriteTimeout = 200 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	// A trace longer than the WriteTimeout is still delivered in full
+	resp, err := ts.Client().Get(ts.URL + "/debug/pprof/trace?seconds=0.5")
+	if err != nil {
+		t.Fatalf("GET /debug/pprof/trace error = %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading trace error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", resp.StatusCode, http.StatusOK, body)
+	}
+	if len(body) == 0 {
+		t.Error("trace is empty")
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:        0,
+		WebDisplayUnit: "celsius",
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	// Registered on the default registry, like in main, which /metrics serves
+	collector, err := metrics.New(logger, bus, prometheus.DefaultRegisterer)
+	if err != nil {
+		t.Fatalf("metrics.New() error = %v", err)
+	}
+	defer func() {
+		_ = collector.Close()
+	}()
+	if err := collector.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	nefitClient, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	bus.PublishStateUpdate(nefitClient, events.StateUpdateEvent{
+		Source:             "nefit",
+		CurrentTemperature: 20.5,
+		TargetTemperature:  21,
+		Mode:               events.ModeHeat,
+	})
+
+	const want = "nefit_current_temperature_celsius 20.5"
+	deadline := time.Now().Add(time.Second)
+	for {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+
+		server.mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /metrics status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if strings.Contains(w.Body.String(), want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GET /metrics does not contain %q:\n%s", want, w.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLifecycleDoesNotLeakGoroutines(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:            0,
+		WebDisplayUnit:     "celsius",
+		WebSSEWriteTimeout: time.Second,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	ts := httptest.NewServer(server.server.Handler)
+	defer ts.Close()
+	defer ts.Client().CloseIdleConnections()
+
+	// An SSE stream that is open while the server closes
+	resp, err := ts.Client().Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatalf("GET /events error = %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	publisher, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	bus.PublishStateUpdate(publisher, events.StateUpdateEvent{
+		Source:             "nefit",
+		CurrentTemperature: 20.5,
+		TargetTemperature:  21.0,
+		Mode:               events.ModeHeat,
+	})
+
+	if _, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil {
+		t.Fatalf("reading SSE stream error = %v", err)
+	}
+}
+
+func TestWebBasePath(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:              0,
+		WebDisplayUnit:       "celsius",
+		WebBasePath:          "/nefit",
+		EventBusDebugEnabled: true,
+		PprofEnabled:         true,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	routes := []struct {
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{http.MethodGet, "/nefit/", http.StatusOK},
+		{http.MethodGet, "/nefit/health", http.StatusOK},
+		{http.MethodGet, "/nefit/api/history", http.StatusOK},
+		{http.MethodGet, "/nefit/debug/eventbus", http.StatusOK},
+		{http.MethodGet, "/nefit/debug/pprof/", http.StatusOK},
+		{http.MethodGet, "/nefit/debug/pprof/goroutine?debug=1", http.StatusOK},
+		{http.MethodGet, "/nefit/api/temperature", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/", http.StatusNotFound},
+		{http.MethodGet, "/health", http.StatusNotFound},
+	}
+
+	for _, tt := range routes {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	t.Run("rendered URLs", func(t *testing.T) {
+		pages := map[string][]string{
+			"/nefit/": {
+				`hx-post="/nefit/api/temperature"`,
+				`hx-post="/nefit/api/mode"`,
+				`href="/nefit/debug/eventbus"`,
+				`href="/nefit/metrics"`,
+				`new EventSource("/nefit/events")`,
+				`fetch("/nefit/api/history")`,
+			},
+			"/nefit/debug/eventbus": {
+				`href="/nefit/"`,
+				`href="/nefit/debug/events.json"`,
+				`href="/nefit/debug/events.csv"`,
+			},
+		}
+
+		for page, wants := range pages {
+			w := httptest.NewRecorder()
+			server.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, page, nil))
+			body := w.Body.String()
+
+			for _, want := range wants {
+				if !strings.Contains(body, want) {
+					t.Errorf("%s missing %q", page, want)
+				}
+			}
+		}
+	})
+}
+
+func TestHandleEnergy(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:               0,
+		WebDisplayUnit:        "celsius",
+		XMPPKeepaliveInterval: 30 * time.Second,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	get := func() *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/energy", nil)
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status before any energy = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	} else if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After before any energy = %q, want 30", got)
+	}
+
+	// Published before Start, like a read right after nefit connects
+	publisher, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+	bus.PublishEnergy(publisher, events.EnergyEvent{
+		Source: "nefit",
+		Days: []events.EnergyUsage{
+			{Date: time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC), CentralHeating: 12.5, HotWater: 2.0},
+		},
+	})
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	w := get()
+	for w.Code != http.StatusOK && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		w = get()
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	var got events.EnergyEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode energy: %v", err)
+	}
+	if len(got.Days) != 1 || got.Days[0].CentralHeating != 12.5 || got.Days[0].HotWater != 2.0 {
+		t.Errorf("energy = %+v, want one day with 12.5 kWh heating and 2 kWh hot water", got)
+	}
+}
+
+func TestSliderStep(t *testing.T) {
+	tests := []struct {
+		name        string
+		step        float64
+		displayUnit string
+		want        string
+	}{
+		{name: "default", displayUnit: "celsius", want: `step="0.5"`},
+		{name: "whole degrees", step: 1.0, displayUnit: "celsius", want: `step="1"`},
+		{name: "tenths", step: 0.1, displayUnit: "celsius", want: `step="0.1"`},
+		{name: "fahrenheit whole degrees", step: 0.5, displayUnit: "fahrenheit", want: `step="1"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				WebDisplayUnit:        tt.displayUnit,
+				TargetTemperatureStep: tt.step,
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+
+			index := server.renderThermostatUI(nil, "", nil)
+			if !strings.Contains(index, tt.want) {
+				t.Errorf("index page missing slider %s", tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleConnection(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:        0,
+		WebDisplayUnit: "celsius",
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	get := func() map[string]events.ConnectionStatusEvent {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/connection", nil)
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+
+		var statuses map[string]events.ConnectionStatusEvent
+		if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil {
+			t.Fatalf("failed to decode connection statuses: %v", err)
+		}
+		return statuses
+	}
+
+	if statuses := get(); len(statuses) != 0 {
+		t.Errorf("statuses before any event = %v, want none", statuses)
+	}
+
+	server.updateConnectionStatus(events.ConnectionStatusEvent{
+		Component:   "nefit",
+		Status:      events.ConnectionStatusReconnecting,
+		Error:       "connection refused",
+		Reconnects:  3,
+		NextRetryIn: 20 * time.Second,
+	})
+	server.updateConnectionStatus(events.ConnectionStatusEvent{
+		Component: "homekit",
+		Status:    events.ConnectionStatusConnected,
+	})
+
+	statuses := get()
+	if len(statuses) != 2 {
+		t.Fatalf("statuses = %v, want nefit and homekit", statuses)
+	}
+
+	nefit := statuses["nefit"]
+	if nefit.Status != events.ConnectionStatusReconnecting || nefit.Error != "connection refused" ||
+		nefit.Reconnects != 3 || nefit.NextRetryIn != 20*time.Second {
+		t.Errorf("nefit = %+v, want reconnecting after 3 attempts with its error and backoff", nefit)
+	}
+
+	homekit := statuses["homekit"]
+	if homekit.Status != events.ConnectionStatusConnected || homekit.Error != "" {
+		t.Errorf("homekit = %+v, want connected without error", homekit)
+	}
+}
+
+func TestHandleConfig(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:              "TEST123",
+		NefitAccessKey:           "secret-access-key",
+		NefitPassword:            "secret-password",
+		HAPPin:                   "12345678",
+		TailscaleAuthKey:         "tskey-secret",
+		WebPort:                  0,
+		WebDisplayUnit:           "celsius",
+		WebConfigEndpointEnabled: true,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+	w := httptest.NewRecorder()
+	server.mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	body := w.Body.String()
+	for _, secret := range []string{"secret-access-key", "secret-password", "12345678", "tskey-secret"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("config response contains secret %q: %s", secret, body)
+		}
+	}
+
+	var got config.Config
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+	if got.NefitSerial != "TEST123" || got.WebDisplayUnit != "celsius" {
+		t.Errorf("config = %+v, want the non-secret fields", got)
+	}
+	if got.NefitPassword != "REDACTED" {
+		t.Errorf("NefitPassword = %q, want REDACTED", got.NefitPassword)
+	}
+	if cfg.NefitPassword != "secret-password" {
+		t.Errorf("serving the config changed NefitPassword to %q", cfg.NefitPassword)
+	}
+}
+
+func TestHandleConfigDisabled(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		NefitSerial:    "TEST123",
+		WebPort:        0,
+		WebDisplayUnit: "celsius",
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+	w := httptest.NewRecorder()
+	server.mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d without NEFITHK_WEB_CONFIG_ENDPOINT_ENABLED", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,441 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+// sseEvent is one event read from an SSE stream.
+type sseEvent struct {
+	name string // Empty for plain data messages, which carry state updates
+	data string
+}
+
+// sseClient reads an SSE stream over a real connection, one event at a time
+// as it arrives, like a browser's EventSource. Unlike a ResponseRecorder, it
+// notices when the server buffers events instead of flushing them.
+type sseClient struct {
+	t      testing.TB
+	resp   *http.Response
+	events chan sseEvent
+	cancel context.CancelFunc
+}
+
+// dialSSE opens the SSE stream at url with client and starts reading it.
+// Callers must close the client before closing the test server, which waits
+// for open streams.
+func dialSSE(t testing.TB, client *http.Client, url string) *sseClient {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatalf("GET %s error = %v", url, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		cancel()
+		t.Fatalf("GET %s status = %d, want %d", url, resp.StatusCode, http.StatusOK)
+	}
+
+	c := &sseClient{
+		t:      t,
+		resp:   resp,
+		events: make(chan sseEvent, 100),
+		cancel: cancel,
+	}
+	go c.read()
+
+	return c
+}
+
+// close ends the stream.
+func (c *sseClient) close() {
+	c.cancel()
+	_ = c.resp.Body.Close()
+}
+
+// read parses the stream into events until it ends. Comments such as
+// keepalives are skipped.
+func (c *sseClient) read() {
+	defer close(c.events)
+
+	scanner := bufio.NewScanner(c.resp.Body)
+	var event sseEvent
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data != nil {
+				event.data = strings.Join(data, "\n")
+				c.events <- event
+			}
+			event, data = sseEvent{}, nil
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+	}
+}
+
+// next returns the next event, failing the test if none arrives within
+// timeout. It returns false once the stream has ended.
+func (c *sseClient) next(timeout time.Duration) (sseEvent, bool) {
+	c.t.Helper()
+
+	select {
+	case event, ok := <-c.events:
+		return event, ok
+	case <-time.After(timeout):
+		c.t.Fatalf("no SSE event within %s", timeout)
+		return sseEvent{}, false
+	}
+}
+
+// nextState returns the next state update, skipping other events such as
+// connection status, and fails the test if the stream ends first.
+func (c *sseClient) nextState(timeout time.Duration) events.StateUpdateEvent {
+	c.t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		event, ok := c.next(time.Until(deadline))
+		if !ok {
+			c.t.Fatal("SSE stream ended while waiting for a state update")
+		}
+		if event.name != "" {
+			continue
+		}
+
+		var state events.StateUpdateEvent
+		if err := json.Unmarshal([]byte(event.data), &state); err != nil {
+			c.t.Fatalf("failed to decode SSE state %q: %v", event.data, err)
+		}
+		return state
+	}
+}
+
+// expectNone fails the test if an event arrives within d.
+func (c *sseClient) expectNone(d time.Duration) {
+	c.t.Helper()
+
+	select {
+	case event, ok := <-c.events:
+		if ok {
+			c.t.Errorf("unexpected SSE event %q: %s", event.name, event.data)
+		}
+	case <-time.After(d):
+	}
+}
+
+func TestSSEDeliversEventsAsPublished(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:            0,
+		WebSSEWriteTimeout: 10 * time.Second,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	// Start subscribes to state updates, which are then served over a real
+	// connection by the test server
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	ts := httptest.NewServer(server.server.Handler)
+	defer ts.Close()
+
+	stream := dialSSE(t, ts.Client(), ts.URL+"/events")
+	defer stream.close()
+
+	// The connection status is sent as soon as the stream opens
+	if event, ok := stream.next(time.Second); !ok || event.name != "connection" {
+		t.Fatalf("first event = %q, %v, want connection", event.name, ok)
+	}
+
+	publisher, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	// Each update must arrive before the next one is published, while the
+	// stream stays open, so buffering until close would fail here
+	for i, temp := range []float64{20.0, 20.5, 21.0} {
+		bus.PublishStateUpdate(publisher, events.StateUpdateEvent{
+			Source:             "nefit",
+			CurrentTemperature: temp,
+			Mode:               events.ModeHeat,
+		})
+
+		state := stream.nextState(time.Second)
+		if state.CurrentTemperature != temp {
+			t.Errorf("update %d CurrentTemperature = %v, want %v", i, state.CurrentTemperature, temp)
+		}
+		stream.expectNone(50 * time.Millisecond)
+	}
+}
+
+func TestSSEBroadcastKeepsOrderAndDropsOldest(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	server, err := New(&config.Config{WebPort: 0}, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	// A client that does not read while more updates arrive than it can queue
+	client := make(chan sseMessage, sseClientBuffer)
+	server.mu.Lock()
+	server.sseClients[client] = struct{}{}
+	server.mu.Unlock()
+
+	const updates = sseClientBuffer + 5
+	for i := range updates {
+		server.updateState(events.StateUpdateEvent{
+			Source:             "nefit",
+			CurrentTemperature: float64(i),
+		})
+	}
+
+	// The oldest updates were dropped, the newest ones are queued in order
+	for want := updates - sseClientBuffer; want < updates; want++ {
+		select {
+		case msg := <-client:
+			state, ok := msg.data.(events.StateUpdateEvent)
+			if !ok {
+				t.Fatalf("queued message %T, want a state update", msg.data)
+			}
+			if state.CurrentTemperature != float64(want) {
+				t.Errorf("queued CurrentTemperature = %v, want %v", state.CurrentTemperature, float64(want))
+			}
+		default:
+			t.Fatalf("queue ended before update %d", want)
+		}
+	}
+
+	select {
+	case msg := <-client:
+		t.Errorf("unexpected queued message: %+v", msg)
+	default:
+	}
+}
+
+func TestSSEDeliversRapidUpdatesInOrder(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:            0,
+		WebSSEWriteTimeout: 10 * time.Second,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	ts := httptest.NewServer(server.server.Handler)
+	defer ts.Close()
+
+	stream := dialSSE(t, ts.Client(), ts.URL+"/events")
+	defer stream.close()
+
+	if event, ok := stream.next(time.Second); !ok || event.name != "connection" {
+		t.Fatalf("first event = %q, %v, want connection", event.name, ok)
+	}
+
+	// Published back to back, without waiting for the stream. Updates may be
+	// dropped under load, but never reordered, and the last one always arrives.
+	const updates = 50
+	for i := range updates {
+		server.updateState(events.StateUpdateEvent{
+			Source:             "nefit",
+			CurrentTemperature: float64(i),
+		})
+	}
+
+	last := -1.0
+	for last < updates-1 {
+		state := stream.nextState(time.Second)
+		if state.CurrentTemperature <= last {
+			t.Fatalf("CurrentTemperature %v arrived after %v", state.CurrentTemperature, last)
+		}
+		last = state.CurrentTemperature
+	}
+}
+
+func TestSSERefreshesDeduplicatedState(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:               0,
+		WebSSEWriteTimeout:    10 * time.Second,
+		WebSSERefreshInterval: 100 * time.Millisecond,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	ts := httptest.NewServer(server.server.Handler)
+	defer ts.Close()
+
+	publisher, err := bus.Client(events.ClientNefit)
+	if err != nil {
+		t.Fatalf("Client() error = %v", err)
+	}
+
+	state := events.StateUpdateEvent{
+		Source:             "nefit",
+		CurrentTemperature: 20.5,
+		Mode:               events.ModeHeat,
+	}
+	bus.PublishStateUpdate(publisher, state)
+
+	stream := dialSSE(t, ts.Client(), ts.URL+"/events")
+	defer stream.close()
+
+	if got := stream.nextState(time.Second); got.CurrentTemperature != 20.5 {
+		t.Fatalf("initial CurrentTemperature = %v, want 20.5", got.CurrentTemperature)
+	}
+
+	// The bus drops this identical update, the refresh still sends the state
+	bus.PublishStateUpdate(publisher, state)
+	if got := bus.Stats().DuplicateStateUpdates; got != 1 {
+		t.Fatalf("deduplicated state updates = %d, want 1", got)
+	}
+
+	if got := stream.nextState(time.Second); got.CurrentTemperature != 20.5 {
+		t.Errorf("refreshed CurrentTemperature = %v, want 20.5", got.CurrentTemperature)
+	}
+}
+
+func TestSSEFinalConnectionEventOnClose(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:            0,
+		WebSSEWriteTimeout: 10 * time.Second,
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	ts := httptest.NewServer(server.server.Handler)
+	defer ts.Close()
+
+	stream := dialSSE(t, ts.Client(), ts.URL+"/events")
+	defer stream.close()
+
+	if event, _ := stream.next(time.Second); event.name != "connection" {
+		t.Fatalf("first event = %q, want connection", event.name)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	event, ok := stream.next(time.Second)
+	if !ok {
+		t.Fatal("stream ended without a final connection event")
+	}
+	if event.name != "connection" {
+		t.Fatalf("final event = %q, want connection", event.name)
+	}
+
+	var msg connectionMessage
+	if err := json.Unmarshal([]byte(event.data), &msg); err != nil {
+		t.Fatalf("failed to decode connection event: %v", err)
+	}
+	if msg.Connected || msg.Status != events.ConnectionStatusDisconnected || msg.Notice != shutdownNotice {
+		t.Errorf("final connection event = %+v, want disconnected with the shutdown notice", msg)
+	}
+
+	// The stream ends after the final event
+	if event, ok := stream.next(time.Second); ok {
+		t.Errorf("event after the final connection event: %+v", event)
+	}
+}
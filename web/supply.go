@@ -0,0 +1,93 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/chasefleming/elem-go"
+	"github.com/chasefleming/elem-go/attrs"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+)
+
+// handleSetSupplySetpoint handles boiler supply temperature setpoint changes.
+// The setpoint is always in Celsius, as in the Nefit app, whatever the
+// display unit.
+func (s *Server) handleSetSupplySetpoint(w http.ResponseWriter, r *http.Request) {
+	if !parseForm(w, r) {
+		return
+	}
+
+	setpoint, err := strconv.ParseFloat(r.FormValue("supply_setpoint"), 64)
+	if err != nil {
+		http.Error(w, "Invalid supply setpoint value", http.StatusBadRequest)
+		return
+	}
+
+	if setpoint < events.MinSupplySetpoint || setpoint > events.MaxSupplySetpoint {
+		http.Error(w, fmt.Sprintf("Supply setpoint out of range (%s-%s°C)",
+			strconv.FormatFloat(events.MinSupplySetpoint, 'f', -1, 64),
+			strconv.FormatFloat(events.MaxSupplySetpoint, 'f', -1, 64),
+		), http.StatusBadRequest)
+		return
+	}
+
+	// Publish command event
+	event := events.CommandEvent{
+		Source:         "web",
+		CommandType:    events.CommandTypeSetSupplySetpoint,
+		SupplySetpoint: &setpoint,
+	}
+	if s.publishCommand(event) {
+		s.logger.Info("supply setpoint changed via web",
+			zap.Float64("setpoint", setpoint),
+		)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("OK"))
+}
+
+// renderSupplySetpoint renders the supply setpoint control, only shown when
+// NEFITHK_ADVANCED_SUPPLY_SETPOINT_ENABLED is set.
+func (s *Server) renderSupplySetpoint(state *events.StateUpdateEvent, disabled string) elem.Node {
+	if !s.cfg.AdvancedSupplySetpointEnabled {
+		return elem.None()
+	}
+
+	current := "Unknown"
+	value := ""
+	if state != nil && state.SupplySetpoint > 0 {
+		current = formatTemperature(state.SupplySetpoint) + "°C"
+		value = strconv.FormatFloat(state.SupplySetpoint, 'f', -1, 64)
+	}
+
+	return elem.Div(attrs.Props{attrs.Class: "control-card"},
+		elem.H2(nil, elem.Text("Supply Temperature Setpoint")),
+		elem.Div(attrs.Props{attrs.Class: "setpoint-source"},
+			elem.Text("Advanced: a high setpoint keeps the boiler from condensing and wastes gas"),
+		),
+		elem.Div(attrs.Props{attrs.Class: "temp-value", attrs.ID: "supply-setpoint"}, elem.Text(current)),
+		elem.Form(s.controlForm("/api/supply-setpoint", "#supply-response"),
+			elem.Div(attrs.Props{attrs.Class: "mode-buttons"},
+				elem.Input(attrs.Props{
+					attrs.Type:     "number",
+					attrs.Name:     "supply_setpoint",
+					attrs.Min:      strconv.FormatFloat(events.MinSupplySetpoint, 'f', -1, 64),
+					attrs.Max:      strconv.FormatFloat(events.MaxSupplySetpoint, 'f', -1, 64),
+					attrs.Step:     "1",
+					attrs.Value:    attrValue(value),
+					attrs.Class:    "supply-input",
+					attrs.Disabled: disabled,
+				}),
+				elem.Button(attrs.Props{
+					attrs.Type:     "submit",
+					attrs.Class:    "mode-btn supply-btn",
+					attrs.Disabled: disabled,
+				}, elem.Text("Set")),
+			),
+		),
+		elem.Div(attrs.Props{attrs.ID: "supply-response"}),
+	)
+}
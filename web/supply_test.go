@@ -0,0 +1,126 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"go.uber.org/zap"
+	"tailscale.com/util/eventbus"
+)
+
+func TestHandleSetSupplySetpoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		setpoint   string
+		wantStatus int
+	}{
+		{name: "valid setpoint", enabled: true, setpoint: "55", wantStatus: http.StatusOK},
+		{name: "below minimum", enabled: true, setpoint: "25", wantStatus: http.StatusBadRequest},
+		{name: "above maximum", enabled: true, setpoint: "95", wantStatus: http.StatusBadRequest},
+		{name: "not a number", enabled: true, setpoint: "hot", wantStatus: http.StatusBadRequest},
+		{name: "advanced features disabled", setpoint: "55", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := zap.NewNop()
+			bus, err := events.New(logger)
+			if err != nil {
+				t.Fatalf("events.New() error = %v", err)
+			}
+			defer func() {
+				_ = bus.Close()
+			}()
+
+			cfg := &config.Config{
+				WebPort:                       0,
+				AdvancedSupplySetpointEnabled: tt.enabled,
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+
+			subscriberClient, err := bus.Client(events.ClientNefit)
+			if err != nil {
+				t.Fatalf("Client() error = %v", err)
+			}
+
+			sub := eventbus.Subscribe[events.CommandEvent](subscriberClient)
+			defer sub.Close()
+
+			form := url.Values{"supply_setpoint": {tt.setpoint}}
+			req := httptest.NewRequest(http.MethodPost, "/api/supply-setpoint", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			server.server.Handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				select {
+				case event := <-sub.Events():
+					t.Errorf("unexpected command published: %+v", event)
+				case <-time.After(50 * time.Millisecond):
+				}
+				return
+			}
+
+			select {
+			case event := <-sub.Events():
+				if event.CommandType != events.CommandTypeSetSupplySetpoint {
+					t.Errorf("event.CommandType = %v, want %v", event.CommandType, events.CommandTypeSetSupplySetpoint)
+				}
+				if event.SupplySetpoint == nil || *event.SupplySetpoint != 55 {
+					t.Errorf("event.SupplySetpoint = %v, want 55", event.SupplySetpoint)
+				}
+			case <-time.After(1 * time.Second):
+				t.Fatal("timeout waiting for command event")
+			}
+		})
+	}
+}
+
+func TestRenderSupplySetpoint(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	state := &events.StateUpdateEvent{CurrentTemperature: 20.5, TargetTemperature: 21, SupplySetpoint: 55}
+
+	for _, enabled := range []bool{false, true} {
+		server, err := New(&config.Config{AdvancedSupplySetpointEnabled: enabled}, logger, bus)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		html := server.renderThermostatUI(state, "", nil)
+		_ = server.Close()
+
+		if got := strings.Contains(html, `id="supply-setpoint"`); got != enabled {
+			t.Errorf("enabled=%v: supply setpoint shown = %v", enabled, got)
+		}
+		if enabled && !strings.Contains(html, "55.0°C") {
+			t.Error("supply setpoint value not shown")
+		}
+	}
+}
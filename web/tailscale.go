@@ -0,0 +1,60 @@
+package web
+
+import (
+	"fmt"
+	"net"
+
+	"go.uber.org/zap"
+	"tailscale.com/tsnet"
+)
+
+// tailscaleAddr is the address the web interface listens on in the tailnet.
+const tailscaleAddr = ":80"
+
+// tailscaleNode is the tailnet node the web interface is served on. It is
+// implemented by *tsnet.Server.
+type tailscaleNode interface {
+	// Listen brings the node up and listens on addr in the tailnet.
+	Listen(network, addr string) (net.Listener, error)
+	// Close leaves the tailnet and closes the node's listeners.
+	Close() error
+}
+
+// newTailscaleServer returns the tsnet node serving the web interface in the
+// tailnet, or nil when Tailscale is disabled.
+func (s *Server) newTailscaleServer() tailscaleNode {
+	if !s.cfg.TailscaleEnabled {
+		return nil
+	}
+
+	logger := s.logger.Named("tailscale")
+	return &tsnet.Server{
+		Hostname: s.cfg.TailscaleHostname,
+		AuthKey:  s.cfg.TailscaleAuthKey,
+		Dir:      s.cfg.TailscaleStateDir,
+		// tsnet logs its backend verbosely, only show it when debugging
+		Logf: func(format string, args ...any) {
+			if ce := logger.Check(zap.DebugLevel, "tsnet"); ce != nil {
+				ce.Write(zap.String("message", fmt.Sprintf(format, args...)))
+			}
+		},
+		UserLogf: func(format string, args ...any) {
+			logger.Info(fmt.Sprintf(format, args...))
+		},
+	}
+}
+
+// listenTailscale brings up the tsnet node and listens on it. Joining the
+// tailnet continues in the background, requests are served once it is up.
+func (s *Server) listenTailscale() (net.Listener, error) {
+	ln, err := s.tailscale.Listen("tcp", tailscaleAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on tailscale node %q (check NEFITHK_TAILSCALE_AUTHKEY): %w", s.cfg.TailscaleHostname, err)
+	}
+
+	s.logger.Info("serving web interface on tailscale",
+		zap.String("hostname", s.cfg.TailscaleHostname),
+		zap.String("addr", tailscaleAddr),
+	)
+	return ln, nil
+}
@@ -0,0 +1,200 @@
+package web
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kradalby/nefit-homekit/config"
+	"github.com/kradalby/nefit-homekit/events"
+	"github.com/kradalby/nefit-homekit/leaktest"
+	"go.uber.org/zap"
+	"tailscale.com/tsnet"
+)
+
+// fakeTailscaleNode is a tailscaleNode that listens on loopback instead of
+// joining a tailnet.
+type fakeTailscaleNode struct {
+	ln        net.Listener // Returned by Listen
+	listenErr error        // Returned by Listen instead of ln when set
+
+	network string // Arguments of the last Listen call
+	addr    string
+	closes  int // Number of Close calls
+}
+
+func (f *fakeTailscaleNode) Listen(network, addr string) (net.Listener, error) {
+	f.network = network
+	f.addr = addr
+	if f.listenErr != nil {
+		return nil, f.listenErr
+	}
+	return f.ln, nil
+}
+
+func (f *fakeTailscaleNode) Close() error {
+	f.closes++
+	return nil
+}
+
+func TestNewTailscaleServer(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "disabled"},
+		{name: "enabled", enabled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				WebPort:           0,
+				WebDisplayUnit:    "celsius",
+				TailscaleEnabled:  tt.enabled,
+				TailscaleHostname: "nefit-test",
+				TailscaleStateDir: t.TempDir(),
+			}
+
+			server, err := New(cfg, logger, bus)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			if !tt.enabled {
+				if server.tailscale != nil {
+					t.Errorf("tailscale = %v, want nil", server.tailscale)
+				}
+				return
+			}
+
+			node, ok := server.tailscale.(*tsnet.Server)
+			if !ok {
+				t.Fatalf("tailscale = %T, want *tsnet.Server", server.tailscale)
+			}
+			if node.Hostname != cfg.TailscaleHostname {
+				t.Errorf("Hostname = %q, want %q", node.Hostname, cfg.TailscaleHostname)
+			}
+			if node.Dir != cfg.TailscaleStateDir {
+				t.Errorf("Dir = %q, want %q", node.Dir, cfg.TailscaleStateDir)
+			}
+		})
+	}
+}
+
+func TestTailscaleListener(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:           0,
+		WebDisplayUnit:    "celsius",
+		TailscaleHostname: "nefit-test",
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	node := &fakeTailscaleNode{ln: ln}
+	server.tailscale = node
+
+	if err := server.Start(); err != nil {
+		_ = server.Close()
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if node.network != "tcp" || node.addr != tailscaleAddr {
+		t.Errorf("Listen(%q, %q), want Listen(%q, %q)", node.network, node.addr, "tcp", tailscaleAddr)
+	}
+
+	// The web interface is served on the tailnet listener
+	client := &http.Client{Transport: &http.Transport{}}
+	resp, err := client.Get("http://" + ln.Addr().String() + "/health")
+	if err != nil {
+		_ = server.Close()
+		t.Fatalf("GET /health over tailscale error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	client.CloseIdleConnections()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /health status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if !strings.Contains(string(body), `"ok"`) {
+		t.Errorf("GET /health body = %q, want ok status", body)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Close shuts the node down and stops serving on its listener
+	if node.closes != 1 {
+		t.Errorf("node closed %d times, want 1", node.closes)
+	}
+	if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		_ = conn.Close()
+		t.Error("tailscale listener still accepts connections after Close()")
+	}
+}
+
+func TestTailscaleListenError(t *testing.T) {
+	logger := zap.NewNop()
+	bus, err := events.New(logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	defer func() {
+		_ = bus.Close()
+	}()
+
+	cfg := &config.Config{
+		WebPort:           0,
+		WebDisplayUnit:    "celsius",
+		TailscaleHostname: "nefit-test",
+	}
+
+	server, err := New(cfg, logger, bus)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	node := &fakeTailscaleNode{listenErr: errors.New("invalid auth key")}
+	server.tailscale = node
+	defer func() {
+		_ = server.Close()
+	}()
+
+	err = server.Start()
+	if err == nil {
+		t.Fatal("Start() error = nil, want the tailscale listen error")
+	}
+	if !strings.Contains(err.Error(), "NEFITHK_TAILSCALE_AUTHKEY") {
+		t.Errorf("Start() error = %v, want a hint at NEFITHK_TAILSCALE_AUTHKEY", err)
+	}
+}